@@ -1,5 +1,6 @@
-/*Package auth comprises all logic concerning the authentication
-against the LDAP server. */
+/*Package auth comprises all logic concerning the authentication of users,
+e.g., against an LDAP server, an OpenID Connect provider, a SAML identity provider
+or the passwords of external users. */
 package auth
 
 import "github.com/revel/revel"
@@ -8,3 +9,15 @@ var (
 	//log all authentication errors
 	log = revel.AppLog.New("section", "authentication")
 )
+
+func init() {
+
+	//register all provider types
+	Register("ldap", newLDAPProvider)
+	Register("local", newLocalProvider)
+	Register("oidc", newOIDCProvider)
+	Register("shibboleth", newSAMLProvider)
+
+	//NOTE: must be executed after initializing the config variables and the DB
+	revel.OnAppStart(initProviders, 6)
+}
@@ -6,23 +6,107 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"turm/app"
 	"turm/app/models"
 
+	"github.com/revel/revel"
 	ldap "gopkg.in/ldap.v2"
 )
 
-/*LDAPServerAuth implements the authentication of an user against the ldap server after the user
-entered his username and password. */
-func LDAPServerAuth(credentials *models.Credentials, user *models.User) (success bool, err error) {
+//ldapAttributes maps the user fields to the attribute names of the LDAP server
+type ldapAttributes struct {
+	FirstName     string
+	LastName      string
+	EMail         string
+	Salutation    string
+	Title         string
+	AcademicTitle string
+	NameAffix     string
+	MatrNr        string
+	Affiliations  string
+}
+
+//ldapProvider authenticates users against an LDAP server
+type ldapProvider struct {
+	name string
+
+	host      string
+	port      int
+	tlsVerify bool
+
+	//bindDN, searchBase and filter contain %s, which is replaced by the username
+	bindDN     string
+	searchBase string
+	filter     string
+
+	attributes ldapAttributes
+
+	//values of the salutation attribute
+	salutationMr string
+	salutationMs string
+}
+
+//newLDAPProvider creates a LDAP provider from the config keys auth.<name>.*
+func newLDAPProvider(name string) (Provider, error) {
+
+	p := ldapProvider{
+		name:       name,
+		host:       configString(name, "host"),
+		tlsVerify:  revel.Config.BoolDefault("auth."+name+".tls.verify", true),
+		bindDN:     configString(name, "bind.dn"),
+		searchBase: configString(name, "search.base"),
+		filter:     configString(name, "search.filter"),
+
+		salutationMr: revel.Config.StringDefault("auth."+name+".salutation.mr", ""),
+		salutationMs: revel.Config.StringDefault("auth."+name+".salutation.ms", ""),
+	}
+
+	port, err := strconv.Atoi(configString(name, "port"))
+	if err != nil {
+		return nil, err
+	}
+	p.port = port
+
+	if !p.tlsVerify {
+		log.Warn("the TLS certificate of the LDAP server is not verified", "provider", name)
+	}
+
+	//the name and e-mail attributes are required, all other attributes are optional
+	p.attributes = ldapAttributes{
+		FirstName:     configString(name, "attr.firstname"),
+		LastName:      configString(name, "attr.lastname"),
+		EMail:         configString(name, "attr.email"),
+		Salutation:    revel.Config.StringDefault("auth."+name+".attr.salutation", ""),
+		Title:         revel.Config.StringDefault("auth."+name+".attr.title", ""),
+		AcademicTitle: revel.Config.StringDefault("auth."+name+".attr.academic.title", ""),
+		NameAffix:     revel.Config.StringDefault("auth."+name+".attr.name.affix", ""),
+		MatrNr:        revel.Config.StringDefault("auth."+name+".attr.matrnr", ""),
+		Affiliations:  revel.Config.StringDefault("auth."+name+".attr.affiliations", ""),
+	}
+
+	return &p, nil
+}
+
+/*Name of the provider. */
+func (p *ldapProvider) Name() string {
+	return p.name
+}
+
+/*Supports LDAP login credentials, i.e., credentials containing a username. */
+func (p *ldapProvider) Supports(credentials *models.Credentials) bool {
+	return credentials.Username != ""
+}
+
+/*Authenticate implements the authentication of an user against the LDAP server after the
+user entered his username and password. */
+func (p *ldapProvider) Authenticate(credentials *models.Credentials, user *models.User) (success bool, err error) {
 
 	//get a TLS encrypted connection
-	tlsConfig := &tls.Config{InsecureSkipVerify: true}
-	hostAndPort := fmt.Sprintf("%s:%d", app.LdapHost, app.LdapPort)
+	tlsConfig := &tls.Config{InsecureSkipVerify: !p.tlsVerify, ServerName: p.host}
+	hostAndPort := fmt.Sprintf("%s:%d", p.host, p.port)
 	l, err := ldap.DialTLS("tcp", hostAndPort, tlsConfig)
 	if err != nil {
-		log.Error("error getting the TLS encrypted connection",
-			"hostAndPort", hostAndPort, "tlsConfig", tlsConfig, "error", err.Error())
+		log.Error("error getting the TLS encrypted connection", "provider", p.name,
+			"hostAndPort", hostAndPort, "tlsVerify", p.tlsVerify, "error", err.Error())
 		return
 	}
 	defer l.Close()
@@ -36,13 +120,14 @@ func LDAPServerAuth(credentials *models.Credentials, user *models.User) (success
 	*/
 
 	//try to bind with specified user
-	base := fmt.Sprintf("cn=%s,ou=user,o=uni", credentials.Username)
-	err = l.Bind(base, credentials.Password) //actual 'login'
+	bindDN := fmt.Sprintf(p.bindDN, escapeDN(credentials.Username))
+	err = l.Bind(bindDN, credentials.Password) //actual 'login'
 	if err != nil {
-		if !strings.Contains(err.Error(), "Invalid Credentials") &&
-			!strings.Contains(err.Error(), "Invalid DN Syntax") &&
+		if !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) &&
+			!ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidDNSyntax) &&
 			!strings.Contains(err.Error(), "NDS error: log account expired") {
-			log.Error("cannot login the user", "base", base, "error", err.Error())
+			log.Error("cannot login the user", "provider", p.name, "bindDN", bindDN,
+				"error", err.Error())
 			return
 		}
 		err = nil
@@ -52,81 +137,128 @@ func LDAPServerAuth(credentials *models.Credentials, user *models.User) (success
 	//at this point the actual login was successful
 	//now we want to get the user details
 
-	//attrNames is used to filter for specific attributes
-	attrNames := []string{"thuEduStudentNumber", "givenName", "sn", "mail", "thuEduTitle",
-		"thuEduSalutation", "eduPersonAffiliation", "thuEduAcademicTitle", "thuEduNameExtension"}
-
 	//search for the given username
 	searchRequest := ldap.NewSearchRequest(
-		base,
+		fmt.Sprintf(p.searchBase, escapeDN(credentials.Username)),
 		ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
 		0,
 		0,
 		false,
-		fmt.Sprintf("(&(objectClass=user)(uid=%s))", credentials.Username), //we are looking for a user
-		attrNames, //attrNames to get only certain ones
+		fmt.Sprintf(p.filter, ldap.EscapeFilter(credentials.Username)),
+		p.attributes.names(), //attrNames to get only certain ones
 		nil,
 	)
 
 	sr, err := l.Search(searchRequest)
 	if err != nil {
-		log.Error("error getting attributes", "search request", searchRequest, "error", err.Error())
+		log.Error("error getting attributes", "provider", p.name,
+			"search request", searchRequest, "error", err.Error())
 		return
 	}
 	//must be at least one, because we already logged in with this username
 	if len(sr.Entries) != 1 {
 		err = errors.New("user does not exist or too many entries returned")
-		log.Error(err.Error())
+		log.Error(err.Error(), "provider", p.name, "entries", len(sr.Entries))
+		return
+	}
+
+	if err = p.setUserData(sr.Entries[0], user); err != nil {
 		return
 	}
 
-	//get the entry
-	e := sr.Entries[0]
+	//the failed login attempts of LDAP users are counted per username
+	user.LDAPUsername.String = strings.ToLower(strings.TrimSpace(credentials.Username))
+	user.LDAPUsername.Valid = true
+	return true, nil
+}
 
-	//now we simly put the data we searched for with attrNames into an user struct
-	user.FirstName = e.GetAttributeValue("givenName")
-	user.LastName = e.GetAttributeValue("sn")
-	user.EMail = strings.ToLower(e.GetAttributeValue("mail"))
+//setUserData maps the attributes of an LDAP entry to the user fields
+func (p *ldapProvider) setUserData(e *ldap.Entry, user *models.User) (err error) {
 
-	user.Affiliations.Affiliations = e.GetAttributeValues("eduPersonAffiliation")
-	if len(user.Affiliations.Affiliations) != 0 {
-		user.Affiliations.Valid = true
-	}
+	attr := p.attributes
+	user.IsLDAP = true
 
-	switch salutation := e.GetAttributeValue("thuEduSalutation"); salutation {
-	case "Frau":
-		user.Salutation = models.MS
-	case "Herr":
-		user.Salutation = models.MR
-	default:
-		user.Salutation = models.NONE
-	}
+	user.FirstName = e.GetAttributeValue(attr.FirstName)
+	user.LastName = e.GetAttributeValue(attr.LastName)
+	user.EMail = strings.ToLower(e.GetAttributeValue(attr.EMail))
 
-	if e.GetAttributeValue("thuEduTitle") != "" {
-		user.Title.String = e.GetAttributeValue("thuEduTitle")
-		user.Title.Valid = true
-	}
-	if e.GetAttributeValue("thuEduAcademicTitle") != "" {
-		user.AcademicTitle.String = e.GetAttributeValue("thuEduAcademicTitle")
-		user.AcademicTitle.Valid = true
+	if attr.Affiliations != "" {
+		user.Affiliations.Affiliations = e.GetAttributeValues(attr.Affiliations)
+		if len(user.Affiliations.Affiliations) != 0 {
+			user.Affiliations.Valid = true
+		}
 	}
-	if e.GetAttributeValue("thuEduNameExtension") != "" {
-		user.NameAffix.String = e.GetAttributeValue("thuEduNameExtension")
-		user.NameAffix.Valid = true
+
+	user.Salutation = models.NONE
+	if attr.Salutation != "" {
+		switch salutation := e.GetAttributeValue(attr.Salutation); salutation {
+		case "":
+		case p.salutationMs:
+			user.Salutation = models.MS
+		case p.salutationMr:
+			user.Salutation = models.MR
+		}
 	}
 
+	setNullString(e, attr.Title, &user.Title.String, &user.Title.Valid)
+	setNullString(e, attr.AcademicTitle, &user.AcademicTitle.String, &user.AcademicTitle.Valid)
+	setNullString(e, attr.NameAffix, &user.NameAffix.String, &user.NameAffix.Valid)
+
 	//set the matriculation number, if not null
-	if e.GetAttributeValue("thuEduStudentNumber") != "" {
-		matrNr, err := strconv.Atoi(e.GetAttributeValue("thuEduStudentNumber"))
+	if attr.MatrNr != "" && e.GetAttributeValue(attr.MatrNr) != "" {
+		matrNr, err := strconv.Atoi(e.GetAttributeValue(attr.MatrNr))
 		if err != nil {
-			log.Error("error parsing matriculation number",
-				"matrNr", e.GetAttributeValue("thuEduStudentNumber"), "error", err.Error())
-			return false, err
+			log.Error("error parsing matriculation number", "provider", p.name,
+				"matrNr", e.GetAttributeValue(attr.MatrNr), "error", err.Error())
+			return err
 		}
 		user.MatrNr.Int32 = int32(matrNr)
 		user.MatrNr.Valid = true
 	}
 
-	return true, nil
+	return
+}
+
+//names returns all configured attribute names
+func (attr *ldapAttributes) names() (names []string) {
+
+	for _, name := range []string{attr.FirstName, attr.LastName, attr.EMail,
+		attr.Salutation, attr.Title, attr.AcademicTitle, attr.NameAffix,
+		attr.MatrNr, attr.Affiliations} {
+
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return
+}
+
+//setNullString sets a nullable user field to the value of an attribute, if it is not empty
+func setNullString(e *ldap.Entry, attr string, str *string, valid *bool) {
+
+	if attr != "" && e.GetAttributeValue(attr) != "" {
+		*str = e.GetAttributeValue(attr)
+		*valid = true
+	}
+}
+
+//escapeDN escapes all special characters of a value inserted into a DN (RFC 4514)
+func escapeDN(value string) string {
+
+	var escaped strings.Builder
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			(i == 0 && (r == ' ' || r == '#')),
+			(i == len(value)-1 && r == ' '):
+			escaped.WriteRune('\\')
+			escaped.WriteRune(r)
+		case r == 0:
+			escaped.WriteString(`\00`)
+		default:
+			escaped.WriteRune(r)
+		}
+	}
+	return escaped.String()
 }
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"database/sql"
+	"strings"
+	"turm/app/models"
+)
+
+//localProvider authenticates external users with the passwords stored in the DB
+type localProvider struct {
+	name string
+}
+
+//newLocalProvider creates a provider for external users
+func newLocalProvider(name string) (Provider, error) {
+	return &localProvider{name: name}, nil
+}
+
+/*Name of the provider. */
+func (p *localProvider) Name() string {
+	return p.name
+}
+
+/*Supports external login credentials, i.e., credentials containing an e-mail address. */
+func (p *localProvider) Supports(credentials *models.Credentials) bool {
+	return credentials.EMail != ""
+}
+
+/*Authenticate an external user by the e-mail address and password. */
+func (p *localProvider) Authenticate(credentials *models.Credentials, user *models.User) (success bool, err error) {
+
+	user.EMail = strings.ToLower(credentials.EMail)
+	success, err = user.VerifyPassword(credentials.Password)
+	if err != nil || !success {
+		return
+	}
+
+	//a valid password marks the user as external user, the login updates the user by
+	//the ID set when verifying the password, so the password itself is not kept
+	user.Password = sql.NullString{Valid: true}
+	return
+}
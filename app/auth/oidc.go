@@ -0,0 +1,750 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"turm/app"
+	"turm/app/models"
+
+	"github.com/revel/revel"
+)
+
+//oidcClaims maps the user fields to the claims of the OpenID Connect provider
+type oidcClaims struct {
+	FirstName     string
+	LastName      string
+	EMail         string
+	EMailVerified string
+	MatrNr        string
+	Title         string
+	AcademicTitle string
+	Affiliations  string
+}
+
+//oidcDiscovery contains the endpoints of the OpenID Connect provider
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+//oidcJWKS is the JSON web key set of the provider, which contains the public keys
+//to verify the signatures of the ID tokens
+type oidcJWKS struct {
+	Keys []oidcJWK `json:"keys"`
+}
+
+//oidcJWK is a public key of the provider
+type oidcJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+//oidcKeysRefresh is the minimum time between two requests of the JSON web key set,
+//e.g., if an ID token is signed with an unknown key
+const oidcKeysRefresh = time.Minute
+
+//oidcTokens is the response of the token endpoint
+type oidcTokens struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+}
+
+/*OIDCProvider authenticates users with the OpenID Connect authorization code flow.
+Users do not enter credentials at the login page, instead, they are redirected to
+the provider. */
+type OIDCProvider struct {
+	name  string
+	label string
+
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       string
+
+	claims oidcClaims
+	client *http.Client
+
+	//the endpoints are discovered at the first login, so that the
+	//application starts even if the provider is not reachable
+	mutex     sync.Mutex
+	discovery *oidcDiscovery
+
+	//the public keys of the provider by their key IDs, they are requested again
+	//if an ID token is signed with an unknown key
+	keysMutex   sync.Mutex
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+//newOIDCProvider creates an OpenID Connect provider from the config keys auth.<name>.*,
+//the client secret is set in the passwords file as auth.<name>.client.secret
+func newOIDCProvider(name string) (Provider, error) {
+
+	p := OIDCProvider{
+		name:         name,
+		label:        revel.Config.StringDefault("auth."+name+".label", name),
+		issuer:       strings.TrimSuffix(configString(name, "issuer"), "/"),
+		clientID:     configString(name, "client.id"),
+		clientSecret: app.GetPassword("auth." + name + ".client.secret"),
+		redirectURL:  revel.Config.StringDefault("auth."+name+".redirect.url", ""),
+		scopes:       revel.Config.StringDefault("auth."+name+".scopes", "openid profile email"),
+	}
+
+	//the callback of the login flow
+	if p.redirectURL == "" {
+		p.redirectURL = app.Server.URL + "/user/oidcCallback"
+		if !strings.HasPrefix(p.redirectURL, "http") {
+			p.redirectURL = "http://" + p.redirectURL
+		}
+	}
+
+	//an empty email.verified claim means that all e-mail addresses of the provider are verified
+	p.claims = oidcClaims{
+		FirstName:     revel.Config.StringDefault("auth."+name+".claim.firstname", "given_name"),
+		LastName:      revel.Config.StringDefault("auth."+name+".claim.lastname", "family_name"),
+		EMail:         revel.Config.StringDefault("auth."+name+".claim.email", "email"),
+		EMailVerified: revel.Config.StringDefault("auth."+name+".claim.email.verified", "email_verified"),
+		MatrNr:        revel.Config.StringDefault("auth."+name+".claim.matrnr", ""),
+		Title:         revel.Config.StringDefault("auth."+name+".claim.title", ""),
+		AcademicTitle: revel.Config.StringDefault("auth."+name+".claim.academic.title", ""),
+		Affiliations:  revel.Config.StringDefault("auth."+name+".claim.affiliations", ""),
+	}
+
+	//NOTE: the keys to verify the ID tokens are requested from the provider, so that
+	//its TLS certificate must always be verified
+	if !revel.Config.BoolDefault("auth."+name+".tls.verify", true) {
+		return nil, errors.New("auth." + name + ".tls.verify = false is not supported, " +
+			"set a trusted CA certificate with auth." + name + ".tls.ca instead")
+	}
+
+	//trust an additional CA certificate, e.g., of a local mock provider
+	tlsConfig := &tls.Config{}
+	if caFile := revel.Config.StringDefault("auth."+name+".tls.ca", ""); caFile != "" {
+
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no CA certificate found in " + caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	p.client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+
+	return &p, nil
+}
+
+/*OIDCProviders returns all enabled OpenID Connect providers. */
+func OIDCProviders() (oidcProviders []*OIDCProvider) {
+
+	for _, provider := range providers {
+		if oidcProvider, ok := provider.(*OIDCProvider); ok {
+			oidcProviders = append(oidcProviders, oidcProvider)
+		}
+	}
+	return
+}
+
+/*GetOIDCProvider returns the enabled OpenID Connect provider with this name. */
+func GetOIDCProvider(name string) (*OIDCProvider, bool) {
+
+	for _, provider := range OIDCProviders() {
+		if provider.name == name {
+			return provider, true
+		}
+	}
+	return nil, false
+}
+
+/*Name of the provider. */
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+/*Label of the provider shown at the login page. */
+func (p *OIDCProvider) Label() string {
+	return p.label
+}
+
+/*Supports no credentials, users log in by redirection to the provider. */
+func (p *OIDCProvider) Supports(credentials *models.Credentials) bool {
+	return false
+}
+
+/*Authenticate is not supported, users log in by redirection to the provider. */
+func (p *OIDCProvider) Authenticate(credentials *models.Credentials, user *models.User) (success bool, err error) {
+	return false, nil
+}
+
+/*AuthCodeURL returns the URL of the authorization endpoint. The state, nonce and
+verifier must be stored in the session to verify the callback. */
+func (p *OIDCProvider) AuthCodeURL() (authURL, state, nonce, verifier string, err error) {
+
+	discovery, err := p.discover()
+	if err != nil {
+		return
+	}
+
+	if state, err = randomString(); err != nil {
+		return
+	}
+	if nonce, err = randomString(); err != nil {
+		return
+	}
+	if verifier, err = randomString(); err != nil {
+		return
+	}
+
+	//PKCE code challenge
+	challenge := sha256.Sum256([]byte(verifier))
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.clientID)
+	params.Set("redirect_uri", p.redirectURL)
+	params.Set("scope", p.scopes)
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	authURL = discovery.AuthorizationEndpoint
+	if strings.Contains(authURL, "?") {
+		authURL += "&" + params.Encode()
+	} else {
+		authURL += "?" + params.Encode()
+	}
+	return
+}
+
+/*Exchange the authorization code for the tokens of the user and set all user data
+known to the provider. */
+func (p *OIDCProvider) Exchange(code, nonce, verifier string, user *models.User) (identity models.OIDCIdentity, err error) {
+
+	discovery, err := p.discover()
+	if err != nil {
+		return
+	}
+
+	params := url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("code", code)
+	params.Set("redirect_uri", p.redirectURL)
+	params.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		log.Error("failed to create token request", "provider", p.name, "error", err.Error())
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+
+	tokens := oidcTokens{}
+	if err = p.do(req, &tokens); err != nil {
+		return
+	}
+	if tokens.Error != "" || tokens.IDToken == "" {
+		err = errors.New("token endpoint returned no ID token")
+		log.Error(err.Error(), "provider", p.name, "tokenError", tokens.Error)
+		return
+	}
+
+	claims, err := p.validateIDToken(discovery, tokens.IDToken, nonce)
+	if err != nil {
+		return
+	}
+
+	//the userinfo endpoint might provide additional claims
+	if discovery.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		if err = p.userinfo(discovery, tokens.AccessToken, claims); err != nil {
+			return
+		}
+	}
+
+	identity.Issuer = discovery.Issuer
+	identity.Subject = claimString(claims, "sub")
+	identity.EMailVerified = p.claims.EMailVerified == "" ||
+		claimString(claims, p.claims.EMailVerified) == "true"
+
+	err = p.setUserData(claims, user)
+	return
+}
+
+//discover the endpoints of the provider
+func (p *OIDCProvider) discover() (discovery *oidcDiscovery, err error) {
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequest("GET", p.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		log.Error("failed to create discovery request", "provider", p.name, "error", err.Error())
+		return
+	}
+
+	discovery = &oidcDiscovery{}
+	if err = p.do(req, discovery); err != nil {
+		return
+	}
+
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer ||
+		discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" ||
+		discovery.JWKSURI == "" {
+		err = errors.New("invalid discovery document")
+		log.Error(err.Error(), "provider", p.name, "discovery", *discovery)
+		return
+	}
+
+	p.discovery = discovery
+	return
+}
+
+//validateIDToken validates the signature, issuer, audience, expiration and nonce of
+//an ID token and returns its claims
+func (p *OIDCProvider) validateIDToken(discovery *oidcDiscovery, idToken, nonce string) (claims map[string]interface{}, err error) {
+
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		err = errors.New("malformed ID token")
+		log.Error(err.Error(), "provider", p.name)
+		return
+	}
+
+	if err = p.verifySignature(discovery, parts); err != nil {
+		return
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		log.Error("failed to decode ID token", "provider", p.name, "error", err.Error())
+		return
+	}
+
+	claims = make(map[string]interface{})
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		log.Error("failed to unmarshal ID token", "provider", p.name, "error", err.Error())
+		return
+	}
+
+	//the audience is either a string or an array of strings
+	audience := claimStrings(claims, "aud")
+	validAudience := false
+	for _, aud := range audience {
+		if aud == p.clientID {
+			validAudience = true
+		}
+	}
+
+	exp, _ := claims["exp"].(float64)
+
+	switch {
+	case claimString(claims, "iss") != discovery.Issuer:
+		err = errors.New("invalid issuer of ID token")
+	case !validAudience:
+		err = errors.New("invalid audience of ID token")
+	case time.Now().After(time.Unix(int64(exp), 0)):
+		err = errors.New("expired ID token")
+	case claimString(claims, "nonce") != nonce:
+		err = errors.New("invalid nonce of ID token")
+	case claimString(claims, "sub") == "":
+		err = errors.New("missing subject of ID token")
+	}
+
+	if err != nil {
+		log.Error(err.Error(), "provider", p.name, "iss", claims["iss"],
+			"aud", audience, "exp", exp)
+	}
+	return
+}
+
+//verifySignature verifies the signature of an ID token with the public key of the
+//provider, only asymmetric signature algorithms are accepted
+func (p *OIDCProvider) verifySignature(discovery *oidcDiscovery, parts []string) (err error) {
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[0], "="))
+	if err == nil {
+		err = json.Unmarshal(data, &header)
+	}
+	if err != nil {
+		log.Error("failed to decode header of ID token", "provider", p.name,
+			"error", err.Error())
+		return
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[2], "="))
+	if err != nil {
+		log.Error("failed to decode signature of ID token", "provider", p.name,
+			"error", err.Error())
+		return
+	}
+
+	var hash crypto.Hash
+	switch header.Alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		err = errors.New("unsupported signature algorithm of ID token")
+		log.Error(err.Error(), "provider", p.name, "alg", header.Alg)
+		return
+	}
+
+	key, err := p.publicKey(discovery, header.Kid)
+	if err != nil {
+		return
+	}
+
+	digest := hash.New()
+	digest.Write([]byte(parts[0] + "." + parts[1]))
+	hashed := digest.Sum(nil)
+
+	valid := false
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		valid = strings.HasPrefix(header.Alg, "RS") &&
+			rsa.VerifyPKCS1v15(pub, hash, hashed, signature) == nil
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if strings.HasPrefix(header.Alg, "ES") && len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			valid = ecdsa.Verify(pub, hashed, r, s)
+		}
+	}
+
+	if !valid {
+		err = errors.New("invalid signature of ID token")
+		log.Error(err.Error(), "provider", p.name, "alg", header.Alg, "kid", header.Kid)
+	}
+	return
+}
+
+//publicKey returns the public key of the provider with this key ID, an empty key ID
+//is only accepted if the provider has exactly one key
+func (p *OIDCProvider) publicKey(discovery *oidcDiscovery, kid string) (key crypto.PublicKey, err error) {
+
+	p.keysMutex.Lock()
+	defer p.keysMutex.Unlock()
+
+	key, found := p.findKey(kid)
+	if !found && time.Since(p.keysFetched) > oidcKeysRefresh {
+		if err = p.fetchKeys(discovery); err != nil {
+			return
+		}
+		key, found = p.findKey(kid)
+	}
+
+	if !found {
+		err = errors.New("unknown key of ID token")
+		log.Error(err.Error(), "provider", p.name, "kid", kid)
+	}
+	return
+}
+
+//findKey returns the key with this key ID
+func (p *OIDCProvider) findKey(kid string) (key crypto.PublicKey, found bool) {
+
+	if kid != "" {
+		key, found = p.keys[kid]
+		return
+	}
+
+	for _, k := range p.keys {
+		key = k
+	}
+	return key, len(p.keys) == 1
+}
+
+//fetchKeys requests the JSON web key set of the provider and replaces all known keys
+func (p *OIDCProvider) fetchKeys(discovery *oidcDiscovery) (err error) {
+
+	req, err := http.NewRequest("GET", discovery.JWKSURI, nil)
+	if err != nil {
+		log.Error("failed to create JWKS request", "provider", p.name, "error", err.Error())
+		return
+	}
+
+	jwks := oidcJWKS{}
+	if err = p.do(req, &jwks); err != nil {
+		return
+	}
+	p.keysFetched = time.Now()
+
+	p.keys = make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+
+		//skip encryption keys
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Error("skipping invalid key of provider", "provider", p.name, "kid", jwk.Kid,
+				"error", err.Error())
+			continue
+		}
+		p.keys[jwk.Kid] = key
+	}
+	return
+}
+
+//publicKey decodes the RSA or elliptic curve public key
+func (jwk *oidcJWK) publicKey() (key crypto.PublicKey, err error) {
+
+	decode := func(value string) (*big.Int, error) {
+		data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(data), nil
+	}
+
+	switch jwk.Kty {
+
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || n.Sign() <= 0 {
+			return nil, errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve " + jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid elliptic curve key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, errors.New("unsupported key type " + jwk.Kty)
+}
+
+//userinfo adds the claims of the userinfo endpoint to the claims of the ID token
+func (p *OIDCProvider) userinfo(discovery *oidcDiscovery, accessToken string, claims map[string]interface{}) (err error) {
+
+	req, err := http.NewRequest("GET", discovery.UserinfoEndpoint, nil)
+	if err != nil {
+		log.Error("failed to create userinfo request", "provider", p.name, "error", err.Error())
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	userinfo := make(map[string]interface{})
+	if err = p.do(req, &userinfo); err != nil {
+		return
+	}
+
+	//the userinfo must belong to the same subject
+	if claimString(userinfo, "sub") != claimString(claims, "sub") {
+		err = errors.New("subject of userinfo does not match the ID token")
+		log.Error(err.Error(), "provider", p.name)
+		return
+	}
+
+	for key, value := range userinfo {
+		claims[key] = value
+	}
+	return
+}
+
+//do sends a request to the provider and decodes the JSON response
+func (p *OIDCProvider) do(req *http.Request, result interface{}) (err error) {
+
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		log.Error("request to provider failed", "provider", p.name, "url", req.URL.String(),
+			"error", err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	//the token endpoint responds with status 400 and an error field
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		err = fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		log.Error(err.Error(), "provider", p.name, "url", req.URL.String())
+		return
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		log.Error("failed to decode response of provider", "provider", p.name,
+			"url", req.URL.String(), "error", err.Error())
+	}
+	return
+}
+
+//setUserData maps the claims to the user fields
+func (p *OIDCProvider) setUserData(claims map[string]interface{}, user *models.User) (err error) {
+
+	user.FirstName = claimString(claims, p.claims.FirstName)
+	user.LastName = claimString(claims, p.claims.LastName)
+	user.EMail = strings.ToLower(claimString(claims, p.claims.EMail))
+	user.Salutation = models.NONE
+
+	if user.EMail == "" {
+		err = errors.New("missing e-mail claim")
+		log.Error(err.Error(), "provider", p.name, "claim", p.claims.EMail)
+		return
+	}
+
+	if value := claimString(claims, p.claims.Title); value != "" {
+		user.Title.String = value
+		user.Title.Valid = true
+	}
+	if value := claimString(claims, p.claims.AcademicTitle); value != "" {
+		user.AcademicTitle.String = value
+		user.AcademicTitle.Valid = true
+	}
+
+	user.Affiliations.Affiliations = claimStrings(claims, p.claims.Affiliations)
+	if len(user.Affiliations.Affiliations) != 0 {
+		user.Affiliations.Valid = true
+	}
+
+	//set the matriculation number, if not null
+	if value := claimString(claims, p.claims.MatrNr); value != "" {
+		matrNr, err := strconv.Atoi(value)
+		if err != nil {
+			log.Error("error parsing matriculation number", "provider", p.name,
+				"matrNr", value, "error", err.Error())
+			return err
+		}
+		user.MatrNr.Int32 = int32(matrNr)
+		user.MatrNr.Valid = true
+	}
+	return
+}
+
+//claimString returns the value of a claim as a string
+func claimString(claims map[string]interface{}, claim string) string {
+
+	if claim == "" {
+		return ""
+	}
+
+	switch value := claims[claim].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	return ""
+}
+
+//claimStrings returns the values of a claim, which is either a string or an array
+func claimStrings(claims map[string]interface{}, claim string) (values []string) {
+
+	if claim == "" {
+		return
+	}
+
+	switch value := claims[claim].(type) {
+	case string:
+		values = append(values, value)
+	case []interface{}:
+		for _, v := range value {
+			if str, ok := v.(string); ok {
+				values = append(values, str)
+			}
+		}
+	}
+	return
+}
+
+/*VerifyState returns true if the state of a callback matches the state stored in
+the session at the start of the login. */
+func VerifyState(expected, state string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(state), []byte(expected)) == 1
+}
+
+//randomString returns a random URL-safe string for the state, nonce and verifier
+func randomString() (string, error) {
+
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		log.Error("failed to generate random string", "error", err.Error())
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+	"turm/app/models"
+)
+
+//mockIdP is a minimal OpenID Connect provider supporting the discovery, the
+//authorization code flow with PKCE, the JSON web key set and the userinfo endpoint
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+
+	//the key signing the ID tokens, defaults to the published key
+	signingKey *rsa.PrivateKey
+
+	//set by the authorization request of the test
+	code      string
+	challenge string
+	nonce     string
+
+	claims map[string]interface{}
+}
+
+const (
+	mockClientID     = "turm"
+	mockClientSecret = "secret"
+	mockSubject      = "248289761001"
+)
+
+func newMockIdP(t *testing.T) *mockIdP {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &mockIdP{key: key, kid: "mock-key", code: "mock-code"}
+	idp.claims = map[string]interface{}{
+		"given_name":     "Jane",
+		"family_name":    "Doe",
+		"email":          "Jane.Doe@example.org",
+		"email_verified": true,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/jwks", idp.jwks)
+	mux.HandleFunc("/userinfo", idp.userinfo)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+//provider returns an OpenID Connect provider using the mock provider as issuer
+func (idp *mockIdP) provider() *OIDCProvider {
+
+	return &OIDCProvider{
+		name:         "mock",
+		issuer:       idp.server.URL,
+		clientID:     mockClientID,
+		clientSecret: mockClientSecret,
+		redirectURL:  "http://localhost/user/oidcCallback",
+		scopes:       "openid profile email",
+		claims: oidcClaims{
+			FirstName:     "given_name",
+			LastName:      "family_name",
+			EMail:         "email",
+			EMailVerified: "email_verified",
+		},
+		client: idp.server.Client(),
+	}
+}
+
+//authorize simulates the redirection of the user to the authorization endpoint
+//and returns the state and nonce of the authorization request
+func (idp *mockIdP) authorize(t *testing.T, p *OIDCProvider) (state, nonce, verifier string) {
+
+	authURL, state, nonce, verifier, err := p.AuthCodeURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+
+	if parsed.Path != "/authorize" || query.Get("client_id") != mockClientID ||
+		query.Get("response_type") != "code" || query.Get("state") != state ||
+		query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("invalid authorization request %s", authURL)
+	}
+
+	idp.challenge = query.Get("code_challenge")
+	idp.nonce = query.Get("nonce")
+	return
+}
+
+func (idp *mockIdP) discovery(w http.ResponseWriter, r *http.Request) {
+
+	json.NewEncoder(w).Encode(oidcDiscovery{
+		Issuer:                idp.server.URL,
+		AuthorizationEndpoint: idp.server.URL + "/authorize",
+		TokenEndpoint:         idp.server.URL + "/token",
+		UserinfoEndpoint:      idp.server.URL + "/userinfo",
+		JWKSURI:               idp.server.URL + "/jwks",
+	})
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+
+	clientID, secret, ok := r.BasicAuth()
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+
+	if !ok || clientID != mockClientID || secret != mockClientSecret ||
+		r.PostFormValue("code") != idp.code ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != idp.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(oidcTokens{Error: "invalid_grant"})
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":   idp.server.URL,
+		"sub":   mockSubject,
+		"aud":   mockClientID,
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": idp.nonce,
+	}
+	for key, value := range idp.claims {
+		claims[key] = value
+	}
+
+	json.NewEncoder(w).Encode(oidcTokens{
+		AccessToken: "mock-access-token",
+		IDToken:     idp.sign(claims),
+	})
+}
+
+func (idp *mockIdP) jwks(w http.ResponseWriter, r *http.Request) {
+
+	json.NewEncoder(w).Encode(oidcJWKS{Keys: []oidcJWK{{
+		Kty: "RSA",
+		Kid: idp.kid,
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+	}}})
+}
+
+func (idp *mockIdP) userinfo(w http.ResponseWriter, r *http.Request) {
+
+	if r.Header.Get("Authorization") != "Bearer mock-access-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"sub": mockSubject})
+}
+
+//sign returns an RS256 signed JWT containing the claims
+func (idp *mockIdP) sign(claims map[string]interface{}) string {
+
+	key := idp.signingKey
+	if key == nil {
+		key = idp.key
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": idp.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	data := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	hashed := sha256.Sum256([]byte(data))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		panic(err)
+	}
+	return data + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCCodeFlow(t *testing.T) {
+
+	idp := newMockIdP(t)
+	p := idp.provider()
+	_, nonce, verifier := idp.authorize(t, p)
+
+	var user models.User
+	identity, err := p.Exchange(idp.code, nonce, verifier, &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity.Issuer != idp.server.URL || identity.Subject != mockSubject ||
+		!identity.EMailVerified {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	if user.FirstName != "Jane" || user.LastName != "Doe" ||
+		user.EMail != "jane.doe@example.org" {
+		t.Errorf("unexpected user data %+v", user)
+	}
+}
+
+func TestOIDCStateMismatch(t *testing.T) {
+
+	idp := newMockIdP(t)
+	state, _, _ := idp.authorize(t, idp.provider())
+
+	if !VerifyState(state, state) {
+		t.Error("valid state rejected")
+	}
+	if VerifyState(state, state+"x") {
+		t.Error("invalid state accepted")
+	}
+	if VerifyState("", "") {
+		t.Error("callback without session state accepted")
+	}
+}
+
+func TestOIDCNonceMismatch(t *testing.T) {
+
+	idp := newMockIdP(t)
+	p := idp.provider()
+	_, nonce, verifier := idp.authorize(t, p)
+
+	var user models.User
+	if _, err := p.Exchange(idp.code, nonce+"x", verifier, &user); err == nil {
+		t.Error("ID token with invalid nonce accepted")
+	}
+}
+
+func TestOIDCInvalidVerifier(t *testing.T) {
+
+	idp := newMockIdP(t)
+	p := idp.provider()
+	_, nonce, _ := idp.authorize(t, p)
+
+	var user models.User
+	if _, err := p.Exchange(idp.code, nonce, "invalid", &user); err == nil {
+		t.Error("code exchanged with invalid PKCE verifier")
+	}
+}
+
+func TestOIDCInvalidSignature(t *testing.T) {
+
+	idp := newMockIdP(t)
+	p := idp.provider()
+	_, nonce, verifier := idp.authorize(t, p)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.signingKey = key
+
+	var user models.User
+	if _, err = p.Exchange(idp.code, nonce, verifier, &user); err == nil {
+		t.Error("ID token with invalid signature accepted")
+	}
+}
+
+func TestOIDCEMailVerified(t *testing.T) {
+
+	idp := newMockIdP(t)
+	p := idp.provider()
+
+	//the e-mail verification claim of the provider is taken over
+	idp.claims["email_verified"] = false
+	_, nonce, verifier := idp.authorize(t, p)
+
+	var user models.User
+	identity, err := p.Exchange(idp.code, nonce, verifier, &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.EMailVerified {
+		t.Error("unverified e-mail address marked as verified")
+	}
+
+	//without e-mail verification claim, all e-mail addresses are verified
+	p.claims.EMailVerified = ""
+	_, nonce, verifier = idp.authorize(t, p)
+
+	identity, err = p.Exchange(idp.code, nonce, verifier, &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !identity.EMailVerified {
+		t.Error("e-mail address of trusted provider not marked as verified")
+	}
+}
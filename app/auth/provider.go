@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"strings"
+	"turm/app/models"
+
+	"github.com/revel/revel"
+)
+
+/*Provider authenticates users with the credentials entered at the login page. */
+type Provider interface {
+
+	//Name returns the name of the provider as set in the config
+	Name() string
+
+	//Supports returns true if the provider can authenticate these credentials,
+	//e.g., LDAP providers require a username
+	Supports(credentials *models.Credentials) bool
+
+	//Authenticate the credentials. If the authentication succeeds, then it returns
+	//true and sets all user data known to the provider.
+	Authenticate(credentials *models.Credentials, user *models.User) (success bool, err error)
+}
+
+/*Factory creates a provider from the config keys with the prefix auth.<name>. */
+type Factory func(name string) (Provider, error)
+
+var (
+	//factories holds all registered provider types
+	factories = make(map[string]Factory)
+
+	//providers holds all enabled providers in the order of authentication
+	providers []Provider
+)
+
+/*Register a provider type. Each enabled provider sets its type in the config,
+e.g., auth.uni.type = ldap. */
+func Register(providerType string, factory Factory) {
+
+	if _, exists := factories[providerType]; exists {
+		log.Fatal("provider type already registered", "type", providerType)
+	}
+	factories[providerType] = factory
+}
+
+/*Authenticate the credentials with the enabled providers, in the order of the config.
+The first provider that successfully authenticates the credentials sets the user data.
+It only returns an error if no provider succeeded and at least one provider failed. */
+func Authenticate(credentials *models.Credentials, user *models.User) (success bool, err error) {
+
+	for _, provider := range providers {
+
+		if !provider.Supports(credentials) {
+			continue
+		}
+
+		var providerErr error
+		success, providerErr = provider.Authenticate(credentials, user)
+		if providerErr != nil {
+			log.Error("provider failed to authenticate user", "provider", provider.Name(),
+				"error", providerErr.Error())
+			err = providerErr
+			continue
+		}
+
+		if success {
+			log.Debug("authentication successful", "provider", provider.Name())
+			return true, nil
+		}
+	}
+
+	return false, err
+}
+
+//initProviders creates all enabled providers, e.g., auth.providers = ldap, local
+func initProviders() {
+
+	revel.AppLog.Info("init authentication providers")
+
+	list, found := revel.Config.String("auth.providers")
+	if !found {
+		revel.AppLog.Fatal("cannot find key in config", "key", "auth.providers")
+	}
+
+	providers = nil
+	for _, name := range strings.Split(list, ",") {
+
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		//the type defaults to the name of the provider
+		providerType := revel.Config.StringDefault("auth."+name+".type", name)
+		factory, exists := factories[providerType]
+		if !exists {
+			revel.AppLog.Fatal("unknown authentication provider type", "name", name,
+				"type", providerType)
+		}
+
+		provider, err := factory(name)
+		if err != nil {
+			revel.AppLog.Fatal("failed to create authentication provider", "name", name,
+				"type", providerType, "error", err.Error())
+		}
+		providers = append(providers, provider)
+	}
+}
+
+//configString returns the config value of a provider and fails if it does not exist
+func configString(name, key string) string {
+
+	value, found := revel.Config.String("auth." + name + "." + key)
+	if !found {
+		revel.AppLog.Fatal("cannot find key in config", "key", "auth."+name+"."+key)
+	}
+	return value
+}
+
+//configList splits a comma-separated config value
+func configList(value string) (list []string) {
+
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return
+}
@@ -0,0 +1,342 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"turm/app"
+	"turm/app/models"
+
+	"github.com/revel/revel"
+)
+
+//samlHeaders maps the user fields to the request headers set by the Shibboleth SP
+type samlHeaders struct {
+	Session       string
+	Issuer        string
+	Subject       string
+	EMailVerified string
+	FirstName     string
+	LastName      string
+	EMail         string
+	Title         string
+	AcademicTitle string
+	MatrNr        string
+	Affiliations  string
+}
+
+/*SAMLProvider authenticates users with SAML 2.0 single sign-on, e.g., in the DFN-AAI.
+The SAML protocol is handled by a Shibboleth service provider in front of the
+application, which protects the login route and sets the attributes of the user as
+request headers. Only headers of trusted proxies are accepted. */
+type SAMLProvider struct {
+	name  string
+	label string
+
+	entityID         string
+	acsURL           string
+	sessionInitiator string
+	certificate      string
+
+	//the remote addresses of the Shibboleth SP
+	trustedProxies []string
+	headers        samlHeaders
+
+	//identity providers whose e-mail addresses are verified
+	verifiedIdPs []string
+
+	//separator of multi-valued attributes
+	separator string
+}
+
+//newSAMLProvider creates a SAML provider from the config keys auth.<name>.*
+func newSAMLProvider(name string) (Provider, error) {
+
+	p := SAMLProvider{
+		name:             name,
+		label:            revel.Config.StringDefault("auth."+name+".label", name),
+		entityID:         configString(name, "entity.id"),
+		acsURL:           revel.Config.StringDefault("auth."+name+".acs.url", ""),
+		sessionInitiator: revel.Config.StringDefault("auth."+name+".session.initiator", "/Shibboleth.sso/Login"),
+		separator:        revel.Config.StringDefault("auth."+name+".separator", ";"),
+	}
+
+	if p.acsURL == "" {
+		p.acsURL = app.Server.URL + "/Shibboleth.sso/SAML2/POST"
+		if !strings.HasPrefix(p.acsURL, "http") {
+			p.acsURL = "http://" + p.acsURL
+		}
+	}
+
+	//NOTE: there is no default, because a local reverse proxy in front of the
+	//application forwards the headers of any client, unless it removes them
+	p.trustedProxies = configList(configString(name, "trusted.proxies"))
+	if len(p.trustedProxies) == 0 {
+		return nil, errors.New("auth." + name + ".trusted.proxies must not be empty")
+	}
+	p.verifiedIdPs = configList(revel.Config.StringDefault("auth."+name+".email.verified.idps", ""))
+
+	//the e-mail, issuer, subject and session headers are required, all other headers are optional
+	p.headers = samlHeaders{
+		Session:       revel.Config.StringDefault("auth."+name+".header.session", "Shib-Session-ID"),
+		Issuer:        revel.Config.StringDefault("auth."+name+".header.issuer", "Shib-Identity-Provider"),
+		Subject:       revel.Config.StringDefault("auth."+name+".header.subject", "persistent-id"),
+		EMailVerified: revel.Config.StringDefault("auth."+name+".header.email.verified", ""),
+		FirstName:     revel.Config.StringDefault("auth."+name+".header.firstname", "givenName"),
+		LastName:      revel.Config.StringDefault("auth."+name+".header.lastname", "sn"),
+		EMail:         revel.Config.StringDefault("auth."+name+".header.email", "mail"),
+		Title:         revel.Config.StringDefault("auth."+name+".header.title", ""),
+		AcademicTitle: revel.Config.StringDefault("auth."+name+".header.academic.title", ""),
+		MatrNr:        revel.Config.StringDefault("auth."+name+".header.matrnr", ""),
+		Affiliations:  revel.Config.StringDefault("auth."+name+".header.affiliations", "affiliation"),
+	}
+
+	//the certificate of the SP is published in its metadata
+	if certFile := revel.Config.StringDefault("auth."+name+".cert.file", ""); certFile != "" {
+
+		if !filepath.IsAbs(certFile) {
+			certFile = filepath.Join(revel.BasePath, certFile)
+		}
+		data, err := ioutil.ReadFile(certFile)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no PEM data found in " + certFile)
+		}
+		p.certificate = base64.StdEncoding.EncodeToString(block.Bytes)
+	}
+
+	return &p, nil
+}
+
+/*SAMLProviders returns all enabled SAML providers. */
+func SAMLProviders() (samlProviders []*SAMLProvider) {
+
+	for _, provider := range providers {
+		if samlProvider, ok := provider.(*SAMLProvider); ok {
+			samlProviders = append(samlProviders, samlProvider)
+		}
+	}
+	return
+}
+
+/*GetSAMLProvider returns the enabled SAML provider with this name. */
+func GetSAMLProvider(name string) (*SAMLProvider, bool) {
+
+	for _, provider := range SAMLProviders() {
+		if provider.name == name {
+			return provider, true
+		}
+	}
+	return nil, false
+}
+
+/*Name of the provider. */
+func (p *SAMLProvider) Name() string {
+	return p.name
+}
+
+/*Label of the provider shown at the login page. */
+func (p *SAMLProvider) Label() string {
+	return p.label
+}
+
+/*Supports no credentials, users log in by redirection to their identity provider. */
+func (p *SAMLProvider) Supports(credentials *models.Credentials) bool {
+	return false
+}
+
+/*Authenticate is not supported, users log in by redirection to their identity provider. */
+func (p *SAMLProvider) Authenticate(credentials *models.Credentials, user *models.User) (success bool, err error) {
+	return false, nil
+}
+
+/*SessionInitiatorURL returns the URL of the Shibboleth SP starting the single sign-on,
+which redirects to the target after the login. */
+func (p *SAMLProvider) SessionInitiatorURL(target string) string {
+
+	if strings.Contains(p.sessionInitiator, "?") {
+		return p.sessionInitiator + "&target=" + url.QueryEscape(target)
+	}
+	return p.sessionInitiator + "?target=" + url.QueryEscape(target)
+}
+
+/*AuthenticateHeaders sets the user data and the identity from the request headers of
+the Shibboleth SP. It returns false if the request contains no SAML session. */
+func (p *SAMLProvider) AuthenticateHeaders(remoteAddr string, header func(string) string,
+	user *models.User) (identity models.SAMLIdentity, success bool, err error) {
+
+	if header(p.headers.Session) == "" {
+		return
+	}
+
+	//NOTE: otherwise, clients could log in as any user by setting the headers
+	if !p.trusted(remoteAddr) {
+		err = errors.New("SAML headers of untrusted remote address")
+		log.Error(err.Error(), "provider", p.name, "remoteAddr", remoteAddr)
+		return
+	}
+
+	//the identity provider and the persistent identifier of the user
+	identity.Issuer = p.value(header, p.headers.Issuer)
+	identity.Subject = p.value(header, p.headers.Subject)
+	if identity.Issuer == "" || identity.Subject == "" {
+		err = errors.New("missing issuer or subject attribute")
+		log.Error(err.Error(), "provider", p.name, "issuer", p.headers.Issuer,
+			"subject", p.headers.Subject)
+		return
+	}
+
+	user.IsLDAP = true
+	user.FirstName = p.value(header, p.headers.FirstName)
+	user.LastName = p.value(header, p.headers.LastName)
+	user.EMail = strings.ToLower(p.value(header, p.headers.EMail))
+	user.Salutation = models.NONE
+
+	if user.EMail == "" {
+		err = errors.New("missing e-mail attribute")
+		log.Error(err.Error(), "provider", p.name, "header", p.headers.EMail)
+		return
+	}
+
+	identity.EMailVerified = p.value(header, p.headers.EMailVerified) == "true"
+	for _, idp := range p.verifiedIdPs {
+		if idp == identity.Issuer {
+			identity.EMailVerified = true
+		}
+	}
+
+	if value := p.value(header, p.headers.Title); value != "" {
+		user.Title.String = value
+		user.Title.Valid = true
+	}
+	if value := p.value(header, p.headers.AcademicTitle); value != "" {
+		user.AcademicTitle.String = value
+		user.AcademicTitle.Valid = true
+	}
+
+	//eduPersonAffiliation values, scoped values (e.g., student@uni.de) are unscoped
+	if p.headers.Affiliations != "" {
+		for _, affiliation := range strings.Split(header(p.headers.Affiliations), p.separator) {
+			affiliation = strings.TrimSpace(strings.SplitN(affiliation, "@", 2)[0])
+			if affiliation != "" {
+				user.Affiliations.Affiliations = append(user.Affiliations.Affiliations, affiliation)
+			}
+		}
+		if len(user.Affiliations.Affiliations) != 0 {
+			user.Affiliations.Valid = true
+		}
+	}
+
+	//set the matriculation number, if not null
+	if value := p.value(header, p.headers.MatrNr); value != "" {
+		matrNr, err := strconv.Atoi(value)
+		if err != nil {
+			log.Error("error parsing matriculation number", "provider", p.name,
+				"matrNr", value, "error", err.Error())
+			return identity, false, err
+		}
+		user.MatrNr.Int32 = int32(matrNr)
+		user.MatrNr.Valid = true
+	}
+
+	return identity, true, nil
+}
+
+//value returns the first value of a (multi-valued) header
+func (p *SAMLProvider) value(header func(string) string, name string) string {
+
+	if name == "" {
+		return ""
+	}
+	return strings.TrimSpace(strings.SplitN(header(name), p.separator, 2)[0])
+}
+
+//trusted returns true if the remote address is a trusted proxy
+func (p *SAMLProvider) trusted(remoteAddr string) bool {
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	for _, proxy := range p.trustedProxies {
+		if host == proxy {
+			return true
+		}
+	}
+	return false
+}
+
+//samlMetadata is the SAML 2.0 metadata of the service provider
+type samlMetadata struct {
+	XMLName         xml.Name            `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string              `xml:"entityID,attr"`
+	SPSSODescriptor samlSPSSODescriptor `xml:"SPSSODescriptor"`
+}
+
+type samlSPSSODescriptor struct {
+	ProtocolSupport          string              `xml:"protocolSupportEnumeration,attr"`
+	KeyDescriptors           []samlKeyDescriptor `xml:"KeyDescriptor"`
+	NameIDFormats            []string            `xml:"NameIDFormat"`
+	AssertionConsumerService samlEndpoint        `xml:"AssertionConsumerService"`
+}
+
+type samlKeyDescriptor struct {
+	Use     string      `xml:"use,attr"`
+	KeyInfo samlKeyInfo `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo"`
+}
+
+type samlKeyInfo struct {
+	Certificate string `xml:"X509Data>X509Certificate"`
+}
+
+type samlEndpoint struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+	Index    int    `xml:"index,attr"`
+}
+
+/*Metadata returns the SAML 2.0 metadata of the service provider, which is registered
+at the federation, e.g., the DFN-AAI. */
+func (p *SAMLProvider) Metadata() (metadata []byte, err error) {
+
+	data := samlMetadata{
+		EntityID: p.entityID,
+		SPSSODescriptor: samlSPSSODescriptor{
+			ProtocolSupport: "urn:oasis:names:tc:SAML:2.0:protocol",
+			NameIDFormats: []string{
+				"urn:oasis:names:tc:SAML:2.0:nameid-format:transient",
+				"urn:oasis:names:tc:SAML:2.0:nameid-format:persistent",
+			},
+			AssertionConsumerService: samlEndpoint{
+				Binding:  "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST",
+				Location: p.acsURL,
+				Index:    1,
+			},
+		},
+	}
+
+	if p.certificate != "" {
+		for _, use := range []string{"signing", "encryption"} {
+			data.SPSSODescriptor.KeyDescriptors = append(data.SPSSODescriptor.KeyDescriptors,
+				samlKeyDescriptor{Use: use, KeyInfo: samlKeyInfo{Certificate: p.certificate}})
+		}
+	}
+
+	metadata, err = xml.MarshalIndent(data, "", "  ")
+	if err != nil {
+		log.Error("failed to marshal SAML metadata", "provider", p.name, "error", err.Error())
+		return
+	}
+	return append([]byte(xml.Header), metadata...), nil
+}
@@ -5,7 +5,6 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 
 	"github.com/revel/revel"
@@ -29,11 +28,6 @@ var (
 	//Languages holds all languages supported by the application
 	Languages []string
 
-	//LdapHost is the host of the LDAP server
-	LdapHost string
-	//LdapPort is the port of the LDAP server
-	LdapPort int
-
 	//PathErrorLog is the path to the error log file
 	PathErrorLog string
 
@@ -49,11 +43,15 @@ func initConfigVariables() {
 
 	revel.AppLog.Info("init custom config variables")
 	var found bool
-	var err error
 
 	initMailerData()
 	initDBData()
 	initServerData()
+	initPasswordData()
+	initTOTPData()
+	initRateLimitData()
+	initSessionData()
+	initWebhookData()
 	initJobData() //NOTE: must be after initMailerData
 
 	//time zone
@@ -71,24 +69,18 @@ func initConfigVariables() {
 	}
 	Languages = strings.Split(languageList, ", ")
 
-	//ldap config
-	if LdapHost, found = revel.Config.String("ldap.host"); !found {
-		revel.AppLog.Fatal("cannot find key in config", "key", "ldap.host")
-	}
-	var portStr string
-	if portStr, found = revel.Config.String("ldap.port"); !found {
-		revel.AppLog.Fatal("cannot find key in config", "key", "ldap.port")
-	}
-	if LdapPort, err = strconv.Atoi(portStr); err != nil {
-		revel.AppLog.Fatal("invalid ldap.port value set in config", "value", portStr, "error", err.Error())
-	}
-
 	//error log path
 	if PathErrorLog, found = revel.Config.String("error.log.path"); !found {
 		revel.AppLog.Fatal("cannot find key in config", "key", "error.log.path")
 	}
 }
 
+/*GetPassword returns a password of the passwords file, or an empty string, if
+it does not exist. */
+func GetPassword(key string) string {
+	return passwords[key]
+}
+
 //initPasswords initializes all passwords.
 func initPasswords() {
 
@@ -112,6 +104,7 @@ func initPasswords() {
 	json.Unmarshal(fileContent, &passwords)
 
 	Mailer.Password = passwords["email.pw"]
+	Mailer.BounceToken = passwords["email.bounce.token"]
 	dbData.Password = passwords["db.pw"]
 }
 
@@ -1,6 +1,12 @@
 package controllers
 
 import (
+	"database/sql"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 	"turm/app/models"
 
 	"github.com/revel/revel"
@@ -98,7 +104,39 @@ func (c Admin) Users(ID int) revel.Result {
 		return c.Render(user)
 	}
 
-	return c.Render()
+	//locked accounts and throttled IP addresses
+	var throttles models.LoginThrottles
+	if err := throttles.Select(); err != nil {
+		return renderError(err, c.Controller)
+	}
+
+	return c.Render(throttles)
+}
+
+/*UnlockLogin deletes all failed login attempts of a locked account or a throttled
+IP address.
+- Roles: admin (activated) */
+func (c Admin) UnlockLogin(ID int) revel.Result {
+
+	c.Log.Debug("unlock login", "ID", ID)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	c.Validation.Required(ID).
+		MessageKey("validation.missing.throttleID")
+	if c.Validation.HasErrors() {
+		return c.RenderJSON(
+			response{Status: INVALID, Msg: getErrorString(c.Validation.Errors)})
+	}
+
+	throttle := models.LoginThrottle{ID: ID}
+	if err := throttle.Unlock(); err != nil {
+		return c.RenderJSON(
+			response{Status: ERROR, Msg: c.Message(errDB.String())})
+	}
+
+	c.Log.Info("unlocked login", "key", throttle.Key, "admin", c.Session["userID"])
+	return c.RenderJSON(
+		response{Status: SUCCESS, Msg: c.Message("admin.unlock.login.success", throttle.Key)})
 }
 
 /*SearchUser renders search results for a search value. The search results are a
@@ -149,6 +187,13 @@ func (c Admin) ChangeRole(user models.User) revel.Result {
 			response{Status: ERROR, Msg: c.Message(errDB.String())})
 	}
 
+	//the user must log in again to use the new role, except for the current session
+	//of an admin changing his own role
+	if err := models.DeleteUserSessions(user.ID, sessionToken(c.Controller)); err != nil {
+		return c.RenderJSON(
+			response{Status: ERROR, Msg: c.Message(errDB.String())})
+	}
+
 	data := models.EMailData{User: user}
 	err := sendEMail(c.Controller, &data,
 		"email.subject.new.role",
@@ -214,6 +259,51 @@ func (c Admin) ChangeUserData(user models.User) revel.Result {
 		)})
 }
 
+/*Impersonate starts an impersonation of an user, e.g., to see which enrollment
+messages the user sees. Impersonations are read-only, unless writable is true. The
+start and the end of each impersonation are recorded in an audit log.
+- Roles: admin (activated) */
+func (c Admin) Impersonate(ID int, writable bool) revel.Result {
+
+	c.Log.Debug("impersonate user", "ID", ID, "writable", writable)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	adminID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+
+	user := models.User{}
+	imp := models.Impersonation{AdminID: adminID, UserID: ID, Writable: writable,
+		IP: c.ClientIP}
+	allowed, err := imp.Start(&user, sessionToken(c.Controller))
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if !allowed {
+		c.Validation.ErrorKey("validation.invalid.impersonation")
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	c.Log.Info("started impersonation", "impersonationID", imp.ID, "adminID", adminID,
+		"userID", ID, "writable", writable)
+
+	//NOTE: the server-side session remains the session of the admin
+	c.Session["impersonatorID"] = strconv.Itoa(adminID)
+	c.Session["impersonatorName"] = c.Session["firstName"].(string) + " " +
+		c.Session["lastName"].(string)
+	c.Session["impersonationID"] = strconv.Itoa(imp.ID)
+	c.Session["impersonationWritable"] = strconv.FormatBool(writable)
+
+	setSession(c.Controller, &user)
+	c.Session.Del("notActivated")
+	if user.ActivationCode.String != "" && !user.IsLDAP {
+		c.Session["notActivated"] = "true"
+	}
+
+	c.Flash.Success(c.Message("impersonation.start.success", user.FirstName, user.LastName))
+	return c.Redirect(App.Index)
+}
+
 /*Roles renders all users with elevated roles (ADMIN or CREATOR).
 - Roles: admin (activated) */
 func (c Admin) Roles() revel.Result {
@@ -318,6 +408,61 @@ func (c Admin) DeleteGroup(ID int) revel.Result {
 	return c.Redirect(c.Session["currPath"])
 }
 
+/*ExportGroup downloads a group, all of its subgroups and all of their courses
+as a group archive.
+- Roles: admin (activated) */
+func (c Admin) ExportGroup(ID int, userLists bool) revel.Result {
+
+	c.Log.Debug("export group", "ID", ID, "userLists", userLists)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	archive := models.GroupArchive{}
+	data, err := archive.Export(ID, userLists)
+	if err == sql.ErrNoRows {
+		c.Validation.ErrorKey("validation.invalid.groupID")
+		return flashError(errValidation, nil, "", c.Controller, "")
+	} else if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+
+	filename := time.Now().Format(revel.TimeFormats[1]) + " " + archive.Groups[0].Name
+	filename = strings.ReplaceAll(filename, "/", " ") + ".zip"
+
+	return groupArchiveResult{data: data, filename: filename}
+}
+
+/*ImportGroup recreates the groups and courses of a group archive below a parent
+group. A dry run only renders the report of the import.
+- Roles: admin (activated) */
+func (c Admin) ImportGroup(parentID int, userLists, dryRun bool, archive []byte) revel.Result {
+
+	c.Log.Debug("import group", "parentID", parentID, "userLists", userLists,
+		"dryRun", dryRun)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	c.Validation.Required(archive).
+		MessageKey("validation.invalid.archive.missing")
+	if c.Validation.HasErrors() {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+
+	report := models.GroupImportReport{DryRun: dryRun}
+	err = report.Import(c.Validation, archive, parentID, userID, userLists)
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if c.Validation.HasErrors() {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	c.ViewArgs["tab"] = c.Message("admin.tab")
+	return c.Render(report)
+}
+
 /*InsertCategory inserts the new category as a FAQ category or a news feed
 category.
 - Roles: admin (activated) */
@@ -495,3 +640,170 @@ func (c Admin) DeleteHelpPageEntry(ID int, table string) revel.Result {
 	c.Flash.Success(c.Message("entry.delete.success", entry.ID))
 	return c.Redirect(c.Session["currPath"])
 }
+
+/*Webhooks renders all global webhooks and their delivery logs. Global webhooks
+receive the events of all courses.
+- Roles: admin (activated) */
+func (c Admin) Webhooks() revel.Result {
+
+	c.Log.Debug("render global webhooks")
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	return renderWebhooks(c.Controller, 0)
+}
+
+/*NewWebhook inserts a new global webhook.
+- Roles: admin (activated) */
+func (c Admin) NewWebhook(webhook models.Webhook) revel.Result {
+
+	c.Log.Debug("insert global webhook", "webhook", webhook)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	return insertWebhook(c.Controller, 0, &webhook)
+}
+
+/*DeleteWebhook deletes a global webhook and its delivery log.
+- Roles: admin (activated) */
+func (c Admin) DeleteWebhook(webhookID int) revel.Result {
+
+	c.Log.Debug("delete global webhook", "webhookID", webhookID)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	return deleteWebhook(c.Controller, 0, webhookID)
+}
+
+/*TestWebhook queues a test delivery to a global webhook.
+- Roles: admin (activated) */
+func (c Admin) TestWebhook(webhookID int) revel.Result {
+
+	c.Log.Debug("test global webhook", "webhookID", webhookID)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	return testWebhook(c.Controller, 0, webhookID)
+}
+
+/*CourseTemplates renders all course templates and the groups to which templates
+can belong.
+- Roles: admin (activated) */
+func (c Admin) CourseTemplates() revel.Result {
+
+	c.Log.Debug("render course templates")
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	var templates models.CourseTemplates
+	if err := templates.Select(); err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	}
+
+	var groups models.Groups
+	if err := groups.SelectFlat(); err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	}
+
+	return c.Render(templates, groups)
+}
+
+/*NewCourseTemplate inserts a new course template. Its structure is taken from an
+existing course.
+- Roles: admin (activated) */
+func (c Admin) NewCourseTemplate(template models.CourseTemplate) revel.Result {
+
+	c.Log.Debug("insert course template", "template", template)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	c.Validation.Required(template.CourseID).
+		MessageKey("validation.invalid.courseID")
+
+	if template.Validate(c.Validation); c.Validation.HasErrors() {
+		return c.RenderJSON(
+			response{Status: INVALID, Msg: getErrorString(c.Validation.Errors)})
+	}
+
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		return c.RenderJSON(
+			response{Status: ERROR, Msg: c.Message(errTypeConv.String())})
+	}
+
+	template.Creator = sql.NullInt32{Int32: int32(userID), Valid: true}
+	if err = template.Insert(c.Validation); err != nil {
+		return c.RenderJSON(
+			response{Status: ERROR, Msg: c.Message(errDB.String())})
+	} else if c.Validation.HasErrors() {
+		return c.RenderJSON(
+			response{Status: INVALID, Msg: getErrorString(c.Validation.Errors)})
+	}
+
+	return c.RenderJSON(
+		response{Status: SUCCESS, Msg: c.Message("template.insert.success", template.Name)})
+}
+
+/*UpdateCourseTemplate updates the name, description and group of a course template.
+If a course ID is provided, then its structure is replaced by the structure of that course.
+- Roles: admin (activated) */
+func (c Admin) UpdateCourseTemplate(template models.CourseTemplate) revel.Result {
+
+	c.Log.Debug("update course template", "template", template)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	c.Validation.Required(template.ID).
+		MessageKey("validation.invalid.params")
+
+	if template.Validate(c.Validation); c.Validation.HasErrors() {
+		return c.RenderJSON(
+			response{Status: INVALID, Msg: getErrorString(c.Validation.Errors)})
+	}
+
+	if err := template.Update(c.Validation); err != nil {
+		return c.RenderJSON(
+			response{Status: ERROR, Msg: c.Message(errDB.String())})
+	} else if c.Validation.HasErrors() {
+		return c.RenderJSON(
+			response{Status: INVALID, Msg: getErrorString(c.Validation.Errors)})
+	}
+
+	return c.RenderJSON(
+		response{Status: SUCCESS, Msg: c.Message("template.update.success", template.Name)})
+}
+
+/*DeleteCourseTemplate deletes a course template. Courses created from the template
+are not affected.
+- Roles: admin (activated) */
+func (c Admin) DeleteCourseTemplate(ID int) revel.Result {
+
+	c.Log.Debug("delete course template", "ID", ID)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	template := models.CourseTemplate{ID: ID}
+	if err := template.Delete(); err != nil {
+		return c.RenderJSON(
+			response{Status: ERROR, Msg: c.Message(errDB.String())})
+	}
+
+	return c.RenderJSON(
+		response{Status: SUCCESS, Msg: c.Message("template.delete.success")})
+}
+
+//groupArchiveResult writes a group archive to the client
+type groupArchiveResult struct {
+	data     []byte
+	filename string
+}
+
+/*Apply writes the group archive as attachment. */
+func (r groupArchiveResult) Apply(req *revel.Request, resp *revel.Response) {
+
+	//group names may contain quotes and non-ASCII characters, so the file name is
+	//quoted or encoded as specified in RFC 2231
+	resp.Out.Header().Set("Content-Disposition", mime.FormatMediaType(
+		string(revel.Attachment), map[string]string{"filename": r.filename}))
+	resp.Out.Header().Set("Content-Length", strconv.Itoa(len(r.data)))
+	resp.WriteHeader(http.StatusOK, "application/zip")
+
+	if _, err := resp.GetWriter().Write(r.data); err != nil {
+		revel.AppLog.Error("failed to write group archive", "filename", r.filename,
+			"error", err.Error())
+	}
+}
@@ -0,0 +1,577 @@
+package controllers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+	"turm/app"
+	"turm/app/models"
+
+	"github.com/revel/revel"
+)
+
+//apiError is the response of a failed API request
+type apiError struct {
+	Error string `json:"error"`
+}
+
+//apiMessage is the response of a successful API request without content
+type apiMessage struct {
+	Message string `json:"message"`
+}
+
+//apiCourse is the API representation of a course
+type apiCourse struct {
+	ID              int        `json:"id"`
+	Title           string     `json:"title"`
+	Subtitle        string     `json:"subtitle,omitempty"`
+	Description     string     `json:"description,omitempty"`
+	Speaker         string     `json:"speaker,omitempty"`
+	Fee             *float64   `json:"fee,omitempty"`
+	Visible         bool       `json:"visible"`
+	Active          bool       `json:"active"`
+	OnlyLDAP        bool       `json:"only_ldap"`
+	EnrollmentStart time.Time  `json:"enrollment_start"`
+	EnrollmentEnd   time.Time  `json:"enrollment_end"`
+	UnsubscribeEnd  *time.Time `json:"unsubscribe_end,omitempty"`
+	ExpirationDate  time.Time  `json:"expiration_date"`
+	Events          []apiEvent `json:"events"`
+}
+
+//apiEvent is the API representation of an event
+type apiEvent struct {
+	ID          int          `json:"id"`
+	CourseID    int          `json:"course_id"`
+	Title       string       `json:"title"`
+	Annotation  string       `json:"annotation,omitempty"`
+	Capacity    int          `json:"capacity"`
+	Fullness    int          `json:"fullness"`
+	HasWaitlist bool         `json:"has_waitlist"`
+	HasKey      bool         `json:"has_enrollment_key"`
+	Enrolled    bool         `json:"enrolled"`
+	OnWaitlist  bool         `json:"on_waitlist"`
+	Meetings    []apiMeeting `json:"meetings"`
+}
+
+//apiMeeting is the API representation of a meeting
+type apiMeeting struct {
+	ID         int       `json:"id"`
+	EventID    int       `json:"event_id"`
+	Interval   string    `json:"interval"`
+	WeekDay    *int32    `json:"weekday,omitempty"`
+	Place      string    `json:"place,omitempty"`
+	Annotation string    `json:"annotation,omitempty"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+}
+
+//apiEnrollment is the API representation of an enrollment of the API user
+type apiEnrollment struct {
+	CourseID    int    `json:"course_id"`
+	CourseTitle string `json:"course_title"`
+	EventID     int    `json:"event_id"`
+	EventTitle  string `json:"event_title"`
+	Status      string `json:"status"`
+	Enrolled    string `json:"time_of_enrollment"`
+}
+
+//apiParticipantList is the API representation of the participant lists of an event
+type apiParticipantList struct {
+	EventID      int              `json:"event_id"`
+	Title        string           `json:"title"`
+	Capacity     int              `json:"capacity"`
+	Participants []apiParticipant `json:"participants"`
+	Waitlist     []apiParticipant `json:"waitlist"`
+	Unsubscribed []apiParticipant `json:"unsubscribed"`
+}
+
+//apiParticipant is the API representation of an user on a participant list
+type apiParticipant struct {
+	UserID    int    `json:"user_id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	EMail     string `json:"email"`
+	MatrNr    *int32 `json:"matr_nr,omitempty"`
+	Status    string `json:"status"`
+	Enrolled  string `json:"time_of_enrollment,omitempty"`
+	Comment   string `json:"comment,omitempty"`
+}
+
+/*Course renders a course with all of its events and meetings.
+- Roles: token owners with the read scope, if the course is not expired or if they
+have elevated rights */
+func (c API) Course(ID int) revel.Result {
+
+	c.Log.Debug("api: get course", "ID", ID)
+
+	if result := c.authorizeCourse(ID, "courses", false); result != nil {
+		return result
+	}
+
+	user := c.apiUser()
+	course := models.Course{ID: ID}
+	if err := course.Get(nil, false, user.ID); err == sql.ErrNoRows {
+		return c.renderError(http.StatusNotFound, "validation.invalid.courseID", nil)
+	} else if err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.db", err)
+	}
+
+	return c.RenderJSON(newAPICourse(&course))
+}
+
+/*Events renders all events of a course.
+- Roles: token owners with the read scope, if the course is not expired or if they
+have elevated rights */
+func (c API) Events(ID int) revel.Result {
+
+	c.Log.Debug("api: get events", "ID", ID)
+
+	if result := c.authorizeCourse(ID, "courses", false); result != nil {
+		return result
+	}
+
+	user := c.apiUser()
+	events := models.Events{}
+	if err := events.Get(nil, &user.ID, &ID, true, nil); err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.db", err)
+	}
+
+	return c.RenderJSON(newAPIEvents(events))
+}
+
+/*Meetings renders all meetings of an event.
+- Roles: token owners with the read scope, if the course is not expired or if they
+have elevated rights */
+func (c API) Meetings(ID int) revel.Result {
+
+	c.Log.Debug("api: get meetings", "ID", ID)
+
+	if result := c.authorizeCourse(ID, "events", false); result != nil {
+		return result
+	}
+
+	meetings := models.Meetings{}
+	if err := meetings.Get(nil, &ID); err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.db", err)
+	}
+
+	return c.RenderJSON(newAPIMeetings(meetings))
+}
+
+/*Enrollments renders all active enrollments of the token owner.
+- Roles: token owners with the read scope */
+func (c API) Enrollments() revel.Result {
+
+	c.Log.Debug("api: get enrollments")
+
+	user := c.apiUser()
+	if err := user.GetNavigationData(); err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.db", err)
+	}
+
+	enrollments := []apiEnrollment{}
+	for _, enrolled := range user.ActiveEnrollments {
+		enrollments = append(enrollments, apiEnrollment{
+			CourseID:    enrolled.CourseID,
+			CourseTitle: enrolled.CourseTitle,
+			EventID:     enrolled.EventID,
+			EventTitle:  enrolled.EventTitle,
+			Status:      enrolled.Status.String(),
+			Enrolled:    enrolled.TimeOfEnrollmentStr,
+		})
+	}
+
+	return c.RenderJSON(enrollments)
+}
+
+/*Enroll the token owner in an event. It validates all enrollment constraints.
+- Roles: token owners with the enroll scope */
+func (c API) Enroll(ID int, key, comment string) revel.Result {
+
+	c.Log.Debug("api: enroll in event", "ID", ID, "comment", comment)
+
+	user := c.apiUser()
+	enrolled := models.Enrolled{
+		EventID: ID,
+		UserID:  user.ID,
+		Comment: sql.NullString{String: comment, Valid: (len(comment) != 0)}}
+	data, waitList, _, msg, err := enrolled.EnrollOrUnsubscribe(models.ENROLL, key)
+
+	if err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.db", err)
+	} else if msg != "" {
+		return c.renderError(http.StatusUnprocessableEntity, msg, nil)
+	}
+
+	triggerWebhook(c.Controller, models.WebhookEnroll, ID, &data, enrolled.Status.String())
+
+	//send e-mail to the user
+	if waitList {
+		err = sendEMail(c.Controller, &data, "email.subject.wait.list", "waitlist")
+	} else {
+		err = sendEMail(c.Controller, &data, "email.subject.enroll", "enroll")
+	}
+	if err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.email", err,
+			data.User.EMail)
+	}
+
+	return c.RenderJSON(apiMessage{Message: c.Message("event.enroll.success")})
+}
+
+/*Unsubscribe the token owner from an event.
+- Roles: token owners with the enroll scope */
+func (c API) Unsubscribe(ID int) revel.Result {
+
+	c.Log.Debug("api: unsubscribe from event", "ID", ID)
+
+	user := c.apiUser()
+	enrolled := models.Enrolled{EventID: ID, UserID: user.ID}
+	data, waitList, users, msg, err := enrolled.EnrollOrUnsubscribe(models.UNSUBSCRIBE, "")
+
+	if err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.db", err)
+	} else if msg != "" {
+		return c.renderError(http.StatusUnprocessableEntity, msg, nil)
+	}
+
+	triggerWebhook(c.Controller, models.WebhookUnsubscribe, ID, &data, "")
+	triggerWaitlistWebhooks(c.Controller, ID, &data, users)
+
+	//send e-mail to the user who unsubscribed
+	if waitList {
+		err = sendEMail(c.Controller, &data, "email.subject.unsub.wait.list", "unsubWaitlist")
+	} else {
+		err = sendEMail(c.Controller, &data, "email.subject.unsubscribe", "unsubscribe")
+	}
+	if err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.email", err,
+			data.User.EMail)
+	}
+
+	if email, err := c.notifyFromWaitlist(&data, users); err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.email", err, email)
+	}
+
+	return c.RenderJSON(apiMessage{Message: c.Message("event.unsubscribe.success")})
+}
+
+/*Participants renders the participants, the wait list and the unsubscribed users of
+each event of a course.
+- Roles: token owners with the read scope and creators, editors and instructors of
+the course */
+func (c API) Participants(ID int) revel.Result {
+
+	c.Log.Debug("api: get participants", "ID", ID)
+
+	if result := c.authorizeCourse(ID, "courses", true); result != nil {
+		return result
+	}
+
+	user := c.apiUser()
+	participants := models.Participants{ID: ID}
+	if err := participants.Get(user.ID, false); err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.db", err)
+	}
+
+	lists := []apiParticipantList{}
+	for _, list := range participants.Lists {
+		lists = append(lists, apiParticipantList{
+			EventID:      list.ID,
+			Title:        list.Title,
+			Capacity:     list.Capacity,
+			Participants: newAPIParticipants(list.Participants, participants.ViewMatrNr),
+			Waitlist:     newAPIParticipants(list.Waitlist, participants.ViewMatrNr),
+			Unsubscribed: newAPIParticipants(list.Unsubscribed, participants.ViewMatrNr),
+		})
+	}
+
+	return c.RenderJSON(lists)
+}
+
+/*EnrollUser enrolls an user in an event without validating enrollment constraints.
+- Roles: token owners with the participants scope and creators, editors and
+instructors of the course */
+func (c API) EnrollUser(ID, eventID, userID int) revel.Result {
+
+	c.Log.Debug("api: enroll user without constraints", "ID", ID, "eventID", eventID,
+		"userID", userID)
+
+	if result := c.authorizeEvent(ID, eventID); result != nil {
+		return result
+	}
+
+	enrolled := models.Enrolled{EventID: eventID, UserID: userID}
+	data, err := enrolled.Enroll(&ID, c.Validation)
+	if err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.db", err)
+	} else if c.Validation.HasErrors() {
+		return c.renderValidationError()
+	}
+
+	triggerWebhook(c.Controller, models.WebhookEnroll, eventID, &data,
+		enrolled.Status.String())
+
+	err = sendEMail(c.Controller, &data, "email.subject.manual.enroll", "manualEnroll")
+	if err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.email", err,
+			data.User.EMail)
+	}
+
+	return c.RenderJSON(apiMessage{Message: c.Message("enroll.manual.success")})
+}
+
+/*WaitlistUser puts an user at the wait list of an event without validating enrollment
+constraints.
+- Roles: token owners with the participants scope and creators, editors and
+instructors of the course */
+func (c API) WaitlistUser(ID, eventID, userID int) revel.Result {
+
+	c.Log.Debug("api: put user at wait list without constraints", "ID", ID,
+		"eventID", eventID, "userID", userID)
+
+	if result := c.authorizeEvent(ID, eventID); result != nil {
+		return result
+	}
+
+	enrolled := models.Enrolled{EventID: eventID, UserID: userID}
+	data, users, err := enrolled.Waitlist(&ID, c.Validation)
+	if err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.db", err)
+	} else if c.Validation.HasErrors() {
+		return c.renderValidationError()
+	}
+
+	triggerWebhook(c.Controller, models.WebhookEnroll, eventID, &data,
+		enrolled.Status.String())
+	triggerWaitlistWebhooks(c.Controller, eventID, &data, users)
+
+	err = sendEMail(c.Controller, &data, "email.subject.manual.wait.list", "manualWaitlist")
+	if err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.email", err,
+			data.User.EMail)
+	}
+
+	if email, err := c.notifyFromWaitlist(&data, users); err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.email", err, email)
+	}
+
+	return c.RenderJSON(apiMessage{
+		Message: c.Message("enroll.manual.to.wait.list.success")})
+}
+
+/*UnsubscribeUser unsubscribes an user from an event.
+- Roles: token owners with the participants scope and creators, editors and
+instructors of the course */
+func (c API) UnsubscribeUser(ID, eventID, userID int) revel.Result {
+
+	c.Log.Debug("api: unsubscribe user", "ID", ID, "eventID", eventID, "userID", userID)
+
+	if result := c.authorizeEvent(ID, eventID); result != nil {
+		return result
+	}
+
+	enrolled := models.Enrolled{EventID: eventID, UserID: userID}
+	data, users, err := enrolled.Unsubscribe(&ID, c.Validation)
+	if err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.db", err)
+	} else if c.Validation.HasErrors() {
+		return c.renderValidationError()
+	}
+
+	triggerWebhook(c.Controller, models.WebhookUnsubscribe, eventID, &data, "")
+	triggerWaitlistWebhooks(c.Controller, eventID, &data, users)
+
+	err = sendEMail(c.Controller, &data, "email.subject.manual.unsubscribed", "manualUnsub")
+	if err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.email", err,
+			data.User.EMail)
+	}
+
+	if email, err := c.notifyFromWaitlist(&data, users); err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.email", err, email)
+	}
+
+	return c.RenderJSON(apiMessage{
+		Message: c.Message("enroll.manual.unsubscribe.success")})
+}
+
+//apiUser returns the user owning the token of the request
+func (c API) apiUser() *models.User {
+	return c.Args["apiUser"].(*models.User)
+}
+
+//authorizeCourse applies the authorization rules of the course and participants
+//interceptors to the token owner. Only users with elevated rights can access
+//expired courses. If elevated is true, then only users with elevated rights are
+//authorized.
+func (c API) authorizeCourse(ID int, table string, elevated bool) revel.Result {
+
+	user := c.apiUser()
+	authorized, expired := true, false
+
+	if user.Role != models.ADMIN {
+		var err error
+		authorized, expired, err = user.HasElevatedRights(&ID, table)
+		if err != nil {
+			return c.renderError(http.StatusInternalServerError, "error.db", err)
+		}
+	}
+
+	if (expired || elevated) && !authorized {
+		return c.renderError(http.StatusForbidden, "intercept.invalid.action", nil)
+	}
+	return nil
+}
+
+//authorizeEvent ensures that the token owner has elevated rights in the course and
+//that the event belongs to the course
+func (c API) authorizeEvent(ID, eventID int) revel.Result {
+
+	if result := c.authorizeCourse(ID, "courses", true); result != nil {
+		return result
+	}
+
+	belongs, err := models.BelongsToElement("events", "course_id", "id", ID, eventID)
+	if err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.db", err)
+	} else if !belongs {
+		return c.renderError(http.StatusNotFound, "intercept.invalid.action", nil)
+	}
+	return nil
+}
+
+//notifyFromWaitlist sends an e-mail to each user, who was auto enrolled from the
+//wait list, it returns the e-mail address of a failed e-mail
+func (c API) notifyFromWaitlist(data *models.EMailData, users models.Users) (email string,
+	err error) {
+
+	var mailData []models.EMailData
+	for _, user := range users {
+		mailData = append(mailData, models.EMailData{
+			User:        user,
+			CourseTitle: data.CourseTitle,
+			EventTitle:  data.EventTitle,
+			CourseID:    data.CourseID,
+		})
+	}
+	return sendBulkEMails(c.Controller, mailData, "email.subject.from.wait.list",
+		"fromWaitlist")
+}
+
+//renderError renders the message of a failed API request with the HTTP status
+func (c API) renderError(status int, msgKey string, err error,
+	args ...interface{}) revel.Result {
+
+	if err != nil {
+		c.Log.Error("api request failed", "status", status, "error", err.Error())
+		if status == http.StatusInternalServerError {
+			app.SendErrorNote()
+		}
+	}
+
+	c.Response.Status = status
+	return c.RenderJSON(apiError{Error: c.Message(msgKey, args...)})
+}
+
+//renderValidationError renders all validation errors of a failed API request
+func (c API) renderValidationError() revel.Result {
+
+	c.Response.Status = http.StatusUnprocessableEntity
+	return c.RenderJSON(apiError{Error: getErrorString(c.Validation.Errors)})
+}
+
+//newAPICourse converts a course into its API representation
+func newAPICourse(course *models.Course) (res apiCourse) {
+
+	res = apiCourse{
+		ID:              course.ID,
+		Title:           course.Title,
+		Subtitle:        course.Subtitle.String,
+		Description:     course.Description.String,
+		Speaker:         course.Speaker.String,
+		Visible:         course.Visible,
+		Active:          course.Active,
+		OnlyLDAP:        course.OnlyLDAP,
+		EnrollmentStart: course.EnrollmentStart,
+		EnrollmentEnd:   course.EnrollmentEnd,
+		ExpirationDate:  course.ExpirationDate,
+		Events:          newAPIEvents(course.Events),
+	}
+	if course.Fee.Valid {
+		res.Fee = &course.Fee.Float64
+	}
+	if course.UnsubscribeEnd.Valid {
+		res.UnsubscribeEnd = &course.UnsubscribeEnd.Time
+	}
+	return
+}
+
+//newAPIEvents converts events into their API representation
+func newAPIEvents(events models.Events) (res []apiEvent) {
+
+	res = []apiEvent{}
+	for _, event := range events {
+		res = append(res, apiEvent{
+			ID:          event.ID,
+			CourseID:    event.CourseID,
+			Title:       event.Title,
+			Annotation:  event.Annotation.String,
+			Capacity:    event.Capacity,
+			Fullness:    event.Fullness,
+			HasWaitlist: event.HasWaitlist,
+			HasKey:      event.EnrollmentKey.Valid,
+			Enrolled:    event.EventStatus.Enrolled,
+			OnWaitlist:  event.EventStatus.OnWaitlist,
+			Meetings:    newAPIMeetings(event.Meetings),
+		})
+	}
+	return
+}
+
+//newAPIMeetings converts meetings into their API representation
+func newAPIMeetings(meetings models.Meetings) (res []apiMeeting) {
+
+	res = []apiMeeting{}
+	for _, meeting := range meetings {
+		m := apiMeeting{
+			ID:         meeting.ID,
+			EventID:    meeting.EventID,
+			Interval:   meeting.MeetingInterval.String(),
+			Place:      meeting.Place.String,
+			Annotation: meeting.Annotation.String,
+			Start:      meeting.MeetingStart,
+			End:        meeting.MeetingEnd,
+		}
+		if meeting.WeekDay.Valid {
+			weekday := meeting.WeekDay.Int32
+			m.WeekDay = &weekday
+		}
+		res = append(res, m)
+	}
+	return
+}
+
+//newAPIParticipants converts the entries of a participant list into their API
+//representation
+func newAPIParticipants(entries models.Entries, viewMatrNr bool) (res []apiParticipant) {
+
+	res = []apiParticipant{}
+	for _, entry := range entries {
+		p := apiParticipant{
+			UserID:    entry.User.ID,
+			FirstName: entry.FirstName,
+			LastName:  entry.LastName,
+			EMail:     entry.EMail,
+			Status:    entry.Status.String(),
+			Enrolled:  entry.TimeOfEnrollmentStr,
+			Comment:   entry.Comment.String,
+		}
+		if viewMatrNr && entry.MatrNr.Valid {
+			matrNr := entry.MatrNr.Int32
+			p.MatrNr = &matrNr
+		}
+		res = append(res, p)
+	}
+	return
+}
@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"crypto/subtle"
+	"turm/app"
 	"turm/app/models"
 
 	"github.com/revel/revel"
@@ -139,3 +141,37 @@ func (c App) FAQs() revel.Result {
 
 	return c.Render(categories)
 }
+
+/*ReportBounce marks all recipients of a delivery status notification (bounce) as
+undeliverable. The raw notification is expected as the request body, e.g., piped
+from the local e-mail server.
+- Roles: all (requires the bounce token) */
+func (c App) ReportBounce(token string) revel.Result {
+
+	c.Log.Debug("report bounce")
+
+	valid := app.Mailer.BounceToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(app.Mailer.BounceToken)) == 1
+	if !valid {
+		c.Log.Warn("invalid bounce token")
+		c.Response.Status = 403
+		return c.RenderJSON(response{Status: ERROR, Msg: c.Message("intercept.invalid.action")})
+	}
+
+	recipients, err := app.ParseBounce(c.Request.GetBody())
+	if err != nil {
+		c.Log.Error("failed to parse bounce", "error", err.Error())
+		c.Response.Status = 400
+		return c.RenderJSON(response{Status: INVALID, Msg: err.Error()})
+	}
+
+	for _, recipient := range recipients {
+		if err = app.MarkUndeliverable(recipient); err != nil {
+			c.Response.Status = 500
+			return c.RenderJSON(response{Status: ERROR, Msg: c.Message(errDB.String())})
+		}
+	}
+
+	c.Log.Debug("marked recipients as undeliverable", "recipients", recipients)
+	return c.RenderJSON(response{Status: SUCCESS})
+}
@@ -7,6 +7,11 @@ type Admin struct {
 	*revel.Controller
 }
 
+/*API implements the versioned JSON API, authenticated with personal access tokens. */
+type API struct {
+	*revel.Controller
+}
+
 /*App implements logic to CRUD general page data. */
 type App struct {
 	*revel.Controller
@@ -47,6 +52,11 @@ type Enrollment struct {
 	*revel.Controller
 }
 
+/*Feed implements the public, cacheable feeds. */
+type Feed struct {
+	*revel.Controller
+}
+
 /*Manage implements the course management page. */
 type Manage struct {
 	*revel.Controller
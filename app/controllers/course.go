@@ -55,23 +55,38 @@ func (c Course) Open(ID int) revel.Result {
 	return c.Render(course)
 }
 
-/*Search for a specific course.
+/*Search for a specific course. The results can be restricted to a group subtree,
+to courses with an open enrollment period, to courses with free seats, to courses
+with (fee = paid) or without (fee = free) a fee and to LDAP-only courses.
 Roles: all (except not activated users). */
-func (c Course) Search(value string) revel.Result {
+func (c Course) Search(value string, group int, open, seats bool, fee string,
+	ldap bool) revel.Result {
 
-	c.Log.Debug("search courses", "value", value)
+	c.Log.Debug("search courses", "value", value, "group", group, "open", open,
+		"seats", seats, "fee", fee, "ldap", ldap)
 	c.Session["lastURL"] = c.Request.URL.String()
 
 	models.ValidateLength(&value, "validation.invalid.searchValue",
 		1, 127, c.Validation)
+	if fee != "" && fee != models.FeeFree && fee != models.FeePaid {
+		c.Validation.ErrorKey("validation.invalid.params")
+	}
 
 	if c.Validation.HasErrors() {
 		c.Validation.Keep()
 		return c.Render()
 	}
 
+	filter := models.CourseSearchFilter{
+		GroupID:        group,
+		EnrollmentOpen: open,
+		FreeSeats:      seats,
+		Fee:            fee,
+		OnlyLDAP:       ldap,
+	}
+
 	var courses models.CourseList
-	if err := courses.Search(value); err != nil {
+	if err := courses.Search(value, &filter); err != nil {
 		renderQuietError(errDB, err, c.Controller)
 		return c.Render()
 	}
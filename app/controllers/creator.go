@@ -18,6 +18,13 @@ func (c Creator) Activate(ID int) revel.Result {
 	//NOTE: the interceptor assures that the course ID is valid
 
 	course := models.Course{ID: ID}
+	required, err := course.RequiresApproval()
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if required {
+		return c.submitForReview(ID)
+	}
+
 	invalid, users, err := course.Activate(c.Validation)
 
 	if err != nil {
@@ -26,6 +33,9 @@ func (c Creator) Activate(ID int) revel.Result {
 		return flashError(errValidation, nil, "", c.Controller, "")
 	}
 
+	triggerWebhook(c.Controller, models.WebhookCourseActivation, 0,
+		&models.EMailData{CourseID: course.ID, CourseTitle: course.Title}, "")
+
 	//send notification e-mail to editors/instructors
 	for _, data := range users {
 
@@ -46,6 +56,39 @@ func (c Creator) Activate(ID int) revel.Result {
 	return c.Redirect(Manage.Active)
 }
 
+//submitForReview submits a course draft for review and notifies all reviewers
+func (c Creator) submitForReview(ID int) revel.Result {
+
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+
+	review := models.CourseReview{CourseID: ID}
+	invalid, reviewers, err := review.Submit(c.Validation, userID)
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if invalid {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	for _, data := range reviewers {
+		err = sendEMail(c.Controller, &data,
+			"email.subject.review.submitted",
+			"reviewSubmitted")
+
+		if err != nil {
+			return flashError(errEMail, err, "", c.Controller, data.User.EMail)
+		}
+	}
+
+	c.Flash.Success(c.Message("creator.course.submitted",
+		review.CourseTitle,
+		review.CourseID,
+	))
+	return c.Redirect(Manage.Drafts)
+}
+
 /*Delete a course (draft).
 - Roles: creator of the course */
 func (c Creator) Delete(ID int) revel.Result {
@@ -120,6 +163,68 @@ func (c Creator) Expire(ID int) revel.Result {
 	return c.Redirect(Manage.Expired)
 }
 
+/*ScheduleTransition schedules the activation, a change of the visibility or the
+expiration of a course at a future timestamp.
+- Roles: creator of the course */
+func (c Creator) ScheduleTransition(ID int, action, date, dueTime string) revel.Result {
+
+	c.Log.Debug("schedule course transition", "ID", ID, "action", action,
+		"date", date, "time", dueTime)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	//NOTE: the interceptor assures that the course ID is valid
+
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+
+	transition := models.ScheduledTransition{
+		CourseID: ID,
+		Action:   action,
+		Creator:  sql.NullInt32{Int32: int32(userID), Valid: true},
+		Due:      date + " " + dueTime,
+	}
+
+	if transition.Validate(c.Validation); c.Validation.HasErrors() {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	if err = transition.Insert(c.Validation); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if c.Validation.HasErrors() {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	c.Flash.Success(c.Message("creator.transition.scheduled",
+		c.Message("creator.transition."+transition.Action),
+		transition.Due,
+		ID,
+	))
+	return c.Redirect(c.Session["currPath"])
+}
+
+/*CancelTransition cancels a scheduled transition of a course that did not yet run.
+- Roles: creator of the course */
+func (c Creator) CancelTransition(ID, transitionID int) revel.Result {
+
+	c.Log.Debug("cancel scheduled course transition", "ID", ID,
+		"transitionID", transitionID)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	//NOTE: the interceptor assures that the course ID is valid
+
+	transition := models.ScheduledTransition{ID: transitionID, CourseID: ID}
+	if err := transition.Cancel(c.Validation); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if c.Validation.HasErrors() {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	c.Flash.Success(c.Message("creator.transition.cancelled", ID))
+	return c.Redirect(c.Session["currPath"])
+}
+
 /*New creates a new inactive course according to the specified parameters.
 - Roles: creator */
 func (c Creator) New(param models.NewCourseParam, file []byte) revel.Result {
@@ -161,6 +266,15 @@ func (c Creator) New(param models.NewCourseParam, file []byte) revel.Result {
 			return flashError(errValidation, nil, "", c.Controller, "")
 		}
 
+	} else if param.Option == models.TEMPLATE {
+
+		c.Log.Debug("insert course from template")
+		tmpl := models.CourseTemplate{ID: param.TemplateID}
+		err = tmpl.Instantiate(c.Validation, &course, param.EnrollmentStart)
+		if c.Validation.HasErrors() {
+			return flashError(errValidation, nil, "", c.Controller, "")
+		}
+
 	} else {
 
 		c.Log.Debug("insert uploaded course")
@@ -207,3 +321,18 @@ func (c Creator) Search(value string) revel.Result {
 
 	return c.Render(courses)
 }
+
+/*Templates returns all course templates from which the user can create new courses. */
+func (c Creator) Templates() revel.Result {
+
+	c.Log.Debug("load course templates")
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	var templates models.CourseTemplates
+	if err := templates.Select(); err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	}
+
+	return c.Render(templates)
+}
@@ -57,12 +57,8 @@ func (c Edit) Download(ID int, filename string) revel.Result {
 		return flashError(
 			errDB, err, "", c.Controller, "")
 	}
-	//reset some values
-	course.CreatorData = models.User{}
-	course.Path = models.Groups{}
-
-	//marshal the course data into json format
-	json, err := json.Marshal(course)
+	//marshal the course data into the current json format
+	json, err := json.Marshal(course.Export())
 	if err != nil {
 		return flashError(
 			errTypeConv, err, "", c.Controller, "")
@@ -467,6 +463,10 @@ func (c Edit) ChangeText(ID int, fieldID, value string, conf models.EditEMailCon
 
 			models.ValidateLength(&value, "validation.invalid.text.area",
 				3, 50000, c.Validation)
+
+			if fieldID == models.ColCustomEMail {
+				models.ValidateCustomEMail(&value, c.Validation)
+			}
 		}
 
 		if c.Validation.HasErrors() {
@@ -534,6 +534,39 @@ func (c Edit) ChangeText(ID int, fieldID, value string, conf models.EditEMailCon
 		response{Status: SUCCESS, Msg: msg, FieldID: fieldID, Value: value})
 }
 
+/*PreviewCustomEMail renders the custom enrollment e-mail for a sample participant.
+- Roles: creator and editors of the course */
+func (c Edit) PreviewCustomEMail(ID int, value string, onWaitlist bool) revel.Result {
+
+	c.Log.Debug("preview custom e-mail", "ID", ID, "value", value,
+		"onWaitlist", onWaitlist)
+
+	//NOTE: the interceptor assures that the course ID is valid
+
+	value = strings.TrimSpace(value)
+	models.ValidateCustomEMail(&value, c.Validation)
+	if c.Validation.HasErrors() {
+		return c.RenderJSON(
+			response{Status: INVALID, Msg: getErrorString(c.Validation.Errors)})
+	}
+
+	language := c.Session["currentLocale"].(string)
+	data := models.CustomEMailData{OnWaitlist: onWaitlist}
+	if err := data.GetSample(ID, language); err != nil {
+		return c.RenderJSON(
+			response{Status: ERROR, Msg: c.Message(errDB.String())})
+	}
+
+	body, err := data.Render(&value, language)
+	if err != nil {
+		return c.RenderJSON(
+			response{Status: ERROR, Msg: c.Message(errContent.String())})
+	}
+
+	return c.RenderJSON(
+		response{Status: SUCCESS, Value: body})
+}
+
 /*ChangeGroup of a course.
 - Roles: creator and editors of the course */
 func (c Edit) ChangeGroup(ID, parentID int, conf models.EditEMailConfig) revel.Result {
@@ -701,3 +734,154 @@ func (c Edit) SearchUser(ID int, value, listType string, searchInactive bool) re
 
 	return c.Render(users, listType)
 }
+
+/*Webhooks renders all webhooks of a course and their delivery logs.
+- Roles: creator and editors of the course */
+func (c Edit) Webhooks(ID int) revel.Result {
+
+	c.Log.Debug("render webhooks of course", "ID", ID)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	//NOTE: the interceptor assures that the course ID is valid
+
+	return renderWebhooks(c.Controller, ID)
+}
+
+/*NewWebhook inserts a new webhook of a course.
+- Roles: creator and editors of the course */
+func (c Edit) NewWebhook(ID int, webhook models.Webhook) revel.Result {
+
+	c.Log.Debug("insert webhook of course", "ID", ID, "webhook", webhook)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	//NOTE: the interceptor assures that the course ID is valid
+
+	return insertWebhook(c.Controller, ID, &webhook)
+}
+
+/*DeleteWebhook deletes a webhook of a course and its delivery log.
+- Roles: creator and editors of the course */
+func (c Edit) DeleteWebhook(ID, webhookID int) revel.Result {
+
+	c.Log.Debug("delete webhook of course", "ID", ID, "webhookID", webhookID)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	//NOTE: the interceptor assures that the course ID is valid
+
+	return deleteWebhook(c.Controller, ID, webhookID)
+}
+
+/*TestWebhook queues a test delivery to a webhook of a course.
+- Roles: creator and editors of the course */
+func (c Edit) TestWebhook(ID, webhookID int) revel.Result {
+
+	c.Log.Debug("test webhook of course", "ID", ID, "webhookID", webhookID)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	//NOTE: the interceptor assures that the course ID is valid
+
+	return testWebhook(c.Controller, ID, webhookID)
+}
+
+/*Revisions renders the revision history of a course.
+- Roles: creator and editors of the course */
+func (c Edit) Revisions(ID int) revel.Result {
+
+	c.Log.Debug("render revisions of course", "ID", ID)
+	c.Session["currPath"] = c.Request.URL.String()
+	c.Session["lastURL"] = c.Request.URL.String()
+	c.ViewArgs["tab"] = c.Message("creator.tab")
+
+	//NOTE: the interceptor assures that the course ID is valid
+
+	course := models.Course{ID: ID}
+	if err := course.GetColumnValue(nil, models.ColTitle); err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	}
+
+	var revisions models.CourseRevisions
+	if err := revisions.Select(ID); err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	}
+
+	return c.Render(course, revisions)
+}
+
+/*Revision renders the differences between a revision of a course and another revision.
+If compareID is 0, then the revision is compared to the current course data, and its
+values can be restored.
+- Roles: creator and editors of the course */
+func (c Edit) Revision(ID, revisionID, compareID int) revel.Result {
+
+	c.Log.Debug("render revision of course", "ID", ID, "revisionID", revisionID,
+		"compareID", compareID)
+	c.Session["currPath"] = c.Request.URL.String()
+	c.Session["lastURL"] = c.Request.URL.String()
+	c.ViewArgs["tab"] = c.Message("creator.tab")
+
+	//NOTE: the interceptor assures that the course ID is valid
+
+	revision := models.CourseRevision{ID: revisionID, CourseID: ID}
+	changes, err := revision.Diff(compareID)
+	if err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	}
+
+	return c.Render(revision, changes, compareID)
+}
+
+/*RestoreRevision restores the values of a revision of a course. If key is empty, then
+all values are restored, else only the respective field or user list entry.
+- Roles: creator and editors of the course */
+func (c Edit) RestoreRevision(ID, revisionID int, key string) revel.Result {
+
+	c.Log.Debug("restore revision of course", "ID", ID, "revisionID", revisionID,
+		"key", key)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	//NOTE: the interceptor assures that the course ID is valid
+
+	revision := models.CourseRevision{ID: revisionID, CourseID: ID}
+	restored, userLists, err := revision.Restore(c.Validation, key)
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if c.Validation.HasErrors() {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	//editors and instructors of active courses get the same notification e-mails
+	//as when editing the user lists
+	for _, data := range userLists.Added {
+		err = sendEMail(c.Controller, &data,
+			"email.subject.new.course.role",
+			"newCourseRole")
+
+		if err != nil {
+			return flashError(errEMail, err, "", c.Controller, data.User.EMail)
+		}
+	}
+	for _, data := range userLists.Removed {
+		err = sendEMail(c.Controller, &data,
+			"email.subject.course.role.deleted",
+			"deleteCourseRole")
+
+		if err != nil {
+			return flashError(errEMail, err, "", c.Controller, data.User.EMail)
+		}
+	}
+	for _, data := range userLists.ViewMatrNr {
+		err = sendEMail(c.Controller, &data,
+			"email.subject.course.role.authorization",
+			"changeViewMatrNr")
+
+		if err != nil {
+			return flashError(errEMail, err, "", c.Controller, data.User.EMail)
+		}
+	}
+
+	c.Flash.Success(c.Message("revision.restore.success", restored, revision.Created))
+	return c.Redirect(c.Session["currPath"])
+}
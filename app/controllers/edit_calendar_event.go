@@ -81,14 +81,11 @@ func (c EditCalendarEvent) Delete(ID, courseID int) revel.Result {
 	}
 
 	//send e-mail to all upcoming slots if the course is active
-	for _, user := range users {
-
-		err = sendEMail(c.Controller, &user,
-			"email.subject.from.slot",
-			"manualRemove")
-		if err != nil {
-			return flashError(errEMail, err, "", c.Controller, user.User.EMail)
-		}
+	recipient, err := sendBulkEMails(c.Controller, users,
+		"email.subject.from.slot",
+		"manualRemove")
+	if err != nil {
+		return flashError(errEMail, err, "", c.Controller, recipient)
 	}
 
 	c.Flash.Success(c.Message("event.calendar.delete.success", ID))
@@ -164,14 +161,11 @@ func (c EditCalendarEvent) EditDayTemplate(ID, courseID int, tmpl models.DayTmpl
 	//TODO: when updating, validate that the tmpl ID fits the calendar event ID
 
 	//send e-mail to each user that got removed from its slot
-	for _, user := range users {
-
-		err = sendEMail(c.Controller, &user,
-			"email.subject.from.slot",
-			"manualRemove")
-		if err != nil {
-			return flashError(errEMail, err, "", c.Controller, user.User.EMail)
-		}
+	recipient, err := sendBulkEMails(c.Controller, users,
+		"email.subject.from.slot",
+		"manualRemove")
+	if err != nil {
+		return flashError(errEMail, err, "", c.Controller, recipient)
 	}
 
 	c.Flash.Success(c.Message("day.tmpl.edit.success", tmpl.ID))
@@ -211,14 +205,11 @@ func (c EditCalendarEvent) ChangeException(ID, courseID int, exception models.Ex
 	}
 
 	//send e-mail to each user that got removed from its slot
-	for _, user := range users {
-
-		err = sendEMail(c.Controller, &user,
-			"email.subject.from.slot",
-			"manualRemove")
-		if err != nil {
-			return flashError(errEMail, err, "", c.Controller, user.User.EMail)
-		}
+	recipient, err := sendBulkEMails(c.Controller, users,
+		"email.subject.from.slot",
+		"manualRemove")
+	if err != nil {
+		return flashError(errEMail, err, "", c.Controller, recipient)
 	}
 
 	c.Flash.Success(c.Message("exception.change.success", exception.ID))
@@ -263,14 +254,11 @@ func (c EditCalendarEvent) DeleteDayTemplate(ID, courseID int) revel.Result {
 	}
 
 	//send e-mail to each user that got removed from its slot
-	for _, user := range users {
-
-		err = sendEMail(c.Controller, &user,
-			"email.subject.from.slot",
-			"manualRemove")
-		if err != nil {
-			return flashError(errEMail, err, "", c.Controller, user.User.EMail)
-		}
+	recipient, err := sendBulkEMails(c.Controller, users,
+		"email.subject.from.slot",
+		"manualRemove")
+	if err != nil {
+		return flashError(errEMail, err, "", c.Controller, recipient)
 	}
 
 	c.Flash.Success(c.Message("day.tmpl.delete.success", ID))
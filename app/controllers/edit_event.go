@@ -126,15 +126,15 @@ func (c EditEvent) ChangeCapacity(ID int, fieldID string, value int) revel.Resul
 	}
 
 	//auto enroll users from wait list if the capacity is changed
-	for _, user := range users {
-
-		err = sendEMail(c.Controller, &user,
-			"email.subject.from.wait.list",
-			"fromWaitlist")
+	for key := range users {
+		triggerWebhook(c.Controller, models.WebhookWaitlistPromotion, ID, &users[key], "")
+	}
 
-		if err != nil {
-			return flashError(errEMail, err, "", c.Controller, user.User.EMail)
-		}
+	recipient, err := sendBulkEMails(c.Controller, users,
+		"email.subject.from.wait.list",
+		"fromWaitlist")
+	if err != nil {
+		return flashError(errEMail, err, "", c.Controller, recipient)
 	}
 
 	msg := c.Message("event.capacity.change.success", event.Capacity)
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"database/sql"
 	"turm/app"
 	"turm/app/models"
 
@@ -11,15 +12,75 @@ import (
 func sendEMail(c *revel.Controller, data *models.EMailData, subjectKey string,
 	filename string) (err error) {
 
+	return queueEMail(c, data, subjectKey, filename, false)
+}
+
+//sendForcedEMail sends an e-mail to the specified user, even if the e-mail address
+//of the user is marked as undeliverable
+func sendForcedEMail(c *revel.Controller, data *models.EMailData, subjectKey string,
+	filename string) (err error) {
+
+	return queueEMail(c, data, subjectKey, filename, true)
+}
+
+//queueEMail renders an e-mail and adds it to the e-mail queue
+func queueEMail(c *revel.Controller, data *models.EMailData, subjectKey string,
+	filename string, force bool) (err error) {
+
 	c.Log.Debug("sending EMail", "subjectKey", subjectKey,
-		"filename", filename)
+		"filename", filename, "force", force)
+
+	email, err := renderEMail(c, data, subjectKey, filename, force)
+	if err != nil {
+		return
+	}
+
+	queue := []app.EMail{email}
+	archiveEMails(c, data.CourseID, queue)
+	app.EMailQueue <- queue[0]
+	return
+}
+
+//sendBulkEMails renders an e-mail for each user and sends them, course related
+//e-mails are archived once for all recipients, it returns the recipient whose
+//e-mail failed to render
+func sendBulkEMails(c *revel.Controller, data []models.EMailData, subjectKey string,
+	filename string) (recipient string, err error) {
+
+	c.Log.Debug("sending bulk EMails", "subjectKey", subjectKey,
+		"filename", filename, "recipients", len(data))
+
+	if len(data) == 0 {
+		return
+	}
+
+	var queue []app.EMail
+	for i := range data {
+		email, err := renderEMail(c, &data[i], subjectKey, filename, false)
+		if err != nil {
+			return data[i].User.EMail, err
+		}
+		queue = append(queue, email)
+	}
+
+	archiveEMails(c, data[0].CourseID, queue)
+	for _, email := range queue {
+		app.EMailQueue <- email
+	}
+	return
+}
+
+//renderEMail renders the subject and body of an e-mail in the language of the user
+func renderEMail(c *revel.Controller, data *models.EMailData, subjectKey string,
+	filename string, force bool) (email app.EMail, err error) {
 
 	if !data.User.Language.Valid {
 		data.User.Language.String = app.DefaultLanguage
 	}
 
-	email := app.EMail{
+	email = app.EMail{
 		Recipient: data.User.EMail,
+		Force:     force,
 	}
 
 	err = models.GetEMailSubjectBody(
@@ -30,12 +91,68 @@ func sendEMail(c *revel.Controller, data *models.EMailData, subjectKey string,
 		&email,
 		c,
 	)
-	if err != nil {
+	return
+}
+
+//archiveEMails stores e-mails in the sent e-mail archive of a course, e-mails with the
+//same rendered subject and body are archived once with all their recipients, failing
+//to archive the e-mails does not prevent sending them
+func archiveEMails(c *revel.Controller, courseID int, queue []app.EMail) {
+
+	if courseID == 0 || len(queue) == 0 {
 		return
 	}
 
-	app.EMailQueue <- email
-	return
+	var sender sql.NullInt32
+	userID, err := getIntFromSession(c, "userID")
+	if err != nil {
+		c.Log.Error("failed to get the sender of archived e-mails", "courseID", courseID,
+			"error", err.Error())
+	} else if userID != 0 {
+		sender = sql.NullInt32{Int32: int32(userID), Valid: true}
+	}
+
+	//group the e-mails by their rendered subject and body, keeping the queue order
+	type content struct {
+		subject string
+		body    string
+	}
+	var order []content
+	groups := make(map[content][]int)
+	for i := range queue {
+		key := content{subject: queue[i].Subject, body: queue[i].Body}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	for _, key := range order {
+
+		indices := groups[key]
+		group := make([]app.EMail, 0, len(indices))
+		for _, i := range indices {
+			group = append(group, queue[i])
+		}
+
+		sentEMail := models.SentEMail{
+			CourseID: courseID,
+			Sender:   sender,
+			Subject:  group[0].Subject,
+			Content:  app.HTMLFromMimeFormat(&group[0].Body),
+		}
+
+		if err = sentEMail.Archive(nil, group); err != nil {
+			c.Log.Error("failed to archive e-mails, sending them anyway", "courseID", courseID,
+				"recipients", len(group), "error", err.Error())
+			continue
+		}
+
+		//the mailer updates the delivery state by the archive ID of each e-mail
+		for j, i := range indices {
+			queue[i].ArchiveID = group[j].ArchiveID
+		}
+	}
 }
 
 //sendEMailsEdit to users/editors/instructors after editing the course
@@ -59,11 +176,13 @@ func sendEMailsEdit(c *revel.Controller, conf *models.EditEMailConfig) (err erro
 	}
 
 	//send to users
+	var users []models.EMailData
 	for _, user := range conf.Users {
 		data.User = user
-		if err = sendEMail(c, &data, subject, file); err != nil {
-			return
-		}
+		users = append(users, data)
+	}
+	if _, err = sendBulkEMails(c, users, subject, file); err != nil {
+		return
 	}
 
 	subject = "email.subject.course.edit.manager"
@@ -72,12 +191,11 @@ func sendEMailsEdit(c *revel.Controller, conf *models.EditEMailConfig) (err erro
 	}
 
 	//send to editors/instructors
+	var managers []models.EMailData
 	for _, user := range conf.EditorsInstructors {
 		data.User = user
-		if err = sendEMail(c, &data, subject, file); err != nil {
-			return
-		}
+		managers = append(managers, data)
 	}
-
+	_, err = sendBulkEMails(c, managers, subject, file)
 	return
 }
@@ -38,6 +38,8 @@ func (c Enrollment) Enroll(ID int, key, comment string) revel.Result {
 		return flashError(errValidation, nil, "", c.Controller, "")
 	}
 
+	triggerWebhook(c.Controller, models.WebhookEnroll, ID, &data, enrolled.Status.String())
+
 	//send e-mail to the user
 	if waitList {
 		err = sendEMail(c.Controller, &data,
@@ -81,6 +83,9 @@ func (c Enrollment) Unsubscribe(ID int) revel.Result {
 		return flashError(errValidation, nil, "", c.Controller, "")
 	}
 
+	triggerWebhook(c.Controller, models.WebhookUnsubscribe, ID, &data, "")
+	triggerWaitlistWebhooks(c.Controller, ID, &data, users)
+
 	//send e-mail to the user who unsubscribed
 	if waitList {
 		err = sendEMail(c.Controller, &data,
@@ -97,22 +102,20 @@ func (c Enrollment) Unsubscribe(ID int) revel.Result {
 	}
 
 	//send e-mail to each auto enrolled user
+	var mailData []models.EMailData
 	for _, user := range users {
-
-		mailData := models.EMailData{
+		mailData = append(mailData, models.EMailData{
 			User:        user,
 			CourseTitle: data.CourseTitle,
 			EventTitle:  data.EventTitle,
 			CourseID:    data.CourseID,
-		}
-
-		err = sendEMail(c.Controller, &mailData,
-			"email.subject.from.wait.list",
-			"fromWaitlist")
-
-		if err != nil {
-			return flashError(errEMail, err, "", c.Controller, mailData.User.EMail)
-		}
+		})
+	}
+	recipient, err := sendBulkEMails(c.Controller, mailData,
+		"email.subject.from.wait.list",
+		"fromWaitlist")
+	if err != nil {
+		return flashError(errEMail, err, "", c.Controller, recipient)
 	}
 
 	c.Flash.Success(c.Message("event.unsubscribe.success"))
@@ -160,6 +163,8 @@ func (c Enrollment) EnrollInSlot(ID, courseID, year, day int, startTime, endTime
 		return flashError(errValidation, err, path, c.Controller, "")
 	}
 
+	triggerWebhook(c.Controller, models.WebhookSlotBooking, ID, &data, "")
+
 	//send e-mail to the user who enrolled
 	err = sendEMail(c.Controller, &data,
 		"email.subject.enroll.slot",
@@ -0,0 +1,425 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"turm/app"
+	"turm/app/models"
+
+	"github.com/revel/revel"
+)
+
+//feedMaxAge is the number of seconds that (shared) caches may store a feed
+const feedMaxAge = 300
+
+//catalog is the JSON representation of the public course catalog
+type catalog struct {
+	Title   string          `json:"title"`
+	GroupID int             `json:"group_id,omitempty"`
+	Updated time.Time       `json:"updated"`
+	Courses []catalogCourse `json:"courses"`
+}
+
+//catalogCourse is the JSON representation of a course in the public course catalog
+type catalogCourse struct {
+	ID              int            `json:"id"`
+	URL             string         `json:"url"`
+	Title           string         `json:"title"`
+	Subtitle        string         `json:"subtitle,omitempty"`
+	Speaker         string         `json:"speaker,omitempty"`
+	Fee             *float64       `json:"fee,omitempty"`
+	OnlyLDAP        bool           `json:"only_ldap"`
+	EnrollmentStart time.Time      `json:"enrollment_start"`
+	EnrollmentEnd   time.Time      `json:"enrollment_end"`
+	UnsubscribeEnd  *time.Time     `json:"unsubscribe_end,omitempty"`
+	ExpirationDate  time.Time      `json:"expiration_date"`
+	Events          []catalogEvent `json:"events"`
+}
+
+//catalogEvent is the JSON representation of an event in the public course catalog
+type catalogEvent struct {
+	ID          int          `json:"id"`
+	Title       string       `json:"title"`
+	Annotation  string       `json:"annotation,omitempty"`
+	Capacity    int          `json:"capacity"`
+	FreeSeats   int          `json:"free_seats"`
+	HasWaitlist bool         `json:"has_waitlist"`
+	HasKey      bool         `json:"has_enrollment_key"`
+	Meetings    []apiMeeting `json:"meetings"`
+}
+
+//atomFeed is an Atom feed (RFC 4287)
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+//atomAuthor is the author of an Atom feed
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+//atomLink is a link of an Atom feed or entry
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+//atomEntry is an entry of an Atom feed
+type atomEntry struct {
+	ID        string   `xml:"id"`
+	Title     string   `xml:"title"`
+	Updated   string   `xml:"updated"`
+	Published string   `xml:"published,omitempty"`
+	Link      atomLink `xml:"link"`
+	Content   atomText `xml:"content"`
+}
+
+//atomText is a text construct of an Atom entry
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+//rssFeed is a RSS 2.0 feed
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+//rssChannel is the channel of a RSS 2.0 feed
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+//rssItem is an item of a RSS 2.0 feed
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+//rssGUID is the unique ID of a RSS 2.0 item
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+//notModified answers conditional requests of unchanged feeds
+type notModified struct{}
+
+/*Apply writes the not modified status without a body. */
+func (r notModified) Apply(req *revel.Request, resp *revel.Response) {
+	resp.WriteHeader(http.StatusNotModified, "")
+}
+
+/*Catalog renders the public course catalog as JSON, Atom or RSS feed. It contains all
+visible and active courses of the group subtree (group != 0) or of all groups. The
+language defaults to the current locale.
+- Roles: all */
+func (c Feed) Catalog(format string, group int, language string) revel.Result {
+
+	c.Log.Debug("render course catalog", "format", format, "group", group,
+		"language", language)
+
+	language, public, supported := c.feedLanguage(language)
+	if !supported {
+		return c.NotFound(c.Message("validation.invalid.language"))
+	}
+
+	data := models.Catalog{GroupID: group}
+	found, err := data.Select()
+	if err != nil {
+		c.Log.Error("failed to render course catalog", "error", err.Error())
+		app.SendErrorNote()
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderText(c.Message("error.db"))
+	} else if !found {
+		return c.NotFound(c.Message("feed.group.not.found"))
+	}
+
+	title := c.Message("feed.catalog.title")
+	if data.GroupName != "" {
+		title = c.Message("feed.catalog.title.group", data.GroupName)
+	}
+	selfURL := app.Mailer.URL + c.Request.URL.String()
+	if data.Updated.IsZero() {
+		data.Updated = time.Now()
+	}
+
+	switch format {
+	case "atom":
+		feed := atomFeed{
+			ID:      selfURL,
+			Title:   title,
+			Updated: data.Updated.Format(time.RFC3339),
+			Author:  atomAuthor{Name: revel.AppName},
+			Links: []atomLink{
+				{Href: selfURL, Rel: "self", Type: "application/atom+xml"},
+				{Href: app.Mailer.URL + "/", Rel: "alternate", Type: "text/html"},
+			},
+			Entries: []atomEntry{},
+		}
+		for _, course := range data.Courses {
+			URL := courseURL(course.ID)
+			feed.Entries = append(feed.Entries, atomEntry{
+				ID:        URL,
+				Title:     course.Title,
+				Updated:   course.LastModified.Format(time.RFC3339),
+				Published: course.CreationDate.Format(time.RFC3339),
+				Link:      atomLink{Href: URL, Rel: "alternate", Type: "text/html"},
+				Content:   atomText{Type: "html", Body: c.catalogSummary(&course)},
+			})
+		}
+		return c.renderFeed(feed, "application/atom+xml; charset=utf-8", public)
+
+	case "rss":
+		feed := rssFeed{
+			Version: "2.0",
+			Channel: rssChannel{
+				Title:         title,
+				Link:          app.Mailer.URL + "/",
+				Description:   title,
+				LastBuildDate: data.Updated.Format(time.RFC1123Z),
+				Items:         []rssItem{},
+			},
+		}
+		for _, course := range data.Courses {
+			URL := courseURL(course.ID)
+			feed.Channel.Items = append(feed.Channel.Items, rssItem{
+				Title:       course.Title,
+				Link:        URL,
+				GUID:        rssGUID{IsPermaLink: true, Value: URL},
+				PubDate:     course.CreationDate.Format(time.RFC1123Z),
+				Description: c.catalogSummary(&course),
+			})
+		}
+		return c.renderFeed(feed, "application/rss+xml; charset=utf-8", public)
+	}
+
+	res := catalog{
+		Title:   title,
+		GroupID: group,
+		Updated: data.Updated,
+		Courses: []catalogCourse{},
+	}
+	for _, course := range data.Courses {
+		res.Courses = append(res.Courses, newCatalogCourse(&course))
+	}
+	return c.renderFeed(res, "application/json; charset=utf-8", public)
+}
+
+/*News renders the news feed entries of a language as Atom feed. The language defaults
+to the current locale.
+- Roles: all */
+func (c Feed) News(language string) revel.Result {
+
+	c.Log.Debug("render news feed", "language", language)
+
+	language, public, supported := c.feedLanguage(language)
+	if !supported {
+		return c.NotFound(c.Message("validation.invalid.language"))
+	}
+
+	var entries models.HelpPageEntries
+	if err := entries.SelectNewsFeed(language); err != nil {
+		app.SendErrorNote()
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderText(c.Message("error.db"))
+	}
+
+	selfURL := app.Mailer.URL + c.Request.URL.String()
+	newsURL := app.Mailer.URL + "/app/news"
+	feed := atomFeed{
+		ID:      newsURL + "?language=" + language,
+		Title:   c.Message("feed.news.title"),
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: revel.AppName},
+		Links: []atomLink{
+			{Href: selfURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: newsURL, Rel: "alternate", Type: "text/html"},
+		},
+		Entries: []atomEntry{},
+	}
+	if len(entries) != 0 {
+		//entries are ordered by their last edited timestamp
+		feed.Updated = entries[0].LastEdited
+	}
+
+	for _, entry := range entries {
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      newsURL + "?entry=" + strconv.Itoa(entry.ID),
+			Title:   entry.CategoryName,
+			Updated: entry.LastEdited,
+			Link:    atomLink{Href: newsURL, Rel: "alternate", Type: "text/html"},
+			Content: atomText{Type: "html", Body: entry.Content},
+		})
+	}
+	return c.renderFeed(feed, "application/atom+xml; charset=utf-8", public)
+}
+
+//feedLanguage returns the language of a feed and sets it as the locale of the request.
+//Without language parameter, the feed uses the language of the session. As the session
+//is not part of the cache key, such feeds must not be stored by shared caches.
+func (c Feed) feedLanguage(language string) (lang string, public, supported bool) {
+
+	public = language != ""
+	if !public {
+		language = app.DefaultLanguage
+		if locale, ok := c.Session["currentLocale"].(string); ok {
+			language = locale
+		}
+	}
+
+	for _, l := range app.Languages {
+		if l == language {
+			supported = true
+		}
+	}
+	if supported {
+		c.Request.Locale = language
+	}
+	return language, public, supported
+}
+
+//renderFeed renders a cacheable feed. Clients sending the ETag of the current
+//feed receive an empty response. Only public feeds may be stored by shared caches.
+func (c Feed) renderFeed(feed interface{}, contentType string, public bool) revel.Result {
+
+	var body []byte
+	var err error
+	if strings.HasPrefix(contentType, "application/json") {
+		body, err = json.Marshal(feed)
+	} else {
+		body, err = xml.MarshalIndent(feed, "", "  ")
+		body = append([]byte(xml.Header), body...)
+	}
+	if err != nil {
+		c.Log.Error("failed to marshal feed", "error", err.Error())
+		app.SendErrorNote()
+		c.Response.Status = http.StatusInternalServerError
+		return c.RenderText(c.Message("error.undefined"))
+	}
+
+	hash := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(hash[:16]) + `"`
+
+	header := c.Response.Out.Header()
+	if public {
+		header.Set("Cache-Control", "public, max-age="+strconv.Itoa(feedMaxAge))
+	} else {
+		header.Set("Cache-Control", "private, max-age="+strconv.Itoa(feedMaxAge))
+	}
+	header.Set("ETag", etag)
+	header.Set("Access-Control-Allow-Origin", "*")
+
+	if c.Request.Header.Get("If-None-Match") == etag {
+		return notModified{}
+	}
+
+	c.Response.ContentType = contentType
+	return c.RenderText(string(body))
+}
+
+//catalogSummary returns the HTML summary of a course in the Atom and RSS feeds
+func (c Feed) catalogSummary(course *models.Course) string {
+
+	var summary strings.Builder
+	if course.Subtitle.Valid {
+		summary.WriteString("<p>" + html.EscapeString(course.Subtitle.String) + "</p>")
+	}
+	if course.Speaker.Valid {
+		summary.WriteString("<p>" + html.EscapeString(c.Message("course.speaker")) + ": " +
+			html.EscapeString(course.Speaker.String) + "</p>")
+	}
+	summary.WriteString("<p>" + html.EscapeString(c.Message("course.enrollment.period")) +
+		": " + course.EnrollmentStartStr + " - " + course.EnrollmentEndStr + "</p>")
+
+	summary.WriteString("<ul>")
+	for _, event := range course.Events {
+		summary.WriteString("<li>" + html.EscapeString(event.Title) + ": " +
+			html.EscapeString(c.Message("feed.catalog.free.seats", freeSeats(&event),
+				event.Capacity)))
+		for _, meeting := range event.Meetings {
+			summary.WriteString("<br>" + meeting.MeetingStartStr + " - " +
+				meeting.MeetingEndStr)
+			if meeting.Place.Valid {
+				summary.WriteString(", " + html.EscapeString(meeting.Place.String))
+			}
+		}
+		summary.WriteString("</li>")
+	}
+	summary.WriteString("</ul>")
+	return summary.String()
+}
+
+//newCatalogCourse converts a course into its catalog representation
+func newCatalogCourse(course *models.Course) (res catalogCourse) {
+
+	res = catalogCourse{
+		ID:              course.ID,
+		URL:             courseURL(course.ID),
+		Title:           course.Title,
+		Subtitle:        course.Subtitle.String,
+		Speaker:         course.Speaker.String,
+		OnlyLDAP:        course.OnlyLDAP,
+		EnrollmentStart: course.EnrollmentStart,
+		EnrollmentEnd:   course.EnrollmentEnd,
+		ExpirationDate:  course.ExpirationDate,
+		Events:          []catalogEvent{},
+	}
+	if course.Fee.Valid {
+		res.Fee = &course.Fee.Float64
+	}
+	if course.UnsubscribeEnd.Valid {
+		res.UnsubscribeEnd = &course.UnsubscribeEnd.Time
+	}
+
+	for _, event := range course.Events {
+		res.Events = append(res.Events, catalogEvent{
+			ID:          event.ID,
+			Title:       event.Title,
+			Annotation:  event.Annotation.String,
+			Capacity:    event.Capacity,
+			FreeSeats:   freeSeats(&event),
+			HasWaitlist: event.HasWaitlist,
+			HasKey:      event.EnrollmentKey.Valid,
+			Meetings:    newAPIMeetings(event.Meetings),
+		})
+	}
+	return
+}
+
+//freeSeats returns the number of free seats of an event
+func freeSeats(event *models.Event) int {
+
+	if event.Fullness >= event.Capacity {
+		return 0
+	}
+	return event.Capacity - event.Fullness
+}
+
+//courseURL returns the public URL of a course
+func courseURL(courseID int) string {
+	return app.Mailer.URL + "/course/open?ID=" + strconv.Itoa(courseID)
+}
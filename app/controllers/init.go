@@ -16,6 +16,7 @@ func init() {
 
 	//prevent unauthorized actions
 	revel.InterceptMethod(Admin.auth, revel.BEFORE)
+	revel.InterceptMethod(API.auth, revel.BEFORE)
 	revel.InterceptMethod(App.auth, revel.BEFORE)
 	revel.InterceptMethod(Course.auth, revel.BEFORE)
 	revel.InterceptMethod(Creator.auth, revel.BEFORE)
@@ -27,6 +28,16 @@ func init() {
 	revel.InterceptMethod(Manage.auth, revel.BEFORE)
 	revel.InterceptMethod(Participants.auth, revel.BEFORE)
 	revel.InterceptMethod(User.auth, revel.BEFORE)
+
+	//record the revision history of courses
+	revel.InterceptFunc(recordRevisionBefore, revel.BEFORE, &Edit{})
+	revel.InterceptFunc(recordRevisionBefore, revel.BEFORE, &EditEvent{})
+	revel.InterceptFunc(recordRevisionBefore, revel.BEFORE, &EditCalendarEvent{})
+	revel.InterceptFunc(recordRevisionBefore, revel.BEFORE, &EditMeeting{})
+	revel.InterceptFunc(recordRevisionAfter, revel.AFTER, &Edit{})
+	revel.InterceptFunc(recordRevisionAfter, revel.AFTER, &EditEvent{})
+	revel.InterceptFunc(recordRevisionAfter, revel.AFTER, &EditCalendarEvent{})
+	revel.InterceptFunc(recordRevisionAfter, revel.AFTER, &EditMeeting{})
 }
 
 func getTimestamp(str string, c *revel.Controller, valid bool, fieldID string) (t time.Time, err error) {
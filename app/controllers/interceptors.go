@@ -1,14 +1,44 @@
 package controllers
 
 import (
+	"database/sql"
 	"errors"
+	"net/http"
 	"strconv"
+	"strings"
 	"turm/app"
 	"turm/app/models"
 
 	"github.com/revel/revel"
 )
 
+//readOnlyActions are all actions that do not change any data, they can be executed
+//during read-only impersonations
+var readOnlyActions = map[string]bool{
+	"App.Index": true, "App.ChangeLanguage": true, "App.Groups": true,
+	"App.DataPrivacy": true, "App.Imprint": true, "App.FAQs": true, "App.News": true,
+	"Course.Open": true, "Course.Search": true, "Course.EditorInstructorList": true,
+	"Course.Allowlist": true, "Course.Blocklist": true, "Course.Path": true,
+	"Course.Restrictions": true, "Course.Events": true, "Course.Meetings": true,
+	"Course.CalendarEvents": true, "Course.CalendarEvent": true,
+	"Creator.Search": true, "Creator.Templates": true,
+	"Feed.Catalog": true, "Feed.News": true, "Edit.Open": true, "Edit.Webhooks": true,
+	"Edit.Revisions": true, "Edit.Revision": true,
+	"Manage.Active": true, "Manage.Drafts": true, "Manage.Expired": true, "Manage.Reviews": true,
+	"Participants.Open": true, "Participants.SentEMails": true,
+	"Participants.ScheduledEMails": true, "Participants.Days": true,
+	"User.Profile": true, "User.Logout": true, "User.StopImpersonation": true,
+}
+
+//noRevisionActions are all actions of the edit controllers that do not change
+//the course data, so that they do not record a revision
+var noRevisionActions = map[string]bool{
+	"Edit.Open": true, "Edit.Download": true, "Edit.Validate": true,
+	"Edit.PreviewCustomEMail": true, "Edit.SearchUser": true, "Edit.Webhooks": true,
+	"Edit.NewWebhook": true, "Edit.DeleteWebhook": true, "Edit.TestWebhook": true,
+	"Edit.Revisions": true, "Edit.Revision": true,
+}
+
 //general intercepts each revel controller.
 //It sets the service e-mail, the languages, the current language,
 //the call path (if not set) and resets the logout timer.
@@ -49,6 +79,42 @@ func general(c *revel.Controller) revel.Result {
 	//NOTE: we log, but do not handle the error because we need to avoid redirect loops
 	userID, _ := getIntFromSession(c, "userID")
 
+	//NOTE: during impersonations, the server-side session is the session of the admin
+	sessionUserID := userID
+	impersonatorID, _ := getIntFromSession(c, "impersonatorID")
+	if impersonatorID != 0 {
+		sessionUserID = impersonatorID
+	}
+
+	//the server-side session of a logged in user must be valid
+	if userID != 0 {
+		valid, err := validUserSession(c, sessionUserID)
+		if err != nil || !valid {
+			c.Log.Debug("invalid server-side session", "userID", sessionUserID)
+			if impID, _ := getIntFromSession(c, "impersonationID"); impID != 0 {
+				imp := models.Impersonation{ID: impID}
+				imp.End()
+			}
+			for k := range c.Session {
+				c.Session.Del(k)
+			}
+			c.Flash.Error(c.Message("session.invalid"))
+			return c.Redirect(User.LoginPage)
+		}
+	}
+
+	//impersonations are read-only, unless the admin allowed changes
+	if impersonatorID != 0 && c.Session["impersonationWritable"] != models.BoolTrue &&
+		!readOnlyActions[c.Action] && c.Name != "Static" {
+		c.Log.Info("blocked action during read-only impersonation", "action", c.Action,
+			"impersonatorID", impersonatorID, "userID", userID)
+		c.Flash.Error(c.Message("impersonation.read.only"))
+		if c.Session["currPath"] == c.Request.URL.Path {
+			return c.Redirect(App.Index)
+		}
+		return c.Redirect(c.Session["currPath"])
+	}
+
 	//if a user is logged in, render all courses of that user for the navigation bar
 	if userID != 0 {
 		navUser := models.User{ID: userID}
@@ -75,6 +141,46 @@ func (c Admin) auth() revel.Result {
 	return c.Redirect(App.Index)
 }
 
+//apiScopes contains the scope required by each action of the API
+var apiScopes = map[string]string{
+	"Course": models.ScopeRead, "Events": models.ScopeRead, "Meetings": models.ScopeRead,
+	"Enrollments": models.ScopeRead, "Participants": models.ScopeRead,
+	"Enroll": models.ScopeEnroll, "Unsubscribe": models.ScopeEnroll,
+	"EnrollUser": models.ScopeParticipants, "WaitlistUser": models.ScopeParticipants,
+	"UnsubscribeUser": models.ScopeParticipants,
+}
+
+//auth authenticates requests of the API with a personal access token and
+//ensures that the token grants the scope of the action.
+func (c API) auth() revel.Result {
+
+	c.Log.Debug("executing auth api interceptor")
+
+	header := c.Request.Header.Get("Authorization")
+	plain := strings.TrimPrefix(header, "Bearer ")
+	if plain == "" || plain == header {
+		return c.renderError(http.StatusUnauthorized, "api.unauthorized", nil)
+	}
+
+	token := models.APIToken{}
+	user := models.User{}
+	valid, err := token.Authenticate(plain, &user)
+	if err != nil {
+		return c.renderError(http.StatusInternalServerError, "error.db", err)
+	} else if !valid {
+		c.Log.Info("invalid api token", "ip", c.ClientIP)
+		return c.renderError(http.StatusUnauthorized, "api.unauthorized", nil)
+	}
+
+	if !token.HasScope(apiScopes[c.MethodName]) {
+		return c.renderError(http.StatusForbidden, "api.scope.missing", nil,
+			apiScopes[c.MethodName])
+	}
+
+	c.Args["apiUser"] = &user
+	return nil
+}
+
 //auth prevents unauthorized access to controllers of type App.
 func (c App) auth() revel.Result {
 
@@ -230,7 +336,7 @@ func (c Creator) auth() revel.Result {
 	}
 
 	//admins and creators are authorized to create new courses
-	if (c.MethodName == "New" || c.MethodName == "Search") &&
+	if (c.MethodName == "New" || c.MethodName == "Search" || c.MethodName == "Templates") &&
 		c.Session["role"] != nil {
 
 		if c.Session["role"] == models.ADMIN.String() ||
@@ -260,7 +366,7 @@ func (c Creator) auth() revel.Result {
 		return c.Redirect(App.Index)
 	}
 
-	if expired && (c.MethodName == "Activate") {
+	if expired && (c.MethodName == "Activate" || c.MethodName == "ScheduleTransition") {
 		c.Flash.Error(c.Message("intercept.invalid.action"))
 		return c.Redirect(App.Index)
 	}
@@ -425,7 +531,14 @@ func (c User) auth() revel.Result {
 
 	//all
 	if c.MethodName == "Logout" || c.MethodName == "NewPassword" ||
-		c.MethodName == "ActivationPage" || c.MethodName == "VerifyActivationCode" {
+		c.MethodName == "ActivationPage" || c.MethodName == "VerifyActivationCode" ||
+		c.MethodName == "SAMLMetadata" || c.MethodName == "ResetPasswordPage" ||
+		c.MethodName == "ResetPassword" {
+		return nil
+	}
+
+	//impersonated users
+	if c.MethodName == "StopImpersonation" && c.Session["impersonatorID"] != nil {
 		return nil
 	}
 
@@ -434,7 +547,9 @@ func (c User) auth() revel.Result {
 
 		if c.MethodName == "LoginPage" || c.MethodName == "Login" ||
 			c.MethodName == "RegistrationPage" || c.MethodName == "Registration" ||
-			c.MethodName == "NewPasswordPage" {
+			c.MethodName == "NewPasswordPage" || c.MethodName == "OIDCLogin" ||
+			c.MethodName == "OIDCCallback" || c.MethodName == "SAMLLogin" ||
+			c.MethodName == "TOTPPage" || c.MethodName == "VerifyTOTP" {
 			return nil
 		}
 
@@ -448,7 +563,17 @@ func (c User) auth() revel.Result {
 		if c.Session["notActivated"] == nil {
 
 			//all activated users
-			if c.MethodName == "Profile" {
+			if c.MethodName == "Profile" || c.MethodName == "NewEMail" ||
+				c.MethodName == "ConfirmEMail" || c.MethodName == "DeleteSession" ||
+				c.MethodName == "LogoutEverywhere" || c.MethodName == "NewAPIToken" ||
+				c.MethodName == "DeleteAPIToken" {
+				return nil
+			}
+
+			//admins and creators
+			if c.Session["role"].(string) != models.USER.String() &&
+				(c.MethodName == "TOTPSetupPage" || c.MethodName == "EnableTOTP" ||
+					c.MethodName == "DisableTOTP" || c.MethodName == "NewRecoveryCodes") {
 				return nil
 			}
 
@@ -653,3 +778,77 @@ func getCourseID(c *revel.Controller, paramKey string) (ID int, err error) {
 
 	return
 }
+
+//recordRevisionBefore records changes of a course that were not made by the edit
+//controllers, e.g., by scheduled transitions, as a revision without editor
+func recordRevisionBefore(c *revel.Controller) revel.Result {
+	recordRevision(c, false)
+	return nil
+}
+
+//recordRevisionAfter records the changes of an edit as a revision of the course
+func recordRevisionAfter(c *revel.Controller) revel.Result {
+	recordRevision(c, true)
+	return nil
+}
+
+//recordRevision records a revision of the course of an edit action, failing to record
+//a revision must not fail the action, so errors are only logged
+func recordRevision(c *revel.Controller, withEditor bool) {
+
+	if noRevisionActions[c.Action] {
+		return
+	}
+
+	courseID, err := getRevisionCourseID(c)
+	if err != nil {
+		c.Log.Error("failed to get course ID of revision", "action", c.Action,
+			"error", err.Error())
+		return
+	}
+
+	revision := models.CourseRevision{CourseID: courseID}
+	if withEditor {
+		userID, err := getIntFromSession(c, "userID")
+		if err != nil {
+			c.Log.Error("failed to get editor of revision", "error", err.Error())
+			return
+		}
+		revision.Editor = sql.NullInt32{Int32: int32(userID), Valid: true}
+	}
+
+	if _, err = revision.Record(); err != nil {
+		c.Log.Error("failed to record revision", "courseID", courseID,
+			"action", c.Action, "error", err.Error())
+	}
+}
+
+//getRevisionCourseID returns the ID of the course of an edit action, deleted elements
+//are identified by the ID of their course or event
+func getRevisionCourseID(c *revel.Controller) (courseID int, err error) {
+
+	if courseID, err = getCourseID(c, "courseID"); err == nil {
+		return
+	}
+
+	eventID, err := getCourseID(c, "eventID")
+	if err == nil {
+		return models.CourseIDOf("events", eventID)
+	}
+
+	ID, err := getCourseID(c, "ID")
+	if err != nil {
+		return
+	}
+
+	table := "courses"
+	switch c.Name {
+	case "EditEvent":
+		table = "events"
+	case "EditMeeting":
+		table = "meetings"
+	case "EditCalendarEvent":
+		table = "calendar_events"
+	}
+	return models.CourseIDOf(table, ID)
+}
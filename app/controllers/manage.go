@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"database/sql"
 	"turm/app/models"
 
 	"github.com/revel/revel"
@@ -31,6 +32,10 @@ func (c Manage) Active() revel.Result {
 		renderQuietError(errDB, err, c.Controller)
 		return c.Render()
 	}
+	if err = creator.SelectTransitions(); err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	}
 
 	return c.Render(creator, editor, instructor)
 }
@@ -60,6 +65,10 @@ func (c Manage) Drafts() revel.Result {
 		renderQuietError(errDB, err, c.Controller)
 		return c.Render()
 	}
+	if err = creator.SelectTransitions(); err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	}
 
 	return c.Render(creator, editor)
 }
@@ -92,3 +101,111 @@ func (c Manage) Expired() revel.Result {
 
 	return c.Render(creator, editor, instructor)
 }
+
+/*Reviews renders the review queue, i.e., all courses submitted for review to the
+groups managed by the user. Admins review all courses.
+- Roles: creator and admin */
+func (c Manage) Reviews() revel.Result {
+
+	c.Log.Debug("render review queue", "url", c.Request.URL)
+
+	c.Session["callPath"] = c.Request.URL.String()
+	c.Session["currPath"] = c.Request.URL.String()
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	c.ViewArgs["tab"] = c.Message("creator.tab")
+
+	//get the user
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		renderQuietError(errTypeConv, err, c.Controller)
+		return c.Render()
+	}
+	if c.Session["role"].(string) == models.ADMIN.String() {
+		userID = 0
+	}
+
+	var reviews models.CourseReviews
+	if err = reviews.SelectQueue(userID); err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	}
+
+	return c.Render(reviews)
+}
+
+/*DecideReview approves, rejects or requests changes of a course submitted for review.
+Approving a course activates it. Rejections and change requests require a comment.
+- Roles: admin and managers of any group of the course path */
+func (c Manage) DecideReview(ID int, decision models.ReviewState,
+	comment string) revel.Result {
+
+	c.Log.Debug("decide on review", "ID", ID, "decision", decision, "comment", comment)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+
+	review := models.CourseReview{CourseID: ID, State: decision}
+	if c.Session["role"].(string) != models.ADMIN.String() {
+		isReviewer, err := review.IsReviewer(userID)
+		if err != nil {
+			return flashError(errDB, err, "", c.Controller, "")
+		} else if !isReviewer {
+			c.Flash.Error(c.Message("intercept.invalid.action"))
+			return c.Redirect(Manage.Reviews)
+		}
+	}
+
+	if decision != models.APPROVED && decision != models.REJECTED &&
+		decision != models.CHANGESREQUESTED {
+		c.Validation.ErrorKey("validation.invalid.params")
+	}
+	if decision != models.APPROVED || comment != "" {
+		models.ValidateLength(&comment, "validation.invalid.review.comment",
+			3, 2047, c.Validation)
+		review.Comment = sql.NullString{String: comment, Valid: true}
+	}
+	if c.Validation.HasErrors() {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	invalid, submitter, users, err := review.Decide(c.Validation, userID)
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if invalid {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	if decision == models.APPROVED {
+		triggerWebhook(c.Controller, models.WebhookCourseActivation, 0,
+			&models.EMailData{CourseID: ID, CourseTitle: submitter.CourseTitle}, "")
+
+		//send notification e-mail to editors/instructors
+		for _, data := range users {
+			err = sendEMail(c.Controller, &data,
+				"email.subject.new.course.role",
+				"newCourseRole")
+
+			if err != nil {
+				return flashError(errEMail, err, "", c.Controller, data.User.EMail)
+			}
+		}
+	}
+
+	//notify the user who submitted the course
+	if submitter.User.ID != 0 {
+		err = sendEMail(c.Controller, &submitter,
+			"email.subject.review.decision",
+			"reviewDecision")
+
+		if err != nil {
+			return flashError(errEMail, err, "", c.Controller, submitter.User.EMail)
+		}
+	}
+
+	c.Flash.Success(c.Message("review.decide.success", submitter.CourseTitle, ID))
+	return c.Redirect(Manage.Reviews)
+}
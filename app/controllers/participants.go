@@ -1,7 +1,10 @@
 package controllers
 
 import (
+	"database/sql"
 	"encoding/csv"
+	"mime"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
@@ -89,6 +92,10 @@ func (c Participants) Download(ID int, conf models.ListConf) revel.Result {
 		}
 	}
 
+	if conf.Format < models.CSV || conf.Format > models.ODS {
+		c.Validation.ErrorKey("validation.invalid.params")
+	}
+
 	if c.Validation.HasErrors() {
 		return flashError(
 			errValidation, nil, "", c.Controller, "")
@@ -101,6 +108,13 @@ func (c Participants) Download(ID int, conf models.ListConf) revel.Result {
 			errDB, err, "", c.Controller, "")
 	}
 
+	//spreadsheets are streamed to the client without temporary files
+	if conf.Format != models.CSV {
+		spreadsheet := createSpreadsheet(c.Controller, &participants, &conf)
+		return spreadsheetResult{spreadsheet: spreadsheet, format: conf.Format,
+			filename: conf.Filename + "." + conf.Format.String()}
+	}
+
 	//create the file and get the filepath
 	filepath, err := createCSV(c.Controller, &participants, &conf)
 	if err != nil {
@@ -149,6 +163,11 @@ func (c Participants) EMail(ID int, conf models.ListConf) revel.Result {
 		}
 	}
 
+	//schedule the e-mail or save it as a draft
+	if conf.Mode == models.SENDLATER || conf.Mode == models.SAVEDRAFT {
+		return c.saveScheduledEMail(ID, userID, &conf)
+	}
+
 	if c.Validation.HasErrors() {
 		return flashError(
 			errValidation, nil, "", c.Controller, "")
@@ -162,73 +181,194 @@ func (c Participants) EMail(ID int, conf models.ListConf) revel.Result {
 	}
 
 	//get all e-mail recipients
-	emails := make(map[string]bool)
-	for _, event := range participants.Lists {
+	emails := participants.EMailRecipients(&conf)
 
-		if conf.AllEvents || containsEvent(conf.EventIDs, event.ID) {
+	//archive the e-mails
+	var queue []app.EMail
+	for _, email := range emails {
+		queue = append(queue, app.EMail{
+			Recipient: email,
+			Subject:   conf.Subject,
+			ReplyTo:   participants.UserEMail,
+			Body:      app.HTMLToMimeFormat(&conf.Content),
+		})
+	}
+	archiveEMails(c.Controller, ID, queue)
 
-			//participants
-			if conf.Participants && len(event.Participants) != 0 {
-				for _, user := range event.Participants {
-					_, exists := emails[user.EMail]
-					if !exists {
-						emails[user.EMail] = true
-					}
-				}
-			}
+	//send e-mails
+	for _, email := range queue {
+		app.EMailQueue <- email
+	}
 
-			//wait list
-			if conf.WaitList && len(event.Waitlist) != 0 {
-				for _, user := range event.Waitlist {
-					_, exists := emails[user.EMail]
-					if !exists {
-						emails[user.EMail] = true
-					}
-				}
-			}
+	//a sent draft is no longer needed
+	if conf.ScheduledID != 0 {
+		scheduled := models.ScheduledEMail{ID: conf.ScheduledID, CourseID: ID}
+		if err = scheduled.Delete(c.Validation); err != nil {
+			return flashError(
+				errDB, err, "", c.Controller, "")
+		}
+	}
 
-			//unsubscribed
-			if conf.Unsubscribed && len(event.Unsubscribed) != 0 {
-				for _, user := range event.Unsubscribed {
-					_, exists := emails[user.EMail]
-					if !exists {
-						emails[user.EMail] = true
-					}
-				}
-			}
+	c.Flash.Success(c.Message("email.send.success", len(emails)))
+	return c.Redirect(Participants.Open, ID)
+}
 
-			//slots
-			for _, slot := range event.Slots {
+//saveScheduledEMail saves an e-mail to lists of participants as a draft or schedules
+//it for sending
+func (c Participants) saveScheduledEMail(ID, userID int, conf *models.ListConf) revel.Result {
+
+	email := models.ScheduledEMail{
+		ID:       conf.ScheduledID,
+		CourseID: ID,
+		Creator:  sql.NullInt32{Int32: int32(userID), Valid: userID != 0},
+		Subject:  conf.Subject,
+		Content:  conf.Content,
+		Conf:     *conf,
+		IsDraft:  conf.Mode == models.SAVEDRAFT,
+	}
 
-				//skip all slots not inside the defined interval
-				if conf.Start != "" {
-					if slot.EndStr < conf.Start || slot.StartStr > conf.End {
-						continue
-					}
-				}
+	if email.IsDraft {
+		c.Validation.MaxSize(email.Subject, 255).
+			MessageKey("validation.invalid.text.short")
 
-				_, exists := emails[slot.User.EMail]
-				if !exists {
-					emails[slot.User.EMail] = true
-				}
-			}
+	} else {
 
-		}
+		models.ValidateLength(&email.Subject, "validation.invalid.text.short",
+			3, 255, c.Validation)
+		c.Validation.Required(strings.TrimSpace(email.Content)).
+			MessageKey("validation.invalid.text.area")
+
+		email.TimeOfSending.String = conf.SendDate + " " + conf.SendTime
+		email.TimeOfSending.Valid = true
+		c.Validation.Check(email.TimeOfSending.String,
+			models.IsTimestamp{},
+		).MessageKey("validation.invalid.timestamp")
 	}
 
-	//send e-mails
-	for email := range emails {
-		email := app.EMail{
-			Recipient: email,
-			Subject:   conf.Subject,
-			ReplyTo:   participants.UserEMail,
-			Body:      app.HTMLToMimeFormat(&conf.Content),
-		}
-		app.EMailQueue <- email
+	if c.Validation.HasErrors() {
+		return flashError(
+			errValidation, nil, "", c.Controller, "")
 	}
 
-	c.Flash.Success(c.Message("email.send.success", len(emails)))
-	return c.Redirect(Participants.Open, ID)
+	if err := email.Save(c.Validation); err != nil {
+		return flashError(
+			errDB, err, "", c.Controller, "")
+	} else if c.Validation.HasErrors() {
+		return flashError(
+			errValidation, nil, "", c.Controller, "")
+	}
+
+	if email.IsDraft {
+		c.Flash.Success(c.Message("pcpts.scheduled.emails.draft.success"))
+	} else {
+		c.Flash.Success(c.Message("pcpts.scheduled.emails.schedule.success",
+			email.TimeOfSending.String))
+	}
+	return c.Redirect(Participants.ScheduledEMails, ID)
+}
+
+/*ScheduledEMails renders all scheduled e-mails and drafts of a course.
+- Roles: creator, editors and instructors of this course */
+func (c Participants) ScheduledEMails(ID int) revel.Result {
+
+	c.Log.Debug("render scheduled e-mails", "ID", ID)
+
+	//NOTE: the interceptor assures that the course ID is valid
+
+	c.Session["callPath"] = c.Request.URL.String()
+	c.Session["currPath"] = c.Request.URL.String()
+	c.Session["lastURL"] = c.Request.URL.String()
+	c.ViewArgs["tab"] = c.Message("pcpts.scheduled.emails.tab")
+
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		renderQuietError(errTypeConv, err, c.Controller)
+		return c.Render()
+	}
+
+	//the participants are required to compose e-mails
+	participants := models.Participants{ID: ID}
+	if err = participants.Get(userID, false); err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	}
+
+	var scheduledEMails models.ScheduledEMails
+	if err = scheduledEMails.Get(ID); err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	}
+
+	return c.Render(participants, scheduledEMails)
+}
+
+/*CancelScheduledEMail cancels a scheduled e-mail before its time of sending. The
+e-mail is kept as a draft.
+- Roles: creator, editors and instructors of this course */
+func (c Participants) CancelScheduledEMail(ID, scheduledID int) revel.Result {
+
+	c.Log.Debug("cancel scheduled e-mail", "ID", ID, "scheduledID", scheduledID)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	email := models.ScheduledEMail{ID: scheduledID, CourseID: ID}
+	if err := email.Cancel(c.Validation); err != nil {
+		return flashError(
+			errDB, err, "", c.Controller, "")
+	} else if c.Validation.HasErrors() {
+		return flashError(
+			errValidation, nil, "", c.Controller, "")
+	}
+
+	c.Flash.Success(c.Message("pcpts.scheduled.emails.cancel.success"))
+	return c.Redirect(Participants.ScheduledEMails, ID)
+}
+
+/*DeleteScheduledEMail deletes a draft or a scheduled e-mail that was not yet sent.
+- Roles: creator, editors and instructors of this course */
+func (c Participants) DeleteScheduledEMail(ID, scheduledID int) revel.Result {
+
+	c.Log.Debug("delete scheduled e-mail", "ID", ID, "scheduledID", scheduledID)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	email := models.ScheduledEMail{ID: scheduledID, CourseID: ID}
+	if err := email.Delete(c.Validation); err != nil {
+		return flashError(
+			errDB, err, "", c.Controller, "")
+	} else if c.Validation.HasErrors() {
+		return flashError(
+			errValidation, nil, "", c.Controller, "")
+	}
+
+	c.Flash.Success(c.Message("pcpts.scheduled.emails.delete.success"))
+	return c.Redirect(Participants.ScheduledEMails, ID)
+}
+
+/*SentEMails renders the archive of all e-mails sent in the context of a course.
+- Roles: creator, editors and instructors of this course */
+func (c Participants) SentEMails(ID int) revel.Result {
+
+	c.Log.Debug("render sent e-mails", "ID", ID)
+
+	//NOTE: the interceptor assures that the course ID is valid
+
+	c.Session["callPath"] = c.Request.URL.String()
+	c.Session["currPath"] = c.Request.URL.String()
+	c.Session["lastURL"] = c.Request.URL.String()
+	c.ViewArgs["tab"] = c.Message("pcpts.sent.emails.tab")
+
+	course := models.Course{ID: ID}
+	if err := course.GetColumnValue(nil, "title"); err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	}
+
+	var sentEMails models.SentEMails
+	if err := sentEMails.Get(ID); err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	}
+
+	return c.Render(course, sentEMails)
 }
 
 /*SearchUser renders search results for a search value.
@@ -281,6 +421,9 @@ func (c Participants) Enroll(ID, eventID, userID int) revel.Result {
 			errValidation, nil, "", c.Controller, "")
 	}
 
+	triggerWebhook(c.Controller, models.WebhookEnroll, eventID, &data,
+		enrolled.Status.String())
+
 	//send e-mail to the user
 	err = sendEMail(c.Controller, &data,
 		"email.subject.manual.enroll",
@@ -312,6 +455,9 @@ func (c Participants) Unsubscribe(ID, eventID, userID int) revel.Result {
 			errValidation, nil, "", c.Controller, "")
 	}
 
+	triggerWebhook(c.Controller, models.WebhookUnsubscribe, eventID, &data, "")
+	triggerWaitlistWebhooks(c.Controller, eventID, &data, users)
+
 	//send e-mail to the user
 	err = sendEMail(c.Controller, &data,
 		"email.subject.manual.unsubscribed",
@@ -322,19 +468,20 @@ func (c Participants) Unsubscribe(ID, eventID, userID int) revel.Result {
 	}
 
 	//send e-mail to each auto enrolled user
+	var mailData []models.EMailData
 	for _, user := range users {
-		mailData := models.EMailData{
+		mailData = append(mailData, models.EMailData{
 			User:        user,
 			CourseTitle: data.CourseTitle,
 			EventTitle:  data.EventTitle,
 			CourseID:    data.CourseID,
-		}
-		err = sendEMail(c.Controller, &mailData,
-			"email.subject.from.wait.list",
-			"fromWaitlist")
-		if err != nil {
-			return flashError(errEMail, err, "", c.Controller, mailData.User.EMail)
-		}
+		})
+	}
+	recipient, err := sendBulkEMails(c.Controller, mailData,
+		"email.subject.from.wait.list",
+		"fromWaitlist")
+	if err != nil {
+		return flashError(errEMail, err, "", c.Controller, recipient)
 	}
 
 	c.Flash.Success(c.Message("enroll.manual.unsubscribe.success"))
@@ -360,6 +507,10 @@ func (c Participants) Waitlist(ID, eventID, userID int) revel.Result {
 			errValidation, nil, "", c.Controller, "")
 	}
 
+	triggerWebhook(c.Controller, models.WebhookEnroll, eventID, &data,
+		enrolled.Status.String())
+	triggerWaitlistWebhooks(c.Controller, eventID, &data, users)
+
 	//send e-mail to the user
 	err = sendEMail(c.Controller, &data,
 		"email.subject.manual.wait.list",
@@ -370,19 +521,20 @@ func (c Participants) Waitlist(ID, eventID, userID int) revel.Result {
 	}
 
 	//send e-mail to each auto enrolled user
+	var mailData []models.EMailData
 	for _, user := range users {
-		mailData := models.EMailData{
+		mailData = append(mailData, models.EMailData{
 			User:        user,
 			CourseTitle: data.CourseTitle,
 			EventTitle:  data.EventTitle,
 			CourseID:    data.CourseID,
-		}
-		err = sendEMail(c.Controller, &mailData,
-			"email.subject.from.wait.list",
-			"fromWaitlist")
-		if err != nil {
-			return flashError(errEMail, err, "", c.Controller, mailData.User.EMail)
-		}
+		})
+	}
+	recipient, err := sendBulkEMails(c.Controller, mailData,
+		"email.subject.from.wait.list",
+		"fromWaitlist")
+	if err != nil {
+		return flashError(errEMail, err, "", c.Controller, recipient)
 	}
 
 	c.Flash.Success(c.Message("enroll.manual.to.wait.list.success"))
@@ -407,6 +559,9 @@ func (c Participants) ChangeStatus(ID, eventID int, enrolled models.Enrolled) re
 			errValidation, nil, "", c.Controller, "")
 	}
 
+	triggerWebhook(c.Controller, models.WebhookStatusChange, eventID, &data,
+		data.Status.String())
+
 	//send e-mail to the user
 	err = sendEMail(c.Controller, &data,
 		"email.subject.change.status",
@@ -677,6 +832,193 @@ func createCSV(c *revel.Controller, participants *models.Participants,
 	return
 }
 
+//createSpreadsheet creates a spreadsheet containing one sheet per selected event or
+//calendar event, the columns of dates and matriculation numbers are typed
+func createSpreadsheet(c *revel.Controller, participants *models.Participants,
+	conf *models.ListConf) (spreadsheet app.Spreadsheet) {
+
+	//no custom filename set
+	if conf.Filename == "" {
+		conf.Filename = time.Now().Format("2006-01-02") + "_" + participants.Title
+	}
+	conf.Filename = strings.NewReplacer("/", " ", `"`, " ").Replace(conf.Filename)
+
+	header := []string{
+		c.Message("user.salutation"),
+		c.Message("user.academic.title"),
+		c.Message("user.title"),
+		c.Message("user.firstname"),
+		c.Message("user.name.affix"),
+		c.Message("user.lastname"),
+		c.Message("user.email"),
+		c.Message("user.language"),
+		c.Message("user.matr.nr"),
+		c.Message("user.affiliation"),
+		c.Message("user.degree"),
+		c.Message("user.course.of.studies"),
+		c.Message("user.semester"),
+	}
+
+	for _, event := range participants.Lists {
+
+		if !conf.IncludesEvent(event.ID) {
+			continue
+		}
+
+		//calendar event data
+		if event.IsCalendarEvent {
+
+			sheet := app.Sheet{Name: event.Title, Header: append(header[:len(header):len(header)],
+				c.Message("enroll.start.time"),
+				c.Message("enroll.end.time"))}
+
+			for _, slot := range event.Slots {
+
+				//skip all slots not inside the defined interval
+				if conf.Start != "" {
+					if slot.EndStr < conf.Start || slot.StartStr > conf.End {
+						continue
+					}
+				}
+
+				row := append(spreadsheetUser(c, &slot.User), slot.Start, slot.End)
+				sheet.Rows = append(sheet.Rows, row)
+			}
+
+			spreadsheet.AddSheet(sheet)
+			continue
+		}
+
+		//event data
+		sheet := app.Sheet{Name: event.Title, Header: append(header[:len(header):len(header)],
+			c.Message("enroll.time"),
+			c.Message("enroll.status"),
+			c.Message("event.comment"))}
+
+		var lists []models.Entries
+		if conf.Participants {
+			lists = append(lists, event.Participants)
+		}
+		if conf.WaitList {
+			lists = append(lists, event.Waitlist)
+		}
+		if conf.Unsubscribed {
+			lists = append(lists, event.Unsubscribed)
+		}
+
+		for _, list := range lists {
+			for _, entry := range list {
+				row := append(spreadsheetUser(c, &entry.User), entry.TimeOfEnrollment,
+					enrollStatus(c, entry.Status), entry.Comment.String)
+				sheet.Rows = append(sheet.Rows, row)
+			}
+		}
+
+		spreadsheet.AddSheet(sheet)
+	}
+
+	return
+}
+
+//spreadsheetUser returns the cells of the user data of a spreadsheet row
+func spreadsheetUser(c *revel.Controller, user *models.User) (cells []interface{}) {
+
+	//matriculation number
+	var matrNr interface{}
+	if user.MatrNr.Valid {
+		if user.MatrNr.Int32 != 12345 {
+			matrNr = int(user.MatrNr.Int32)
+		} else {
+			matrNr = c.Message("user.matr.nr.not.visible")
+		}
+	}
+
+	degrees, studies, semesters := "", "", ""
+	for _, study := range user.Studies {
+		degrees = appendValueToString(degrees, study.Degree)
+		studies = appendValueToString(studies, study.CourseOfStudies)
+		semesters = appendValueToString(semesters, strconv.Itoa(study.Semester))
+	}
+
+	return []interface{}{
+		salutation(c, user.Salutation),
+		user.AcademicTitle.String,
+		user.Title.String,
+		user.FirstName,
+		user.NameAffix.String,
+		user.LastName,
+		user.EMail,
+		user.Language.String,
+		matrNr,
+		stringFromSlice(user.Affiliations.Affiliations),
+		degrees,
+		studies,
+		semesters,
+	}
+}
+
+//salutation returns the translated salutation of a user
+func salutation(c *revel.Controller, value models.Salutation) string {
+
+	if value == models.NONE {
+		return c.Message("user.salutation.none")
+	} else if value == models.MR {
+		return c.Message("user.salutation.mr")
+	}
+	return c.Message("user.salutation.ms")
+}
+
+//enrollStatus returns the translated enrollment status of a user
+func enrollStatus(c *revel.Controller, status models.EnrollmentStatus) string {
+
+	switch status {
+	case models.ONWAITLIST:
+		return c.Message("enroll.status.on.wait.list")
+	case models.AWAITINGPAYMENT:
+		return c.Message("enroll.status.awaiting.payment")
+	case models.PAID:
+		return c.Message("enroll.status.paid")
+	case models.FREED:
+		return c.Message("enroll.status.freed")
+	case models.UNSUBSCRIBED:
+		return c.Message("enroll.status.unsubscribed")
+	}
+	return c.Message("enroll.status.enrolled")
+}
+
+//spreadsheetResult streams a spreadsheet to the client
+type spreadsheetResult struct {
+	spreadsheet app.Spreadsheet
+	format      models.ListFormat
+	filename    string
+}
+
+/*Apply writes the spreadsheet as attachment. */
+func (r spreadsheetResult) Apply(req *revel.Request, resp *revel.Response) {
+
+	contentType := "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	if r.format == models.ODS {
+		contentType = "application/vnd.oasis.opendocument.spreadsheet"
+	}
+
+	//course titles may contain quotes and non-ASCII characters, so the file name is
+	//quoted or encoded as specified in RFC 2231
+	resp.Out.Header().Set("Content-Disposition", mime.FormatMediaType(
+		string(revel.Attachment), map[string]string{"filename": r.filename}))
+	resp.WriteHeader(http.StatusOK, contentType)
+
+	var err error
+	if r.format == models.ODS {
+		err = r.spreadsheet.WriteODS(resp.GetWriter())
+	} else {
+		err = r.spreadsheet.WriteXLSX(resp.GetWriter())
+	}
+	if err != nil {
+		revel.AppLog.Error("failed to stream spreadsheet", "filename", r.filename,
+			"error", err.Error())
+	}
+}
+
 //appendList appends the users of one of the partiticpant lists (enrolled, waitlist, etc.)
 //to the csv data slice
 func appendList(data *[][]string, list models.Entries, c *revel.Controller,
@@ -686,13 +1028,6 @@ func appendList(data *[][]string, list models.Entries, c *revel.Controller,
 
 		row := []string{}
 
-		salutation := c.Message("user.salutation.ms")
-		if user.Salutation == models.NONE {
-			salutation = c.Message("user.salutation.none")
-		} else if user.Salutation == models.MR {
-			salutation = c.Message("user.salutation.mr")
-		}
-
 		//matriculation number
 		matrNr := ""
 		if user.MatrNr.Valid {
@@ -713,24 +1048,10 @@ func appendList(data *[][]string, list models.Entries, c *revel.Controller,
 			semesters = appendValueToString(semesters, strconv.Itoa(study.Semester))
 		}
 
-		enrollStatus := c.Message("enroll.status.enrolled")
-		switch user.Status {
-		case models.ONWAITLIST:
-			enrollStatus = c.Message("enroll.status.on.wait.list")
-		case models.AWAITINGPAYMENT:
-			enrollStatus = c.Message("enroll.status.awaiting.payment")
-		case models.PAID:
-			enrollStatus = c.Message("enroll.status.paid")
-		case models.FREED:
-			enrollStatus = c.Message("enroll.status.freed")
-		case models.UNSUBSCRIBED:
-			enrollStatus = c.Message("enroll.status.unsubscribed")
-		}
-
 		row = append(row,
 			strconv.Itoa(ID),
 			strings.ReplaceAll(title, old, new),
-			salutation,
+			salutation(c, user.Salutation),
 			strings.ReplaceAll(user.AcademicTitle.String, old, new),
 			strings.ReplaceAll(user.Title.String, old, new),
 			strings.ReplaceAll(user.FirstName, old, new),
@@ -744,7 +1065,7 @@ func appendList(data *[][]string, list models.Entries, c *revel.Controller,
 			strings.ReplaceAll(studies, old, new),
 			strings.ReplaceAll(semesters, old, new),
 			user.TimeOfEnrollmentStr,
-			enrollStatus,
+			enrollStatus(c, user.Status),
 			strings.ReplaceAll(user.Comment.String, old, new),
 		)
 
@@ -759,13 +1080,6 @@ func appendSlot(data *[][]string, c *revel.Controller, slot *models.Slot,
 
 	row := []string{}
 
-	salutation := c.Message("user.salutation.ms")
-	if slot.User.Salutation == models.NONE {
-		salutation = c.Message("user.salutation.none")
-	} else if slot.User.Salutation == models.MR {
-		salutation = c.Message("user.salutation.mr")
-	}
-
 	//matriculation number
 	matrNr := ""
 	if slot.User.MatrNr.Valid {
@@ -789,7 +1103,7 @@ func appendSlot(data *[][]string, c *revel.Controller, slot *models.Slot,
 	row = append(row,
 		strconv.Itoa(ID),
 		strings.ReplaceAll(title, old, new),
-		salutation,
+		salutation(c, slot.User.Salutation),
 		strings.ReplaceAll(slot.User.AcademicTitle.String, old, new),
 		strings.ReplaceAll(slot.User.Title.String, old, new),
 		strings.ReplaceAll(slot.User.FirstName, old, new),
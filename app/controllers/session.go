@@ -18,10 +18,14 @@ The page calling the current page. Used for redirection after
 
 lastURL:
 The url of the last controller being executed. This session value ensures easier debugging.
+
+Additionally, the session of a logged in user contains the token of its server-side
+session (sessionToken). Server-side sessions expire after inactivity and can be revoked.
 */
 
 import (
 	"strconv"
+	"turm/app/models"
 
 	"github.com/revel/revel"
 )
@@ -37,3 +41,57 @@ func getIntFromSession(c *revel.Controller, key string) (value int, err error) {
 	}
 	return
 }
+
+//setSession sets all user related session values.
+func setSession(c *revel.Controller, user *models.User) {
+
+	c.Log.Debug("setting user session", "userID", user.ID)
+	c.Session["userID"] = strconv.Itoa(user.ID)
+	c.Session["firstName"] = user.FirstName
+	c.Session["lastName"] = user.LastName
+	c.Session["role"] = user.Role.String()
+	c.Session["isEditor"] = strconv.FormatBool(user.IsEditor)
+	c.Session["isInstructor"] = strconv.FormatBool(user.IsInstructor)
+	c.Session["eMail"] = user.EMail
+	c.Session["prefLanguage"] = user.Language.String
+	c.Session["isLDAP"] = strconv.FormatBool(user.IsLDAP)
+}
+
+//newUserSession inserts a new server-side session of a logged in user and sets
+//its token in the session cookie
+func newUserSession(c *revel.Controller, userID int, stayLoggedIn bool) (err error) {
+
+	session := models.UserSession{
+		UserID:       userID,
+		StayLoggedIn: stayLoggedIn,
+		IP:           c.ClientIP,
+		UserAgent:    c.Request.Header.Get("User-Agent"),
+	}
+
+	token, err := session.Insert()
+	if err != nil {
+		return
+	}
+	c.Session["sessionToken"] = token
+	return
+}
+
+//validUserSession returns whether the server-side session of a logged in user exists
+//and is not expired
+func validUserSession(c *revel.Controller, userID int) (valid bool, err error) {
+
+	token, _ := c.Session["sessionToken"].(string)
+	if token == "" {
+		return false, nil
+	}
+
+	session := models.UserSession{UserID: userID}
+	return session.Validate(token)
+}
+
+//sessionToken returns the token of the server-side session
+func sessionToken(c *revel.Controller) string {
+
+	token, _ := c.Session["sessionToken"].(string)
+	return token
+}
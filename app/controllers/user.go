@@ -1,15 +1,26 @@
 package controllers
 
 import (
+	"bytes"
 	"database/sql"
+	"errors"
 	"strconv"
 	"strings"
+	"time"
+	"turm/app"
 	"turm/app/auth"
 	"turm/app/models"
 
 	"github.com/revel/revel"
 )
 
+const (
+	//totpLoginValidity is the time to complete the second login step
+	totpLoginValidity = 5 * time.Minute
+	//totpMaxAttempts is the maximum number of codes per second login step
+	totpMaxAttempts = 5
+)
+
 /*LoginPage renders the login page.
 - Roles: not logged in users */
 func (c User) LoginPage() revel.Result {
@@ -21,6 +32,8 @@ func (c User) LoginPage() revel.Result {
 	c.Session["lastURL"] = c.Request.URL.String()
 
 	c.ViewArgs["tab"] = c.Message("login.tab")
+	c.ViewArgs["oidcProviders"] = auth.OIDCProviders()
+	c.ViewArgs["samlProviders"] = auth.SAMLProviders()
 
 	return c.Render()
 }
@@ -38,29 +51,36 @@ func (c User) Login(credentials models.Credentials) revel.Result {
 		return flashError(errValidation, nil, "", c.Controller, "")
 	}
 
-	var user models.User
-
-	if credentials.Username != "" { //ldap login, authenticate the user
+	//rate limits per account and per IP address
+	account := models.LoginThrottle{Key: models.ThrottleKey(models.ThrottleAccount,
+		credentials.Username+credentials.EMail)}
+	ip := models.LoginThrottle{Key: models.ThrottleKey(models.ThrottleIP, c.ClientIP)}
+	if throttled, err := c.throttled(&account, &ip); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if throttled {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
 
-		success, err := auth.LDAPServerAuth(&credentials, &user)
+	var user models.User
 
-		if err != nil {
-			return flashError(errAuth, err, "", c.Controller, "")
-		} else if !success {
+	//authenticate the user with the enabled providers
+	success, err := auth.Authenticate(&credentials, &user)
+	if err != nil {
+		return flashError(errAuth, err, "", c.Controller, "")
+	} else if !success {
+		if err = c.fail(&account, &ip); err != nil {
+			return flashError(errDB, err, "", c.Controller, "")
+		}
+		if credentials.Username != "" {
 			c.Validation.ErrorKey("login.ldap.auth.failed")
-			return flashError(errValidation, nil, "", c.Controller, "")
+		} else {
+			c.Validation.ErrorKey("validation.invalid.login")
 		}
-
-		c.Log.Debug("ldap authentication successful", "user", user)
-
-	} else { //external login
-
-		user.EMail = strings.ToLower(credentials.EMail)
-		user.Password.String = credentials.Password
-		user.Password.Valid = true
-		c.Log.Debug("login of external user", "user", user)
+		return flashError(errValidation, nil, "", c.Controller, "")
 	}
 
+	c.Log.Debug("authentication successful", "userID", user.ID)
+
 	//login of user
 	if err := user.Login(); err != nil {
 		return flashError(errDB, err, "", c.Controller, "")
@@ -69,36 +89,153 @@ func (c User) Login(credentials models.Credentials) revel.Result {
 	c.Validation.Required(user.ID).
 		MessageKey("validation.invalid.login")
 	if c.Validation.HasErrors() { //invalid external user credentials
+		if err = c.fail(&account, &ip); err != nil {
+			return flashError(errDB, err, "", c.Controller, "")
+		}
 		return flashError(errValidation, nil, "", c.Controller, "")
 	}
 
-	c.setSession(&user)
-	c.Session["stayLoggedIn"] = strconv.FormatBool(credentials.StayLoggedIn)
+	if err = account.Reset(); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
 
-	//set default expiration of session cookie
-	c.Session.SetDefaultExpiration()
-	if credentials.StayLoggedIn {
-		c.Session.SetNoExpiration()
+	return c.login(&user, credentials.StayLoggedIn, credentials.EMail != "")
+}
+
+/*OIDCLogin redirects the user to the authorization endpoint of an OpenID Connect provider.
+- Roles: not logged in users */
+func (c User) OIDCLogin(provider string, stayLoggedIn bool) revel.Result {
+
+	c.Log.Debug("login user with OIDC", "provider", provider, "stayLoggedIn", stayLoggedIn)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	oidcProvider, found := auth.GetOIDCProvider(provider)
+	if !found {
+		return flashError(errContent, errors.New("muted error: unknown provider"), "",
+			c.Controller, "")
 	}
 
-	c.Log.Debug("login successful", "user", user)
-	c.Flash.Success(c.Message("login.success",
-		user.EMail,
-		user.FirstName,
-		user.LastName,
-	))
+	authURL, state, nonce, verifier, err := oidcProvider.AuthCodeURL()
+	if err != nil {
+		return flashError(errAuth, err, "", c.Controller, "")
+	}
 
-	//not activated external users get redirected to the activation page
-	if user.ActivationCode.String != "" && credentials.EMail != "" {
-		c.Session["callPath"] = "/user/activationPage"
-		c.Session["notActivated"] = "true"
+	//used to verify the callback
+	c.Session["oidcProvider"] = provider
+	c.Session["oidcState"] = state
+	c.Session["oidcNonce"] = nonce
+	c.Session["oidcVerifier"] = verifier
+	c.Session["oidcStayLoggedIn"] = strconv.FormatBool(stayLoggedIn)
+
+	return c.Redirect(authURL)
+}
+
+/*OIDCCallback completes the login of an user at an OpenID Connect provider.
+- Roles: not logged in users */
+func (c User) OIDCCallback(state, code string) revel.Result {
+
+	c.Log.Debug("OIDC callback", "error", c.Params.Query.Get("error"))
+
+	provider, _ := c.Session["oidcProvider"].(string)
+	expectedState, _ := c.Session["oidcState"].(string)
+	nonce, _ := c.Session["oidcNonce"].(string)
+	verifier, _ := c.Session["oidcVerifier"].(string)
+	stayLoggedIn, _ := c.Session["oidcStayLoggedIn"].(string)
+
+	//the state and verifier are only valid for one callback
+	for _, key := range []string{"oidcProvider", "oidcState", "oidcNonce",
+		"oidcVerifier", "oidcStayLoggedIn"} {
+		c.Session.Del(key)
 	}
 
-	//if not yet set, prompt the user to set the preferred language
-	if !user.Language.Valid {
-		return c.Redirect(User.PrefLanguagePage)
+	//e.g., the user denied the access
+	if c.Params.Query.Get("error") != "" {
+		c.Validation.ErrorKey("login.oidc.auth.failed")
+		return flashError(errValidation, nil, "", c.Controller, "")
 	}
-	return c.Redirect(c.Session["callPath"])
+
+	oidcProvider, found := auth.GetOIDCProvider(provider)
+	if !found || code == "" || !auth.VerifyState(expectedState, state) {
+		c.Validation.ErrorKey("login.oidc.auth.failed")
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	var user models.User
+	identity, err := oidcProvider.Exchange(code, nonce, verifier, &user)
+	if err != nil {
+		return flashError(errAuth, err, "", c.Controller, "")
+	}
+
+	if err = user.LoginOIDC(&identity, c.Validation); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if c.Validation.HasErrors() {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	return c.login(&user, stayLoggedIn == "true", !user.IsLDAP)
+}
+
+/*SAMLLogin logs in an user authenticated by the Shibboleth service provider. Users
+without SAML session are redirected to the session initiator of the service provider.
+- Roles: not logged in users */
+func (c User) SAMLLogin(provider string, stayLoggedIn bool) revel.Result {
+
+	c.Log.Debug("login user with SAML", "provider", provider, "stayLoggedIn", stayLoggedIn)
+
+	samlProvider, found := auth.GetSAMLProvider(provider)
+	if !found {
+		return flashError(errContent, errors.New("muted error: unknown provider"), "",
+			c.Controller, "")
+	}
+
+	var user models.User
+	identity, success, err := samlProvider.AuthenticateHeaders(c.Request.RemoteAddr,
+		c.Request.Header.Get, &user)
+	if err != nil {
+		return flashError(errAuth, err, "", c.Controller, "")
+	}
+
+	if !success {
+		//the service provider did not set the headers after the single sign-on
+		if c.Session["samlInitiated"] != nil {
+			c.Session.Del("samlInitiated")
+			c.Validation.ErrorKey("login.saml.auth.failed")
+			return flashError(errValidation, nil, "", c.Controller, "")
+		}
+		c.Session["samlInitiated"] = "true"
+		return c.Redirect(samlProvider.SessionInitiatorURL(c.Request.URL.String()))
+	}
+	c.Session.Del("samlInitiated")
+
+	c.Log.Debug("SAML authentication successful", "userID", user.ID)
+
+	if err = user.LoginSAML(&identity, c.Validation); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if c.Validation.HasErrors() {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	return c.login(&user, stayLoggedIn, !user.IsLDAP)
+}
+
+/*SAMLMetadata renders the SAML 2.0 metadata of the service provider.
+- Roles: all */
+func (c User) SAMLMetadata(provider string) revel.Result {
+
+	c.Log.Debug("render SAML metadata", "provider", provider)
+
+	samlProvider, found := auth.GetSAMLProvider(provider)
+	if !found {
+		return c.NotFound(c.Message("error.content"))
+	}
+
+	metadata, err := samlProvider.Metadata()
+	if err != nil {
+		return c.RenderError(err)
+	}
+
+	return c.RenderBinary(bytes.NewReader(metadata), provider+"-metadata.xml",
+		revel.Inline, time.Now())
 }
 
 /*Logout handles logout, deletes all session values.
@@ -107,6 +244,16 @@ func (c User) Logout() revel.Result {
 
 	c.Log.Debug("logout", "length session", len(c.Session))
 
+	//NOTE: we log, but do not handle the errors, the session cookie is deleted anyways
+	if token := sessionToken(c.Controller); token != "" {
+		session := models.UserSession{}
+		session.DeleteByToken(token)
+	}
+	if impID, _ := getIntFromSession(c.Controller, "impersonationID"); impID != 0 {
+		imp := models.Impersonation{ID: impID}
+		imp.End()
+	}
+
 	for k := range c.Session {
 		c.Session.Del(k)
 	}
@@ -116,6 +263,46 @@ func (c User) Logout() revel.Result {
 	return c.Redirect(User.LoginPage)
 }
 
+/*StopImpersonation ends the impersonation of an user and restores the session of
+the admin.
+- Roles: logged in users impersonated by an admin */
+func (c User) StopImpersonation() revel.Result {
+
+	c.Log.Debug("stop impersonation")
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	adminID, err := getIntFromSession(c.Controller, "impersonatorID")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+	impID, err := getIntFromSession(c.Controller, "impersonationID")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+
+	imp := models.Impersonation{ID: impID}
+	if err = imp.End(); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+
+	admin := models.User{ID: adminID}
+	if err = admin.GetSessionData(); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+
+	c.Log.Info("stopped impersonation", "impersonationID", impID, "adminID", adminID,
+		"userID", c.Session["userID"])
+
+	for _, key := range []string{"impersonatorID", "impersonatorName", "impersonationID",
+		"impersonationWritable", "notActivated"} {
+		c.Session.Del(key)
+	}
+	setSession(c.Controller, &admin)
+
+	c.Flash.Success(c.Message("impersonation.stop.success"))
+	return c.Redirect(Admin.Index)
+}
+
 /*RegistrationPage renders the registration page.
 - Roles: not logged in users */
 func (c User) RegistrationPage() revel.Result {
@@ -128,6 +315,7 @@ func (c User) RegistrationPage() revel.Result {
 	c.Session["lastURL"] = c.Request.URL.String()
 
 	c.ViewArgs["tab"] = c.Message("register.tab")
+	c.ViewArgs["passwordPolicy"] = app.Passwords
 
 	return c.Render()
 }
@@ -136,7 +324,7 @@ func (c User) RegistrationPage() revel.Result {
 - Roles: not logged in users */
 func (c User) Registration(user models.User) revel.Result {
 
-	c.Log.Debug("registration of user", "user", user)
+	c.Log.Debug("registration of user", "email", user.EMail)
 	c.Session["lastURL"] = c.Request.URL.String()
 
 	//register the new user
@@ -148,11 +336,14 @@ func (c User) Registration(user models.User) revel.Result {
 			errValidation, nil, "", c.Controller, "")
 	}
 
-	c.setSession(&user)
+	setSession(c.Controller, &user)
 	c.Session["notActivated"] = "true"
+	if err := newUserSession(c.Controller, user.ID, false); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
 
 	data := models.EMailData{User: user}
-	err := sendEMail(c.Controller, &data,
+	err := sendForcedEMail(c.Controller, &data,
 		"email.subject.activation",
 		"activation")
 
@@ -182,15 +373,27 @@ func (c User) NewPasswordPage() revel.Result {
 	return c.Render()
 }
 
-/*NewPassword generates a new password and sends it via e-mail.
+/*NewPassword sends a link to reset the password via e-mail.
 - Roles: all */
 func (c User) NewPassword(email string) revel.Result {
 
-	c.Log.Debug("requesting new password", "email", email)
+	c.Log.Debug("requesting password reset", "email", email)
 	c.Session["lastURL"] = c.Request.URL.String()
 
+	//rate limits per e-mail address and per IP address, each request counts
+	account := models.LoginThrottle{Key: models.ThrottleKey(models.ThrottleReset, email)}
+	ip := models.LoginThrottle{Key: models.ThrottleKey(models.ThrottleResetIP, c.ClientIP)}
+	if throttled, err := c.throttled(&account, &ip); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if throttled {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+	if err := c.fail(&account, &ip); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+
 	user := models.User{EMail: strings.ToLower(email)}
-	err := user.GenerateNewPassword(c.Validation)
+	token, err := user.RequestPasswordReset(c.Validation)
 
 	if err != nil {
 		return flashError(errDB, err, "", c.Controller, "")
@@ -198,16 +401,83 @@ func (c User) NewPassword(email string) revel.Result {
 		return flashError(errValidation, nil, "", c.Controller, "")
 	}
 
+	//NOTE: without an external user with this e-mail address, the token is empty and
+	//the success message is shown anyway, so that it does not reveal registered addresses
+	if token != "" {
+		mailData := models.EMailData{User: user, Code: token,
+			Validity: app.Passwords.ResetValidity}
+		err = sendForcedEMail(c.Controller, &mailData,
+			"email.subject.reset.pw",
+			"resetPw")
+
+		if err != nil {
+			return flashError(errEMail, err, "", c.Controller, user.EMail)
+		}
+	}
+
+	c.Flash.Success(c.Message("new.pw.success", email))
+	return c.Redirect(User.LoginPage)
+}
+
+/*ResetPasswordPage renders the page to set a new password with a reset link.
+- Roles: all */
+func (c User) ResetPasswordPage(token string) revel.Result {
+
+	c.Log.Debug("render reset password page")
+
+	c.Session["currPath"] = c.Request.URL.String()
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	c.ViewArgs["tab"] = c.Message("new.pw.tab")
+	c.ViewArgs["passwordPolicy"] = app.Passwords
+
+	valid, err := models.ValidResetToken(token)
+	if err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	} else if !valid {
+		c.ViewArgs["errMsg"] = c.Message("validation.invalid.reset.token")
+	}
+
+	return c.Render(token)
+}
+
+/*ResetPassword sets a new password with a reset link.
+- Roles: all */
+func (c User) ResetPassword(token, newPw1, newPw2 string) revel.Result {
+
+	c.Log.Debug("reset password")
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	var user models.User
+	err := user.ResetPassword(token, newPw1, newPw2, c.Validation)
+
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if c.Validation.HasErrors() {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	//invalidate all other sessions and all api tokens of the user
+	err = models.DeleteUserSessions(user.ID, sessionToken(c.Controller))
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+	if err = models.DeleteAPITokens(user.ID); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+
+	//notify the user about the new password
 	mailData := models.EMailData{User: user}
-	err = sendEMail(c.Controller, &mailData,
-		"email.subject.new.pw",
-		"newPw")
+	err = sendForcedEMail(c.Controller, &mailData,
+		"email.subject.change.pw",
+		"changePw")
 
 	if err != nil {
 		return flashError(errEMail, err, "", c.Controller, user.EMail)
 	}
 
-	c.Flash.Success(c.Message("new.pw.success", email))
+	c.Flash.Success(c.Message("reset.pw.success"))
 	return c.Redirect(User.LoginPage)
 }
 
@@ -299,7 +569,7 @@ func (c User) NewActivationCode() revel.Result {
 	}
 
 	data := models.EMailData{User: user}
-	err = sendEMail(c.Controller, &data,
+	err = sendForcedEMail(c.Controller, &data,
 		"email.subject.activation",
 		"activation")
 
@@ -365,7 +635,7 @@ func (c User) SetPrefLanguage(prefLanguage string) revel.Result {
 
 /*Profile page of the user.
 - Roles: logged in and activated users */
-func (c User) Profile() revel.Result {
+func (c User) Profile(code string) revel.Result {
 
 	c.Log.Debug("render profile page")
 
@@ -374,6 +644,8 @@ func (c User) Profile() revel.Result {
 	c.Session["lastURL"] = c.Request.URL.String()
 
 	c.ViewArgs["tab"] = c.Message("profile.tab")
+	c.ViewArgs["passwordPolicy"] = app.Passwords
+	c.ViewArgs["totpEnforced"] = app.TOTPEnforced(c.Session["role"].(string))
 
 	userID, err := getIntFromSession(c.Controller, "userID")
 	if err != nil {
@@ -387,15 +659,78 @@ func (c User) Profile() revel.Result {
 		return c.Render()
 	}
 
-	return c.Render(user)
+	var sessions models.UserSessions
+	if err = sessions.Select(userID, sessionToken(c.Controller)); err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	}
+
+	var apiTokens models.APITokens
+	if err = apiTokens.Select(userID); err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	}
+
+	//links of e-mail confirmations open the confirmation with the code
+	c.ViewArgs["confirmationCode"] = code
+	c.ViewArgs["apiScopes"] = models.APIScopes
+	return c.Render(user, sessions, apiTokens)
+}
+
+/*DeleteSession revokes an active session of an user, e.g., on a lost device.
+- Roles: logged in and activated users */
+func (c User) DeleteSession(ID int) revel.Result {
+
+	c.Log.Debug("delete session", "ID", ID)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+
+	session := models.UserSession{ID: ID, UserID: userID}
+	if err = session.Delete(); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+
+	c.Flash.Success(c.Message("profile.session.delete.success"))
+	return c.Redirect(User.Profile)
+}
+
+/*LogoutEverywhere revokes all sessions of an user, including the current session, and
+all api tokens of the user.
+- Roles: logged in and activated users */
+func (c User) LogoutEverywhere() revel.Result {
+
+	c.Log.Debug("logout everywhere")
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+
+	if err = models.DeleteUserSessions(userID, ""); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+	if err = models.DeleteAPITokens(userID); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+
+	for k := range c.Session {
+		c.Session.Del(k)
+	}
+
+	c.Flash.Success(c.Message("profile.logout.everywhere.success"))
+	return c.Redirect(User.LoginPage)
 }
 
 /*ChangePassword of an user.
 - Roles: logged in and activated extern users */
 func (c User) ChangePassword(oldPw, newPw1, newPw2 string) revel.Result {
 
-	c.Log.Debug("change password of user", "oldPw", oldPw, "newPw1", newPw1,
-		"newPw2", newPw2)
+	c.Log.Debug("change password of user")
 	c.Session["lastURL"] = c.Request.URL.String()
 
 	userID, err := getIntFromSession(c.Controller, "userID")
@@ -414,6 +749,15 @@ func (c User) ChangePassword(oldPw, newPw1, newPw2 string) revel.Result {
 		return flashError(errValidation, nil, "", c.Controller, "")
 	}
 
+	//invalidate all other sessions and all api tokens of the user
+	err = models.DeleteUserSessions(user.ID, sessionToken(c.Controller))
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+	if err = models.DeleteAPITokens(user.ID); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+
 	mailData := models.EMailData{User: user}
 	err = sendEMail(c.Controller, &mailData,
 		"email.subject.change.pw",
@@ -431,7 +775,7 @@ func (c User) ChangePassword(oldPw, newPw1, newPw2 string) revel.Result {
 - Roles: logged in and activated extern users */
 func (c User) UpdateExternUserData(user models.User) revel.Result {
 
-	c.Log.Debug("update extern user data", "user", user)
+	c.Log.Debug("update extern user data", "userID", user.ID)
 	c.Session["lastURL"] = c.Request.URL.String()
 
 	userID, err := getIntFromSession(c.Controller, "userID")
@@ -453,17 +797,502 @@ func (c User) UpdateExternUserData(user models.User) revel.Result {
 	return c.Redirect(User.Profile)
 }
 
-//setSession sets all user related session values.
-func (c User) setSession(user *models.User) {
+/*NewEMail sends a confirmation code to the (new) e-mail address of an user whose
+e-mail address is undeliverable.
+- Roles: logged in and activated users */
+func (c User) NewEMail(email string) revel.Result {
+
+	c.Log.Debug("request e-mail confirmation", "email", email)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+
+	user := models.User{ID: userID, EMail: email}
+	code, err := user.RequestEMailConfirmation(c.Validation)
+
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if c.Validation.HasErrors() {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	data := models.EMailData{User: user, Code: code}
+	err = sendForcedEMail(c.Controller, &data,
+		"email.subject.confirm.email",
+		"confirmEMail")
+
+	if err != nil {
+		return flashError(errEMail, err, "", c.Controller, user.EMail)
+	}
+
+	c.Flash.Success(c.Message("profile.email.confirm.sent", user.EMail))
+	return c.Redirect(User.Profile)
+}
+
+/*ConfirmEMail verifies an e-mail confirmation code and sets the confirmed e-mail
+address as the new e-mail address of the user.
+- Roles: logged in and activated users */
+func (c User) ConfirmEMail(code string) revel.Result {
+
+	c.Log.Debug("confirm e-mail", "code", code)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	models.ValidateLength(&code, "validation.invalid.email.confirmation",
+		7, 7, c.Validation)
+	if c.Validation.HasErrors() {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+
+	user := models.User{ID: userID}
+	success, err := user.ConfirmEMail(code)
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+
+	if !success {
+		c.Validation.ErrorKey("validation.invalid.email.confirmation")
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
 
-	c.Log.Debug("setting user session", "user", user)
-	c.Session["userID"] = strconv.Itoa(user.ID)
-	c.Session["firstName"] = user.FirstName
-	c.Session["lastName"] = user.LastName
-	c.Session["role"] = user.Role.String()
-	c.Session["isEditor"] = strconv.FormatBool(user.IsEditor)
-	c.Session["isInstructor"] = strconv.FormatBool(user.IsInstructor)
 	c.Session["eMail"] = user.EMail
-	c.Session["prefLanguage"] = user.Language.String
-	c.Session["isLDAP"] = strconv.FormatBool(user.IsLDAP)
+	c.Flash.Success(c.Message("profile.email.confirm.success", user.EMail))
+	return c.Redirect(User.Profile)
+}
+
+/*TOTPPage renders the second login step of users with two-factor authentication. Users
+whose role enforces the two-factor authentication enroll their authenticator app first.
+- Roles: not logged in users */
+func (c User) TOTPPage() revel.Result {
+
+	c.Log.Debug("render TOTP page")
+
+	c.Session["currPath"] = c.Request.URL.String()
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	c.ViewArgs["tab"] = c.Message("totp.tab")
+
+	user, valid := c.pendingTOTPLogin()
+	if !valid {
+		return c.Redirect(User.LoginPage)
+	}
+
+	if err := user.GetSessionData(); err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	}
+
+	//enrollment of an enforced two-factor authentication
+	if !user.TOTPEnabled {
+		secret, qrCode, err := user.NewTOTPSecret()
+		if err != nil {
+			renderQuietError(errDB, err, c.Controller)
+			return c.Render()
+		}
+		c.ViewArgs["enroll"] = true
+		c.ViewArgs["secret"] = secret
+		c.ViewArgs["qrCode"] = qrCode
+	}
+
+	return c.Render()
+}
+
+/*VerifyTOTP completes the login of an user with a TOTP code or a recovery code. Users
+whose role enforces the two-factor authentication enable it with their first code.
+- Roles: not logged in users */
+func (c User) VerifyTOTP(code string) revel.Result {
+
+	c.Log.Debug("verify TOTP code")
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	user, valid := c.pendingTOTPLogin()
+	if !valid {
+		c.Validation.ErrorKey("validation.invalid.totp.expired")
+		c.Validation.Keep()
+		return c.Redirect(User.LoginPage)
+	}
+
+	attempts, err := getIntFromSession(c.Controller, "totpAttempts")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+	c.Session["totpAttempts"] = strconv.Itoa(attempts + 1)
+
+	//NOTE: the session values can be replayed, so the attempts are also limited per user
+	account := models.LoginThrottle{Key: models.ThrottleKey(models.ThrottleTOTP,
+		strconv.Itoa(user.ID))}
+	ip := models.LoginThrottle{Key: models.ThrottleKey(models.ThrottleIP, c.ClientIP)}
+	if throttled, err := c.throttled(&account, &ip); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if throttled {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	if err = user.GetSessionData(); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+
+	var recoveryCodes []string
+	if user.TOTPEnabled {
+		valid, err = user.VerifyTOTP(code)
+	} else {
+		recoveryCodes, err = user.EnableTOTP(code)
+		valid = len(recoveryCodes) != 0
+	}
+
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if !valid {
+		if err = c.fail(&account, &ip); err != nil {
+			return flashError(errDB, err, "", c.Controller, "")
+		}
+		c.Validation.ErrorKey("validation.invalid.totp")
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	if err = account.Reset(); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+
+	stayLoggedIn, _ := c.Session["totpStayLoggedIn"].(string)
+	external, _ := c.Session["totpExternal"].(string)
+	c.delPendingTOTPLogin()
+
+	result := c.completeLogin(&user, stayLoggedIn == "true", external == "true")
+	if len(recoveryCodes) == 0 {
+		return result
+	}
+
+	//the recovery codes are only shown once
+	c.ViewArgs["tab"] = c.Message("totp.tab")
+	c.ViewArgs["recoveryCodes"] = recoveryCodes
+	return c.RenderTemplate("user/recoveryCodes.html")
+}
+
+/*TOTPSetupPage renders the QR code to enable the two-factor authentication.
+- Roles: logged in and activated admins and creators */
+func (c User) TOTPSetupPage() revel.Result {
+
+	c.Log.Debug("render TOTP setup page")
+
+	c.Session["currPath"] = c.Request.URL.String()
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	c.ViewArgs["tab"] = c.Message("totp.tab")
+
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		renderQuietError(errTypeConv, err, c.Controller)
+		return c.Render()
+	}
+
+	user := models.User{ID: userID}
+	secret, qrCode, err := user.NewTOTPSecret()
+	if err != nil {
+		renderQuietError(errDB, err, c.Controller)
+		return c.Render()
+	} else if secret == "" { //already enabled
+		return c.Redirect(User.Profile)
+	}
+
+	return c.Render(secret, qrCode)
+}
+
+/*EnableTOTP enables the two-factor authentication of an user.
+- Roles: logged in and activated admins and creators */
+func (c User) EnableTOTP(code string) revel.Result {
+
+	c.Log.Debug("enable TOTP")
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+
+	user := models.User{ID: userID}
+	recoveryCodes, err := user.EnableTOTP(code)
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if len(recoveryCodes) == 0 {
+		c.Validation.ErrorKey("validation.invalid.totp")
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	c.Flash.Success(c.Message("totp.enable.success"))
+	c.ViewArgs["tab"] = c.Message("totp.tab")
+	c.ViewArgs["recoveryCodes"] = recoveryCodes
+	return c.RenderTemplate("user/recoveryCodes.html")
+}
+
+/*DisableTOTP disables the two-factor authentication of an user, unless the role of
+the user enforces it.
+- Roles: logged in and activated admins and creators */
+func (c User) DisableTOTP(code string) revel.Result {
+
+	c.Log.Debug("disable TOTP")
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	if app.TOTPEnforced(c.Session["role"].(string)) {
+		c.Validation.ErrorKey("validation.invalid.totp.enforced")
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+
+	user := models.User{ID: userID}
+	valid, err := user.VerifyTOTP(code)
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if !valid {
+		c.Validation.ErrorKey("validation.invalid.totp")
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	if err = user.DisableTOTP(); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+
+	c.Flash.Success(c.Message("totp.disable.success"))
+	return c.Redirect(User.Profile)
+}
+
+/*NewRecoveryCodes replaces the recovery codes of an user.
+- Roles: logged in and activated admins and creators */
+func (c User) NewRecoveryCodes(code string) revel.Result {
+
+	c.Log.Debug("generate new recovery codes")
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+
+	user := models.User{ID: userID}
+	valid, err := user.VerifyTOTP(code)
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	} else if !valid {
+		c.Validation.ErrorKey("validation.invalid.totp")
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	recoveryCodes, err := user.NewRecoveryCodes()
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+
+	c.ViewArgs["tab"] = c.Message("totp.tab")
+	c.ViewArgs["recoveryCodes"] = recoveryCodes
+	return c.RenderTemplate("user/recoveryCodes.html")
+}
+
+/*NewAPIToken creates a new personal access token of the API. The token is only
+shown once.
+- Roles: logged in and activated users */
+func (c User) NewAPIToken(token models.APIToken) revel.Result {
+
+	c.Log.Debug("create api token", "name", token.Name, "scopes", token.ScopeList)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+
+	token.Validate(c.Validation)
+	if c.Validation.HasErrors() {
+		return flashError(errValidation, nil, "", c.Controller, "")
+	}
+
+	token.UserID = userID
+	plain, err := token.Insert()
+	if err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+
+	c.ViewArgs["tab"] = c.Message("profile.tab")
+	c.ViewArgs["apiToken"] = plain
+	c.ViewArgs["apiTokenName"] = token.Name
+	return c.RenderTemplate("user/apiToken.html")
+}
+
+/*DeleteAPIToken revokes a personal access token of the API.
+- Roles: logged in and activated users */
+func (c User) DeleteAPIToken(ID int) revel.Result {
+
+	c.Log.Debug("delete api token", "ID", ID)
+	c.Session["lastURL"] = c.Request.URL.String()
+
+	userID, err := getIntFromSession(c.Controller, "userID")
+	if err != nil {
+		return flashError(errTypeConv, err, "", c.Controller, "")
+	}
+
+	token := models.APIToken{ID: ID, UserID: userID}
+	if err = token.Delete(); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+
+	c.Flash.Success(c.Message("profile.api.token.delete.success"))
+	return c.Redirect(User.Profile)
+}
+
+//throttled returns true if the next attempt of the account or of the IP address is not
+//yet allowed, or if the account is locked. Attempts of locked accounts are suspicious.
+func (c User) throttled(account, ip *models.LoginThrottle) (throttled bool, err error) {
+
+	wait, locked, err := account.Check(app.RateLimits.AccountAttempts)
+	if err != nil {
+		return
+	}
+	if locked {
+		c.Log.Error("suspicious login attempt of locked account", "key", account.Key,
+			"ip", c.ClientIP, "failures", account.Failures)
+		c.Validation.ErrorKey("login.locked", int(wait.Minutes())+1)
+		return true, nil
+	}
+
+	ipWait, _, err := ip.Check(app.RateLimits.IPAttempts)
+	if err != nil {
+		return
+	}
+	if ipWait > wait {
+		wait = ipWait
+	}
+
+	if wait > 0 {
+		c.Validation.ErrorKey("login.throttled", int(wait.Seconds())+1)
+		return true, nil
+	}
+	return false, nil
+}
+
+//fail counts a failed attempt of the account and of the IP address. The lockout of
+//an account and IP addresses exceeding their free attempts are suspicious.
+func (c User) fail(account, ip *models.LoginThrottle) (err error) {
+
+	lockout := strings.HasPrefix(account.Key, models.ThrottleAccount) ||
+		strings.HasPrefix(account.Key, models.ThrottleTOTP)
+
+	locked, err := account.Fail(lockout)
+	if err != nil {
+		return
+	}
+	if locked {
+		c.Log.Error("suspicious login attempts, locked account", "key", account.Key,
+			"ip", c.ClientIP, "failures", account.Failures)
+	}
+
+	if _, err = ip.Fail(false); err != nil {
+		return
+	}
+	exceeded := ip.Failures - app.RateLimits.IPAttempts
+	if exceeded > 0 && (exceeded-1)%app.RateLimits.IPAttempts == 0 {
+		c.Log.Error("suspicious login attempts, throttled IP address", "key", ip.Key,
+			"failures", ip.Failures)
+	}
+	return
+}
+
+//login redirects users with two-factor authentication to the second login step,
+//and completes the login of all other users.
+func (c User) login(user *models.User, stayLoggedIn, external bool) revel.Result {
+
+	if !user.TOTPEnabled && !app.TOTPEnforced(user.Role.String()) {
+		return c.completeLogin(user, stayLoggedIn, external)
+	}
+
+	//NOTE: the session is only set after the second factor was verified
+	c.Log.Debug("second login step required", "userID", user.ID)
+	c.Session["totpUserID"] = strconv.Itoa(user.ID)
+	c.Session["totpStayLoggedIn"] = strconv.FormatBool(stayLoggedIn)
+	c.Session["totpExternal"] = strconv.FormatBool(external)
+	c.Session["totpExpires"] = strconv.FormatInt(time.Now().Add(totpLoginValidity).Unix(), 10)
+	c.Session["totpAttempts"] = "0"
+
+	return c.Redirect(User.TOTPPage)
+}
+
+//pendingTOTPLogin returns the user of a pending second login step, if it is neither
+//expired nor exceeded the maximum number of attempts.
+func (c User) pendingTOTPLogin() (user models.User, valid bool) {
+
+	userID, err := getIntFromSession(c.Controller, "totpUserID")
+	if err != nil || userID == 0 {
+		return
+	}
+	expiresStr, _ := c.Session["totpExpires"].(string)
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		c.delPendingTOTPLogin()
+		return
+	}
+	attempts, err := getIntFromSession(c.Controller, "totpAttempts")
+	if err != nil || attempts >= totpMaxAttempts {
+		c.delPendingTOTPLogin()
+		return
+	}
+
+	return models.User{ID: userID}, true
+}
+
+//delPendingTOTPLogin deletes all session values of a pending second login step.
+func (c User) delPendingTOTPLogin() {
+
+	for _, key := range []string{"totpUserID", "totpStayLoggedIn", "totpExternal",
+		"totpExpires", "totpAttempts"} {
+		c.Session.Del(key)
+	}
+}
+
+//completeLogin sets the session of an authenticated user and redirects to the previous page.
+func (c User) completeLogin(user *models.User, stayLoggedIn, external bool) revel.Result {
+
+	if err := newUserSession(c.Controller, user.ID, stayLoggedIn); err != nil {
+		return flashError(errDB, err, "", c.Controller, "")
+	}
+	setSession(c.Controller, user)
+	c.Session["stayLoggedIn"] = strconv.FormatBool(stayLoggedIn)
+
+	//set default expiration of session cookie
+	c.Session.SetDefaultExpiration()
+	if stayLoggedIn {
+		c.Session.SetNoExpiration()
+	}
+
+	c.Log.Debug("login successful", "userID", user.ID)
+	c.Flash.Success(c.Message("login.success",
+		user.EMail,
+		user.FirstName,
+		user.LastName,
+	))
+
+	//remind the user to confirm an undeliverable e-mail address
+	if user.EMailUndeliverable {
+		c.Flash.Error(c.Message("profile.email.undeliverable"))
+	}
+
+	//not activated external users get redirected to the activation page
+	if user.ActivationCode.String != "" && external {
+		c.Session["callPath"] = "/user/activationPage"
+		c.Session["notActivated"] = "true"
+	}
+
+	//if not yet set, prompt the user to set the preferred language
+	if !user.Language.Valid {
+		return c.Redirect(User.PrefLanguagePage)
+	}
+	return c.Redirect(c.Session["callPath"])
 }
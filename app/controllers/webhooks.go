@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"database/sql"
+	"turm/app/models"
+
+	"github.com/revel/revel"
+)
+
+//triggerWebhook queues the deliveries of an event to all subscribed webhooks, failing
+//deliveries must not fail the action, so errors are only logged
+func triggerWebhook(c *revel.Controller, event string, eventID int,
+	data *models.EMailData, status string) {
+
+	payload := models.WebhookPayload{
+		Event:       event,
+		CourseID:    data.CourseID,
+		CourseTitle: data.CourseTitle,
+		EventID:     eventID,
+		EventTitle:  data.EventTitle,
+		Status:      status,
+		Start:       data.Start,
+		End:         data.End,
+	}
+	if data.User.ID != 0 {
+		payload.User = models.NewWebhookUser(&data.User)
+	}
+
+	if err := payload.Trigger(); err != nil {
+		c.Log.Error("failed to trigger webhook", "event", event, "courseID",
+			data.CourseID, "eventID", eventID, "error", err.Error())
+	}
+}
+
+//triggerWaitlistWebhooks queues a wait list promotion for each auto enrolled user
+func triggerWaitlistWebhooks(c *revel.Controller, eventID int, data *models.EMailData,
+	users models.Users) {
+
+	for _, user := range users {
+		promoted := models.EMailData{
+			User:        user,
+			CourseTitle: data.CourseTitle,
+			EventTitle:  data.EventTitle,
+			CourseID:    data.CourseID,
+		}
+		triggerWebhook(c, models.WebhookWaitlistPromotion, eventID, &promoted, "")
+	}
+}
+
+//renderWebhooks renders the webhooks of a course or all global webhooks
+func renderWebhooks(c *revel.Controller, courseID int) revel.Result {
+
+	ID := courseIDToNull(courseID)
+
+	var webhooks models.Webhooks
+	if err := webhooks.Select(ID); err != nil {
+		renderQuietError(errDB, err, c)
+		return c.RenderTemplate("templates/webhooks.html")
+	}
+
+	c.ViewArgs["webhooks"] = webhooks
+	c.ViewArgs["webhookEvents"] = models.WebhookEvents
+	c.ViewArgs["courseID"] = courseID
+
+	//reload the content after each change
+	c.ViewArgs["reloadURL"] = c.Request.URL.String()
+	c.ViewArgs["contentDiv"] = "#nav-pill-content-webhooks"
+	if courseID != 0 {
+		c.ViewArgs["contentDiv"] = "#webhooks-modal-content"
+	}
+	return c.RenderTemplate("templates/webhooks.html")
+}
+
+//courseIDToNull converts a course ID to a nullable course ID, global webhooks
+//have no course
+func courseIDToNull(courseID int) sql.NullInt32 {
+	return sql.NullInt32{Int32: int32(courseID), Valid: courseID != 0}
+}
+
+//insertWebhook validates and inserts a new webhook of a course or a new global webhook
+func insertWebhook(c *revel.Controller, courseID int, webhook *models.Webhook) revel.Result {
+
+	if webhook.Validate(c.Validation); c.Validation.HasErrors() {
+		return c.RenderJSON(
+			response{Status: INVALID, Msg: getErrorString(c.Validation.Errors)})
+	}
+
+	userID, err := getIntFromSession(c, "userID")
+	if err != nil {
+		return c.RenderJSON(
+			response{Status: ERROR, Msg: c.Message(errTypeConv.String())})
+	}
+
+	webhook.CourseID = courseIDToNull(courseID)
+	webhook.Creator = sql.NullInt32{Int32: int32(userID), Valid: true}
+	if err = webhook.Insert(); err != nil {
+		return c.RenderJSON(
+			response{Status: ERROR, Msg: c.Message(errDB.String())})
+	}
+
+	return c.RenderJSON(
+		response{Status: SUCCESS, Msg: c.Message("webhook.insert.success", webhook.URL)})
+}
+
+//deleteWebhook deletes a webhook of a course or a global webhook
+func deleteWebhook(c *revel.Controller, courseID, webhookID int) revel.Result {
+
+	webhook := models.Webhook{ID: webhookID, CourseID: courseIDToNull(courseID)}
+	if err := webhook.Delete(); err != nil {
+		return c.RenderJSON(
+			response{Status: ERROR, Msg: c.Message(errDB.String())})
+	}
+
+	return c.RenderJSON(
+		response{Status: SUCCESS, Msg: c.Message("webhook.delete.success")})
+}
+
+//testWebhook queues a test delivery to a webhook of a course or to a global webhook
+func testWebhook(c *revel.Controller, courseID, webhookID int) revel.Result {
+
+	webhook := models.Webhook{ID: webhookID, CourseID: courseIDToNull(courseID)}
+	found, err := webhook.Test()
+	if err != nil {
+		return c.RenderJSON(
+			response{Status: ERROR, Msg: c.Message(errDB.String())})
+	} else if !found {
+		return c.RenderJSON(
+			response{Status: INVALID, Msg: c.Message("validation.invalid.params")})
+	}
+
+	return c.RenderJSON(
+		response{Status: SUCCESS, Msg: c.Message("webhook.test.success")})
+}
@@ -53,6 +53,7 @@ func init() {
 		jobs.Schedule(jobSchedules["jobs.fetchEnrollData"], fetchEnrollData{})
 		jobs.Schedule(jobSchedules["jobs.parseStudies"], parseStudies{})
 		jobs.Schedule(jobSchedules["jobs.deleteCourses"], deleteCourses{})
+		jobs.Schedule(jobSchedules["jobs.deleteSentEMails"], deleteSentEMails{})
 		jobs.Schedule(jobSchedules["jobs.connTest"], dbConnTest{})
 	}, 5)
 
@@ -356,6 +356,28 @@ func (e deleteCourses) Run() {
 	revel.AppLog.Warn("finished DB job to delete all courses older than 10 years...")
 }
 
+//deleteSentEMails deletes the sent e-mail archive of all expired courses
+type deleteSentEMails struct{}
+
+/*Run the job to delete the sent e-mail archive of all expired courses. */
+func (e deleteSentEMails) Run() {
+
+	revel.AppLog.Warn("running DB job to delete the sent e-mails of expired courses...")
+
+	stmt := `DELETE FROM sent_emails s
+		USING courses c
+		WHERE s.course_id = c.id
+			AND c.expiration_date < now()`
+
+	if _, err := Db.Exec(stmt); err != nil {
+		revel.AppLog.Error("job to delete the sent e-mails of expired courses failed",
+			"error", err.Error())
+		SendErrorNote()
+	}
+
+	revel.AppLog.Warn("finished DB job to delete the sent e-mails of expired courses...")
+}
+
 //initJobData initializes all job config variables
 func initJobData() {
 
@@ -421,6 +443,13 @@ func initJobSchedules() {
 	}
 	jobSchedules["jobs.deleteCourses"] = deleteCourses
 
+	//delete sent e-mails
+	deleteSentEMails, found := revel.Config.String("jobs.deleteSentEMails")
+	if !found {
+		revel.AppLog.Fatal("cannot find key in config", "key", "jobs.deleteSentEMails")
+	}
+	jobSchedules["jobs.deleteSentEMails"] = deleteSentEMails
+
 	//testServer
 	if testServer, found = revel.Config.String("jobs.testServer"); !found {
 		revel.AppLog.Fatal("cannot find key in config", "key", "enroll.testServer")
@@ -1,8 +1,15 @@
 package app
 
 import (
+	"bufio"
 	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
 	"net/smtp"
+	"net/textproto"
+	"strings"
 	"time"
 
 	"github.com/k3a/html2text"
@@ -16,6 +23,9 @@ type MailerConn struct {
 	User     string
 	Password string
 	Suffix   string
+
+	//BounceToken authenticates the reports of bounced e-mails
+	BounceToken string
 }
 
 /*EMail contains all fields required to send an e-mail. */
@@ -24,6 +34,12 @@ type EMail struct {
 	Subject   string
 	ReplyTo   string
 	Body      string
+
+	//ArchiveID is the ID of the recipient entry in the sent e-mail archive (if archived)
+	ArchiveID int
+
+	//Force sends the e-mail even if the recipient is marked as undeliverable
+	Force bool
 }
 
 var (
@@ -46,17 +62,51 @@ func (e sendEMails) Run() {
 
 	select {
 	case email := <-EMailQueue:
-		mailer(&email)
+
+		//do not send e-mails to addresses that are known to be undeliverable
+		if !email.Force {
+			undeliverable := false
+			err := Db.Get(&undeliverable, stmtIsUndeliverable, email.Recipient)
+			if err != nil {
+				revel.AppLog.Error("failed to get undeliverable state", "recipient",
+					email.Recipient, "error", err.Error())
+			} else if undeliverable {
+				revel.AppLog.Debug("suppressing email to undeliverable address",
+					"recipient", email.Recipient, "subject", email.Subject)
+				updateDeliveryState(&email, 3 /* suppressed */)
+				return
+			}
+		}
+
+		err := mailer(&email)
 		revel.AppLog.Debug("sending email", "recipient", email.Recipient,
 			"subject", email.Subject, "replyTo", email.ReplyTo)
 
+		state := 1 /* sent */
+		if err != nil {
+			state = 2 /* failed */
+		}
+		updateDeliveryState(&email, state)
+
 	case <-time.After(1 * time.Second):
 		//no e-mail in queue
 	}
 }
 
+//updateDeliveryState updates the delivery state of archived e-mails
+func updateDeliveryState(email *EMail, state int) {
+
+	if email.ArchiveID == 0 {
+		return
+	}
+	if _, err := Db.Exec(stmtUpdateDeliveryState, email.ArchiveID, state); err != nil {
+		revel.AppLog.Error("failed to update delivery state", "archiveID",
+			email.ArchiveID, "state", state, "error", err.Error())
+	}
+}
+
 //mailer sends an e-mail
-func mailer(email *EMail) {
+func mailer(email *EMail) (err error) {
 
 	//set the subject and the body
 	subjectb64 := base64.StdEncoding.EncodeToString([]byte(email.Subject))
@@ -85,6 +135,14 @@ func mailer(email *EMail) {
 	if err = c.Rcpt(email.Recipient); err != nil {
 		revel.AppLog.Error("failed setting the recipient of the e-mail",
 			"error", err.Error())
+
+		//only replies rejecting the mailbox itself indicate that the address does not
+		//exist, other permanent errors (e.g., policy rejections) do not
+		if smtpErr, ok := err.(*textproto.Error); ok && (smtpErr.Code == 550 ||
+			smtpErr.Code == 551 || smtpErr.Code == 553) &&
+			isUnknownMailbox(smtpErr.Msg) {
+			MarkUndeliverable(email.Recipient)
+		}
 		return
 	}
 
@@ -111,6 +169,7 @@ func mailer(email *EMail) {
 		revel.AppLog.Error("failed to quit client", "error", err.Error())
 		return
 	}
+	return
 }
 
 /*SendErrorNote sends an error notification e-mail to the mailer. */
@@ -144,6 +203,98 @@ func HTMLToMimeFormat(html *string) (mimeBody string) {
 	return
 }
 
+/*HTMLFromMimeFormat returns the content of the HTML body of an e-mail in MIME format. */
+func HTMLFromMimeFormat(mimeBody *string) (html string) {
+
+	html = *mimeBody
+
+	start := strings.Index(html, "<body")
+	if start == -1 {
+		return
+	}
+	html = html[start:]
+	html = html[strings.Index(html, ">")+1:]
+
+	if end := strings.Index(html, "</body>"); end != -1 {
+		html = html[:end]
+	}
+	return strings.TrimSpace(html)
+}
+
+/*MarkUndeliverable marks all users with the provided e-mail address as undeliverable.
+No further e-mails are sent to them until they confirm a (new) e-mail address. */
+func MarkUndeliverable(email string) (err error) {
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	if _, err = Db.Exec(stmtMarkUndeliverable, email); err != nil {
+		revel.AppLog.Error("failed to mark e-mail address as undeliverable",
+			"email", email, "error", err.Error())
+	}
+	return
+}
+
+/*ParseBounce returns all recipients of a delivery status notification (RFC 3464)
+whose delivery failed permanently because their mailbox does not exist. */
+func ParseBounce(r io.Reader) (recipients []string, err error) {
+
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" {
+		return
+	}
+
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return recipients, err
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if partType != "message/delivery-status" {
+			continue
+		}
+
+		//the first block contains the per-message fields, all following blocks
+		//contain the per-recipient fields
+		reader := textproto.NewReader(bufio.NewReader(part))
+		for {
+			fields, err := reader.ReadMIMEHeader()
+
+			action := strings.ToLower(strings.TrimSpace(fields.Get("Action")))
+			recipient := fields.Get("Final-Recipient")
+			if action == "failed" && recipient != "" &&
+				isUnknownMailbox(fields.Get("Status")) {
+				//Final-Recipient: rfc822; user@example.com
+				if idx := strings.Index(recipient, ";"); idx != -1 {
+					recipient = recipient[idx+1:]
+				}
+				recipients = append(recipients, strings.TrimSpace(recipient))
+			}
+
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return recipients, err
+			}
+		}
+	}
+
+	return
+}
+
+//isUnknownMailbox returns whether an enhanced status code (RFC 3463) reports a
+//permanent addressing failure, i.e., a bad or unknown mailbox (5.1.x)
+func isUnknownMailbox(status string) bool {
+	return strings.HasPrefix(strings.TrimSpace(status), "5.1.")
+}
+
 //initMailerData initializes all Mailer config variables
 func initMailerData() {
 
@@ -161,3 +312,25 @@ func initMailerData() {
 		revel.AppLog.Fatal("cannot find key in config", "key", "email.suffix")
 	}
 }
+
+const (
+	stmtIsUndeliverable = `
+		SELECT EXISTS (
+			SELECT true FROM users
+			WHERE email = LOWER($1)
+				AND email_undeliverable
+		) AS undeliverable
+	`
+
+	stmtMarkUndeliverable = `
+		UPDATE users
+		SET email_undeliverable = true
+		WHERE email = $1
+	`
+
+	stmtUpdateDeliveryState = `
+		UPDATE sent_email_recipients
+		SET delivery_state = $2
+		WHERE id = $1
+	`
+)
@@ -22,9 +22,12 @@ func (affiliations NullAffiliations) Value() (driver.Value, error) {
 		return nil, nil
 	}
 
+	//affiliations of identity providers might contain quotes
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
 	var str string
 	for _, affiliation := range affiliations.Affiliations {
-		str += `"` + affiliation + `",`
+		str += `"` + escape.Replace(affiliation) + `",`
 	}
 	return driver.Value("{" + strings.TrimRight(str, ",") + "}"), nil
 }
@@ -0,0 +1,216 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"turm/app"
+
+	"github.com/revel/revel"
+)
+
+/*Scopes of personal access tokens. Tokens with the read scope can read courses, events,
+meetings, their own enrollments and the participants of courses they manage. The
+enroll scope allows enrolling in and unsubscribing from events, and the participants
+scope allows managing the participants of courses. */
+const (
+	ScopeRead         = "read"
+	ScopeEnroll       = "enroll"
+	ScopeParticipants = "participants"
+)
+
+/*APIScopes contains all valid scopes of personal access tokens. */
+var APIScopes = []string{ScopeRead, ScopeEnroll, ScopeParticipants}
+
+/*APITokens contains all personal access tokens of an user. */
+type APITokens []APIToken
+
+/*APIToken is a revocable personal access token of the JSON API. Only the hash of
+the token is stored. */
+type APIToken struct {
+	ID        int    `db:"id, primarykey, autoincrement"`
+	UserID    int    `db:"user_id"`
+	Name      string `db:"name"`
+	TokenHash string `db:"token_hash, unique"`
+	Scopes    string `db:"scopes"`
+
+	//not fields in the respective table
+	ExpiresIn  int            ``
+	ScopeList  []string       ``
+	Created    string         `db:"created_str"`
+	LastUsed   sql.NullString `db:"last_used_str"`
+	ExpiresStr sql.NullString `db:"expires_str"`
+}
+
+/*Validate the name, the scopes and the validity of a token. */
+func (token *APIToken) Validate(v *revel.Validation) {
+
+	token.Name = strings.TrimSpace(token.Name)
+	v.Check(token.Name,
+		revel.MinSize{3},
+		revel.MaxSize{255},
+	).MessageKey("validation.invalid.text.short")
+
+	if len(token.ScopeList) == 0 {
+		v.ErrorKey("validation.invalid.api.scopes")
+	}
+	for _, scope := range token.ScopeList {
+		valid := false
+		for _, apiScope := range APIScopes {
+			if scope == apiScope {
+				valid = true
+			}
+		}
+		if !valid {
+			v.ErrorKey("validation.invalid.api.scopes")
+			return
+		}
+	}
+
+	if token.ExpiresIn < 0 || token.ExpiresIn > 365 {
+		v.ErrorKey("validation.invalid.api.expires")
+	}
+
+	token.Scopes = strings.Join(token.ScopeList, ",")
+}
+
+/*Insert a new token. It returns the token, which is only shown once. */
+func (token *APIToken) Insert() (plain string, err error) {
+
+	plain, token.TokenHash, err = generateToken()
+	if err != nil {
+		return
+	}
+
+	expires := sql.NullInt32{Int32: int32(token.ExpiresIn), Valid: token.ExpiresIn != 0}
+	err = app.Db.Get(&token.ID, stmtInsertAPIToken, token.UserID, token.Name,
+		token.TokenHash, token.Scopes, expires)
+	if err != nil {
+		log.Error("failed to insert api token", "userID", token.UserID, "name", token.Name,
+			"error", err.Error())
+	}
+	return
+}
+
+/*Delete (revoke) a token of an user. */
+func (token *APIToken) Delete() (err error) {
+
+	_, err = app.Db.Exec(stmtDeleteAPIToken, token.ID, token.UserID)
+	if err != nil {
+		log.Error("failed to delete api token", "ID", token.ID, "userID", token.UserID,
+			"error", err.Error())
+	}
+	return
+}
+
+/*DeleteAPITokens deletes (revokes) all tokens of an user, e.g., when logging out
+everywhere or changing the password. */
+func DeleteAPITokens(userID int) (err error) {
+
+	_, err = app.Db.Exec(stmtDeleteAPITokens, userID)
+	if err != nil {
+		log.Error("failed to delete api tokens", "userID", userID, "error", err.Error())
+	}
+	return
+}
+
+/*Authenticate returns whether the token exists and is not expired. It also gets the
+session data of the user owning the token. Tokens of not activated users are invalid. */
+func (token *APIToken) Authenticate(plain string, user *User) (valid bool, err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	err = tx.Get(token, stmtUseAPIToken, hashToken(plain))
+	if err == sql.ErrNoRows {
+		tx.Commit()
+		return false, nil
+	} else if err != nil {
+		log.Error("failed to get api token", "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	if err = tx.Get(user, stmtGetSessionData, token.UserID); err != nil {
+		log.Error("failed to get session data", "userID", token.UserID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+	if user.ActivationCode.Valid && !user.IsLDAP {
+		tx.Commit()
+		return false, nil
+	}
+
+	user.IsEditor, user.IsInstructor, err = user.IsEditorInstructor(tx)
+	if err != nil {
+		return
+	}
+
+	tx.Commit()
+	token.ScopeList = strings.Split(token.Scopes, ",")
+	return true, nil
+}
+
+/*HasScope returns true if the token grants the scope. */
+func (token *APIToken) HasScope(scope string) bool {
+
+	for _, tokenScope := range token.ScopeList {
+		if tokenScope == scope {
+			return true
+		}
+	}
+	return false
+}
+
+/*Select all tokens of an user. */
+func (tokens *APITokens) Select(userID int) (err error) {
+
+	err = app.Db.Select(tokens, stmtSelectAPITokens, userID, app.TimeZone)
+	if err != nil {
+		log.Error("failed to select api tokens", "userID", userID, "error", err.Error())
+	}
+	for key := range *tokens {
+		(*tokens)[key].ScopeList = strings.Split((*tokens)[key].Scopes, ",")
+	}
+	return
+}
+
+const (
+	stmtInsertAPIToken = `
+		INSERT INTO api_tokens (user_id, name, token_hash, scopes, created, expires)
+		VALUES ($1, $2, $3, $4, now(), now() + $5 * interval '1 day')
+		RETURNING id
+	`
+
+	stmtDeleteAPIToken = `
+		DELETE FROM api_tokens
+		WHERE id = $1
+			AND user_id = $2
+	`
+
+	stmtDeleteAPITokens = `
+		DELETE FROM api_tokens
+		WHERE user_id = $1
+	`
+
+	stmtUseAPIToken = `
+		UPDATE api_tokens
+		SET last_used = now()
+		WHERE token_hash = $1
+			AND (expires IS NULL OR expires > now())
+		RETURNING id, user_id, name, token_hash, scopes
+	`
+
+	stmtSelectAPITokens = `
+		SELECT id, user_id, name, token_hash, scopes,
+			TO_CHAR (created AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS created_str,
+			TO_CHAR (last_used AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS last_used_str,
+			TO_CHAR (expires AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS expires_str
+		FROM api_tokens
+		WHERE user_id = $1
+			AND (expires IS NULL OR expires > now())
+		ORDER BY created DESC
+	`
+)
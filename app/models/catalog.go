@@ -0,0 +1,111 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+	"turm/app"
+)
+
+/*Catalog contains all publicly visible courses of a group subtree. It is the
+source of the public course catalog feeds. */
+type Catalog struct {
+	//GroupID is the root of the subtree, or 0 to include all groups
+	GroupID int
+
+	//GroupName is the name of the root of the subtree
+	GroupName string
+	Courses   Courses
+	Updated   time.Time
+}
+
+/*Select all courses of the catalog, their events and their meetings. The catalog
+uses the same visibility rules as Course.GetVisible and the groups tree, i.e., it
+contains all visible and active courses that are not yet expired. */
+func (catalog *Catalog) Select() (found bool, err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	if catalog.GroupID != 0 {
+		group := Group{ID: catalog.GroupID}
+		err = tx.Get(&group, stmtGetGroup, group.ID)
+		if err == sql.ErrNoRows {
+			tx.Commit()
+			return false, nil
+		} else if err != nil {
+			log.Error("failed to get group of catalog", "groupID", catalog.GroupID,
+				"error", err.Error())
+			tx.Rollback()
+			return
+		}
+		catalog.GroupName = group.Name
+	}
+
+	err = tx.Select(&catalog.Courses, stmtSelectCatalog, catalog.GroupID, app.TimeZone)
+	if err != nil {
+		log.Error("failed to select catalog", "groupID", catalog.GroupID,
+			"error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	//NOTE: events are loaded as if managing them, so that no enrollment status
+	//of a specific user is evaluated
+	userID := 0
+	for key := range catalog.Courses {
+
+		course := &catalog.Courses[key]
+		err = course.Events.Get(tx, &userID, &course.ID, true, &course.EnrollLimitEvents)
+		if err != nil {
+			return
+		}
+
+		if course.LastModified.After(catalog.Updated) {
+			catalog.Updated = course.LastModified
+		}
+	}
+
+	tx.Commit()
+	return true, nil
+}
+
+const (
+	stmtSelectCatalog = `
+		WITH RECURSIVE subtree (id)
+			AS (
+				SELECT id
+				FROM groups
+				WHERE id = $1
+
+				UNION ALL
+
+				SELECT g.id
+				FROM groups g, subtree s
+				WHERE g.parent_id = s.id
+			)
+
+		SELECT c.id, c.title, c.subtitle, c.speaker, c.fee, c.only_ldap, c.visible, c.active,
+			c.creation_date, c.enrollment_start, c.enrollment_end, c.unsubscribe_end,
+			c.expiration_date, c.enroll_limit_events, c.parent_id,
+			/* revisions are only recorded if the course data changed */
+			GREATEST (c.creation_date, (
+					SELECT MAX(r.created)
+					FROM course_revisions r
+					WHERE r.course_id = c.id
+				)) AS last_modified,
+			TO_CHAR (c.creation_date AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS creation_date_str,
+			TO_CHAR (c.enrollment_start AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS enrollment_start_str,
+			TO_CHAR (c.enrollment_end AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS enrollment_end_str,
+			TO_CHAR (c.unsubscribe_end AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS unsubscribe_end_str,
+			TO_CHAR (c.expiration_date AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS expiration_date_str
+		FROM courses c
+		WHERE c.visible
+			AND c.active
+			AND current_timestamp < c.expiration_date
+			AND ($1 = 0 OR c.parent_id IN (SELECT id FROM subtree))
+		ORDER BY c.enrollment_start DESC, c.title ASC
+	`
+)
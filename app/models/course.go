@@ -2,7 +2,6 @@ package models
 
 import (
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"regexp"
 	"strconv"
@@ -23,6 +22,7 @@ type Course struct {
 	Active            bool            `db:"active"`
 	OnlyLDAP          bool            `db:"only_ldap"`
 	CreationDate      time.Time       `db:"creation_date"`
+	LastModified      time.Time       `db:"last_modified"`
 	Description       sql.NullString  `db:"description"`
 	Speaker           sql.NullString  `db:"speaker"`
 	Fee               sql.NullFloat64 `db:"fee"`
@@ -641,6 +641,23 @@ func (course *Course) Activate(v *revel.Validation) (invalid bool,
 		return
 	}
 
+	invalid, users, err = course.activate(tx, v)
+	if err != nil {
+		return
+	} else if invalid {
+		tx.Rollback()
+		return
+	}
+
+	tx.Commit()
+	return
+}
+
+//activate validates and activates a course, it returns the e-mail data of all
+//editors and instructors of the course
+func (course *Course) activate(tx *sqlx.Tx, v *revel.Validation) (invalid bool,
+	users EMailsData, err error) {
+
 	if err = course.Get(tx, true, 0); err != nil {
 		return
 	}
@@ -690,7 +707,6 @@ func (course *Course) Activate(v *revel.Validation) (invalid bool,
 		users = append(users, data)
 	}
 
-	tx.Commit()
 	return
 }
 
@@ -747,48 +763,6 @@ func (course *Course) Duplicate() (err error) {
 	return
 }
 
-/*Load a course from a JSON file. The JSON can have the struct of the old Turm2. */
-func (course *Course) Load(version int, data *[]byte) (success bool, err error) {
-
-	if version == 4 {
-		//unmarshal into the course struct
-		err = json.Unmarshal(*data, &course)
-		if err != nil {
-			log.Error("failed to unmarshal into new struct", "data",
-				*data, "error", err.Error())
-			return
-		}
-
-	} else if version == 1 || version == 2 {
-		//unmarshal the struct into the version 2 layout
-		version2Course := Version2Course{}
-		err = json.Unmarshal(*data, &version2Course)
-		if err != nil {
-			log.Error("failed to unmarshal into version 2 struct", "data",
-				*data, "error", err.Error())
-			return
-		}
-
-		//then transform the data to the current (version 4) course struct
-		err = version2Course.Transform(course)
-
-	} else if version == 3 {
-		//unmarshal the struct into the version 3 layout
-		version3Course := Version3Course{}
-		err = json.Unmarshal(*data, &version3Course)
-		if err != nil {
-			log.Error("failed to unmarshal into version 3 struct", "data",
-				*data, "error", err.Error())
-			return
-		}
-
-		//then transform the data to the current (version 4) course struct
-		version3Course.Transform(course)
-	}
-
-	return
-}
-
 /*InsertUploadedCourse a new course from a provided course struct. The course
 struct is extracted from an uploaded JSON file. */
 func (course *Course) InsertUploadedCourse() (err error) {
@@ -799,6 +773,18 @@ func (course *Course) InsertUploadedCourse() (err error) {
 		return
 	}
 
+	if err = course.insert(tx); err != nil {
+		return
+	}
+
+	tx.Commit()
+	return
+}
+
+//insert the course and all its data, i.e., its events, calendar events, user lists
+//and restrictions
+func (course *Course) insert(tx *sqlx.Tx) (err error) {
+
 	err = tx.Get(course, stmtInsertCourse, course.Visible, course.Creator, course.CustomEMail, course.Description,
 		course.EnrollLimitEvents, course.EnrollmentEnd, course.EnrollmentStart, course.ExpirationDate,
 		course.Fee, course.OnlyLDAP, course.Speaker, course.Subtitle, course.Title, course.UnsubscribeEnd)
@@ -829,11 +815,7 @@ func (course *Course) InsertUploadedCourse() (err error) {
 		return
 	}
 
-	if err = course.Restrictions.InsertUploaded(tx, course.ID); err != nil {
-		return
-	}
-
-	tx.Commit()
+	err = course.Restrictions.InsertUploaded(tx, course.ID)
 	return
 }
 
@@ -0,0 +1,604 @@
+package models
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+	"turm/app"
+	"unicode/utf8"
+
+	"github.com/revel/revel"
+)
+
+/*CourseJSONVersion is the version of the current course JSON format. Files without
+a version field are files of previous versions, see legacy.go. */
+const CourseJSONVersion = 5
+
+/*CourseJSON is the version 5 format of downloaded and uploaded courses. Timestamps
+are RFC 3339 timestamps, times of day have the format HH:MM. Nullable fields are
+null if not set. Unknown fields are rejected. */
+type CourseJSON struct {
+	Version           int                 `json:"version"`
+	Title             string              `json:"title"`
+	Subtitle          *string             `json:"subtitle"`
+	Visible           bool                `json:"visible"`
+	OnlyLDAP          bool                `json:"only_ldap"`
+	Description       *string             `json:"description"`
+	Speaker           *string             `json:"speaker"`
+	Fee               *float64            `json:"fee"`
+	CustomEMail       *string             `json:"custom_email"`
+	EnrollLimitEvents *int                `json:"enroll_limit_events"`
+	EnrollmentStart   time.Time           `json:"enrollment_start"`
+	EnrollmentEnd     time.Time           `json:"enrollment_end"`
+	UnsubscribeEnd    *time.Time          `json:"unsubscribe_end"`
+	ExpirationDate    time.Time           `json:"expiration_date"`
+	Events            []EventJSON         `json:"events"`
+	CalendarEvents    []CalendarEventJSON `json:"calendar_events"`
+	Editors           []UserListJSON      `json:"editors"`
+	Instructors       []UserListJSON      `json:"instructors"`
+	Blocklist         []UserListJSON      `json:"blocklist"`
+	Allowlist         []UserListJSON      `json:"allowlist"`
+	Restrictions      []RestrictionJSON   `json:"restrictions"`
+}
+
+/*EventJSON is the version 5 format of an event. The enrollment key is stored as
+its hash. */
+type EventJSON struct {
+	Title             string        `json:"title"`
+	Annotation        *string       `json:"annotation"`
+	Capacity          int           `json:"capacity"`
+	HasWaitlist       bool          `json:"has_waitlist"`
+	HasComments       bool          `json:"has_comments"`
+	EnrollmentKeyHash *string       `json:"enrollment_key_hash"`
+	Meetings          []MeetingJSON `json:"meetings"`
+}
+
+/*MeetingJSON is the version 5 format of a meeting. The interval is single, weekly,
+even or odd. Regular meetings have a week day between 0 (Monday) and 6 (Sunday), and
+only the times of day of their start and end are relevant. */
+type MeetingJSON struct {
+	Interval   string    `json:"interval"`
+	WeekDay    *int      `json:"weekday"`
+	Place      *string   `json:"place"`
+	Annotation *string   `json:"annotation"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+}
+
+/*CalendarEventJSON is the version 5 format of a calendar event. */
+type CalendarEventJSON struct {
+	Title        string            `json:"title"`
+	Annotation   *string           `json:"annotation"`
+	DayTemplates []DayTemplateJSON `json:"day_templates"`
+	Exceptions   []ExceptionJSON   `json:"exceptions"`
+}
+
+/*DayTemplateJSON is the version 5 format of a day template. The day of the week is
+between 0 (Monday) and 6 (Sunday), the interval is the length of a slot in minutes. */
+type DayTemplateJSON struct {
+	DayOfWeek int    `json:"day_of_week"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	Interval  int    `json:"interval"`
+}
+
+/*ExceptionJSON is the version 5 format of a calendar exception. */
+type ExceptionJSON struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Annotation *string   `json:"annotation"`
+}
+
+/*UserListJSON is the version 5 format of an entry of a user list. Users that do not
+exist are skipped during uploads. */
+type UserListJSON struct {
+	UserID     int    `json:"user_id"`
+	EMail      string `json:"email"`
+	ViewMatrNr bool   `json:"view_matr_nr"`
+}
+
+/*RestrictionJSON is the version 5 format of a course restriction. The names of the
+degree and the course of studies are informative, uploads use their IDs. */
+type RestrictionJSON struct {
+	MinimumSemester   *int64  `json:"minimum_semester"`
+	DegreeID          *int64  `json:"degree_id"`
+	Degree            *string `json:"degree"`
+	CourseOfStudiesID *int64  `json:"courses_of_studies_id"`
+	CourseOfStudies   *string `json:"course_of_studies"`
+}
+
+/*Export returns the version 5 format of a course. */
+func (course *Course) Export() (data CourseJSON) {
+
+	data = CourseJSON{
+		Version:         CourseJSONVersion,
+		Title:           course.Title,
+		Subtitle:        fromNullString(course.Subtitle),
+		Visible:         course.Visible,
+		OnlyLDAP:        course.OnlyLDAP,
+		Description:     fromNullString(course.Description),
+		Speaker:         fromNullString(course.Speaker),
+		CustomEMail:     fromNullString(course.CustomEMail),
+		EnrollmentStart: course.EnrollmentStart,
+		EnrollmentEnd:   course.EnrollmentEnd,
+		ExpirationDate:  course.ExpirationDate,
+		Events:          []EventJSON{},
+		CalendarEvents:  []CalendarEventJSON{},
+		Editors:         exportUserList(course.Editors),
+		Instructors:     exportUserList(course.Instructors),
+		Blocklist:       exportUserList(course.Blocklist),
+		Allowlist:       exportUserList(course.Allowlist),
+		Restrictions:    []RestrictionJSON{},
+	}
+
+	if course.Fee.Valid {
+		data.Fee = &course.Fee.Float64
+	}
+	if course.EnrollLimitEvents.Valid {
+		limit := int(course.EnrollLimitEvents.Int32)
+		data.EnrollLimitEvents = &limit
+	}
+	if course.UnsubscribeEnd.Valid {
+		data.UnsubscribeEnd = &course.UnsubscribeEnd.Time
+	}
+
+	for _, event := range course.Events {
+		eventJSON := EventJSON{
+			Title:             event.Title,
+			Annotation:        fromNullString(event.Annotation),
+			Capacity:          event.Capacity,
+			HasWaitlist:       event.HasWaitlist,
+			HasComments:       event.HasComments,
+			EnrollmentKeyHash: fromNullString(event.EnrollmentKey),
+			Meetings:          []MeetingJSON{},
+		}
+		for _, meeting := range event.Meetings {
+			meetingJSON := MeetingJSON{
+				Interval:   meeting.MeetingInterval.String(),
+				Place:      fromNullString(meeting.Place),
+				Annotation: fromNullString(meeting.Annotation),
+				Start:      meeting.MeetingStart,
+				End:        meeting.MeetingEnd,
+			}
+			if meeting.MeetingInterval != SINGLE {
+				weekDay := int(meeting.WeekDay.Int32)
+				meetingJSON.WeekDay = &weekDay
+			}
+			eventJSON.Meetings = append(eventJSON.Meetings, meetingJSON)
+		}
+		data.Events = append(data.Events, eventJSON)
+	}
+
+	for _, event := range course.CalendarEvents {
+		eventJSON := CalendarEventJSON{
+			Title:        event.Title,
+			Annotation:   fromNullString(event.Annotation),
+			DayTemplates: []DayTemplateJSON{},
+			Exceptions:   []ExceptionJSON{},
+		}
+		for _, day := range event.Days {
+			for _, tmpl := range day.DayTmpls {
+				eventJSON.DayTemplates = append(eventJSON.DayTemplates, DayTemplateJSON{
+					DayOfWeek: tmpl.DayOfWeek,
+					StartTime: tmpl.StartTime,
+					EndTime:   tmpl.EndTime,
+					Interval:  tmpl.Interval,
+				})
+			}
+		}
+		for _, exception := range event.Exceptions {
+			start, err := getTimestamp(exception.ExceptionStart)
+			if err != nil {
+				continue
+			}
+			end, err := getTimestamp(exception.ExceptionEnd)
+			if err != nil {
+				continue
+			}
+			eventJSON.Exceptions = append(eventJSON.Exceptions, ExceptionJSON{
+				Start:      start,
+				End:        end,
+				Annotation: fromNullString(exception.Annotation),
+			})
+		}
+		data.CalendarEvents = append(data.CalendarEvents, eventJSON)
+	}
+
+	for i := range course.Restrictions {
+		rest := &course.Restrictions[i]
+		restJSON := RestrictionJSON{
+			Degree:          fromNullString(rest.DegreeName),
+			CourseOfStudies: fromNullString(rest.StudiesName),
+		}
+		if rest.MinimumSemester.Valid {
+			restJSON.MinimumSemester = &rest.MinimumSemester.Int64
+		}
+		if rest.DegreeID.Valid {
+			restJSON.DegreeID = &rest.DegreeID.Int64
+		}
+		if rest.CourseOfStudiesID.Valid {
+			restJSON.CourseOfStudiesID = &rest.CourseOfStudiesID.Int64
+		}
+		data.Restrictions = append(data.Restrictions, restJSON)
+	}
+
+	return
+}
+
+/*Load a course from a JSON file. Files of the current version are validated against
+the version 5 format, files without version are converted from previous versions. */
+func (course *Course) Load(v *revel.Validation, data []byte) {
+
+	var jsonIntf map[string]interface{}
+	if err := json.Unmarshal(data, &jsonIntf); err != nil {
+		v.ErrorKey("validation.invalid.json.syntax", err.Error())
+		return
+	}
+
+	version, ok := jsonIntf["version"]
+	if !ok {
+		course.loadLegacy(v, data, jsonIntf)
+		return
+	}
+	if number, isNumber := version.(float64); !isNumber || number != CourseJSONVersion {
+		v.ErrorKey("validation.invalid.json.version", version, CourseJSONVersion)
+		return
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	courseJSON := CourseJSON{}
+	if err := decoder.Decode(&courseJSON); err != nil {
+		v.ErrorKey("validation.invalid.json.schema", err.Error())
+		return
+	}
+
+	if courseJSON.Validate(v); v.HasErrors() {
+		return
+	}
+	courseJSON.Transform(course)
+}
+
+/*Validate a course in the version 5 format. The error messages contain the path
+of each invalid field. */
+func (data *CourseJSON) Validate(v *revel.Validation) {
+
+	validateJSONLength(v, "title", data.Title, 511)
+	validateJSONNullLength(v, "subtitle", data.Subtitle, 511)
+
+	if data.Fee != nil && (*data.Fee < 0 || *data.Fee > 1000000) {
+		v.ErrorKey("validation.invalid.json.range", "fee", 0, 1000000)
+	}
+	if data.EnrollLimitEvents != nil {
+		validateJSONRange(v, "enroll_limit_events", *data.EnrollLimitEvents, 1, 1000000)
+	}
+
+	validateJSONCustomEMail(v, "custom_email", data.CustomEMail)
+
+	validateJSONRequired(v, "enrollment_start", data.EnrollmentStart)
+	validateJSONRequired(v, "enrollment_end", data.EnrollmentEnd)
+	validateJSONRequired(v, "expiration_date", data.ExpirationDate)
+
+	for i, event := range data.Events {
+		path := fmt.Sprintf("events[%d]", i)
+
+		validateJSONLength(v, path+".title", event.Title, 255)
+		if event.Title == "" {
+			v.ErrorKey("validation.invalid.json.required", path+".title")
+		}
+		validateJSONNullLength(v, path+".annotation", event.Annotation, 255)
+		validateJSONNullLength(v, path+".enrollment_key_hash", event.EnrollmentKeyHash, 511)
+		validateJSONRange(v, path+".capacity", event.Capacity, 1, 1000000)
+
+		for j, meeting := range event.Meetings {
+			meetingPath := fmt.Sprintf("%s.meetings[%d]", path, j)
+
+			if _, ok := meetingIntervals[meeting.Interval]; !ok {
+				v.ErrorKey("validation.invalid.json.interval", meetingPath+".interval")
+			} else if meeting.Interval != SINGLE.String() {
+				if meeting.WeekDay == nil {
+					v.ErrorKey("validation.invalid.json.required", meetingPath+".weekday")
+				} else {
+					validateJSONRange(v, meetingPath+".weekday", *meeting.WeekDay, 0, 6)
+				}
+			}
+
+			validateJSONNullLength(v, meetingPath+".place", meeting.Place, 255)
+			validateJSONNullLength(v, meetingPath+".annotation", meeting.Annotation, 255)
+			validateJSONRequired(v, meetingPath+".start", meeting.Start)
+			validateJSONRequired(v, meetingPath+".end", meeting.End)
+			if meeting.End.Before(meeting.Start) {
+				v.ErrorKey("validation.invalid.json.order", meetingPath+".end",
+					meetingPath+".start")
+			}
+		}
+	}
+
+	for i, event := range data.CalendarEvents {
+		path := fmt.Sprintf("calendar_events[%d]", i)
+
+		validateJSONLength(v, path+".title", event.Title, 255)
+		if event.Title == "" {
+			v.ErrorKey("validation.invalid.json.required", path+".title")
+		}
+		validateJSONNullLength(v, path+".annotation", event.Annotation, 255)
+
+		for j, tmpl := range event.DayTemplates {
+			tmplPath := fmt.Sprintf("%s.day_templates[%d]", path, j)
+
+			validateJSONRange(v, tmplPath+".day_of_week", tmpl.DayOfWeek, 0, 6)
+			validateJSONRange(v, tmplPath+".interval", tmpl.Interval, 1, 1440)
+
+			start, validStart := parseTimeOfDay(tmpl.StartTime)
+			if !validStart {
+				v.ErrorKey("validation.invalid.json.time", tmplPath+".start_time")
+			}
+			end, validEnd := parseTimeOfDay(tmpl.EndTime)
+			if !validEnd {
+				v.ErrorKey("validation.invalid.json.time", tmplPath+".end_time")
+			}
+			//an end time of 00:00 is the end of the day
+			if validStart && validEnd && end != 0 && end <= start {
+				v.ErrorKey("validation.invalid.json.order", tmplPath+".end_time",
+					tmplPath+".start_time")
+			}
+		}
+
+		for j, exception := range event.Exceptions {
+			exceptionPath := fmt.Sprintf("%s.exceptions[%d]", path, j)
+
+			validateJSONRequired(v, exceptionPath+".start", exception.Start)
+			validateJSONRequired(v, exceptionPath+".end", exception.End)
+			if !exception.End.After(exception.Start) {
+				v.ErrorKey("validation.invalid.json.order", exceptionPath+".end",
+					exceptionPath+".start")
+			}
+			validateJSONNullLength(v, exceptionPath+".annotation", exception.Annotation, 255)
+		}
+	}
+
+	validateJSONUserList(v, "editors", data.Editors)
+	validateJSONUserList(v, "instructors", data.Instructors)
+	validateJSONUserList(v, "blocklist", data.Blocklist)
+	validateJSONUserList(v, "allowlist", data.Allowlist)
+
+	for i, rest := range data.Restrictions {
+		path := fmt.Sprintf("restrictions[%d]", i)
+
+		if rest.MinimumSemester == nil && rest.DegreeID == nil && rest.CourseOfStudiesID == nil {
+			v.ErrorKey("validation.invalid.json.restriction", path)
+		}
+		if rest.MinimumSemester != nil {
+			validateJSONRange(v, path+".minimum_semester", int(*rest.MinimumSemester), 1, 100)
+		}
+	}
+}
+
+/*Transform a validated course in the version 5 format into the course struct. */
+func (data *CourseJSON) Transform(course *Course) {
+
+	course.Title = data.Title
+	course.Subtitle = toNullString(data.Subtitle)
+	course.Visible = data.Visible
+	course.OnlyLDAP = data.OnlyLDAP
+	course.Description = toNullString(data.Description)
+	course.Speaker = toNullString(data.Speaker)
+	course.CustomEMail = toNullString(data.CustomEMail)
+	course.EnrollmentStart = data.EnrollmentStart
+	course.EnrollmentEnd = data.EnrollmentEnd
+	course.ExpirationDate = data.ExpirationDate
+
+	if data.Fee != nil {
+		course.Fee = sql.NullFloat64{Float64: *data.Fee, Valid: true}
+	}
+	if data.EnrollLimitEvents != nil {
+		course.EnrollLimitEvents = sql.NullInt32{Int32: int32(*data.EnrollLimitEvents),
+			Valid: true}
+	}
+	if data.UnsubscribeEnd != nil {
+		course.UnsubscribeEnd = sql.NullTime{Time: *data.UnsubscribeEnd, Valid: true}
+	}
+
+	for _, eventJSON := range data.Events {
+		event := Event{
+			Title:         eventJSON.Title,
+			Annotation:    toNullString(eventJSON.Annotation),
+			Capacity:      eventJSON.Capacity,
+			HasWaitlist:   eventJSON.HasWaitlist,
+			HasComments:   eventJSON.HasComments,
+			EnrollmentKey: toNullString(eventJSON.EnrollmentKeyHash),
+		}
+		for _, meetingJSON := range eventJSON.Meetings {
+			meeting := Meeting{
+				MeetingInterval: meetingIntervals[meetingJSON.Interval],
+				Place:           toNullString(meetingJSON.Place),
+				Annotation:      toNullString(meetingJSON.Annotation),
+				MeetingStart:    meetingJSON.Start,
+				MeetingEnd:      meetingJSON.End,
+			}
+			if meeting.MeetingInterval != SINGLE {
+				meeting.WeekDay = sql.NullInt32{Int32: int32(*meetingJSON.WeekDay), Valid: true}
+			}
+			event.Meetings = append(event.Meetings, meeting)
+		}
+		course.Events = append(course.Events, event)
+	}
+
+	for _, eventJSON := range data.CalendarEvents {
+		event := CalendarEvent{
+			Title:      eventJSON.Title,
+			Annotation: toNullString(eventJSON.Annotation),
+			Days:       make(Days, 7),
+		}
+		for _, tmplJSON := range eventJSON.DayTemplates {
+			event.Days[tmplJSON.DayOfWeek].DayTmpls = append(
+				event.Days[tmplJSON.DayOfWeek].DayTmpls, DayTmpl{
+					DayOfWeek: tmplJSON.DayOfWeek,
+					StartTime: tmplJSON.StartTime,
+					EndTime:   tmplJSON.EndTime,
+					Interval:  tmplJSON.Interval,
+				})
+		}
+		for _, exceptionJSON := range eventJSON.Exceptions {
+			event.Exceptions = append(event.Exceptions, Exception{
+				ExceptionStart: formatTimestamp(exceptionJSON.Start),
+				ExceptionEnd:   formatTimestamp(exceptionJSON.End),
+				Annotation:     toNullString(exceptionJSON.Annotation),
+			})
+		}
+		course.CalendarEvents = append(course.CalendarEvents, event)
+	}
+
+	course.Editors = importUserList(data.Editors)
+	course.Instructors = importUserList(data.Instructors)
+	course.Blocklist = importUserList(data.Blocklist)
+	course.Allowlist = importUserList(data.Allowlist)
+
+	for _, restJSON := range data.Restrictions {
+		rest := Restriction{}
+		if restJSON.MinimumSemester != nil {
+			rest.MinimumSemester = sql.NullInt64{Int64: *restJSON.MinimumSemester, Valid: true}
+		}
+		if restJSON.DegreeID != nil {
+			rest.DegreeID = sql.NullInt64{Int64: *restJSON.DegreeID, Valid: true}
+		}
+		if restJSON.CourseOfStudiesID != nil {
+			rest.CourseOfStudiesID = sql.NullInt64{Int64: *restJSON.CourseOfStudiesID,
+				Valid: true}
+		}
+		course.Restrictions = append(course.Restrictions, rest)
+	}
+}
+
+//meetingIntervals maps the intervals of the version 5 format to meeting intervals
+var meetingIntervals = map[string]MeetingInterval{
+	SINGLE.String(): SINGLE,
+	WEEKLY.String(): WEEKLY,
+	EVEN.String():   EVEN,
+	ODD.String():    ODD,
+}
+
+//exportUserList returns the version 5 format of a user list
+func exportUserList(users UserList) (list []UserListJSON) {
+
+	list = []UserListJSON{}
+	for _, user := range users {
+		list = append(list, UserListJSON{
+			UserID:     user.UserID,
+			EMail:      user.EMail,
+			ViewMatrNr: user.ViewMatrNr,
+		})
+	}
+	return
+}
+
+//importUserList returns the user list of the version 5 format of a user list
+func importUserList(list []UserListJSON) (users UserList) {
+
+	for _, entry := range list {
+		users = append(users, UserListEntry{
+			UserID:     entry.UserID,
+			EMail:      entry.EMail,
+			ViewMatrNr: entry.ViewMatrNr,
+		})
+	}
+	return
+}
+
+//validateJSONRequired validates that a timestamp of the version 5 format is set
+func validateJSONRequired(v *revel.Validation, path string, t time.Time) {
+
+	if t.IsZero() {
+		v.ErrorKey("validation.invalid.json.required", path)
+	}
+}
+
+//validateJSONLength validates the length of a string of the version 5 format
+func validateJSONLength(v *revel.Validation, path, str string, max int) {
+
+	if utf8.RuneCountInString(str) > max {
+		v.ErrorKey("validation.invalid.json.length", path, max)
+	}
+}
+
+//validateJSONNullLength validates the length of a nullable string of the version 5 format
+func validateJSONNullLength(v *revel.Validation, path string, str *string, max int) {
+
+	if str != nil {
+		validateJSONLength(v, path, *str, max)
+	}
+}
+
+//validateJSONRange validates that an integer of the version 5 format is within a range
+func validateJSONRange(v *revel.Validation, path string, value, min, max int) {
+
+	if value < min || value > max {
+		v.ErrorKey("validation.invalid.json.range", path, min, max)
+	}
+}
+
+//validateJSONCustomEMail validates that a custom e-mail of the course format is a
+//valid e-mail template
+func validateJSONCustomEMail(v *revel.Validation, path string, content *string) {
+
+	if content == nil {
+		return
+	}
+	if err := checkCustomEMail(content); err != nil {
+		v.ErrorKey("validation.invalid.json.custom.email", path, err.Error())
+	}
+}
+
+//validateJSONUserList validates the entries of a user list of the version 5 format
+func validateJSONUserList(v *revel.Validation, path string, list []UserListJSON) {
+
+	for i, entry := range list {
+		if entry.UserID < 1 {
+			v.ErrorKey("validation.invalid.json.required", fmt.Sprintf("%s[%d].user_id", path, i))
+		}
+	}
+}
+
+//parseTimeOfDay returns the minutes since midnight of a time of day (HH:MM)
+func parseTimeOfDay(str string) (minutes int, valid bool) {
+
+	if str == "24:00" {
+		return 24 * 60, true
+	}
+
+	t, err := time.Parse("15:04", str)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+//formatTimestamp formats a timestamp in the time zone of the application
+func formatTimestamp(t time.Time) string {
+
+	if loc, err := time.LoadLocation(app.TimeZone); err == nil {
+		t = t.In(loc)
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+//fromNullString returns a pointer to the string of a valid nullable string
+func fromNullString(str sql.NullString) *string {
+
+	if !str.Valid {
+		return nil
+	}
+	return &str.String
+}
+
+//toNullString returns a nullable string of a pointer to a string
+func toNullString(str *string) sql.NullString {
+
+	if str == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *str, Valid: true}
+}
@@ -0,0 +1,886 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"turm/app"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/revel/revel"
+)
+
+/*CourseRevisions holds the revision history of a course. */
+type CourseRevisions []CourseRevision
+
+/*CourseRevision is a snapshot of the editable data of a course, i.e., of its fields,
+events, meetings, calendar events and user lists, after an edit. */
+type CourseRevision struct {
+	ID       int           `db:"id, primarykey, autoincrement"`
+	CourseID int           `db:"course_id"`
+	Editor   sql.NullInt32 `db:"editor"`
+	Data     string        `db:"data"`
+
+	//not fields in the respective table
+	Created     string         `db:"created_str"`
+	EditorName  sql.NullString `db:"editor_name"`
+	EditorEMail sql.NullString `db:"editor_email"`
+	Snapshot    RevisionData   ``
+
+	//Changes is the number of changes compared to the preceding revision
+	Changes    int ``
+	PreviousID int ``
+}
+
+/*RevisionData contains all values of a revision. Keys are of the form
+table.ID.column, e.g., events.12.title. Labels contain a human readable name of each
+element, their keys are of the form table.ID. Users of user lists are only identified
+by their ID, their labels are resolved when rendering the changes. */
+type RevisionData struct {
+	Values map[string]*string `json:"values"`
+	Labels map[string]string  `json:"labels"`
+}
+
+/*RestoredUserLists contains the e-mail data of all editors and instructors of an
+active course whose user list entries were restored, i.e., who get the same
+notification e-mails as when editing the user lists. */
+type RestoredUserLists struct {
+	Added      EMailsData
+	Removed    EMailsData
+	ViewMatrNr EMailsData
+}
+
+/*RevisionChanges contains the differences between two revisions. */
+type RevisionChanges []RevisionChange
+
+/*RevisionChange is the difference of a field or of an element between two revisions.
+If Field is empty, then the element was added or removed. */
+type RevisionChange struct {
+	Key        string
+	Table      string
+	Element    string
+	Field      string
+	Old        sql.NullString
+	New        sql.NullString
+	Restorable bool
+}
+
+//revisionTable specifies how to snapshot and restore the rows of a table
+type revisionTable struct {
+	name   string
+	from   string
+	id     string
+	label  string
+	filter string
+
+	//userList tables can restore added and removed entries
+	userList bool
+	columns  []revisionColumn
+
+	//removed elements of restorable tables can be re-created, elements of tables with
+	//a parent table are re-created with their parent element, e.g., the meetings of an
+	//event, parentID is the column referencing the parent element
+	restorable bool
+	parent     string
+	parentID   string
+}
+
+//revisionColumn is a column of a revisionTable, kind is the type of the column
+type revisionColumn struct {
+	name string
+	kind string
+
+	//fixed columns have side effects on the enrollments, e.g., changing the capacity
+	//enrolls users from the wait list, so they are not restored
+	fixed bool
+}
+
+var revisionTables = []revisionTable{
+	{name: "courses", from: "courses c", id: "c.id", label: "c.title", filter: "c.id = $1",
+		columns: []revisionColumn{
+			{name: "title", kind: "text"},
+			{name: "subtitle", kind: "text"},
+			{name: "description", kind: "text"},
+			{name: "speaker", kind: "text"},
+			{name: "fee", kind: "numeric"},
+			{name: "custom_email", kind: "text"},
+			{name: "visible", kind: "boolean"},
+			{name: "only_ldap", kind: "boolean"},
+			{name: "enroll_limit_events", kind: "integer"},
+			{name: "enrollment_start", kind: "timestamp"},
+			{name: "enrollment_end", kind: "timestamp"},
+			{name: "unsubscribe_end", kind: "timestamp"},
+			{name: "expiration_date", kind: "timestamp"},
+			{name: "parent_id", kind: "integer"},
+		}},
+	{name: "events", from: "events e", id: "e.id", label: "e.title", filter: "e.course_id = $1",
+		restorable: true,
+		columns: []revisionColumn{
+			{name: "title", kind: "text"},
+			{name: "annotation", kind: "text"},
+			{name: "capacity", kind: "integer", fixed: true},
+			{name: "has_waitlist", kind: "boolean", fixed: true},
+			{name: "has_comments", kind: "boolean", fixed: true},
+		}},
+	{name: "meetings", from: "meetings m JOIN events e ON m.event_id = e.id", id: "m.id",
+		label: "e.title", filter: "e.course_id = $1",
+		restorable: true, parent: "events", parentID: "event_id",
+		columns: []revisionColumn{
+			{name: "meeting_interval", kind: "integer"},
+			{name: "weekday", kind: "integer"},
+			{name: "place", kind: "text"},
+			{name: "annotation", kind: "text"},
+			{name: "meeting_start", kind: "timestamp"},
+			{name: "meeting_end", kind: "timestamp"},
+		}},
+	{name: "calendar_events", from: "calendar_events ce", id: "ce.id", label: "ce.title",
+		filter: "ce.course_id = $1", restorable: true,
+		columns: []revisionColumn{
+			{name: "title", kind: "text"},
+			{name: "annotation", kind: "text"},
+		}},
+	{name: "day_templates", from: "day_templates d JOIN calendar_events ce ON d.calendar_event_id = ce.id",
+		id: "d.id", label: "ce.title", filter: "ce.course_id = $1",
+		restorable: true, parent: "calendar_events", parentID: "calendar_event_id",
+		columns: []revisionColumn{
+			{name: "day_of_week", kind: "integer"},
+			{name: "start_time", kind: "time"},
+			{name: "end_time", kind: "time"},
+			{name: "interval", kind: "integer"},
+		}},
+	{name: "calendar_exceptions", from: "calendar_exceptions x JOIN calendar_events ce ON x.calendar_event_id = ce.id",
+		id: "x.id", label: "ce.title", filter: "ce.course_id = $1",
+		restorable: true, parent: "calendar_events", parentID: "calendar_event_id",
+		columns: []revisionColumn{
+			{name: "exception_start", kind: "timestamp"},
+			{name: "exception_end", kind: "timestamp"},
+			{name: "annotation", kind: "text"},
+		}},
+	{name: TableEditors, from: TableEditors + " l", id: "l.user_id",
+		label: "''", filter: "l.course_id = $1", userList: true,
+		columns: []revisionColumn{
+			{name: "view_matr_nr", kind: "boolean"},
+		}},
+	{name: TableInstructors, from: TableInstructors + " l", id: "l.user_id",
+		label: "''", filter: "l.course_id = $1", userList: true,
+		columns: []revisionColumn{
+			{name: "view_matr_nr", kind: "boolean"},
+		}},
+	{name: TableBlocklists, from: TableBlocklists + " l", id: "l.user_id",
+		label: "''", filter: "l.course_id = $1", userList: true},
+	{name: TableAllowlists, from: TableAllowlists + " l", id: "l.user_id",
+		label: "''", filter: "l.course_id = $1", userList: true},
+}
+
+/*CourseIDOf returns the ID of the course of an element of a course. */
+func CourseIDOf(table string, ID int) (courseID int, err error) {
+
+	switch table {
+	case "courses":
+		return ID, nil
+	case "events":
+		err = app.Db.Get(&courseID, stmtGetCourseIDByEvent, ID)
+	case "meetings":
+		err = app.Db.Get(&courseID, stmtGetCourseIDByMeeting, ID)
+	case "calendar_events":
+		err = app.Db.Get(&courseID, stmtGetCourseIDByCalendarEvent, ID)
+	default:
+		err = errors.New("invalid table")
+	}
+
+	if err != nil {
+		log.Error("failed to get course ID", "table", table, "ID", ID,
+			"error", err.Error())
+	}
+	return
+}
+
+/*Record a new revision of a course, if its data changed since its latest revision. */
+func (revision *CourseRevision) Record() (recorded bool, err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	if err = revision.Snapshot.get(tx, revision.CourseID); err != nil {
+		return
+	}
+
+	data, err := json.Marshal(revision.Snapshot)
+	if err != nil {
+		log.Error("failed to marshal revision data", "courseID", revision.CourseID,
+			"error", err.Error())
+		tx.Rollback()
+		return
+	}
+	revision.Data = string(data)
+
+	//compare the snapshot to the latest revision
+	latest := CourseRevision{}
+	err = tx.Get(&latest, stmtGetLatestRevision, revision.CourseID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Error("failed to get latest revision", "courseID", revision.CourseID,
+			"error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	if err == nil {
+		if err = json.Unmarshal([]byte(latest.Data), &latest.Snapshot); err != nil {
+			log.Error("failed to unmarshal revision data", "revisionID", latest.ID,
+				"error", err.Error())
+			tx.Rollback()
+			return
+		}
+		if len(latest.Snapshot.diff(&revision.Snapshot)) == 0 {
+			tx.Commit()
+			return false, nil
+		}
+	}
+
+	err = tx.Get(revision, stmtInsertRevision, revision.CourseID, revision.Editor,
+		revision.Data)
+	if err != nil {
+		log.Error("failed to insert revision", "courseID", revision.CourseID,
+			"error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	tx.Commit()
+	return true, nil
+}
+
+/*Select all revisions of a course. */
+func (revisions *CourseRevisions) Select(courseID int) (err error) {
+
+	err = app.Db.Select(revisions, stmtSelectRevisions, courseID, app.TimeZone)
+	if err != nil {
+		log.Error("failed to select revisions", "courseID", courseID,
+			"error", err.Error())
+		return
+	}
+
+	//count the changes of each revision, revisions are sorted descending
+	for key := range *revisions {
+		if err = json.Unmarshal([]byte((*revisions)[key].Data),
+			&(*revisions)[key].Snapshot); err != nil {
+			log.Error("failed to unmarshal revision data", "revisionID",
+				(*revisions)[key].ID, "error", err.Error())
+			return
+		}
+	}
+	for key := range *revisions {
+		if key+1 < len(*revisions) {
+			(*revisions)[key].PreviousID = (*revisions)[key+1].ID
+			(*revisions)[key].Changes = len((*revisions)[key+1].Snapshot.diff(
+				&(*revisions)[key].Snapshot))
+		} else {
+			(*revisions)[key].Changes = len(RevisionData{}.diff(&(*revisions)[key].Snapshot))
+		}
+	}
+	return
+}
+
+/*Get a revision of a course. */
+func (revision *CourseRevision) Get(tx *sqlx.Tx) (err error) {
+
+	if tx == nil {
+		err = app.Db.Get(revision, stmtGetRevision, revision.ID, revision.CourseID,
+			app.TimeZone)
+	} else {
+		err = tx.Get(revision, stmtGetRevision, revision.ID, revision.CourseID,
+			app.TimeZone)
+	}
+
+	if err != nil {
+		log.Error("failed to get revision", "ID", revision.ID, "courseID",
+			revision.CourseID, "error", err.Error())
+		if tx != nil {
+			tx.Rollback()
+		}
+		return
+	}
+
+	if err = json.Unmarshal([]byte(revision.Data), &revision.Snapshot); err != nil {
+		log.Error("failed to unmarshal revision data", "ID", revision.ID,
+			"error", err.Error())
+		if tx != nil {
+			tx.Rollback()
+		}
+	}
+	return
+}
+
+/*Diff returns all changes between a revision and another revision of the same course.
+If compareID is 0, then the revision is compared to the current data of the course. */
+func (revision *CourseRevision) Diff(compareID int) (changes RevisionChanges, err error) {
+
+	if err = revision.Get(nil); err != nil {
+		return
+	}
+
+	compare := CourseRevision{ID: compareID, CourseID: revision.CourseID}
+	if compareID != 0 {
+		if err = compare.Get(nil); err != nil {
+			return
+		}
+		changes = revision.Snapshot.diff(&compare.Snapshot)
+
+		//only changes compared to the current data can be restored
+		for key := range changes {
+			changes[key].Restorable = false
+		}
+		err = changes.resolveUsers()
+		return
+	}
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	if err = compare.Snapshot.get(tx, revision.CourseID); err != nil {
+		return
+	}
+
+	tx.Commit()
+	changes = revision.Snapshot.diff(&compare.Snapshot)
+	err = changes.resolveUsers()
+	return
+}
+
+/*Restore the values of a revision. If key is empty, then all restorable changes are
+restored, else only the change of the respective field or element. Active courses
+must remain valid. Returns the number of restored changes and the e-mail data of
+all editors and instructors whose user list entries were restored. */
+func (revision *CourseRevision) Restore(v *revel.Validation, key string) (restored int,
+	userLists RestoredUserLists, err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	if err = revision.Get(tx); err != nil {
+		return
+	}
+
+	current := RevisionData{}
+	if err = current.get(tx, revision.CourseID); err != nil {
+		return
+	}
+
+	for _, change := range revision.Snapshot.diff(&current) {
+
+		if (key != "" && change.Key != key) || !change.Restorable {
+			continue
+		}
+
+		//users deleted due to data policy requirements cannot be restored
+		if change.Field == "" && change.Old.Valid && revisionTableOf(change.Table).userList {
+			entry := UserListEntry{UserID: elementID(change.Key)}
+			exists, err := entry.Exists(tx)
+			if err != nil {
+				return restored, userLists, err
+			} else if !exists {
+				continue
+			}
+		}
+
+		err = change.restore(tx, revision.CourseID, &revision.Snapshot, &userLists)
+		if err != nil {
+			return
+		}
+		restored++
+	}
+
+	if restored == 0 {
+		v.ErrorKey("validation.invalid.revision.restore")
+		tx.Rollback()
+		return
+	}
+
+	//active courses must remain valid
+	course := Course{ID: revision.CourseID}
+	if err = course.Get(tx, true, 0); err != nil {
+		return
+	}
+	if course.Active {
+		if course.Validate(v); v.HasErrors() {
+			tx.Rollback()
+			return 0, RestoredUserLists{}, nil
+		}
+	}
+
+	tx.Commit()
+	return
+}
+
+//get the current data of a course
+func (data *RevisionData) get(tx *sqlx.Tx, courseID int) (err error) {
+
+	data.Values = make(map[string]*string)
+	data.Labels = make(map[string]string)
+
+	for _, table := range revisionTables {
+
+		selection := table.id + "::text, " + table.label
+		hasTimestamp := false
+		alias := strings.Split(table.id, ".")[0]
+
+		for _, column := range table.columns {
+			if column.kind == "timestamp" {
+				hasTimestamp = true
+				selection += `, TO_CHAR (` + alias + `."` + column.name +
+					`" AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI')`
+			} else {
+				selection += `, ` + alias + `."` + column.name + `"::text`
+			}
+		}
+
+		//the parent column is only stored to re-create removed elements
+		if table.parentID != "" {
+			selection += `, ` + alias + `."` + table.parentID + `"::text`
+		}
+
+		args := []interface{}{courseID}
+		if hasTimestamp {
+			args = append(args, app.TimeZone)
+		}
+
+		var rows *sql.Rows
+		rows, err = tx.Query(`SELECT `+selection+` FROM `+table.from+
+			` WHERE `+table.filter, args...)
+		if err != nil {
+			log.Error("failed to select revision data", "table", table.name,
+				"courseID", courseID, "error", err.Error())
+			tx.Rollback()
+			return
+		}
+
+		for rows.Next() {
+
+			values := make([]sql.NullString, len(table.columns)+2)
+			if table.parentID != "" {
+				values = append(values, sql.NullString{})
+			}
+			dest := make([]interface{}, len(values))
+			for i := range values {
+				dest[i] = &values[i]
+			}
+
+			if err = rows.Scan(dest...); err != nil {
+				log.Error("failed to scan revision data", "table", table.name,
+					"courseID", courseID, "error", err.Error())
+				rows.Close()
+				tx.Rollback()
+				return
+			}
+
+			element := table.name + "." + values[0].String
+			data.Labels[element] = values[1].String
+			for i, column := range table.columns {
+				if values[i+2].Valid {
+					value := values[i+2].String
+					data.Values[element+"."+column.name] = &value
+				} else {
+					data.Values[element+"."+column.name] = nil
+				}
+			}
+			if table.parentID != "" {
+				parentID := values[len(values)-1].String
+				data.Values[element+"."+table.parentID] = &parentID
+			}
+		}
+
+		if err = rows.Err(); err != nil {
+			log.Error("failed to iterate revision data", "table", table.name,
+				"courseID", courseID, "error", err.Error())
+			tx.Rollback()
+			return
+		}
+	}
+	return
+}
+
+//diff returns all changes from the old data to the compared data
+func (old RevisionData) diff(compared *RevisionData) (changes RevisionChanges) {
+
+	for _, table := range revisionTables {
+
+		//collect the elements of this table
+		var elements []string
+		seen := make(map[string]bool)
+		for _, labels := range []map[string]string{old.Labels, compared.Labels} {
+			for element := range labels {
+				if strings.HasPrefix(element, table.name+".") && !seen[element] {
+					seen[element] = true
+					elements = append(elements, element)
+				}
+			}
+		}
+		sort.Slice(elements, func(i, j int) bool {
+			return elementID(elements[i]) < elementID(elements[j])
+		})
+
+		for _, element := range elements {
+
+			oldLabel, inOld := old.Labels[element]
+			newLabel, inNew := compared.Labels[element]
+
+			//the element was added or removed
+			if !inOld || !inNew {
+				restorable := table.userList
+				if table.restorable && !inNew {
+					restorable = old.parentExists(&table, element, compared)
+				}
+				changes = append(changes, RevisionChange{
+					Key:        element,
+					Table:      table.name,
+					Element:    oldLabel + newLabel,
+					Old:        sql.NullString{String: oldLabel, Valid: inOld},
+					New:        sql.NullString{String: newLabel, Valid: inNew},
+					Restorable: restorable,
+				})
+				continue
+			}
+
+			for _, column := range table.columns {
+
+				key := element + "." + column.name
+				oldValue, newValue := old.Values[key], compared.Values[key]
+				if oldValue == nil && newValue == nil {
+					continue
+				} else if oldValue != nil && newValue != nil && *oldValue == *newValue {
+					continue
+				}
+
+				change := RevisionChange{
+					Key:        key,
+					Table:      table.name,
+					Element:    newLabel,
+					Field:      column.name,
+					Restorable: !column.fixed,
+				}
+				if oldValue != nil {
+					change.Old = sql.NullString{String: *oldValue, Valid: true}
+				}
+				if newValue != nil {
+					change.New = sql.NullString{String: *newValue, Valid: true}
+				}
+				changes = append(changes, change)
+			}
+		}
+	}
+	return
+}
+
+//restore the old value of a change, old contains all values of the restored revision,
+//user list entries are restored like edits of the user lists, userLists collects the
+//e-mail data of their notifications
+func (change *RevisionChange) restore(tx *sqlx.Tx, courseID int, old *RevisionData,
+	userLists *RestoredUserLists) (err error) {
+
+	table := revisionTableOf(change.Table)
+
+	parts := strings.Split(change.Key, ".")
+	ID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		log.Error("failed to parse element ID", "key", change.Key, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	//re-create a removed element
+	if change.Field == "" && table.restorable && change.Old.Valid {
+		return restoreElement(tx, &table, ID, courseID, old)
+	}
+
+	//restore an added or removed user list entry
+	if table.userList && change.Field == "" {
+
+		entry := UserListEntry{UserID: ID, CourseID: courseID}
+		var active bool
+		var data EMailData
+
+		if change.Old.Valid {
+			viewMatrNr := old.Values[change.Key+".view_matr_nr"]
+			entry.ViewMatrNr = viewMatrNr != nil && *viewMatrNr == "true"
+			if active, data, err = entry.insert(tx, table.name); err == nil && active {
+				userLists.Added = append(userLists.Added, data)
+			}
+		} else {
+			if active, data, err = entry.delete(tx, table.name); err == nil && active {
+				userLists.Removed = append(userLists.Removed, data)
+			}
+		}
+		return
+	}
+
+	//restore the matriculation number authorization of an editor or instructor
+	if table.userList && change.Field == "view_matr_nr" {
+
+		entry := UserListEntry{UserID: ID, CourseID: courseID,
+			ViewMatrNr: change.Old.String == "true"}
+		active, data, err := entry.update(tx, table.name)
+		if err == nil && active {
+			userLists.ViewMatrNr = append(userLists.ViewMatrNr, data)
+		}
+		return err
+	}
+
+	//the fee also changes the enrollment status of all participants
+	if change.Table == "courses" && change.Field == ColFee {
+
+		fee := sql.NullFloat64{Valid: change.Old.Valid}
+		if fee.Valid {
+			fee.Float64, err = strconv.ParseFloat(change.Old.String, 64)
+			if err != nil {
+				log.Error("failed to parse fee", "value", change.Old.String,
+					"error", err.Error())
+				tx.Rollback()
+				return
+			}
+		}
+
+		course := Course{ID: courseID}
+		return course.Update(tx, ColFee, fee, nil)
+	}
+
+	var kind string
+	for _, column := range table.columns {
+		if column.name == change.Field {
+			kind = column.kind
+		}
+	}
+
+	value := `$2::` + kind
+	args := []interface{}{ID, change.Old}
+	if kind == "timestamp" {
+		value = `$2::timestamp AT TIME ZONE $3`
+		args = append(args, app.TimeZone)
+	}
+
+	update := `UPDATE ` + table.name + ` SET "` + change.Field + `" = ` + value +
+		` WHERE id = $1`
+	if table.userList {
+		update += ` AND course_id = ` + strconv.Itoa(courseID)
+		update = strings.Replace(update, "WHERE id = $1", "WHERE user_id = $1", 1)
+	}
+
+	if _, err = tx.Exec(update, args...); err != nil {
+		log.Error("failed to restore value", "key", change.Key, "courseID", courseID,
+			"error", err.Error())
+		tx.Rollback()
+	}
+	return
+}
+
+//restoreElement re-creates a removed element with its ID, and all elements that were
+//removed with it, e.g., the meetings of an event, old contains all values of the
+//restored revision
+func restoreElement(tx *sqlx.Tx, table *revisionTable, ID, courseID int,
+	old *RevisionData) (err error) {
+
+	element := table.name + "." + strconv.Itoa(ID)
+	columns := []string{"id"}
+	values := []string{"$1"}
+	args := []interface{}{ID}
+
+	if table.parent == "" {
+		columns = append(columns, "course_id")
+		args = append(args, courseID)
+	} else {
+		columns = append(columns, table.parentID)
+		args = append(args, nullString(old.Values[element+"."+table.parentID]))
+	}
+	values = append(values, "$2::integer")
+
+	timeZone := ""
+	for _, column := range table.columns {
+
+		args = append(args, nullString(old.Values[element+"."+column.name]))
+		value := "$" + strconv.Itoa(len(args)) + "::" + column.kind
+		if column.kind == "timestamp" {
+			if timeZone == "" {
+				args = append(args, app.TimeZone)
+				timeZone = "$" + strconv.Itoa(len(args))
+			}
+			value += " AT TIME ZONE " + timeZone
+		}
+
+		columns = append(columns, `"`+column.name+`"`)
+		values = append(values, value)
+	}
+
+	_, err = tx.Exec(`INSERT INTO `+table.name+` (`+strings.Join(columns, ", ")+
+		`) VALUES (`+strings.Join(values, ", ")+`)`, args...)
+	if err != nil {
+		log.Error("failed to re-create element", "element", element,
+			"courseID", courseID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	//re-create the elements of all child tables
+	for _, child := range revisionTables {
+
+		if child.parent != table.name {
+			continue
+		}
+
+		for childElement := range old.Labels {
+
+			parentID := old.Values[childElement+"."+child.parentID]
+			if !strings.HasPrefix(childElement, child.name+".") || parentID == nil ||
+				*parentID != strconv.Itoa(ID) {
+				continue
+			}
+
+			err = restoreElement(tx, &child, elementID(childElement), courseID, old)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+//parentExists returns whether the parent of a removed element exists in the compared
+//data, so that the element can be re-created. Elements of revisions recorded without
+//their parent ID cannot be re-created.
+func (old *RevisionData) parentExists(table *revisionTable, element string,
+	compared *RevisionData) bool {
+
+	if table.parent == "" {
+		return true
+	}
+
+	parentID := old.Values[element+"."+table.parentID]
+	if parentID == nil {
+		return false
+	}
+	_, exists := compared.Labels[table.parent+"."+*parentID]
+	return exists
+}
+
+//resolveUsers sets the labels of the changes of user lists to the current name and
+//e-mail address of the users, as revisions only contain their IDs
+func (changes RevisionChanges) resolveUsers() (err error) {
+
+	labels := make(map[int]string)
+	for key := range changes {
+
+		change := &changes[key]
+		if !revisionTableOf(change.Table).userList {
+			continue
+		}
+
+		ID := elementID(change.Key)
+		label, found := labels[ID]
+		if !found {
+			err = app.Db.Get(&label, stmtGetRevisionUserLabel, ID)
+			if err == sql.ErrNoRows {
+				//the user data was deleted due to data policy requirements
+				err = nil
+			} else if err != nil {
+				log.Error("failed to get label of revision user", "userID", ID,
+					"error", err.Error())
+				return
+			}
+			labels[ID] = label
+		}
+
+		change.Element = label
+		if change.Field == "" {
+			if change.Old.Valid {
+				change.Old.String = label
+			}
+			if change.New.Valid {
+				change.New.String = label
+			}
+		}
+	}
+	return
+}
+
+//revisionTableOf returns the revision table of a table name
+func revisionTableOf(name string) (table revisionTable) {
+
+	for _, t := range revisionTables {
+		if t.name == name {
+			table = t
+		}
+	}
+	return
+}
+
+//nullString returns the SQL representation of a revision value
+func nullString(value *string) sql.NullString {
+
+	if value == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *value, Valid: true}
+}
+
+//elementID returns the ID of the element of a key
+func elementID(key string) (ID int) {
+
+	parts := strings.Split(key, ".")
+	if len(parts) > 1 {
+		ID, _ = strconv.Atoi(parts[1])
+	}
+	return
+}
+
+const (
+	stmtGetLatestRevision = `
+		SELECT id, course_id, editor, data
+		FROM course_revisions
+		WHERE course_id = $1
+		ORDER BY id DESC
+		LIMIT 1
+	`
+
+	stmtInsertRevision = `
+		INSERT INTO course_revisions
+			(course_id, editor, created, data)
+		VALUES ($1, $2, now(), $3)
+		RETURNING id
+	`
+
+	stmtSelectRevisions = `
+		SELECT r.id, r.course_id, r.editor, r.data,
+			TO_CHAR (r.created AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS created_str,
+			u.first_name || ' ' || u.last_name AS editor_name,
+			u.email AS editor_email
+		FROM course_revisions r LEFT OUTER JOIN users u ON r.editor = u.id
+		WHERE r.course_id = $1
+		ORDER BY r.id DESC
+	`
+
+	stmtGetRevisionUserLabel = `
+		SELECT first_name || ' ' || last_name || ' (' || email || ')'
+		FROM users
+		WHERE id = $1
+	`
+
+	stmtGetRevision = `
+		SELECT r.id, r.course_id, r.editor, r.data,
+			TO_CHAR (r.created AT TIME ZONE $3, 'YYYY-MM-DD HH24:MI') AS created_str,
+			u.first_name || ' ' || u.last_name AS editor_name,
+			u.email AS editor_email
+		FROM course_revisions r LEFT OUTER JOIN users u ON r.editor = u.id
+		WHERE r.id = $1
+			AND r.course_id = $2
+	`
+)
@@ -0,0 +1,293 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+	"turm/app"
+
+	"github.com/revel/revel"
+)
+
+/*CourseTemplates contains all course templates. */
+type CourseTemplates []CourseTemplate
+
+/*CourseTemplate is a recommended course structure curated by admins. Templates
+without a group are available institution-wide, courses created from a group
+template are placed in that group. The structure of a template, i.e., its events,
+meetings, restrictions, custom e-mail and calendar day templates, is stored in the
+course JSON format. */
+type CourseTemplate struct {
+	ID          int            `db:"id, primarykey, autoincrement"`
+	Name        string         `db:"name"`
+	Description sql.NullString `db:"description"`
+	GroupID     sql.NullInt32  `db:"group_id"`
+	Creator     sql.NullInt32  `db:"creator"`
+	Created     string         `db:"created"`
+	Data        string         `db:"data"`
+
+	//the course from which the structure of the template is taken
+	CourseID int ``
+
+	//used for rendering
+	GroupName sql.NullString `db:"group_name"`
+}
+
+/*Validate CourseTemplate fields. */
+func (tmpl *CourseTemplate) Validate(v *revel.Validation) {
+
+	tmpl.Name = strings.TrimSpace(tmpl.Name)
+	v.Check(tmpl.Name,
+		revel.MinSize{3},
+		revel.MaxSize{255},
+	).MessageKey("validation.invalid.template.name")
+
+	ValidateLengthAndValid(&tmpl.Description, "validation.invalid.template.description",
+		0, 2047, v)
+
+	if tmpl.GroupID.Int32 != 0 {
+		tmpl.GroupID.Valid = true
+	}
+}
+
+/*Insert a new course template. Its structure is taken from an existing course. */
+func (tmpl *CourseTemplate) Insert(v *revel.Validation) (err error) {
+
+	if err = tmpl.setData(v); err != nil || v.HasErrors() {
+		return
+	}
+
+	err = app.Db.Get(tmpl, stmtInsertCourseTemplate, tmpl.Name, tmpl.Description,
+		tmpl.GroupID, tmpl.Creator, tmpl.Data)
+	if err != nil {
+		log.Error("failed to insert course template", "name", tmpl.Name,
+			"groupID", tmpl.GroupID, "error", err.Error())
+	}
+	return
+}
+
+/*Update the name, description and group of a course template. If a course ID is
+provided, then the structure of the template is replaced by the structure of that
+course. */
+func (tmpl *CourseTemplate) Update(v *revel.Validation) (err error) {
+
+	if tmpl.CourseID == 0 {
+		err = app.Db.Get(tmpl, stmtUpdateCourseTemplate, tmpl.ID, tmpl.Name,
+			tmpl.Description, tmpl.GroupID)
+	} else {
+		if err = tmpl.setData(v); err != nil || v.HasErrors() {
+			return
+		}
+		err = app.Db.Get(tmpl, stmtUpdateCourseTemplateData, tmpl.ID, tmpl.Name,
+			tmpl.Description, tmpl.GroupID, tmpl.Data)
+	}
+
+	if err == sql.ErrNoRows {
+		v.ErrorKey("validation.invalid.template")
+		return nil
+	} else if err != nil {
+		log.Error("failed to update course template", "ID", tmpl.ID,
+			"courseID", tmpl.CourseID, "error", err.Error())
+	}
+	return
+}
+
+/*Delete a course template. Courses created from the template are not affected. */
+func (tmpl *CourseTemplate) Delete() (err error) {
+
+	_, err = app.Db.Exec(stmtDeleteCourseTemplate, tmpl.ID)
+	if err != nil {
+		log.Error("failed to delete course template", "ID", tmpl.ID,
+			"error", err.Error())
+	}
+	return
+}
+
+/*Get a course template. */
+func (tmpl *CourseTemplate) Get() (err error) {
+
+	err = app.Db.Get(tmpl, stmtGetCourseTemplate, tmpl.ID, app.TimeZone)
+	if err != nil && err != sql.ErrNoRows {
+		log.Error("failed to get course template", "ID", tmpl.ID,
+			"error", err.Error())
+	}
+	return
+}
+
+/*Select all course templates, the institution-wide templates first. */
+func (templates *CourseTemplates) Select() (err error) {
+
+	err = app.Db.Select(templates, stmtSelectCourseTemplates, app.TimeZone)
+	if err != nil {
+		log.Error("failed to select course templates", "error", err.Error())
+	}
+	return
+}
+
+/*Instantiate inserts a new course draft from a course template. All dates of the
+template are shifted by the same number of days, so that the enrollment of the new
+course starts at the specified date. The time of day of each date is preserved. */
+func (tmpl *CourseTemplate) Instantiate(v *revel.Validation, course *Course,
+	enrollmentStart string) (err error) {
+
+	if err = tmpl.Get(); err == sql.ErrNoRows {
+		v.ErrorKey("validation.invalid.template")
+		return nil
+	} else if err != nil {
+		return
+	}
+
+	start, err := getTimestamp(enrollmentStart + " 00:00")
+	if err != nil {
+		return
+	}
+
+	//the title and the creator are not part of the template
+	title, creator := course.Title, course.Creator
+
+	if course.Load(v, []byte(tmpl.Data)); v.HasErrors() {
+		return
+	}
+
+	course.Title = title
+	course.Creator = creator
+	course.ParentID = tmpl.GroupID
+	course.shiftDates(start.Location(), daysBetween(course.EnrollmentStart.In(start.Location()),
+		start))
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	if err = course.insert(tx); err != nil {
+		return
+	}
+
+	//courses of group templates are placed in that group
+	if course.ParentID.Valid {
+		err = updateByID(tx, "parent_id", "courses", course.ParentID, course.ID, course)
+		if err != nil {
+			return
+		}
+	}
+
+	tx.Commit()
+	return
+}
+
+//setData sets the structure of a course template to the structure of a course,
+//i.e., all data of the course except its users, enrollment keys and calendar exceptions
+func (tmpl *CourseTemplate) setData(v *revel.Validation) (err error) {
+
+	course := Course{ID: tmpl.CourseID}
+	if err = course.Get(nil, true, 0); err == sql.ErrNoRows {
+		v.ErrorKey("validation.invalid.courseID")
+		return nil
+	} else if err != nil {
+		return
+	}
+
+	courseJSON := course.Export()
+	courseJSON.Title = ""
+	courseJSON.Editors = []UserListJSON{}
+	courseJSON.Instructors = []UserListJSON{}
+	courseJSON.Blocklist = []UserListJSON{}
+	courseJSON.Allowlist = []UserListJSON{}
+
+	for i := range courseJSON.Events {
+		courseJSON.Events[i].EnrollmentKeyHash = nil
+	}
+	for i := range courseJSON.CalendarEvents {
+		courseJSON.CalendarEvents[i].Exceptions = []ExceptionJSON{}
+	}
+
+	data, err := json.Marshal(courseJSON)
+	if err != nil {
+		log.Error("failed to marshal course template", "courseID", tmpl.CourseID,
+			"error", err.Error())
+		return
+	}
+	tmpl.Data = string(data)
+	return
+}
+
+//shiftDates shifts all dates of a course and of its meetings by a number of days,
+//the time of day of each date is preserved in the specified location
+func (course *Course) shiftDates(loc *time.Location, days int) {
+
+	shift := func(t time.Time) time.Time {
+		return t.In(loc).AddDate(0, 0, days)
+	}
+
+	course.EnrollmentStart = shift(course.EnrollmentStart)
+	course.EnrollmentEnd = shift(course.EnrollmentEnd)
+	course.ExpirationDate = shift(course.ExpirationDate)
+	if course.UnsubscribeEnd.Valid {
+		course.UnsubscribeEnd.Time = shift(course.UnsubscribeEnd.Time)
+	}
+
+	for i := range course.Events {
+		for j := range course.Events[i].Meetings {
+			meeting := &course.Events[i].Meetings[j]
+			meeting.MeetingStart = shift(meeting.MeetingStart)
+			meeting.MeetingEnd = shift(meeting.MeetingEnd)
+		}
+	}
+}
+
+//daysBetween returns the number of calendar days from one date to another
+func daysBetween(from, to time.Time) int {
+
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(to.Sub(from).Hours() / 24)
+}
+
+const (
+	stmtInsertCourseTemplate = `
+		INSERT INTO course_templates
+			(name, description, group_id, creator, created, data)
+		VALUES ($1, $2, $3, $4, now(), $5)
+		RETURNING id
+	`
+
+	stmtUpdateCourseTemplate = `
+		UPDATE course_templates
+		SET name = $2, description = $3, group_id = $4
+		WHERE id = $1
+		RETURNING id
+	`
+
+	stmtUpdateCourseTemplateData = `
+		UPDATE course_templates
+		SET name = $2, description = $3, group_id = $4, data = $5
+		WHERE id = $1
+		RETURNING id
+	`
+
+	stmtDeleteCourseTemplate = `
+		DELETE FROM course_templates
+		WHERE id = $1
+	`
+
+	stmtGetCourseTemplate = `
+		SELECT t.id, t.name, t.description, t.group_id, t.creator, t.data,
+			TO_CHAR (t.created AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS created,
+			g.name AS group_name
+		FROM course_templates t LEFT OUTER JOIN groups g
+			ON t.group_id = g.id
+		WHERE t.id = $1
+	`
+
+	stmtSelectCourseTemplates = `
+		SELECT t.id, t.name, t.description, t.group_id, t.creator, '' AS data,
+			TO_CHAR (t.created AT TIME ZONE $1, 'YYYY-MM-DD HH24:MI') AS created,
+			g.name AS group_name
+		FROM course_templates t LEFT OUTER JOIN groups g
+			ON t.group_id = g.id
+		ORDER BY g.name ASC NULLS FIRST, t.name ASC
+	`
+)
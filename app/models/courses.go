@@ -1,9 +1,11 @@
 package models
 
 import (
+	"database/sql"
 	"strings"
 	"time"
 	"turm/app"
+	"unicode"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -18,25 +20,58 @@ type CourseListInfo struct {
 	CreationDate    time.Time `db:"creation_date"`
 	CreationDateStr string    `db:"creation_date_str"`
 	EMail           string    `db:"email"` //e-mail address of either the creator or the editor
+	Rank            float64   `db:"rank"`  //relevance of a course search result
+
+	//state of the latest review of a course draft, if any
+	ReviewState sql.NullInt32 `db:"review_state"`
+
+	//pending scheduled transitions of a course
+	Transitions ScheduledTransitions ``
 }
 
 /*CourseList holds the most essential information about a list of courses. */
 type CourseList []CourseListInfo
 
-/*Search all courses. */
-func (list *CourseList) Search(value string) (err error) {
+/*CourseSearchFilter restricts the results of a course search. */
+type CourseSearchFilter struct {
+	//GroupID is the root of the group subtree containing the courses, or 0
+	GroupID        int
+	EnrollmentOpen bool
+	FreeSeats      bool
+	//Fee is either FeeFree, FeePaid or empty
+	Fee      string
+	OnlyLDAP bool
+}
 
-	//we need to divide the string into substrings so we don't have whitespaces
-	strSlice := strings.Split(value, " ")
-	searchVal := ""
-	for _, str := range strSlice {
-		searchVal += "%" + str + "%"
+const (
+	//FeeFree restricts a course search to courses without a fee
+	FeeFree = "free"
+	//FeePaid restricts a course search to courses with a fee
+	FeePaid = "paid"
+)
+
+/*Search all active courses with a full-text search across the title, subtitle,
+description, speaker, group path, creator, editors, instructors, events, meetings and
+calendar events of the courses. The search uses the stored search documents of the
+courses, which are stemmed in German and English, and orders the results by their
+relevance. Each word of the search value is a prefix, so that courses are found while
+typing. */
+func (list *CourseList) Search(value string, filter *CourseSearchFilter) (err error) {
+
+	//only keep letters and digits to prevent syntax errors in the query
+	words := strings.FieldsFunc(value, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return
 	}
+	query := strings.Join(words, ":* & ") + ":*"
 
-	err = app.Db.Select(list, stmtSearchCourses, searchVal)
+	err = app.Db.Select(list, stmtSearchCourses, query, filter.GroupID,
+		filter.EnrollmentOpen, filter.FreeSeats, filter.Fee, filter.OnlyLDAP)
 	if err != nil {
-		log.Error("failed to search courses", "value", value, "searchVal",
-			searchVal, "error", err.Error())
+		log.Error("failed to search courses", "value", value, "query", query,
+			"filter", *filter, "error", err.Error())
 	}
 
 	return
@@ -73,8 +108,8 @@ func (list *CourseList) GetByUserID(tx *sqlx.Tx, userID int, userType string, ac
 	//construct SQL
 	stmtSelect := `
 		SELECT c.id, c.title, u.email, c.creation_date,
-			TO_CHAR (c.creation_date AT TIME ZONE $1, 'YYYY-MM-DD HH24:MI') as creation_date_str
-	`
+			TO_CHAR (c.creation_date AT TIME ZONE $1, 'YYYY-MM-DD HH24:MI') as creation_date_str,
+	` + stmtSelectReviewState
 
 	stmtWhere := `
 			AND c.active = $3
@@ -176,100 +211,51 @@ func (list *CourseList) Get(active, expired bool, userID int, role string) (
 
 const (
 	stmtSearchCourses = `
-    /* search course fields */
-    SELECT c.id, c.title
-    FROM courses c LEFT OUTER JOIN events e ON c.id = e.course_id
-      LEFT OUTER JOIN meetings m ON e.id = m.event_id
-    WHERE
-      c.active
-      AND c.expiration_date > now()
-      AND (
-        c.title ILIKE $1
-        OR c.description ILIKE $1
-        OR c.speaker ILIKE $1
-        OR c.subtitle ILIKE $1
-        OR e.title ILIKE $1
-        OR e.annotation ILIKE $1
-        OR m.place ILIKE $1
-        OR m.annotation ILIKE $1
-      )
-
-    UNION
-
-    /* search editors */
-    SELECT c.id, c.title
-    FROM courses c JOIN editors e ON c.id = e.course_id
-      JOIN users u ON u.id = e.user_id
-    WHERE
-      c.active
-      AND c.expiration_date > now()
-      AND (
-        u.first_name ILIKE $1
-        OR u.last_name ILIKE $1
-        OR u.email ILIKE $1
-      )
-
-    UNION
-
-    /* search instructors */
-    SELECT c.id, c.title
-    FROM courses c JOIN instructors i ON c.id = i.course_id
-      JOIN users u ON u.id = i.user_id
-    WHERE
-      c.active
-      AND c.expiration_date > now()
-      AND (
-        u.first_name ILIKE $1
-        OR u.last_name ILIKE $1
-        OR u.email ILIKE $1
-      )
+		WITH RECURSIVE subtree (id)
+			AS (
+				SELECT id
+				FROM groups
+				WHERE id = $2
 
-    UNION
+				UNION ALL
 
-    /* search groups */
-    SELECT c.id, c.title
-    FROM courses c
-    WHERE
-      c.active
-      AND c.expiration_date > now()
-      AND c.parent_id IN (
-        SELECT g.id
-        FROM groups g
-        WHERE g.name ILIKE $1
-      )
-
-    UNION
-
-    /* search creators */
-    SELECT c.id, c.title
-    FROM courses c JOIN users u ON c.creator = u.id
-    WHERE
-      c.active
-      AND c.expiration_date > now()
-      AND (
-        u.first_name ILIKE $1
-        OR u.last_name ILIKE $1
-        OR u.email ILIKE $1
-      )
-
-    UNION
-
-    /* search calendar events */
-    SELECT c.id, c.title
-    FROM courses c LEFT OUTER JOIN calendar_events e ON c.id = e.course_id
-    WHERE
-      c.active
-      AND c.expiration_date > now()
-      AND (
-        e.title ILIKE $1
-        OR e.annotation ILIKE $1
-      )
+				SELECT g.id
+				FROM groups g, subtree s
+				WHERE g.parent_id = s.id
+			)
 
-  `
+		SELECT c.id, c.title, ts_rank(c.search_document, q.query) AS rank
+		FROM courses c,
+			(SELECT to_tsquery('german', $1) || to_tsquery('english', $1) AS query) q
+		WHERE c.search_document @@ q.query
+			AND c.active
+			AND c.expiration_date > now()
+			AND ($2 = 0 OR c.parent_id IN (SELECT id FROM subtree))
+			AND (NOT $3 OR (c.enrollment_start <= now() AND now() < c.enrollment_end))
+			AND (NOT $4 OR EXISTS (
+				SELECT true
+				FROM events e
+				WHERE e.course_id = c.id
+					AND e.capacity > (
+						SELECT COUNT(en.user_id)
+						FROM enrolled en
+						WHERE en.event_id = e.id
+							AND en.status != 1 /*on waitlist*/
+					)
+			))
+			AND (
+				$5 = ''
+				OR ($5 = 'free' AND (c.fee IS NULL OR c.fee = 0))
+				OR ($5 = 'paid' AND c.fee > 0)
+			)
+			AND (NOT $6 OR c.only_ldap)
+		ORDER BY rank DESC, c.title ASC
+	`
 
 	stmtAllCoursesAdmin = `
 		SELECT c.id, c.title, c.creation_date,
-			TO_CHAR (c.creation_date AT TIME ZONE $1, 'YYYY-MM-DD HH24:MI') as creation_date_str
+			TO_CHAR (c.creation_date AT TIME ZONE $1, 'YYYY-MM-DD HH24:MI') as creation_date_str,
+		` + stmtSelectReviewState + `
 		FROM courses c
 		WHERE $2 = 0
 	`
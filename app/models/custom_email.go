@@ -0,0 +1,384 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template/parse"
+	"time"
+	"turm/app"
+
+	"github.com/revel/revel"
+)
+
+//customEMailVars are all variables that can be used in a custom e-mail template,
+//e.g., {{.FirstName}} or {{if .OnWaitlist}} ... {{else}} ... {{end}}
+type customEMailVars struct {
+	Salutation    string
+	Title         string
+	AcademicTitle string
+	FirstName     string
+	NameAffix     string
+	LastName      string
+	CourseTitle   string
+	EventTitle    string
+	MeetingCount  int
+	EMailCreator  string
+	URL           string
+	Start         string
+	End           string
+	Fee           string
+	HasFee        bool
+	OnWaitlist    bool
+}
+
+//customEMailFuncs are the only functions that can be used in a custom e-mail template
+var customEMailFuncs = map[string]bool{
+	"and": true, "or": true, "not": true,
+	"eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+}
+
+//legacyPlaceholders maps the message keys of the old [[placeholder]] names
+//to the respective template variables
+var legacyPlaceholders = map[string]string{
+	"user.salutation":       "Salutation",
+	"user.title":            "Title",
+	"user.academic.title":   "AcademicTitle",
+	"user.firstname":        "FirstName",
+	"user.name.affix":       "NameAffix",
+	"user.lastname":         "LastName",
+	"course.title":          "CourseTitle",
+	"event.title":           "EventTitle",
+	"event.number.meetings": "MeetingCount",
+	"course.creator.email":  "EMailCreator",
+	"course.url":            "URL",
+	"enroll.start.time":     "Start",
+	"enroll.end.time":       "End",
+}
+
+/*ValidateCustomEMail parses the custom e-mail template and renders it for all
+combinations of its conditionals to ensure that it is executable. */
+func ValidateCustomEMail(content *string, v *revel.Validation) {
+
+	if err := checkCustomEMail(content); err != nil {
+		v.ErrorKey("validation.invalid.custom.email", err.Error())
+	}
+}
+
+//checkCustomEMail parses the custom e-mail template and executes it for all
+//combinations of its conditionals
+func checkCustomEMail(content *string) (err error) {
+
+	tmpl, err := parseCustomEMail(content)
+	if err != nil {
+		return
+	}
+
+	vars := customEMailVars{}
+	for _, onWaitlist := range []bool{false, true} {
+		for _, hasFee := range []bool{false, true} {
+
+			vars.OnWaitlist = onWaitlist
+			vars.HasFee = hasFee
+
+			var buf bytes.Buffer
+			if err = tmpl.Execute(&buf, vars); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+//reportInvalidCustomEMails logs all stored custom e-mails that cannot be rendered,
+//e.g., custom e-mails written before the template syntax was introduced
+func reportInvalidCustomEMails() {
+
+	var courses []struct {
+		ID          int    `db:"id"`
+		CustomEMail string `db:"custom_email"`
+	}
+
+	if err := app.Db.Select(&courses, stmtSelectCustomEMails); err != nil {
+		log.Error("failed to get custom e-mails", "error", err.Error())
+		return
+	}
+
+	invalid := 0
+	for _, course := range courses {
+		if err := checkCustomEMail(&course.CustomEMail); err != nil {
+			log.Error("stored custom e-mail is invalid", "courseID", course.ID,
+				"error", err.Error())
+			invalid++
+		}
+	}
+
+	if invalid != 0 {
+		app.SendErrorNote()
+	}
+}
+
+/*Render the custom e-mail template with the data of the participant. */
+func (data *CustomEMailData) Render(content *string, language string) (body string, err error) {
+
+	tmpl, err := parseCustomEMail(content)
+	if err != nil {
+		log.Error("failed to parse custom e-mail", "content", *content,
+			"error", err.Error())
+		return
+	}
+
+	vars := customEMailVars{
+		Title:         data.Title.String,
+		AcademicTitle: data.AcademicTitle.String,
+		FirstName:     data.FirstName,
+		NameAffix:     data.NameAffix.String,
+		LastName:      data.LastName,
+		CourseTitle:   data.CourseTitle,
+		EventTitle:    data.EventTitle,
+		MeetingCount:  data.MeetingCount,
+		EMailCreator:  data.EMailCreator,
+		URL:           data.URL + "/course/open?ID=" + strconv.Itoa(data.CourseID),
+		Start:         data.Start,
+		End:           data.End,
+		HasFee:        data.Fee.Valid,
+		OnWaitlist:    data.OnWaitlist,
+	}
+
+	vars.Salutation = revel.MessageFunc(language, "user.salutation."+data.Salutation.String())
+
+	if data.Fee.Valid {
+		vars.Fee = strconv.FormatFloat(data.Fee.Float64, 'f', 2, 64)
+		if language == "de-DE" {
+			vars.Fee = strings.ReplaceAll(vars.Fee, ".", ",")
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, vars); err != nil {
+		log.Error("failed to execute custom e-mail", "content", *content,
+			"vars", vars, "error", err.Error())
+		return
+	}
+
+	return buf.String(), nil
+}
+
+/*GetSample sets the custom e-mail data of a course for a sample participant. */
+func (data *CustomEMailData) GetSample(courseID int, language string) (err error) {
+
+	err = app.Db.Get(data, stmtGetCustomEMailDataSample, courseID)
+	if err != nil {
+		log.Error("failed to get sample custom e-mail data", "courseID", courseID,
+			"error", err.Error())
+		return
+	}
+
+	data.Salutation = MS
+	data.FirstName = revel.MessageFunc(language, "creator.custom.email.sample.firstname")
+	data.LastName = revel.MessageFunc(language, "creator.custom.email.sample.lastname")
+	data.URL = app.Mailer.URL
+
+	now := time.Now()
+	data.Start = now.Format("2006-01-02 15:04")
+	data.End = now.Add(30 * time.Minute).Format("2006-01-02 15:04")
+
+	return
+}
+
+/*CustomEMailUsesWaitlist returns true if the custom e-mail template distinguishes
+between users on the wait list and enrolled users. */
+func CustomEMailUsesWaitlist(content *string) bool {
+
+	tmpl, err := parseCustomEMail(content)
+	if err != nil || tmpl.Tree == nil {
+		return false
+	}
+	return customEMailUsesVar(tmpl.Tree.Root, "OnWaitlist")
+}
+
+//customEMailUsesVar recursively returns whether a node of the template tree uses a
+//variable, the tree only contains the nodes allowed by checkCustomEMailNode
+func customEMailUsesVar(node parse.Node, name string) bool {
+
+	switch n := node.(type) {
+
+	case *parse.ListNode:
+		for _, child := range n.Nodes {
+			if customEMailUsesVar(child, name) {
+				return true
+			}
+		}
+
+	case *parse.ActionNode:
+		return customEMailUsesVar(n.Pipe, name)
+
+	case *parse.IfNode:
+		if customEMailUsesVar(n.Pipe, name) || customEMailUsesVar(n.List, name) {
+			return true
+		}
+		if n.ElseList != nil {
+			return customEMailUsesVar(n.ElseList, name)
+		}
+
+	case *parse.PipeNode:
+		for _, cmd := range n.Cmds {
+			for _, arg := range cmd.Args {
+				if customEMailUsesVar(arg, name) {
+					return true
+				}
+			}
+		}
+
+	case *parse.FieldNode:
+		return len(n.Ident) == 1 && n.Ident[0] == name
+	}
+
+	return false
+}
+
+//parseCustomEMail parses the content of a custom e-mail and ensures that it
+//only uses the allowed variables, functions and actions
+func parseCustomEMail(content *string) (tmpl *template.Template, err error) {
+
+	text := *content
+
+	//replace the old [[placeholder]] names of all languages with template variables
+	for _, language := range app.Languages {
+		for key, variable := range legacyPlaceholders {
+			placeholder := "[[" + revel.MessageFunc(language, key) + "]]"
+			text = strings.ReplaceAll(text, placeholder, "{{."+variable+"}}")
+		}
+	}
+
+	tmpl, err = template.New("customEMail").Parse(text)
+	if err != nil {
+		return
+	}
+
+	//{{define}} and {{block}} create additional templates
+	if len(tmpl.Templates()) > 1 {
+		return nil, errors.New("defining templates is not allowed")
+	}
+
+	if tmpl.Tree != nil {
+		err = checkCustomEMailNode(tmpl.Tree, tmpl.Tree.Root)
+	}
+	return
+}
+
+//checkCustomEMailNode recursively ensures that a node of the template tree only
+//contains text, variables and if-else conditionals
+func checkCustomEMailNode(tree *parse.Tree, node parse.Node) (err error) {
+
+	switch n := node.(type) {
+
+	case *parse.ListNode:
+		for _, child := range n.Nodes {
+			if err = checkCustomEMailNode(tree, child); err != nil {
+				return
+			}
+		}
+
+	case *parse.TextNode:
+		//plain text is always allowed
+
+	case *parse.ActionNode:
+		err = checkCustomEMailPipe(tree, n.Pipe)
+
+	case *parse.IfNode:
+		if err = checkCustomEMailPipe(tree, n.Pipe); err != nil {
+			return
+		}
+		if err = checkCustomEMailNode(tree, n.List); err != nil {
+			return
+		}
+		if n.ElseList != nil {
+			err = checkCustomEMailNode(tree, n.ElseList)
+		}
+
+	default:
+		err = customEMailError(tree, node, "only variables and if-else conditionals are allowed")
+	}
+
+	return
+}
+
+//checkCustomEMailPipe ensures that a pipeline only uses the allowed variables and functions
+func checkCustomEMailPipe(tree *parse.Tree, pipe *parse.PipeNode) (err error) {
+
+	if len(pipe.Decl) != 0 {
+		return customEMailError(tree, pipe, "declaring variables is not allowed")
+	}
+
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+
+			switch a := arg.(type) {
+
+			case *parse.FieldNode:
+				_, found := reflect.TypeOf(customEMailVars{}).FieldByName(a.Ident[0])
+				if len(a.Ident) != 1 || !found {
+					return customEMailError(tree, a, "unknown variable "+a.String())
+				}
+
+			case *parse.IdentifierNode:
+				if !customEMailFuncs[a.Ident] {
+					return customEMailError(tree, a, "unknown function "+a.Ident)
+				}
+
+			case *parse.StringNode, *parse.NumberNode, *parse.BoolNode:
+				//constants are always allowed
+
+			case *parse.PipeNode:
+				if err = checkCustomEMailPipe(tree, a); err != nil {
+					return
+				}
+
+			default:
+				return customEMailError(tree, a, "invalid expression "+a.String())
+			}
+		}
+	}
+
+	return
+}
+
+//customEMailError returns an error containing the position of the invalid node
+func customEMailError(tree *parse.Tree, node parse.Node, msg string) error {
+
+	location, _ := tree.ErrorContext(node)
+	return fmt.Errorf("%s: %s", location, msg)
+}
+
+const (
+	stmtGetCustomEMailDataSample = `
+		SELECT c.id AS course_id, c.title AS course_title, uc.email AS email_creator, c.fee,
+			COALESCE((
+					SELECT e.title FROM events e
+					WHERE e.course_id = c.id
+					ORDER BY e.id
+					LIMIT 1
+				), '') AS event_title,
+			(
+				SELECT COUNT(m.id) FROM meetings m
+				WHERE m.event_id = (
+						SELECT MIN(e.id) FROM events e
+						WHERE e.course_id = c.id
+					)
+			) AS meeting_count
+		FROM courses c JOIN users uc ON c.creator = uc.id
+		WHERE c.id = $1
+	`
+
+	stmtSelectCustomEMails = `
+		SELECT id, custom_email
+		FROM courses
+		WHERE custom_email IS NOT NULL
+		ORDER BY id
+	`
+)
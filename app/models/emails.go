@@ -3,8 +3,6 @@ package models
 import (
 	"database/sql"
 	"path/filepath"
-	"strconv"
-	"strings"
 	"turm/app"
 
 	"github.com/jmoiron/sqlx"
@@ -46,6 +44,16 @@ type EMailData struct {
 
 	//used for the custom enrollment e-mail
 	CustomEMailData CustomEMailData
+
+	//used for confirming a (new) e-mail address and for password reset links
+	Code string
+
+	//validity of password reset links in minutes
+	Validity int
+
+	//used for course reviews
+	ReviewState ReviewState
+	Comment     string
 }
 
 /*EditEMailConfig provides all information for sending edit notification e-mails. */
@@ -68,20 +76,22 @@ type EditEMailConfig struct {
 
 /*CustomEMailData contains all fields that can be used in the custom e-mail. */
 type CustomEMailData struct {
-	Salutation    Salutation     `db:"salutation"`
-	Title         sql.NullString `db:"title"`
-	NameAffix     sql.NullString `db:"name_affix"`
-	AcademicTitle sql.NullString `db:"academic_title"`
-	LastName      string         `db:"last_name"`
-	FirstName     string         `db:"first_name"`
-	CourseID      int            `db:"course_id"`
-	CourseTitle   string         `db:"course_title"`
-	EventTitle    string         `db:"event_title"`
-	MeetingCount  int            `db:"meeting_count"`
-	EMailCreator  string         `db:"email_creator"`
-	Start         string         `db:"start"`
-	End           string         `db:"end"`
+	Salutation    Salutation      `db:"salutation"`
+	Title         sql.NullString  `db:"title"`
+	NameAffix     sql.NullString  `db:"name_affix"`
+	AcademicTitle sql.NullString  `db:"academic_title"`
+	LastName      string          `db:"last_name"`
+	FirstName     string          `db:"first_name"`
+	CourseID      int             `db:"course_id"`
+	CourseTitle   string          `db:"course_title"`
+	EventTitle    string          `db:"event_title"`
+	MeetingCount  int             `db:"meeting_count"`
+	EMailCreator  string          `db:"email_creator"`
+	Start         string          `db:"start"`
+	End           string          `db:"end"`
+	Fee           sql.NullFloat64 `db:"fee"`
 	URL           string
+	OnWaitlist    bool
 }
 
 /*GetEMailSubjectBody assigns the template content to the e-mail body and sets the e-mail subject. */
@@ -97,8 +107,11 @@ func GetEMailSubjectBody(data *EMailData, language *string, subjectKey string,
 	if data.CustomEMail.Valid {
 
 		data.CustomEMailData.URL = data.URL
-		parseCustomEMail(&data.CustomEMail.String, &data.CustomEMailData, c)
-		email.Body = app.HTMLToMimeFormat(&data.CustomEMail.String)
+		body, err := data.CustomEMailData.Render(&data.CustomEMail.String, *language)
+		if err != nil {
+			return err
+		}
+		email.Body = app.HTMLToMimeFormat(&body)
 
 	} else { //parse the default e-mail template
 
@@ -126,6 +139,60 @@ func GetEMailSubjectBody(data *EMailData, language *string, subjectKey string,
 	return
 }
 
+//queueEMails renders the default e-mail templates of e-mails outside of a request, e.g.,
+//in jobs, archives the e-mails of each course and adds them to the e-mail queue
+func queueEMails(data EMailsData, sender sql.NullInt32, subjectKey string,
+	filename string) (err error) {
+
+	var queue []app.EMail
+	for i := range data {
+
+		language := data[i].User.Language.String
+		if !data[i].User.Language.Valid {
+			language = app.DefaultLanguage
+		}
+		data[i].URL = app.Mailer.URL
+
+		filepath := filepath.Join("emails", filename+"_"+language+".html")
+		viewArgs := map[string]interface{}{
+			"data":          &data[i],
+			"currentLocale": language,
+		}
+		buf, err := revel.TemplateOutputArgs(filepath, viewArgs)
+		if err != nil {
+			log.Error("failed to parse e-mail template", "filepath", filepath,
+				"userID", data[i].User.ID, "error", err.Error())
+			return err
+		}
+
+		queue = append(queue, app.EMail{
+			Recipient: data[i].User.EMail,
+			Subject:   revel.Message(language, subjectKey),
+			ReplyTo:   revel.Message(language, "email.no.reply", app.Mailer.EMail),
+			Body:      string(buf),
+		})
+	}
+
+	//NOTE: failing to archive the e-mails does not prevent sending them
+	for i := range queue {
+		if data[i].CourseID == 0 {
+			continue
+		}
+		sentEMail := SentEMail{
+			CourseID: data[i].CourseID,
+			Sender:   sender,
+			Subject:  queue[i].Subject,
+			Content:  app.HTMLFromMimeFormat(&queue[i].Body),
+		}
+		sentEMail.Archive(nil, queue[i:i+1])
+	}
+
+	for _, email := range queue {
+		app.EMailQueue <- email
+	}
+	return
+}
+
 /*Get all information for sending edit notification e-mails. */
 func (conf *EditEMailConfig) Get(tx *sqlx.Tx) (err error) {
 
@@ -253,49 +320,6 @@ func (conf *EditEMailConfig) Get(tx *sqlx.Tx) (err error) {
 	return
 }
 
-func parseCustomEMail(content *string, data *CustomEMailData, c *revel.Controller) {
-
-	//store the current language
-	cLanguage := c.Session["currentLocale"].(string)
-
-	//now parse for each language
-	for _, language := range app.Languages {
-
-		//set the language
-		c.ViewArgs["currentLocale"] = language
-		c.Request.Locale = language
-
-		salutation := c.Message("user.salutation.none")
-		if data.Salutation == MR {
-			salutation = c.Message("user.salutation.mr")
-		} else if data.Salutation == MS {
-			salutation = c.Message("user.salutation.ms")
-		}
-
-		data.URL = data.URL + "/course/open?ID=" + strconv.Itoa(data.CourseID)
-
-		*content = strings.ReplaceAll(*content, inBrackets(c.Message("user.salutation")), salutation)
-		*content = strings.ReplaceAll(*content, inBrackets(c.Message("user.title")), data.Title.String)
-		*content = strings.ReplaceAll(*content, inBrackets(c.Message("user.academic.title")), data.AcademicTitle.String)
-		*content = strings.ReplaceAll(*content, inBrackets(c.Message("user.firstname")), data.FirstName)
-		*content = strings.ReplaceAll(*content, inBrackets(c.Message("user.name.affix")), data.NameAffix.String)
-		*content = strings.ReplaceAll(*content, inBrackets(c.Message("user.lastname")), data.LastName)
-		*content = strings.ReplaceAll(*content, inBrackets(c.Message("course.title")), data.CourseTitle)
-		*content = strings.ReplaceAll(*content, inBrackets(c.Message("event.title")), data.EventTitle)
-		*content = strings.ReplaceAll(*content, inBrackets(c.Message("event.number.meetings")), strconv.Itoa(data.MeetingCount))
-		*content = strings.ReplaceAll(*content, inBrackets(c.Message("course.creator.email")), data.EMailCreator)
-		*content = strings.ReplaceAll(*content, inBrackets(c.Message("course.url")), data.URL)
-		*content = strings.ReplaceAll(*content, inBrackets(c.Message("enroll.start.time")), data.Start)
-		*content = strings.ReplaceAll(*content, inBrackets(c.Message("enroll.end.time")), data.End)
-	}
-
-	//reset to original language
-	c.ViewArgs["currentLocale"] = cLanguage
-	c.Request.Locale = cLanguage
-
-	return
-}
-
 func (data *CustomEMailData) get(tx *sqlx.Tx, userID, courseID, eventID, slotID int) (err error) {
 
 	if slotID != 0 {
@@ -314,16 +338,11 @@ func (data *CustomEMailData) get(tx *sqlx.Tx, userID, courseID, eventID, slotID
 	return
 }
 
-func inBrackets(str string) string {
-
-	return "[[" + str + "]]"
-}
-
 const (
 	stmtGetCustomEMailDataEvent = `
 		SELECT u.salutation, u.title, u.name_affix, u.academic_title, u.last_name,
 			u.first_name, c.id AS course_id, c.title AS course_title, e.title AS event_title,
-			COUNT(m.id) AS meeting_count, uc.email AS email_creator
+			COUNT(m.id) AS meeting_count, uc.email AS email_creator, c.fee
 		FROM users u, courses c
 		 	JOIN users uc ON c.creator = uc.id
 			JOIN events e ON c.id = e.course_id
@@ -332,13 +351,13 @@ const (
 			AND c.id = $2
 			AND e.id = $3
 		GROUP BY u.salutation, u.title, u.name_affix, u.academic_title, u.last_name,
-			u.first_name, c.id, c.title, e.title, uc.email
+			u.first_name, c.id, c.title, e.title, uc.email, c.fee
 	`
 
 	stmtGetCustomEMailDataSlot = `
 		SELECT u.salutation, u.title, u.name_affix, u.academic_title, u.last_name,
 			u.first_name, c.id AS course_id, c.title AS course_title, e.title AS event_title,
-			uc.email AS email_creator,
+			uc.email AS email_creator, c.fee,
 			TO_CHAR (s.start_time AT TIME ZONE $5, 'YYYY-MM-DD HH24:MI') AS start,
 			TO_CHAR (s.end_time AT TIME ZONE $5, 'YYYY-MM-DD HH24:MI') AS end
 		FROM users u, courses c
@@ -176,16 +176,20 @@ func (enrolled *Enrolled) EnrollOrUnsubscribe(action EnrollOption, key string) (
 			enrolled.Status = AWAITINGPAYMENT
 		}
 
+		//get custom welcome e-mail (if exists)
+		if err = course.GetColumnValue(tx, "custom_email"); err != nil {
+			return
+		}
+		data.CustomEMail = course.CustomEMail
+
 		if event.EnrollOption == ENROLLTOWAITLIST {
 			enrolled.Status = ONWAITLIST
 			waitList = true
-		} else {
 
-			//get custom welcome e-mail (if exists)
-			if err = course.GetColumnValue(tx, "custom_email"); err != nil {
-				return
+			//users on the wait list only get the custom e-mail if it has a wait list conditional
+			if !CustomEMailUsesWaitlist(&data.CustomEMail.String) {
+				data.CustomEMail.Valid = false
 			}
-			data.CustomEMail = course.CustomEMail
 		}
 
 		//validate enrollment key (if required)
@@ -259,6 +263,7 @@ func (enrolled *Enrolled) EnrollOrUnsubscribe(action EnrollOption, key string) (
 		if err != nil {
 			return
 		}
+		data.CustomEMailData.OnWaitlist = waitList
 	}
 
 	tx.Commit()
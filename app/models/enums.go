@@ -60,10 +60,12 @@ const (
 	DRAFT
 	//UPLOAD is for uploading courses
 	UPLOAD
+	//TEMPLATE is for instantiating course templates
+	TEMPLATE
 )
 
 func (op Option) String() string {
-	return [...]string{"empty", "draft", "upload"}[op]
+	return [...]string{"empty", "draft", "upload", "template"}[op]
 }
 
 /*EnrollmentStatus is a type for encoding the enrollment status. */
@@ -110,3 +112,72 @@ const (
 func (s EnrollOption) String() string {
 	return [...]string{"enroll", "unsubscribe", "noenroll", "nounsubscribe", "enrolltowaitlist"}[s]
 }
+
+/*DeliveryState is a type for encoding the delivery state of an archived e-mail. */
+type DeliveryState int
+
+const (
+	//QUEUED e-mails are waiting in the e-mail queue
+	QUEUED DeliveryState = iota
+	//SENT e-mails were handed to the e-mail server
+	SENT
+	//FAILED e-mails could not be handed to the e-mail server
+	FAILED
+	//SUPPRESSED e-mails were not sent because the address is undeliverable
+	SUPPRESSED
+)
+
+func (state DeliveryState) String() string {
+	return [...]string{"queued", "sent", "failed", "suppressed"}[state]
+}
+
+/*EMailMode is a type for encoding whether an e-mail to participants is sent immediately,
+scheduled or saved as a draft. */
+type EMailMode int
+
+const (
+	//SENDNOW e-mails are sent immediately
+	SENDNOW EMailMode = iota
+	//SENDLATER e-mails are sent at their time of sending
+	SENDLATER
+	//SAVEDRAFT e-mails are saved without being sent
+	SAVEDRAFT
+)
+
+func (mode EMailMode) String() string {
+	return [...]string{"now", "schedule", "draft"}[mode]
+}
+
+/*ListFormat is a type for encoding the file format of downloaded participant lists. */
+type ListFormat int
+
+const (
+	//CSV lists are separated by semicolons or commas
+	CSV ListFormat = iota
+	//XLSX lists are Office Open XML spreadsheets
+	XLSX
+	//ODS lists are OpenDocument spreadsheets
+	ODS
+)
+
+func (format ListFormat) String() string {
+	return [...]string{"csv", "xlsx", "ods"}[format]
+}
+
+/*ReviewState is a type for encoding the state of a course review. */
+type ReviewState int
+
+const (
+	//PENDING courses are waiting for the decision of a reviewer
+	PENDING ReviewState = iota
+	//APPROVED courses were activated by a reviewer
+	APPROVED
+	//REJECTED courses were rejected by a reviewer
+	REJECTED
+	//CHANGESREQUESTED courses must be changed before submitting them again
+	CHANGESREQUESTED
+)
+
+func (state ReviewState) String() string {
+	return [...]string{"pending", "approved", "rejected", "changes requested"}[state]
+}
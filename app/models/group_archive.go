@@ -0,0 +1,597 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+	"turm/app"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/revel/revel"
+)
+
+/*GroupArchiveVersion is the version of the current group archive format. */
+const GroupArchiveVersion = 1
+
+const (
+	//groupArchiveMaxFileSize is the maximum uncompressed size of a file of a group archive
+	groupArchiveMaxFileSize = 10 << 20
+	//groupArchiveMaxSize is the maximum uncompressed size of all files of a group archive
+	groupArchiveMaxSize = 100 << 20
+)
+
+/*GroupArchive is the manifest of a group archive. A group archive is a zip file
+containing the manifest (groups.json) and one file per course in the course JSON
+format. The groups are ordered such that each group follows its parent group. */
+type GroupArchive struct {
+	Version   int                  `json:"version"`
+	Exported  time.Time            `json:"exported"`
+	UserLists bool                 `json:"user_lists"`
+	Groups    []GroupArchiveGroup  `json:"groups"`
+	Courses   []GroupArchiveCourse `json:"courses"`
+}
+
+/*GroupArchiveGroup is a group of a group archive. The parent ID of the exported
+group is null. */
+type GroupArchiveGroup struct {
+	ID               int    `json:"id"`
+	ParentID         *int   `json:"parent_id"`
+	Name             string `json:"name"`
+	CourseLimit      *int   `json:"course_limit"`
+	RequiresApproval bool   `json:"requires_approval"`
+}
+
+/*GroupArchiveCourse is a course of a group archive. */
+type GroupArchiveCourse struct {
+	ID      int    `json:"id" db:"id"`
+	GroupID int    `json:"group_id" db:"group_id"`
+	Title   string `json:"title" db:"title"`
+	File    string `json:"file"`
+}
+
+/*GroupImportReport contains the result of an import of a group archive. */
+type GroupImportReport struct {
+	DryRun bool
+	//true, if the import was rolled back due to invalid courses
+	Failed bool
+	//true, if the course limits of the archive were dropped because
+	//the parent group already inherits a course limit
+	LimitsDropped bool
+	Groups        []GroupImportEntry
+	Courses       []CourseImportEntry
+}
+
+/*GroupImportEntry is an imported group. */
+type GroupImportEntry struct {
+	OldID int
+	NewID int
+	Name  string
+}
+
+/*CourseImportEntry is an imported course. Users without an account and restrictions
+with unknown degrees or courses of studies are skipped. */
+type CourseImportEntry struct {
+	OldID               int
+	NewID               int
+	Title               string
+	GroupName           string
+	Errors              []string
+	SkippedUsers        []string
+	SkippedRestrictions int
+}
+
+/*Export a group, all of its subgroups and all courses of these groups as a group
+archive. The user lists of the courses are only exported if userLists is true. */
+func (archive *GroupArchive) Export(groupID int, userLists bool) (data []byte, err error) {
+
+	archive.Version = GroupArchiveVersion
+	archive.Exported = time.Now()
+	archive.UserLists = userLists
+
+	var groups Groups
+	if err = app.Db.Select(&groups, stmtSelectGroupSubtree, groupID); err != nil {
+		log.Error("failed to select group subtree", "groupID", groupID,
+			"error", err.Error())
+		return
+	}
+	if len(groups) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	archive.Groups = []GroupArchiveGroup{}
+	for i := range groups {
+		group := GroupArchiveGroup{
+			ID:               groups[i].ID,
+			Name:             groups[i].Name,
+			RequiresApproval: groups[i].RequiresApproval,
+		}
+		//the exported group becomes the root of the archive
+		if groups[i].ParentID.Valid && i != 0 {
+			parentID := int(groups[i].ParentID.Int32)
+			group.ParentID = &parentID
+		}
+		if groups[i].CourseLimit.Valid {
+			limit := int(groups[i].CourseLimit.Int32)
+			group.CourseLimit = &limit
+		}
+		archive.Groups = append(archive.Groups, group)
+	}
+
+	archive.Courses = []GroupArchiveCourse{}
+	if err = app.Db.Select(&archive.Courses, stmtSelectSubtreeCourses, groupID); err != nil {
+		log.Error("failed to select courses of group subtree", "groupID", groupID,
+			"error", err.Error())
+		return
+	}
+
+	buffer := new(bytes.Buffer)
+	writer := zip.NewWriter(buffer)
+
+	for i := range archive.Courses {
+
+		course := Course{ID: archive.Courses[i].ID}
+		if err = course.Get(nil, true, 0); err != nil {
+			return
+		}
+
+		courseJSON := course.Export()
+		if !userLists {
+			courseJSON.Editors = []UserListJSON{}
+			courseJSON.Instructors = []UserListJSON{}
+			courseJSON.Blocklist = []UserListJSON{}
+			courseJSON.Allowlist = []UserListJSON{}
+		}
+
+		archive.Courses[i].File = fmt.Sprintf("courses/%d.json", course.ID)
+		if err = writeArchiveFile(writer, archive.Courses[i].File, courseJSON); err != nil {
+			return
+		}
+	}
+
+	if err = writeArchiveFile(writer, "groups.json", archive); err != nil {
+		return
+	}
+
+	if err = writer.Close(); err != nil {
+		log.Error("failed to close group archive", "groupID", groupID,
+			"error", err.Error())
+		return
+	}
+	return buffer.Bytes(), nil
+}
+
+/*Import a group archive. All groups and courses of the archive are recreated below
+the parent group, or as root groups if the parent ID is zero. The courses are inserted
+as drafts of the user. Users of the user lists are identified by their e-mail address
+and only imported if userLists is true. A dry run rolls back all changes, so that the
+report shows the result of the import without changing any data. */
+func (report *GroupImportReport) Import(v *revel.Validation, data []byte, parentID,
+	userID int, userLists bool) (err error) {
+
+	archive, files := GroupArchive{}, make(map[string][]byte)
+	if archive.load(v, data, files); v.HasErrors() {
+		return
+	}
+
+	//load and validate all courses before changing any data
+	courses := []Course{}
+	for _, entry := range archive.Courses {
+
+		course := Course{}
+		courseReport := CourseImportEntry{OldID: entry.ID, Title: entry.Title}
+
+		errCount := len(v.Errors)
+		course.Load(v, files[entry.File])
+		for _, validationErr := range v.Errors[errCount:] {
+			courseReport.Errors = append(courseReport.Errors, validationErr.String())
+			report.Failed = true
+		}
+		v.Errors = v.Errors[:errCount]
+
+		courses = append(courses, course)
+		report.Courses = append(report.Courses, courseReport)
+	}
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	parent := sql.NullInt32{Int32: int32(parentID), Valid: parentID != 0}
+	inheritsLimit := false
+	if parent.Valid {
+
+		exists, err := groupExists(tx, parentID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			v.ErrorKey("validation.invalid.archive.parent")
+			tx.Rollback()
+			return nil
+		}
+
+		if inheritsLimit, err = inheritsCourseLimit(tx, parentID); err != nil {
+			return err
+		}
+	}
+
+	//insert the groups and map their IDs
+	groupIDs, groupNames := make(map[int]sql.NullInt32), make(map[int]string)
+	for _, entry := range archive.Groups {
+
+		group := Group{
+			ParentID:         parent,
+			Name:             entry.Name,
+			RequiresApproval: entry.RequiresApproval,
+		}
+		if entry.ParentID != nil {
+			group.ParentID = groupIDs[*entry.ParentID]
+		}
+
+		//groups must not have a course limit if any parent group already has one
+		if entry.CourseLimit != nil && inheritsLimit {
+			report.LimitsDropped = true
+		} else if entry.CourseLimit != nil {
+			group.CourseLimit = sql.NullInt32{Int32: int32(*entry.CourseLimit), Valid: true}
+		}
+
+		err = tx.Get(&group, stmtInsertGroup, group.ParentID, group.Name,
+			group.CourseLimit, userID, time.Now().Format(revel.TimeFormats[0]),
+			group.RequiresApproval)
+		if err != nil {
+			log.Error("failed to insert imported group", "group", group, "userID",
+				userID, "error", err.Error())
+			tx.Rollback()
+			return
+		}
+
+		groupIDs[entry.ID] = sql.NullInt32{Int32: int32(group.ID), Valid: true}
+		groupNames[entry.ID] = group.Name
+		report.Groups = append(report.Groups, GroupImportEntry{
+			OldID: entry.ID,
+			NewID: group.ID,
+			Name:  group.Name,
+		})
+	}
+
+	//insert the courses
+	for i, entry := range archive.Courses {
+
+		courseReport := &report.Courses[i]
+		courseReport.GroupName = groupNames[entry.GroupID]
+		if courseReport.Errors != nil {
+			continue
+		}
+
+		course := &courses[i]
+		course.Creator = sql.NullInt32{Int32: int32(userID), Valid: true}
+		course.ParentID = groupIDs[entry.GroupID]
+
+		if !userLists {
+			course.Editors, course.Instructors = UserList{}, UserList{}
+			course.Blocklist, course.Allowlist = UserList{}, UserList{}
+		}
+		for _, users := range []*UserList{&course.Editors, &course.Instructors,
+			&course.Blocklist, &course.Allowlist} {
+
+			skipped, err := users.remap(tx)
+			if err != nil {
+				return err
+			}
+			courseReport.SkippedUsers = append(courseReport.SkippedUsers, skipped...)
+		}
+
+		for _, rest := range course.Restrictions {
+			exists, err := rest.Exists(tx)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				courseReport.SkippedRestrictions++
+			}
+		}
+
+		if err = course.insert(tx); err != nil {
+			return
+		}
+		err = updateByID(tx, "parent_id", "courses", course.ParentID, course.ID, course)
+		if err != nil {
+			return
+		}
+		courseReport.NewID = course.ID
+	}
+
+	if report.DryRun || report.Failed {
+		tx.Rollback()
+		return
+	}
+
+	tx.Commit()
+	return
+}
+
+//load reads the manifest of a group archive and all course files referenced by it,
+//and validates the manifest. Files that the manifest does not reference are ignored.
+func (archive *GroupArchive) load(v *revel.Validation, data []byte,
+	files map[string][]byte) {
+
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		v.ErrorKey("validation.invalid.archive", err.Error())
+		return
+	}
+
+	entries := make(map[string]*zip.File)
+	for _, file := range reader.File {
+		if _, found := entries[file.Name]; !found {
+			entries[file.Name] = file
+		}
+	}
+
+	entry, found := entries["groups.json"]
+	if !found {
+		v.ErrorKey("validation.invalid.archive.manifest")
+		return
+	}
+
+	size := 0
+	manifest, tooLarge, err := readArchiveFile(entry, &size)
+	if tooLarge {
+		v.ErrorKey("validation.invalid.archive.size", groupArchiveMaxFileSize>>20,
+			groupArchiveMaxSize>>20)
+		return
+	} else if err != nil {
+		v.ErrorKey("validation.invalid.archive", err.Error())
+		return
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(manifest))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(archive); err != nil {
+		v.ErrorKey("validation.invalid.archive", err.Error())
+		return
+	}
+
+	if archive.Version != GroupArchiveVersion {
+		v.ErrorKey("validation.invalid.archive.version", archive.Version,
+			GroupArchiveVersion)
+		return
+	}
+
+	//missing files are reported when validating the manifest
+	for _, course := range archive.Courses {
+
+		file, found := entries[course.File]
+		if _, read := files[course.File]; !found || read || course.File == "groups.json" {
+			continue
+		}
+
+		files[course.File], tooLarge, err = readArchiveFile(file, &size)
+		if tooLarge {
+			v.ErrorKey("validation.invalid.archive.size", groupArchiveMaxFileSize>>20,
+				groupArchiveMaxSize>>20)
+			return
+		} else if err != nil {
+			v.ErrorKey("validation.invalid.archive", err.Error())
+			return
+		}
+	}
+
+	archive.validate(v, files)
+}
+
+//readArchiveFile reads a file of a group archive, size is the total uncompressed size
+//of all files read so far. The readers are limited, as the sizes in the headers of a
+//zip file are not trustworthy.
+func readArchiveFile(file *zip.File, size *int) (content []byte, tooLarge bool,
+	err error) {
+
+	reader, err := file.Open()
+	if err != nil {
+		return
+	}
+	defer reader.Close()
+
+	content, err = ioutil.ReadAll(io.LimitReader(reader, groupArchiveMaxFileSize+1))
+	if err != nil {
+		return
+	}
+
+	*size += len(content)
+	if len(content) > groupArchiveMaxFileSize || *size > groupArchiveMaxSize {
+		return nil, true, nil
+	}
+	return
+}
+
+//validate the manifest of a group archive, i.e., the fields of its groups and
+//that all references point to groups and files of the archive
+func (archive *GroupArchive) validate(v *revel.Validation, files map[string][]byte) {
+
+	if len(archive.Groups) == 0 {
+		v.ErrorKey("validation.invalid.archive.field", "groups")
+		return
+	}
+
+	groupIDs := make(map[int]bool)
+	for i := range archive.Groups {
+
+		group := &archive.Groups[i]
+		path := fmt.Sprintf("groups[%d]", i)
+		group.Name = strings.TrimSpace(group.Name)
+
+		if length := utf8.RuneCountInString(group.Name); length < 3 || length > 255 {
+			v.ErrorKey("validation.invalid.archive.field", path+".name")
+		}
+		if group.CourseLimit != nil && (*group.CourseLimit < 1 || *group.CourseLimit > 100) {
+			v.ErrorKey("validation.invalid.archive.field", path+".course_limit")
+		}
+
+		//only the first group has no parent, all other groups follow their parent
+		if (i == 0) != (group.ParentID == nil) ||
+			(group.ParentID != nil && !groupIDs[*group.ParentID]) {
+			v.ErrorKey("validation.invalid.archive.field", path+".parent_id")
+		}
+		if groupIDs[group.ID] {
+			v.ErrorKey("validation.invalid.archive.field", path+".id")
+		}
+		groupIDs[group.ID] = true
+	}
+
+	for i, course := range archive.Courses {
+
+		path := fmt.Sprintf("courses[%d]", i)
+		if !groupIDs[course.GroupID] {
+			v.ErrorKey("validation.invalid.archive.field", path+".group_id")
+		}
+		if _, found := files[course.File]; !found || course.File == "groups.json" {
+			v.ErrorKey("validation.invalid.archive.field", path+".file")
+		}
+	}
+}
+
+//groupExists returns whether a group exists
+func groupExists(tx *sqlx.Tx, groupID int) (exists bool, err error) {
+
+	err = tx.Get(&exists, stmtGroupExists, groupID)
+	if err != nil {
+		log.Error("failed to get if the group exists", "groupID", groupID,
+			"error", err.Error())
+		tx.Rollback()
+	}
+	return
+}
+
+//inheritsCourseLimit returns whether a group or any of its parent groups has a course limit
+func inheritsCourseLimit(tx *sqlx.Tx, groupID int) (inherits bool, err error) {
+
+	var limit int
+	err = tx.Get(&limit, stmtParentsGetCourseLimit, groupID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		log.Error("failed to get the course limit of the parent groups", "groupID", groupID,
+			"error", err.Error())
+		tx.Rollback()
+		return
+	}
+	return true, nil
+}
+
+//remap replaces the user IDs of a user list by the IDs of the users with the same
+//e-mail address, users without an account are removed and their e-mail addresses returned
+func (users *UserList) remap(tx *sqlx.Tx) (skipped []string, err error) {
+
+	remapped := UserList{}
+	for _, user := range *users {
+
+		err = tx.Get(&user.UserID, stmtSelectUserIDByEMail, user.EMail)
+		if err == sql.ErrNoRows {
+			skipped = append(skipped, user.EMail)
+			continue
+		} else if err != nil {
+			log.Error("failed to get user by e-mail", "email", user.EMail,
+				"error", err.Error())
+			tx.Rollback()
+			return
+		}
+		remapped = append(remapped, user)
+	}
+
+	*users = remapped
+	return skipped, nil
+}
+
+//writeArchiveFile marshals data into a new file of a group archive
+func writeArchiveFile(writer *zip.Writer, name string, data interface{}) (err error) {
+
+	content, err := json.Marshal(data)
+	if err != nil {
+		log.Error("failed to marshal group archive file", "name", name,
+			"error", err.Error())
+		return
+	}
+
+	file, err := writer.Create(name)
+	if err != nil {
+		log.Error("failed to create group archive file", "name", name,
+			"error", err.Error())
+		return
+	}
+
+	if _, err = file.Write(content); err != nil {
+		log.Error("failed to write group archive file", "name", name,
+			"error", err.Error())
+	}
+	return
+}
+
+const (
+	stmtSelectGroupSubtree = `
+		WITH RECURSIVE subtree (id, parent_id, name, course_limit, requires_approval, depth)
+			AS (
+				/* starting entry */
+				SELECT id, parent_id, name, course_limit, requires_approval, 0
+				FROM groups
+				WHERE id = $1
+
+				UNION ALL
+
+				/* collect all children */
+				SELECT g.id, g.parent_id, g.name, g.course_limit, g.requires_approval,
+					s.depth + 1
+				FROM groups g, subtree s
+				WHERE s.id = g.parent_id
+			)
+
+		/* parents before their children */
+		SELECT id, parent_id, name, course_limit, requires_approval
+		FROM subtree
+		ORDER BY depth ASC, name ASC
+	`
+
+	stmtSelectSubtreeCourses = `
+		WITH RECURSIVE subtree (id)
+			AS (
+				/* starting entry */
+				SELECT id
+				FROM groups
+				WHERE id = $1
+
+				UNION ALL
+
+				/* collect all children */
+				SELECT g.id
+				FROM groups g, subtree s
+				WHERE s.id = g.parent_id
+			)
+
+		SELECT c.id, c.parent_id AS group_id, c.title
+		FROM courses c, subtree s
+		WHERE c.parent_id = s.id
+		ORDER BY c.id ASC
+	`
+
+	stmtGroupExists = `
+		SELECT EXISTS (
+			SELECT true
+			FROM groups
+			WHERE id = $1
+		) AS exists
+	`
+
+	stmtSelectUserIDByEMail = `
+		SELECT id
+		FROM users
+		WHERE lower(email) = lower($1)
+	`
+)
@@ -29,6 +29,12 @@ type Group struct {
 	ChildHasLimits bool ``
 	//used to open courses
 	CourseID int `db:"course_id"`
+
+	//courses of groups requiring approval must be approved by a manager of any group
+	//of their path before their activation
+	RequiresApproval bool `db:"requires_approval"`
+	//comma separated e-mail addresses of the managers of this group
+	Managers string `db:"managers"`
 }
 
 /*Validate Group fields. */
@@ -62,28 +68,95 @@ func (group *Group) Validate(v *revel.Validation) {
 	if group.ParentID.Int32 != 0 {
 		group.ParentID.Valid = true
 	}
+
+	group.Managers = strings.Join(splitManagers(group.Managers), ",")
+	v.Check(group.Managers,
+		ManagersExist{},
+	).MessageKey("validation.invalid.managers")
 }
 
 /*Insert a new group into the groups table. */
 func (group *Group) Insert(userID *int) (err error) {
 
-	err = app.Db.Get(group, stmtInsertGroup, group.ParentID, group.Name,
-		group.CourseLimit, userID, time.Now().Format(revel.TimeFormats[0]))
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	err = tx.Get(group, stmtInsertGroup, group.ParentID, group.Name,
+		group.CourseLimit, userID, time.Now().Format(revel.TimeFormats[0]),
+		group.RequiresApproval)
 	if err != nil {
 		log.Error("failed to insert group", "group", group, "userID",
 			userID, "error", err.Error())
+		tx.Rollback()
+		return
 	}
+
+	if err = group.updateManagers(tx); err != nil {
+		return
+	}
+
+	tx.Commit()
 	return
 }
 
 /*Update a group in the groups table. */
 func (group *Group) Update(userID *int) (err error) {
 
-	err = app.Db.Get(group, stmtUpdateGroup, group.Name, group.CourseLimit, userID,
-		time.Now().Format(revel.TimeFormats[0]), group.ID)
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	err = tx.Get(group, stmtUpdateGroup, group.Name, group.CourseLimit, userID,
+		time.Now().Format(revel.TimeFormats[0]), group.ID, group.RequiresApproval)
 	if err != nil {
 		log.Error("failed to update group", "group", group, "userID",
 			userID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	if err = group.updateManagers(tx); err != nil {
+		return
+	}
+
+	tx.Commit()
+	return
+}
+
+//updateManagers replaces the managers of a group
+func (group *Group) updateManagers(tx *sqlx.Tx) (err error) {
+
+	_, err = tx.Exec(stmtDeleteGroupManagers, group.ID)
+	if err != nil {
+		log.Error("failed to delete group managers", "groupID", group.ID,
+			"error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	_, err = tx.Exec(stmtInsertGroupManagers, group.ID, group.Managers)
+	if err != nil {
+		log.Error("failed to insert group managers", "groupID", group.ID,
+			"managers", group.Managers, "error", err.Error())
+		tx.Rollback()
+	}
+	return
+}
+
+//splitManagers returns the trimmed, non-empty and lower case e-mail addresses
+//of a comma separated list of group managers
+func splitManagers(managers string) (emails []string) {
+
+	for _, email := range strings.Split(managers, ",") {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if email != "" {
+			emails = append(emails, email)
+		}
 	}
 	return
 }
@@ -195,6 +268,16 @@ func (groups *Groups) SelectByUser(userID *int, tx *sqlx.Tx) (err error) {
 	return
 }
 
+/*SelectFlat selects all groups without their hierarchy, ordered by their names. */
+func (groups *Groups) SelectFlat() (err error) {
+
+	err = app.Db.Select(groups, stmtSelectGroupsFlat)
+	if err != nil {
+		log.Error("failed to select groups", "error", err.Error())
+	}
+	return
+}
+
 //selectChildren recursively returns all children of the current group.
 func (group *Group) selectChildren(tx *sqlx.Tx) (hasLimits bool, err error) {
 
@@ -323,6 +406,37 @@ func (noneActive NoActiveChildren) DefaultMessage() string {
 	return fmt.Sprintln("Groups can only be deleted if they contain no subgroups or active courses.")
 }
 
+/*ManagersExist implements whether all group managers are creators or admins. */
+type ManagersExist struct{}
+
+/*IsSatisfied implements the validation result of ManagersExist. */
+func (managersExist ManagersExist) IsSatisfied(i interface{}) bool {
+
+	managers, parsed := i.(string)
+	if !parsed {
+		return false
+	}
+
+	emails := splitManagers(managers)
+	if len(emails) == 0 {
+		return true
+	}
+
+	var count int
+	err := app.Db.Get(&count, stmtCountManagers, strings.Join(emails, ","))
+	if err != nil {
+		log.Error("failed to retrieve information for the group managers",
+			"managers", managers, "error", err.Error())
+		return false
+	}
+	return count == len(emails)
+}
+
+/*DefaultMessage returns the default message of ManagersExist. */
+func (managersExist ManagersExist) DefaultMessage() string {
+	return fmt.Sprintln("Group managers must be creators or admins.")
+}
+
 const (
 	stmtParentsGetCourseLimit = `
 		WITH RECURSIVE path (parent_id, id, course_limit)
@@ -393,7 +507,13 @@ const (
 	stmtGetChildren = `
 		/* get all groups */
 		(
-			SELECT id, parent_id, name::text AS name, course_limit, id AS course_id
+			SELECT id, parent_id, name::text AS name, course_limit, id AS course_id,
+				requires_approval,
+				(
+					SELECT COALESCE(string_agg(u.email, ', ' ORDER BY u.email), '')
+					FROM group_managers m JOIN users u ON m.user_id = u.id
+					WHERE m.group_id = groups.id
+				) AS managers
 			FROM groups
 			WHERE parent_id = $1
 			ORDER BY name ASC
@@ -410,7 +530,8 @@ const (
 					WHERE g.id = c.parent_id
 						AND g.id = $1
 						AND c.id = co.id
-				) AS course_limit, co.id AS course_id
+				) AS course_limit, co.id AS course_id,
+				false AS requires_approval, '' AS managers
 
 			FROM courses co
 			WHERE co.parent_id = $1
@@ -422,18 +543,39 @@ const (
 
 	stmtInsertGroup = `
 		INSERT INTO groups
-			(parent_id, name, course_limit, last_editor, last_edited)
-		VALUES ($1, $2, $3, $4, $5)
+			(parent_id, name, course_limit, last_editor, last_edited, requires_approval)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id, name
 	`
 
 	stmtUpdateGroup = `
 		UPDATE groups
-		SET name = $1, course_limit = $2, last_editor = $3, last_edited = $4
+		SET name = $1, course_limit = $2, last_editor = $3, last_edited = $4,
+			requires_approval = $6
 		WHERE id = $5
 		RETURNING id, name
 	`
 
+	stmtDeleteGroupManagers = `
+		DELETE FROM group_managers
+		WHERE group_id = $1
+	`
+
+	stmtInsertGroupManagers = `
+		INSERT INTO group_managers
+			(group_id, user_id)
+		SELECT $1, id
+		FROM users
+		WHERE lower(email) = ANY(string_to_array($2, ','))
+	`
+
+	stmtCountManagers = `
+		SELECT COUNT(id)
+		FROM users
+		WHERE lower(email) = ANY(string_to_array($1, ','))
+			AND role != 0 /* creators and admins */
+	`
+
 	stmtMoveInactiveCourses = `
 		UPDATE courses
 		SET parent_id = (
@@ -442,7 +584,12 @@ const (
 	`
 
 	stmtGetRootGroups = `
-		SELECT id, parent_id, name, course_limit
+		SELECT id, parent_id, name, course_limit, requires_approval,
+			(
+				SELECT COALESCE(string_agg(u.email, ', ' ORDER BY u.email), '')
+				FROM group_managers m JOIN users u ON m.user_id = u.id
+				WHERE m.group_id = groups.id
+			) AS managers
 		FROM groups
 		WHERE parent_id IS NULL
 		ORDER BY name ASC
@@ -457,6 +604,12 @@ const (
 		ORDER BY name ASC
 	`
 
+	stmtSelectGroupsFlat = `
+		SELECT id, parent_id, name, course_limit
+		FROM groups
+		ORDER BY name ASC
+	`
+
 	stmtGetPath = `
 		WITH RECURSIVE path (parent_id, name, id)
 			AS (
@@ -18,6 +18,10 @@ type Category struct {
 	LastEditor sql.NullInt32 `db:"last_editor"`
 	LastEdited string        `db:"last_edited"`
 
+	//Language of the news feed entries of a news feed category, or none, if the
+	//entries are published in all languages
+	Language sql.NullString `db:"language"`
+
 	Entries HelpPageEntries ``
 }
 
@@ -29,6 +33,20 @@ func (category *Category) Validate(v *revel.Validation) {
 		revel.MinSize{3},
 		revel.MaxSize{255},
 	).MessageKey("validation.invalid.text.short")
+
+	if category.Language.String == "" {
+		category.Language.Valid = false
+		return
+	}
+	category.Language.Valid = false
+	for _, language := range app.Languages {
+		if language == category.Language.String {
+			category.Language.Valid = true
+		}
+	}
+	if !category.Language.Valid {
+		v.ErrorKey("validation.invalid.language")
+	}
 }
 
 /*Insert a new category into either faq_category or news_feed_category. */
@@ -41,8 +59,13 @@ func (category *Category) Insert(table *string, userID *int) (err error) {
 		RETURNING id, name
 	`
 
-	err = app.Db.Get(category, stmt, category.Name, userID,
-		time.Now().Format(revel.TimeFormats[0]))
+	if *table == TableNewsFeedCategory {
+		err = app.Db.Get(category, stmtInsertNewsCategory, category.Name, userID,
+			time.Now().Format(revel.TimeFormats[0]), category.Language)
+	} else {
+		err = app.Db.Get(category, stmt, category.Name, userID,
+			time.Now().Format(revel.TimeFormats[0]))
+	}
 	if err != nil {
 		log.Error("failed to add category", "category", category, "userID", userID,
 			"table", *table, "error", err.Error())
@@ -60,8 +83,13 @@ func (category *Category) Update(table *string, userID *int) (err error) {
 		RETURNING id, name
 	`
 
-	err = app.Db.Get(category, stmt, category.Name, userID,
-		time.Now().Format(revel.TimeFormats[0]), category.ID)
+	if *table == TableNewsFeedCategory {
+		err = app.Db.Get(category, stmtUpdateNewsCategory, category.Name, userID,
+			time.Now().Format(revel.TimeFormats[0]), category.ID, category.Language)
+	} else {
+		err = app.Db.Get(category, stmt, category.Name, userID,
+			time.Now().Format(revel.TimeFormats[0]), category.ID)
+	}
 	if err != nil {
 		log.Error("failed to update category", "category", category, "user ID", userID,
 			"table", *table, "error", err.Error())
@@ -81,8 +109,13 @@ type Categories []Category
 either the FAQs or the NewsFeed table. */
 func (categories *Categories) Select(table string) (err error) {
 
+	language := ""
+	if table == TableNewsFeedCategory {
+		language = ", language"
+	}
+
 	stmt := `
-		SELECT id, name, last_editor,
+		SELECT id, name, last_editor` + language + `,
 			TO_CHAR (last_edited AT TIME ZONE $1, 'YYYY-MM-DD HH24:MI:SS') as last_edited
 		FROM ` + table + `
 		ORDER BY name ASC
@@ -131,8 +164,9 @@ type HelpPageEntry struct {
 	//determine the entry type
 	IsFAQ bool
 
-	//NewsFeed value
-	Content string `db:"content"`
+	//NewsFeed values
+	Content      string `db:"content"`
+	CategoryName string `db:"category_name"`
 
 	//FAQ values
 	Question string `db:"question"`
@@ -209,6 +243,18 @@ func (entry *HelpPageEntry) Delete(table *string) (err error) {
 /*HelpPageEntries holds all entries of a specified help page. */
 type HelpPageEntries []HelpPageEntry
 
+/*SelectNewsFeed selects all news feed entries of a language, i.e., all entries of
+news feed categories of that language or of all languages. The last edited timestamps
+are RFC 3339 timestamps in UTC. */
+func (entries *HelpPageEntries) SelectNewsFeed(language string) (err error) {
+
+	err = app.Db.Select(entries, stmtSelectNewsFeed, language)
+	if err != nil {
+		log.Error("failed to select news feed", "language", language, "error", err.Error())
+	}
+	return
+}
+
 const (
 	stmtSelectFAQs = `
 		SELECT id, last_editor, question, answer, category_id,
@@ -226,6 +272,30 @@ const (
 		ORDER BY last_edited DESC
 	`
 
+	stmtSelectNewsFeed = `
+		SELECT n.id, n.last_editor, n.content, n.category_id, c.name AS category_name,
+			TO_CHAR (n.last_edited AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"') as last_edited
+		FROM news_feed n JOIN news_feed_category c ON n.category_id = c.id
+		WHERE c.language IS NULL
+			OR c.language = $1
+		ORDER BY n.last_edited DESC
+		LIMIT 50
+	`
+
+	stmtInsertNewsCategory = `
+		INSERT INTO news_feed_category
+			(name, last_editor, last_edited, language)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, name, language
+	`
+
+	stmtUpdateNewsCategory = `
+		UPDATE news_feed_category
+		SET name = $1, last_editor = $2, last_edited = $3, language = $5
+		WHERE id = $4
+		RETURNING id, name, language
+	`
+
 	stmtInsertFAQ = `
 		INSERT INTO faqs
 			(question, answer, category_id, last_editor, last_edited)
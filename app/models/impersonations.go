@@ -0,0 +1,130 @@
+package models
+
+import (
+	"database/sql"
+	"turm/app"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/*Impersonations contains the audit log of admins impersonating users. */
+type Impersonations []Impersonation
+
+/*Impersonation is an audit log entry of an admin impersonating an user. */
+type Impersonation struct {
+	ID       int    `db:"id, primarykey, autoincrement"`
+	AdminID  int    `db:"admin_id"`
+	UserID   int    `db:"user_id"`
+	Writable bool   `db:"writable"`
+	IP       string `db:"ip"`
+
+	//not fields in the respective table
+	Started   string         `db:"started_str"`
+	Ended     sql.NullString `db:"ended_str"`
+	AdminName string         `db:"admin_name"`
+	UserName  string         `db:"user_name"`
+}
+
+/*Start inserts a new audit log entry and gets the session values of the impersonated
+user. The impersonation ends at the latest with the server-side session of the admin,
+i.e., the session of the token. Admins can neither impersonate themselves nor other
+admins. */
+func (imp *Impersonation) Start(user *User, sessionToken string) (allowed bool,
+	err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	if err = tx.Get(user, stmtGetSessionData, imp.UserID); err != nil {
+		log.Error("failed to get session data", "userID", imp.UserID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+	if user.Role == ADMIN || user.ID == imp.AdminID {
+		tx.Commit()
+		return false, nil
+	}
+
+	user.IsEditor, user.IsInstructor, err = user.IsEditorInstructor(tx)
+	if err != nil {
+		return
+	}
+
+	err = tx.Get(&imp.ID, stmtInsertImpersonation, imp.AdminID, imp.UserID,
+		imp.Writable, imp.IP, hashToken(sessionToken))
+	if err != nil {
+		log.Error("failed to insert impersonation", "impersonation", imp,
+			"error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	tx.Commit()
+	return true, nil
+}
+
+/*End sets the end of an impersonation. */
+func (imp *Impersonation) End() (err error) {
+
+	_, err = app.Db.Exec(stmtEndImpersonation, imp.ID)
+	if err != nil {
+		log.Error("failed to end impersonation", "ID", imp.ID, "error", err.Error())
+	}
+	return
+}
+
+/*SelectByUser selects all impersonations by or of an user. */
+func (imps *Impersonations) SelectByUser(tx *sqlx.Tx, userID *int) (err error) {
+
+	err = tx.Select(imps, stmtSelectImpersonations, *userID, app.TimeZone)
+	if err != nil {
+		log.Error("failed to select impersonations", "userID", *userID, "error", err.Error())
+		tx.Rollback()
+	}
+	return
+}
+
+const (
+	stmtInsertImpersonation = `
+		INSERT INTO impersonations (admin_id, user_id, writable, ip, session_token_hash,
+			started)
+		VALUES ($1, $2, $3, $4, $5, now())
+		RETURNING id
+	`
+
+	stmtEndImpersonation = `
+		UPDATE impersonations
+		SET ended = now()
+		WHERE id = $1
+			AND ended IS NULL
+	`
+
+	stmtEndImpersonationsWithoutSession = `
+		UPDATE impersonations i
+		SET ended = now()
+		WHERE i.ended IS NULL
+			AND i.session_token_hash IS NOT NULL
+			AND NOT EXISTS (
+				SELECT true
+				FROM user_sessions s
+				WHERE s.token_hash = i.session_token_hash
+			)
+	`
+
+	stmtSelectImpersonations = `
+		SELECT i.id, i.admin_id, i.user_id, i.writable, i.ip,
+			TO_CHAR (i.started AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS started_str,
+			TO_CHAR (i.ended AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS ended_str,
+			(a.first_name || ' ' || a.last_name) AS admin_name,
+			(u.first_name || ' ' || u.last_name) AS user_name
+		FROM impersonations i
+			JOIN users a ON a.id = i.admin_id
+			JOIN users u ON u.id = i.user_id
+		WHERE i.admin_id = $1
+			OR i.user_id = $1
+		ORDER BY i.started DESC
+	`
+)
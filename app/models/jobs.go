@@ -0,0 +1,61 @@
+package models
+
+import (
+	"turm/modules/jobs/app/jobs"
+
+	"github.com/revel/revel"
+)
+
+func init() {
+
+	//NOTE: jobs depending on the models cannot be registered in the app package,
+	//because the models import the app package
+	revel.OnAppStart(initJobs, 6)
+	revel.OnAppStart(reportInvalidCustomEMails, 7)
+}
+
+//initJobs schedules all jobs of the models package
+func initJobs() {
+
+	//send scheduled e-mails
+	sendScheduled, found := revel.Config.String("jobs.sendScheduledEMails")
+	if !found {
+		revel.AppLog.Fatal("cannot find key in config", "key", "jobs.sendScheduledEMails")
+	}
+	jobs.Schedule(sendScheduled, sendScheduledEMails{})
+
+	//delete outdated failed login attempts
+	deleteThrottles, found := revel.Config.String("jobs.deleteLoginThrottles")
+	if !found {
+		revel.AppLog.Fatal("cannot find key in config", "key", "jobs.deleteLoginThrottles")
+	}
+	jobs.Schedule(deleteThrottles, deleteLoginThrottles{})
+
+	//delete expired sessions
+	deleteSessions, found := revel.Config.String("jobs.deleteSessions")
+	if !found {
+		revel.AppLog.Fatal("cannot find key in config", "key", "jobs.deleteSessions")
+	}
+	jobs.Schedule(deleteSessions, deleteUserSessions{})
+
+	//deliver webhooks
+	deliverHooks, found := revel.Config.String("jobs.deliverWebhooks")
+	if !found {
+		revel.AppLog.Fatal("cannot find key in config", "key", "jobs.deliverWebhooks")
+	}
+	jobs.Schedule(deliverHooks, deliverWebhooks{})
+
+	//delete outdated webhook delivery logs
+	deleteDeliveries, found := revel.Config.String("jobs.deleteWebhookDeliveries")
+	if !found {
+		revel.AppLog.Fatal("cannot find key in config", "key", "jobs.deleteWebhookDeliveries")
+	}
+	jobs.Schedule(deleteDeliveries, deleteWebhookDeliveries{})
+
+	//run due scheduled course transitions
+	runTransitions, found := revel.Config.String("jobs.runScheduledTransitions")
+	if !found {
+		revel.AppLog.Fatal("cannot find key in config", "key", "jobs.runScheduledTransitions")
+	}
+	jobs.Schedule(runTransitions, runScheduledTransitions{})
+}
@@ -1,9 +1,12 @@
 package models
 
 import (
+	"encoding/json"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/revel/revel"
 )
 
 /*Version2Course is the version 2 struct of a course. */
@@ -273,6 +276,100 @@ func (version2Meeting *Version2Meeting) Transform(meeting *Meeting) (err error)
 
 /*Transform a version 3 course struct to the current course struct. */
 func (version3Course *Version3Course) Transform(course *Course) {
+	*course = version3Course.Course
 	course.Blocklist = version3Course.Blacklist
 	course.Allowlist = version3Course.Whitelist
 }
+
+//loadLegacy loads a course from a JSON file of a previous version. Unfortunately,
+//there are four different file versions without version field:
+//version 1: enrolllimitevents is a boolean
+//version 2: enrolllimitevents is an integer
+//version 3: still having blacklist and whitelist
+//version 4: the marshalled course struct (blocklist and allowlist)
+func (course *Course) loadLegacy(v *revel.Validation, data []byte,
+	jsonIntf map[string]interface{}) {
+
+	version := 4
+
+	//case 1 or 2
+	if jsonIntf["courseName"] != nil {
+		version = 2
+
+		//assert that enrolllimitevents is an integer
+		if limit, isBool := jsonIntf["enrolllimitevents"].(bool); isBool {
+			if limit {
+				jsonIntf["enrolllimitevents"] = 1
+			} else {
+				jsonIntf["enrolllimitevents"] = 0
+			}
+
+			//create an updated json to be unmarshalled into the course struct
+			var err error
+			if data, err = json.Marshal(jsonIntf); err != nil {
+				log.Error("cannot marshal file", "file", jsonIntf, "error", err.Error())
+				v.ErrorKey("validation.invalid.file")
+				return
+			}
+		}
+
+	} else if jsonIntf["Blacklist"] != nil { //case 3
+		version = 3
+	}
+
+	if err := course.loadVersion(version, data); err != nil {
+		v.ErrorKey("validation.invalid.json.schema", err.Error())
+		return
+	}
+
+	//custom e-mails of all versions are loaded as e-mail templates
+	path := "CustomEMail"
+	if version == 2 {
+		path = "WelcomeMail"
+	}
+	if course.CustomEMail.Valid {
+		validateJSONCustomEMail(v, path, &course.CustomEMail.String)
+	}
+}
+
+//loadVersion loads a course from a JSON file of a previous version, the data of
+//versions 1 to 3 is transformed into the current course struct
+func (course *Course) loadVersion(version int, data []byte) (err error) {
+
+	if version == 4 {
+		//unmarshal into the course struct
+		err = json.Unmarshal(data, course)
+		if err != nil {
+			log.Error("failed to unmarshal into new struct", "data",
+				string(data), "error", err.Error())
+		}
+
+	} else if version == 1 || version == 2 {
+		//unmarshal the struct into the version 2 layout
+		version2Course := Version2Course{}
+		err = json.Unmarshal(data, &version2Course)
+		if err != nil {
+			log.Error("failed to unmarshal into version 2 struct", "data",
+				string(data), "error", err.Error())
+			return
+		}
+
+		//then transform the data to the current course struct
+		err = version2Course.Transform(course)
+
+	} else if version == 3 {
+		//unmarshal the struct into the version 3 layout
+		version3Course := Version3Course{}
+		err = json.Unmarshal(data, &version3Course)
+		if err != nil {
+			log.Error("failed to unmarshal into version 3 struct", "data",
+				string(data), "error", err.Error())
+			return
+		}
+
+		//then transform the data to the current course struct
+		version3Course.Transform(course)
+	}
+
+	return
+}
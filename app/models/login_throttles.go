@@ -0,0 +1,246 @@
+package models
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+	"turm/app"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/*Prefixes of the throttle keys. Failed attempts are counted per account (username or
+e-mail address), per IP address, per second login step and per password reset request. */
+const (
+	ThrottleAccount = "account:"
+	ThrottleIP      = "ip:"
+	ThrottleTOTP    = "totp:"
+	ThrottleReset   = "reset:"
+	ThrottleResetIP = "reset-ip:"
+)
+
+/*LoginThrottles contains all throttled accounts and IP addresses. */
+type LoginThrottles []LoginThrottle
+
+/*LoginThrottle counts the failed login attempts of an account or an IP address. */
+type LoginThrottle struct {
+	ID          int          `db:"id, primarykey, autoincrement"`
+	Key         string       `db:"throttle_key, unique"`
+	Failures    int          `db:"failures"`
+	LastFailure time.Time    `db:"last_failure"`
+	LockedUntil sql.NullTime `db:"locked_until"`
+
+	//not fields in the respective table
+	LastFailureStr string `db:"last_failure_str"`
+	LockedUntilStr string `db:"locked_until_str"`
+	Locked         bool   `db:"locked"`
+}
+
+/*ThrottleKey returns the key of an account, IP address, user or e-mail address. */
+func ThrottleKey(prefix, identifier string) string {
+	return prefix + strings.ToLower(strings.TrimSpace(identifier))
+}
+
+/*Check returns the time to wait until the next attempt is allowed, and whether the
+account is locked. Failed attempts older than the rate limit window are ignored. */
+func (throttle *LoginThrottle) Check(freeAttempts int) (wait time.Duration, locked bool, err error) {
+
+	err = app.Db.Get(throttle, stmtSelectLoginThrottle, throttle.Key,
+		app.RateLimits.Window.Seconds())
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		log.Error("failed to get login throttle", "key", throttle.Key, "error", err.Error())
+		return
+	}
+
+	now := time.Now()
+	if throttle.LockedUntil.Valid && throttle.LockedUntil.Time.After(now) {
+		return throttle.LockedUntil.Time.Sub(now), true, nil
+	}
+
+	next := throttle.LastFailure.Add(app.RateLimits.Backoff(throttle.Failures, freeAttempts))
+	if next.After(now) {
+		wait = next.Sub(now)
+	}
+	return
+}
+
+/*Fail counts a failed attempt. If lockout is true and the failed attempts exceed the
+lockout threshold, then the account is locked. It returns true if the account has
+been locked by this attempt. */
+func (throttle *LoginThrottle) Fail(lockout bool) (locked bool, err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	err = tx.Get(throttle, stmtFailLoginThrottle, throttle.Key,
+		app.RateLimits.Window.Seconds())
+	if err != nil {
+		log.Error("failed to count failed attempt", "key", throttle.Key, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	if lockout && throttle.Failures >= app.RateLimits.LockoutAttempts {
+		_, err = tx.Exec(stmtLockLoginThrottle, throttle.ID,
+			app.RateLimits.LockoutDuration.Seconds())
+		if err != nil {
+			log.Error("failed to lock account", "key", throttle.Key, "error", err.Error())
+			tx.Rollback()
+			return
+		}
+		locked = true
+	}
+
+	tx.Commit()
+	return
+}
+
+/*Reset deletes the failed attempts of an account after a successful login. */
+func (throttle *LoginThrottle) Reset() (err error) {
+
+	_, err = app.Db.Exec(stmtResetLoginThrottle, throttle.Key)
+	if err != nil {
+		log.Error("failed to reset login throttle", "key", throttle.Key, "error", err.Error())
+	}
+	return
+}
+
+/*Unlock an account or IP address by deleting all of its failed attempts. */
+func (throttle *LoginThrottle) Unlock() (err error) {
+
+	err = app.Db.Get(&throttle.Key, stmtUnlockLoginThrottle, throttle.ID)
+	if err != nil {
+		log.Error("failed to unlock login throttle", "ID", throttle.ID, "error", err.Error())
+	}
+	return
+}
+
+/*Select all locked accounts and all accounts and IP addresses exceeding their free
+attempts. */
+func (throttles *LoginThrottles) Select() (err error) {
+
+	err = app.Db.Select(throttles, stmtSelectLoginThrottles, app.TimeZone,
+		app.RateLimits.Window.Seconds(), app.RateLimits.AccountAttempts)
+	if err != nil {
+		log.Error("failed to select login throttles", "error", err.Error())
+	}
+	return
+}
+
+/*SelectByUser selects all failed attempts of an user, i.e., of its e-mail address,
+its LDAP username and its second login step. */
+func (throttles *LoginThrottles) SelectByUser(tx *sqlx.Tx, user *User) (err error) {
+
+	err = tx.Select(throttles, stmtSelectLoginThrottlesByKeys, app.TimeZone,
+		app.RateLimits.Window.Seconds(),
+		ThrottleKey(ThrottleAccount, user.EMail),
+		ThrottleKey(ThrottleTOTP, strconv.Itoa(user.ID)),
+		ThrottleKey(ThrottleReset, user.EMail),
+		user.ID, ThrottleAccount)
+	if err != nil {
+		log.Error("failed to select login throttles of user", "userID", user.ID,
+			"error", err.Error())
+		tx.Rollback()
+	}
+	return
+}
+
+//deleteLoginThrottles deletes all failed attempts outside of the rate limit window
+type deleteLoginThrottles struct{}
+
+/*Run the job to delete all outdated failed attempts. */
+func (job deleteLoginThrottles) Run() {
+
+	_, err := app.Db.Exec(stmtDeleteLoginThrottles, app.RateLimits.Window.Seconds())
+	if err != nil {
+		log.Error("failed to delete login throttles", "error", err.Error())
+		app.SendErrorNote()
+	}
+}
+
+const (
+	stmtSelectLoginThrottle = `
+		SELECT id, throttle_key, failures, last_failure, locked_until
+		FROM login_throttles
+		WHERE throttle_key = $1
+			AND (
+				last_failure > now() - $2 * interval '1 second'
+				OR locked_until > now()
+			)
+	`
+
+	stmtFailLoginThrottle = `
+		INSERT INTO login_throttles (throttle_key, failures, last_failure)
+		VALUES ($1, 1, now())
+		ON CONFLICT (throttle_key) DO UPDATE
+		SET failures = CASE
+				WHEN login_throttles.last_failure < now() - $2 * interval '1 second' THEN 1
+				ELSE login_throttles.failures + 1
+			END,
+			last_failure = now()
+		RETURNING id, throttle_key, failures, last_failure, locked_until
+	`
+
+	stmtLockLoginThrottle = `
+		UPDATE login_throttles
+		SET locked_until = now() + $2 * interval '1 second'
+		WHERE id = $1
+	`
+
+	stmtResetLoginThrottle = `
+		DELETE FROM login_throttles
+		WHERE throttle_key = $1
+	`
+
+	stmtUnlockLoginThrottle = `
+		DELETE FROM login_throttles
+		WHERE id = $1
+		RETURNING throttle_key
+	`
+
+	stmtSelectLoginThrottles = `
+		SELECT id, throttle_key, failures, last_failure, locked_until,
+			TO_CHAR (last_failure AT TIME ZONE $1, 'YYYY-MM-DD HH24:MI:SS') AS last_failure_str,
+			COALESCE(TO_CHAR (locked_until AT TIME ZONE $1, 'YYYY-MM-DD HH24:MI:SS'), '') AS locked_until_str,
+			COALESCE(locked_until > now(), false) AS locked
+		FROM login_throttles
+		WHERE locked_until > now()
+			OR (
+				last_failure > now() - $2 * interval '1 second'
+				AND failures > $3
+			)
+		ORDER BY COALESCE(locked_until > now(), false) DESC, last_failure DESC
+	`
+
+	stmtSelectLoginThrottlesByKeys = `
+		SELECT id, throttle_key, failures, last_failure, locked_until,
+			TO_CHAR (last_failure AT TIME ZONE $1, 'YYYY-MM-DD HH24:MI:SS') AS last_failure_str,
+			COALESCE(TO_CHAR (locked_until AT TIME ZONE $1, 'YYYY-MM-DD HH24:MI:SS'), '') AS locked_until_str,
+			COALESCE(locked_until > now(), false) AS locked
+		FROM login_throttles
+		WHERE (
+				throttle_key IN ($3, $4, $5)
+				OR throttle_key = (
+					SELECT $7::text || ldap_username FROM users
+					WHERE id = $6
+				)
+			)
+			AND (
+				last_failure > now() - $2 * interval '1 second'
+				OR locked_until > now()
+			)
+		ORDER BY last_failure DESC
+	`
+
+	stmtDeleteLoginThrottles = `
+		DELETE FROM login_throttles
+		WHERE last_failure < now() - $1 * interval '1 second'
+			AND (locked_until IS NULL OR locked_until < now())
+	`
+)
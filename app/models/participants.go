@@ -2,6 +2,9 @@ package models
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"strconv"
 	"strings"
 	"time"
@@ -22,12 +25,19 @@ type ListConf struct {
 	Unsubscribed bool
 
 	//used for downloading the participants list
-	UseComma bool
-	Filename string
+	UseComma bool       `json:"-"`
+	Filename string     `json:"-"`
+	Format   ListFormat `json:"-"`
 
 	//used for sending an e-mail
-	Subject string
-	Content string
+	Subject string `json:"-"`
+	Content string `json:"-"`
+
+	//used for scheduling an e-mail or saving it as a draft
+	Mode        EMailMode `json:"-"`
+	SendDate    string    `json:"-"`
+	SendTime    string    `json:"-"`
+	ScheduledID int       `json:"-"`
 
 	//used to specify a time interval for calendar events
 	Start     string
@@ -36,6 +46,43 @@ type ListConf struct {
 	EndTime   string
 }
 
+/*Value constructs a SQL Value from a ListConf. */
+func (conf ListConf) Value() (driver.Value, error) {
+
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	return driver.Value(string(data)), nil
+}
+
+/*Scan constructs a ListConf from a SQL Value. */
+func (conf *ListConf) Scan(value interface{}) error {
+
+	switch value.(type) {
+	case string:
+		return json.Unmarshal([]byte(value.(string)), conf)
+	case []byte:
+		return json.Unmarshal(value.([]byte), conf)
+	default:
+		return errors.New("incompatible type for ListConf")
+	}
+}
+
+/*IncludesEvent returns true if the list configuration selects the event. */
+func (conf *ListConf) IncludesEvent(ID int) bool {
+
+	if conf.AllEvents {
+		return true
+	}
+	for _, eventID := range conf.EventIDs {
+		if eventID == ID {
+			return true
+		}
+	}
+	return false
+}
+
 /*Participants of a course. */
 type Participants struct {
 	ID                 int            `db:"id, primarykey, autoincrement"`
@@ -89,6 +136,61 @@ func (parts *Participants) Get(userID int, allSlots bool) (err error) {
 	return
 }
 
+/*EMailRecipients returns the e-mail addresses of all users on the lists selected by
+the list configuration. Each e-mail address is only contained once. */
+func (parts *Participants) EMailRecipients(conf *ListConf) (recipients []string) {
+
+	emails := make(map[string]bool)
+	add := func(email string) {
+		if !emails[email] {
+			emails[email] = true
+			recipients = append(recipients, email)
+		}
+	}
+
+	for _, event := range parts.Lists {
+
+		if !conf.IncludesEvent(event.ID) {
+			continue
+		}
+
+		//participants
+		if conf.Participants {
+			for _, user := range event.Participants {
+				add(user.EMail)
+			}
+		}
+
+		//wait list
+		if conf.WaitList {
+			for _, user := range event.Waitlist {
+				add(user.EMail)
+			}
+		}
+
+		//unsubscribed
+		if conf.Unsubscribed {
+			for _, user := range event.Unsubscribed {
+				add(user.EMail)
+			}
+		}
+
+		//slots
+		for _, slot := range event.Slots {
+
+			//skip all slots not inside the defined interval
+			if conf.Start != "" {
+				if slot.EndStr < conf.Start || slot.StartStr > conf.End {
+					continue
+				}
+			}
+			add(slot.User.EMail)
+		}
+	}
+
+	return
+}
+
 /*ParticipantLists of a course. */
 type ParticipantLists []ParticipantList
 
@@ -319,10 +421,10 @@ const (
       TO_CHAR (expiration_date AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS expiration_date_str,
       (current_timestamp >= expiration_date) AS expired,
 
-			( SELECT email
+			COALESCE(( SELECT email
 				FROM users
 				WHERE id = $3
-			) AS user_email,
+			), '') AS user_email,
 
 			CASE WHEN unsubscribe_end IS NOT NULL
 					THEN TO_CHAR (unsubscribe_end AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI')
@@ -362,6 +464,7 @@ const (
     SELECT
       u.id, u.last_name, u.first_name, u.email, u.salutation, (u.password IS NULL) AS is_ldap,
       u.language, u.matr_nr, u.academic_title, u.title, u.name_affix, u.affiliations,
+      u.email_undeliverable,
       e.user_id, e.event_id, e.status, e.time_of_enrollment, e.comment,
       TO_CHAR (e.time_of_enrollment AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS time_of_enrollment_str
     FROM users u JOIN enrolled e ON u.id = e.user_id
@@ -374,6 +477,7 @@ const (
     SELECT
       u.id, u.last_name, u.first_name, u.email, u.salutation, (u.password IS NULL) AS is_ldap,
       u.language, u.matr_nr, u.academic_title, u.title, u.name_affix, u.affiliations,
+      u.email_undeliverable,
       e.user_id, e.event_id, e.status, e.time_of_enrollment, e.comment,
       TO_CHAR (e.time_of_enrollment AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS time_of_enrollment_str
     FROM users u JOIN enrolled e ON u.id = e.user_id
@@ -386,6 +490,7 @@ const (
     SELECT
       u.id, u.last_name, u.first_name, u.email, u.salutation, (u.password IS NULL) AS is_ldap,
       u.language, u.matr_nr, u.academic_title, u.title, u.name_affix, u.affiliations,
+      u.email_undeliverable,
 			un.event_id, 5 AS status
     FROM users u JOIN unsubscribed un ON u.id = un.user_id
     WHERE un.event_id = $1
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"turm/app"
+
+	"github.com/revel/revel"
+)
+
+/*RequestPasswordReset generates a single-use, time-limited token to reset the password
+of an external user. To not reveal which e-mail addresses exist, the token is empty
+if there is no external user with this e-mail address. */
+func (user *User) RequestPasswordReset(v *revel.Validation) (token string, err error) {
+
+	v.Check(user.EMail,
+		revel.Required{},
+		revel.MaxSize{255},
+	).MessageKey("validation.invalid.email")
+	v.Email(user.EMail).
+		MessageKey("validation.invalid.email")
+
+	isLdapEMail := !strings.Contains(user.EMail, app.Mailer.Suffix)
+	v.Required(isLdapEMail).
+		MessageKey("validation.email.ldap")
+
+	if v.HasErrors() {
+		return
+	}
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	//only external users have a password
+	err = tx.Get(user, stmtSelectExternUserByEMail, user.EMail)
+	if err == sql.ErrNoRows {
+		tx.Commit()
+		return "", nil
+	} else if err != nil {
+		log.Error("failed to get external user", "email", user.EMail, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+
+	//only the latest token is valid, expired tokens of other users are deleted
+	_, err = tx.Exec(stmtDeleteResetTokens, user.ID)
+	if err != nil {
+		log.Error("failed to delete reset tokens", "userID", user.ID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	_, err = tx.Exec(stmtInsertResetToken, user.ID, tokenHash, app.Passwords.ResetValidity)
+	if err != nil {
+		log.Error("failed to insert reset token", "userID", user.ID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	tx.Commit()
+	return
+}
+
+/*ValidResetToken returns whether a password reset token exists and is not expired. */
+func ValidResetToken(token string) (valid bool, err error) {
+
+	err = app.Db.Get(&valid, stmtValidResetToken, hashToken(token))
+	if err != nil {
+		log.Error("failed to validate reset token", "error", err.Error())
+	}
+	return
+}
+
+/*ResetPassword sets a new password for the user of a password reset token. The token
+is deleted, so that it can only be used once. */
+func (user *User) ResetPassword(token, newPw1, newPw2 string, v *revel.Validation) (err error) {
+
+	if newPw1 != newPw2 {
+		v.ErrorKey("validation.invalid.passwords")
+		return
+	}
+	if ValidatePassword(newPw1, v); v.HasErrors() {
+		return
+	}
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	err = tx.Get(&user.ID, stmtUseResetToken, hashToken(token))
+	if err == sql.ErrNoRows {
+		v.ErrorKey("validation.invalid.reset.token")
+		tx.Commit()
+		return nil
+	} else if err != nil {
+		log.Error("failed to use reset token", "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	hash, err := app.HashPassword(newPw1)
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+
+	err = tx.Get(user, stmtUpdatePassword, hash, user.ID)
+	if err != nil {
+		log.Error("failed to update password", "userID", user.ID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	//all other tokens of that user are no longer valid
+	_, err = tx.Exec(stmtDeleteResetTokens, user.ID)
+	if err != nil {
+		log.Error("failed to delete reset tokens", "userID", user.ID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	tx.Commit()
+	return
+}
+
+//generateToken generates a random token and its hash, only the hash is stored
+func generateToken() (token, tokenHash string, err error) {
+
+	bytes := make([]byte, 32)
+	if _, err = rand.Read(bytes); err != nil {
+		log.Error("failed to generate token", "error", err.Error())
+		return
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(bytes)
+	return token, hashToken(token), nil
+}
+
+//hashToken returns the SHA-256 hash of a token
+func hashToken(token string) string {
+
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+const (
+	stmtSelectExternUserByEMail = `
+		SELECT id, last_name, first_name, email, language, salutation
+		FROM users
+		WHERE email = $1
+			AND password IS NOT NULL
+	`
+
+	stmtDeleteResetTokens = `
+		DELETE FROM password_reset_tokens
+		WHERE user_id = $1
+			OR expiration < now()
+	`
+
+	stmtInsertResetToken = `
+		INSERT INTO password_reset_tokens (user_id, token_hash, expiration)
+		VALUES ($1, $2, now() + $3 * interval '1 minute')
+	`
+
+	stmtValidResetToken = `
+		SELECT EXISTS (
+			SELECT true
+			FROM password_reset_tokens
+			WHERE token_hash = $1
+				AND expiration > now()
+		) AS valid
+	`
+
+	stmtUseResetToken = `
+		DELETE FROM password_reset_tokens
+		WHERE token_hash = $1
+			AND expiration > now()
+		RETURNING user_id
+	`
+)
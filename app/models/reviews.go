@@ -0,0 +1,354 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+	"turm/app"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/revel/revel"
+)
+
+/*CourseReview is a model of the course_reviews table. Courses of groups requiring
+approval are submitted for review instead of being activated immediately. */
+type CourseReview struct {
+	ID          int            `db:"id, primarykey, autoincrement"`
+	CourseID    int            `db:"course_id"`
+	State       ReviewState    `db:"state"`
+	SubmittedBy sql.NullInt32  `db:"submitted_by"`
+	Reviewer    sql.NullInt32  `db:"reviewer"`
+	Comment     sql.NullString `db:"comment"`
+
+	//used for pretty timestamp rendering
+	SubmittedStr string `db:"submitted_str"`
+
+	//used for rejecting the approval of courses by their creator
+	CourseCreator sql.NullInt32 `db:"course_creator"`
+
+	//used for the review queue
+	CourseTitle    string `db:"course_title"`
+	SubmitterName  string `db:"submitter_name"`
+	SubmitterEMail string `db:"submitter_email"`
+}
+
+/*CourseReviews holds different course reviews. */
+type CourseReviews []CourseReview
+
+/*RequiresApproval returns whether any group of the path of a course requires the
+approval of its courses. */
+func (course *Course) RequiresApproval() (required bool, err error) {
+
+	err = app.Db.Get(&required, stmtCourseRequiresApproval, course.ID)
+	if err != nil {
+		log.Error("failed to get whether the course requires approval", "courseID",
+			course.ID, "error", err.Error())
+	}
+	return
+}
+
+/*Submit a course for review. The course must be valid for its activation and must not
+have a pending review. Returns the e-mail data of all reviewers. */
+func (review *CourseReview) Submit(v *revel.Validation, userID int) (invalid bool,
+	reviewers EMailsData, err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	submitter := sql.NullInt32{Int32: int32(userID), Valid: true}
+	invalid, reviewers, err = review.submit(tx, v, submitter)
+	if err != nil {
+		return
+	} else if invalid {
+		tx.Rollback()
+		return
+	}
+
+	tx.Commit()
+	return
+}
+
+//submit a course for review within a transaction, it rolls back the transaction only
+//on errors, scheduled activations submit courses without a submitter, if their creator
+//was deleted
+func (review *CourseReview) submit(tx *sqlx.Tx, v *revel.Validation,
+	submitter sql.NullInt32) (invalid bool, reviewers EMailsData, err error) {
+
+	course := Course{ID: review.CourseID}
+	if err = course.Get(tx, true, 0); err != nil {
+		return
+	}
+
+	if course.Validate(v); v.HasErrors() {
+		return true, reviewers, nil
+	}
+
+	var pending bool
+	if err = tx.Get(&pending, stmtReviewIsPending, review.CourseID); err != nil {
+		log.Error("failed to get whether a review is pending", "courseID",
+			review.CourseID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+	if pending {
+		v.ErrorKey("validation.invalid.review.pending")
+		return true, reviewers, nil
+	}
+
+	review.State = PENDING
+	review.SubmittedBy = submitter
+	err = tx.Get(review, stmtInsertReview, review.CourseID, review.SubmittedBy,
+		time.Now().Format(revel.TimeFormats[0]))
+	if err != nil {
+		log.Error("failed to insert review", "review", *review, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+	review.CourseTitle = course.Title
+
+	//all managers of the groups of the course path review the course, or the
+	//admins, if there are no managers
+	var userIDs []int
+	if err = tx.Select(&userIDs, stmtSelectReviewers, review.CourseID); err != nil {
+		log.Error("failed to select reviewers", "courseID", review.CourseID,
+			"error", err.Error())
+		tx.Rollback()
+		return
+	}
+	if len(userIDs) == 0 {
+		if err = tx.Select(&userIDs, stmtSelectAdminIDs, ADMIN); err != nil {
+			log.Error("failed to select admins", "error", err.Error())
+			tx.Rollback()
+			return
+		}
+	}
+
+	for _, ID := range userIDs {
+		data := EMailData{
+			CourseTitle: course.Title,
+			CourseID:    course.ID,
+			ReviewState: PENDING,
+		}
+		data.User.ID = ID
+		if err = data.User.Get(tx); err != nil {
+			return
+		}
+		reviewers = append(reviewers, data)
+	}
+	return
+}
+
+/*Decide on the pending review of a course. Approving a course activates it.
+Returns the e-mail data of the submitter and, if approved, of all editors and
+instructors of the course. */
+func (review *CourseReview) Decide(v *revel.Validation, reviewerID int) (invalid bool,
+	submitter EMailData, users EMailsData, err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	pending := CourseReview{}
+	err = tx.Get(&pending, stmtGetPendingReview, review.CourseID, app.TimeZone)
+	if err == sql.ErrNoRows {
+		v.ErrorKey("validation.invalid.review")
+		tx.Rollback()
+		return true, submitter, users, nil
+	} else if err != nil {
+		log.Error("failed to get pending review", "courseID", review.CourseID,
+			"error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	//NOTE: creators must not approve their own courses
+	if review.State == APPROVED && pending.CourseCreator.Valid &&
+		int(pending.CourseCreator.Int32) == reviewerID {
+		v.ErrorKey("validation.invalid.review.creator")
+		tx.Rollback()
+		return true, submitter, users, nil
+	}
+
+	course := Course{ID: review.CourseID}
+	if review.State == APPROVED {
+		if invalid, users, err = course.activate(tx, v); err != nil {
+			return
+		} else if invalid {
+			tx.Rollback()
+			return
+		}
+	}
+
+	review.ID = pending.ID
+	review.Reviewer = sql.NullInt32{Int32: int32(reviewerID), Valid: true}
+	_, err = tx.Exec(stmtDecideReview, review.ID, review.State, review.Reviewer,
+		time.Now().Format(revel.TimeFormats[0]), review.Comment)
+	if err != nil {
+		log.Error("failed to decide on review", "review", *review, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	//notify the user who submitted the course
+	submitter = EMailData{
+		CourseTitle: pending.CourseTitle,
+		CourseID:    review.CourseID,
+		ReviewState: review.State,
+		Comment:     review.Comment.String,
+	}
+	if pending.SubmittedBy.Valid {
+		submitter.User.ID = int(pending.SubmittedBy.Int32)
+		if err = submitter.User.Get(tx); err != nil {
+			return
+		}
+	}
+
+	tx.Commit()
+	return
+}
+
+/*IsReviewer returns whether a user manages any group of the path of the course. */
+func (review *CourseReview) IsReviewer(userID int) (isReviewer bool, err error) {
+
+	err = app.Db.Get(&isReviewer, stmtIsReviewer, review.CourseID, userID)
+	if err != nil {
+		log.Error("failed to get whether the user is a reviewer", "courseID",
+			review.CourseID, "userID", userID, "error", err.Error())
+	}
+	return
+}
+
+/*SelectQueue selects all pending reviews of the courses of the groups managed by a user.
+Admins (userID = 0) review all courses. */
+func (reviews *CourseReviews) SelectQueue(userID int) (err error) {
+
+	err = app.Db.Select(reviews, stmtSelectReviewQueue, app.TimeZone, userID)
+	if err != nil {
+		log.Error("failed to select review queue", "userID", userID,
+			"error", err.Error())
+	}
+	return
+}
+
+//stmtPathOfCourse selects the recursive path of the groups of a course ($1)
+const stmtPathOfCourse = `
+	WITH RECURSIVE path (id, parent_id, requires_approval)
+		AS (
+			/* starting entry */
+			SELECT g.id, g.parent_id, g.requires_approval
+			FROM groups g, courses c
+			WHERE c.id = $1
+				AND g.id = c.parent_id
+
+			UNION ALL
+
+			/* construct path */
+			SELECT g.id, g.parent_id, g.requires_approval
+			FROM groups g, path p
+			WHERE p.parent_id = g.id
+		)
+`
+
+const (
+	stmtCourseRequiresApproval = stmtPathOfCourse + `
+		SELECT EXISTS (
+			SELECT true
+			FROM path
+			WHERE requires_approval
+		) AS requires_approval
+	`
+
+	stmtSelectReviewers = stmtPathOfCourse + `
+		SELECT DISTINCT m.user_id
+		FROM group_managers m, path p
+		WHERE m.group_id = p.id
+	`
+
+	stmtIsReviewer = stmtPathOfCourse + `
+		SELECT EXISTS (
+			SELECT true
+			FROM group_managers m, path p
+			WHERE m.group_id = p.id
+				AND m.user_id = $2
+		) AS is_reviewer
+	`
+
+	stmtSelectAdminIDs = `
+		SELECT id
+		FROM users
+		WHERE role = $1
+	`
+
+	stmtReviewIsPending = `
+		SELECT EXISTS (
+			SELECT true
+			FROM course_reviews
+			WHERE course_id = $1
+				AND state = 0 /* pending */
+		) AS pending
+	`
+
+	stmtInsertReview = `
+		INSERT INTO course_reviews
+			(course_id, state, submitted_by, submitted)
+		VALUES ($1, 0, $2, $3)
+		RETURNING id
+	`
+
+	stmtGetPendingReview = `
+		SELECT r.id, r.course_id, r.state, r.submitted_by, c.title AS course_title,
+			c.creator AS course_creator,
+			TO_CHAR (r.submitted AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS submitted_str
+		FROM course_reviews r JOIN courses c ON r.course_id = c.id
+		WHERE r.course_id = $1
+			AND r.state = 0 /* pending */
+		FOR UPDATE OF r
+	`
+
+	stmtDecideReview = `
+		UPDATE course_reviews
+		SET state = $2, reviewer = $3, reviewed = $4, comment = $5
+		WHERE id = $1
+	`
+
+	stmtSelectReviewQueue = `
+		WITH RECURSIVE path (course_id, group_id)
+			AS (
+				/* starting entries */
+				SELECT c.id, c.parent_id
+				FROM courses c JOIN course_reviews r ON c.id = r.course_id
+				WHERE r.state = 0 /* pending */
+					AND c.parent_id IS NOT NULL
+
+				UNION ALL
+
+				/* construct paths */
+				SELECT p.course_id, g.parent_id
+				FROM groups g, path p
+				WHERE p.group_id = g.id
+					AND g.parent_id IS NOT NULL
+			)
+
+		SELECT r.id, r.course_id, r.state, r.submitted_by, c.title AS course_title,
+			COALESCE(u.first_name || ' ' || u.last_name, '') AS submitter_name,
+			COALESCE(u.email, '') AS submitter_email,
+			TO_CHAR (r.submitted AT TIME ZONE $1, 'YYYY-MM-DD HH24:MI') AS submitted_str
+		FROM course_reviews r JOIN courses c ON r.course_id = c.id
+			LEFT OUTER JOIN users u ON r.submitted_by = u.id
+		WHERE r.state = 0 /* pending */
+			AND (
+				$2 = 0
+				OR EXISTS (
+					SELECT true
+					FROM path p JOIN group_managers m ON p.group_id = m.group_id
+					WHERE p.course_id = c.id
+						AND m.user_id = $2
+				)
+			)
+		ORDER BY r.submitted ASC
+	`
+)
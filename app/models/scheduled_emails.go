@@ -0,0 +1,292 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+	"turm/app"
+
+	"github.com/revel/revel"
+)
+
+/*ScheduledEMails contains all scheduled e-mails and drafts of a course. */
+type ScheduledEMails []ScheduledEMail
+
+/*ScheduledEMail is an e-mail to lists of participants that is either saved as a draft
+or scheduled for sending. Its recipients are resolved at the time of sending, so that
+late enrollments are included. */
+type ScheduledEMail struct {
+	ID            int            `db:"id, primarykey, autoincrement"`
+	CourseID      int            `db:"course_id"`
+	Creator       sql.NullInt32  `db:"creator"`
+	Subject       string         `db:"subject"`
+	Content       string         `db:"content"`
+	Conf          ListConf       `db:"list_conf"`
+	IsDraft       bool           `db:"is_draft"`
+	TimeOfSending sql.NullString `db:"time_of_sending"`
+	LastEdited    string         `db:"last_edited"`
+
+	//creator data
+	CreatorName sql.NullString `db:"creator_name"`
+}
+
+/*Get all scheduled e-mails and drafts of a course. */
+func (emails *ScheduledEMails) Get(courseID int) (err error) {
+
+	err = app.Db.Select(emails, stmtSelectScheduledEMails, courseID, app.TimeZone)
+	if err != nil {
+		log.Error("failed to get scheduled e-mails", "courseID", courseID,
+			"error", err.Error())
+	}
+	return
+}
+
+/*Save inserts a new scheduled e-mail or draft, or updates an existing one. E-mails
+that were already sent can no longer be updated. */
+func (email *ScheduledEMail) Save(v *revel.Validation) (err error) {
+
+	var timeOfSending interface{}
+	if !email.IsDraft {
+		var t time.Time
+		if t, err = getTimestamp(email.TimeOfSending.String); err != nil {
+			return
+		}
+		if t.Before(time.Now()) {
+			v.ErrorKey("validation.invalid.time.of.sending")
+			return
+		}
+		timeOfSending = t
+	}
+
+	if email.ID == 0 {
+		err = app.Db.Get(email, stmtInsertScheduledEMail, email.CourseID, email.Creator,
+			email.Subject, email.Content, email.Conf, email.IsDraft, timeOfSending)
+	} else {
+		err = app.Db.Get(email, stmtUpdateScheduledEMail, email.ID, email.CourseID,
+			email.Creator, email.Subject, email.Content, email.Conf, email.IsDraft,
+			timeOfSending)
+	}
+
+	if err == sql.ErrNoRows {
+		v.ErrorKey("validation.invalid.scheduled.email")
+		return nil
+	} else if err != nil {
+		log.Error("failed to save scheduled e-mail", "email", *email,
+			"error", err.Error())
+	}
+	return
+}
+
+/*Cancel a scheduled e-mail before its time of sending. The e-mail is kept as a draft. */
+func (email *ScheduledEMail) Cancel(v *revel.Validation) (err error) {
+
+	err = app.Db.Get(email, stmtCancelScheduledEMail, email.ID, email.CourseID)
+	if err == sql.ErrNoRows {
+		v.ErrorKey("validation.invalid.scheduled.email")
+		return nil
+	} else if err != nil {
+		log.Error("failed to cancel scheduled e-mail", "ID", email.ID,
+			"courseID", email.CourseID, "error", err.Error())
+	}
+	return
+}
+
+/*Delete a draft or a scheduled e-mail that was not yet sent. */
+func (email *ScheduledEMail) Delete(v *revel.Validation) (err error) {
+
+	err = app.Db.Get(email, stmtDeleteScheduledEMail, email.ID, email.CourseID)
+	if err == sql.ErrNoRows {
+		v.ErrorKey("validation.invalid.scheduled.email")
+		return nil
+	} else if err != nil {
+		log.Error("failed to delete scheduled e-mail", "ID", email.ID,
+			"courseID", email.CourseID, "error", err.Error())
+	}
+	return
+}
+
+//maxScheduledEMailAttempts is the number of failed attempts to send a scheduled e-mail
+//after which it is turned back into a draft
+const maxScheduledEMailAttempts = 5
+
+//sendScheduledEMails sends all scheduled e-mails whose time of sending has passed
+type sendScheduledEMails struct{}
+
+/*Run the job to send all due scheduled e-mails. Each e-mail is removed from the
+scheduled e-mails, archived and queued within one transaction. E-mails that fail
+to send are retried with a backoff. */
+func (job sendScheduledEMails) Run() {
+
+	for {
+		ID, sent, err := sendNextScheduledEMail()
+		if err != nil {
+			//the e-mail could not be claimed, i.e., the DB is not available
+			if ID == 0 {
+				app.SendErrorNote()
+				return
+			}
+			if err = failScheduledEMail(ID); err != nil {
+				app.SendErrorNote()
+				return
+			}
+			continue
+		} else if !sent {
+			return
+		}
+	}
+}
+
+//sendNextScheduledEMail resolves the recipients of the next due scheduled e-mail,
+//archives it and adds it to the e-mail queue, it returns the ID of the claimed e-mail
+func sendNextScheduledEMail() (ID int, sent bool, err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	email := ScheduledEMail{}
+	err = tx.Get(&email, stmtClaimScheduledEMail, app.TimeZone)
+	if err == sql.ErrNoRows {
+		tx.Commit()
+		return 0, false, nil
+	} else if err != nil {
+		log.Error("failed to claim scheduled e-mail", "error", err.Error())
+		tx.Rollback()
+		return
+	}
+	ID = email.ID
+
+	//resolve the recipients now, so that late enrollments are included
+	participants := Participants{ID: email.CourseID}
+	if err = participants.Get(int(email.Creator.Int32), true); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	var queue []app.EMail
+	for _, recipient := range participants.EMailRecipients(&email.Conf) {
+		queue = append(queue, app.EMail{
+			Recipient: recipient,
+			Subject:   email.Subject,
+			ReplyTo:   participants.UserEMail,
+			Body:      app.HTMLToMimeFormat(&email.Content),
+		})
+	}
+
+	if len(queue) != 0 {
+		sentEMail := SentEMail{
+			CourseID: email.CourseID,
+			Sender:   email.Creator,
+			Subject:  email.Subject,
+			Content:  email.Content,
+		}
+		if err = sentEMail.Archive(tx, queue); err != nil {
+			return
+		}
+	}
+
+	tx.Commit()
+
+	log.Debug("sending scheduled e-mail", "ID", email.ID, "recipients", len(queue))
+	for _, mail := range queue {
+		app.EMailQueue <- mail
+	}
+	return ID, true, nil
+}
+
+//failScheduledEMail counts a failed attempt to send a scheduled e-mail and delays its
+//next attempt, after too many attempts the e-mail is kept as a draft
+func failScheduledEMail(ID int) (err error) {
+
+	isDraft := false
+	err = app.Db.Get(&isDraft, stmtFailScheduledEMail, ID, maxScheduledEMailAttempts)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		log.Error("failed to count failed attempt of scheduled e-mail", "ID", ID,
+			"error", err.Error())
+		return
+	}
+
+	if isDraft {
+		log.Error("failed to send scheduled e-mail, kept it as a draft", "ID", ID,
+			"attempts", maxScheduledEMailAttempts)
+		app.SendErrorNote()
+	}
+	return
+}
+
+const (
+	stmtSelectScheduledEMails = `
+		SELECT s.id, s.course_id, s.creator, s.subject, s.content, s.list_conf::text AS list_conf,
+			s.is_draft,
+			TO_CHAR (s.time_of_sending AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS time_of_sending,
+			TO_CHAR (s.last_edited AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS last_edited,
+			u.first_name || ' ' || u.last_name AS creator_name
+		FROM scheduled_emails s LEFT OUTER JOIN users u ON s.creator = u.id
+		WHERE s.course_id = $1
+		ORDER BY s.is_draft ASC, s.time_of_sending ASC, s.last_edited DESC
+	`
+
+	stmtInsertScheduledEMail = `
+		INSERT INTO scheduled_emails
+			(course_id, creator, subject, content, list_conf, is_draft, time_of_sending, last_edited)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, now())
+		RETURNING id
+	`
+
+	stmtUpdateScheduledEMail = `
+		UPDATE scheduled_emails
+		SET creator = $3, subject = $4, content = $5, list_conf = $6, is_draft = $7,
+			time_of_sending = $8, last_edited = now(), attempts = 0, retry_after = NULL
+		WHERE id = $1
+			AND course_id = $2
+			AND (is_draft OR time_of_sending > now())
+		RETURNING id
+	`
+
+	stmtCancelScheduledEMail = `
+		UPDATE scheduled_emails
+		SET is_draft = true, last_edited = now()
+		WHERE id = $1
+			AND course_id = $2
+			AND NOT is_draft
+			AND time_of_sending > now()
+		RETURNING id
+	`
+
+	stmtDeleteScheduledEMail = `
+		DELETE FROM scheduled_emails
+		WHERE id = $1
+			AND course_id = $2
+			AND (is_draft OR time_of_sending > now())
+		RETURNING id
+	`
+
+	stmtClaimScheduledEMail = `
+		DELETE FROM scheduled_emails
+		WHERE id = (
+				SELECT id FROM scheduled_emails
+				WHERE NOT is_draft
+					AND time_of_sending <= now()
+					AND (retry_after IS NULL OR retry_after <= now())
+				ORDER BY time_of_sending ASC
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+		RETURNING id, course_id, creator, subject, content, list_conf::text AS list_conf,
+			is_draft,
+			TO_CHAR (time_of_sending AT TIME ZONE $1, 'YYYY-MM-DD HH24:MI') AS time_of_sending,
+			TO_CHAR (last_edited AT TIME ZONE $1, 'YYYY-MM-DD HH24:MI') AS last_edited
+	`
+
+	stmtFailScheduledEMail = `
+		UPDATE scheduled_emails
+		SET attempts = attempts + 1,
+			retry_after = now() + (attempts + 1) * interval '5 minutes',
+			is_draft = (attempts + 1 >= $2)
+		WHERE id = $1
+		RETURNING is_draft
+	`
+)
@@ -0,0 +1,335 @@
+package models
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+	"turm/app"
+
+	"github.com/revel/revel"
+)
+
+const (
+	//TransitionActivate activates a course draft
+	TransitionActivate = "activate"
+	//TransitionShow makes a course publicly visible
+	TransitionShow = "show"
+	//TransitionHide hides a course from users who are not logged in
+	TransitionHide = "hide"
+	//TransitionExpire expires an active course
+	TransitionExpire = "expire"
+)
+
+/*ScheduledTransitions contains scheduled transitions of courses. */
+type ScheduledTransitions []ScheduledTransition
+
+/*ScheduledTransition is a state change of a course that is executed at a future
+timestamp by a job, i.e., its activation, a change of its visibility or its expiration.
+Transitions can be cancelled until they run. */
+type ScheduledTransition struct {
+	ID       int           `db:"id, primarykey, autoincrement"`
+	CourseID int           `db:"course_id"`
+	Action   string        `db:"action"`
+	Creator  sql.NullInt32 `db:"creator"`
+	Due      string        `db:"due"`
+
+	//failed transitions are kept until they are cancelled, so that the creator
+	//notices them
+	Failed bool `db:"failed"`
+}
+
+/*Validate a scheduled transition. */
+func (transition *ScheduledTransition) Validate(v *revel.Validation) {
+
+	if transition.Action != TransitionActivate && transition.Action != TransitionShow &&
+		transition.Action != TransitionHide && transition.Action != TransitionExpire {
+		v.ErrorKey("validation.invalid.params")
+	}
+
+	v.Check(transition.Due,
+		IsTimestamp{},
+	).MessageKey("validation.invalid.timestamp")
+}
+
+/*Insert a new scheduled transition of a course. The transition must be due in
+the future. */
+func (transition *ScheduledTransition) Insert(v *revel.Validation) (err error) {
+
+	due, err := getTimestamp(transition.Due)
+	if err != nil {
+		return
+	}
+	if due.Before(time.Now()) {
+		v.ErrorKey("validation.invalid.transition.due")
+		return
+	}
+
+	err = app.Db.Get(transition, stmtInsertTransition, transition.CourseID,
+		transition.Action, transition.Creator, due)
+	if err != nil {
+		log.Error("failed to insert scheduled transition", "transition", *transition,
+			"error", err.Error())
+	}
+	return
+}
+
+/*Cancel a scheduled transition that did not yet run. Transitions are removed once
+they ran, so that cancelling a transition that already ran fails. */
+func (transition *ScheduledTransition) Cancel(v *revel.Validation) (err error) {
+
+	err = app.Db.Get(transition, stmtCancelTransition, transition.ID, transition.CourseID)
+	if err == sql.ErrNoRows {
+		v.ErrorKey("validation.invalid.transition")
+		return nil
+	} else if err != nil {
+		log.Error("failed to cancel scheduled transition", "ID", transition.ID,
+			"courseID", transition.CourseID, "error", err.Error())
+	}
+	return
+}
+
+/*SelectTransitions selects the scheduled transitions of all courses of a course list. */
+func (list *CourseList) SelectTransitions() (err error) {
+
+	if len(*list) == 0 {
+		return
+	}
+
+	var IDs []string
+	for _, course := range *list {
+		IDs = append(IDs, strconv.Itoa(course.ID))
+	}
+
+	var transitions ScheduledTransitions
+	err = app.Db.Select(&transitions, stmtSelectTransitions, strings.Join(IDs, ","),
+		app.TimeZone)
+	if err != nil {
+		log.Error("failed to select scheduled transitions", "IDs", IDs,
+			"error", err.Error())
+		return
+	}
+
+	for key := range *list {
+		for _, transition := range transitions {
+			if transition.CourseID == (*list)[key].ID {
+				(*list)[key].Transitions = append((*list)[key].Transitions, transition)
+			}
+		}
+	}
+	return
+}
+
+//runScheduledTransitions executes all due scheduled transitions
+type runScheduledTransitions struct{}
+
+/*Run the job to execute all due scheduled transitions. Each transition is claimed,
+executed and removed within one transaction. */
+func (job runScheduledTransitions) Run() {
+
+	for {
+		ran, err := runNextTransition()
+		if err != nil {
+			app.SendErrorNote()
+			return
+		} else if !ran {
+			return
+		}
+	}
+}
+
+//runNextTransition executes the next due scheduled transition. All state changes are
+//idempotent, i.e., running a transition of a course that already is in the target
+//state does not change the course.
+func runNextTransition() (ran bool, err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	transition := ScheduledTransition{}
+	err = tx.Get(&transition, stmtClaimTransition, app.TimeZone)
+	if err == sql.ErrNoRows {
+		tx.Commit()
+		return false, nil
+	} else if err != nil {
+		log.Error("failed to claim scheduled transition", "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	course := Course{ID: transition.CourseID}
+	activated := false
+	var users, reviewers EMailsData
+	switch transition.Action {
+	case TransitionActivate:
+		var active bool
+		if err = tx.Get(&active, stmtCourseIsActive, course.ID); err != nil {
+			log.Error("failed to get whether the course is active", "courseID",
+				course.ID, "error", err.Error())
+			tx.Rollback()
+			return
+		}
+		if active {
+			break
+		}
+
+		//courses requiring approval are submitted for review instead
+		var required bool
+		if err = tx.Get(&required, stmtCourseRequiresApproval, course.ID); err != nil {
+			log.Error("failed to get whether the course requires approval", "courseID",
+				course.ID, "error", err.Error())
+			tx.Rollback()
+			return
+		}
+
+		v := revel.Validation{}
+		invalid := false
+		if required {
+			var pending bool
+			if err = tx.Get(&pending, stmtReviewIsPending, course.ID); err != nil {
+				log.Error("failed to get whether a review is pending", "courseID",
+					course.ID, "error", err.Error())
+				tx.Rollback()
+				return
+			}
+			if pending {
+				break
+			}
+
+			review := CourseReview{CourseID: course.ID}
+			if invalid, reviewers, err = review.submit(tx, &v, transition.Creator); err != nil {
+				return
+			}
+		} else {
+			if invalid, users, err = course.activate(tx, &v); err != nil {
+				return
+			}
+		}
+
+		if invalid {
+			log.Info("failed to run scheduled activation", "courseID", course.ID,
+				"requiresApproval", required)
+			tx.Rollback()
+			return true, failTransition(transition.ID)
+		}
+		activated = !required
+
+	case TransitionShow, TransitionHide:
+		_, err = tx.Exec(stmtUpdateVisible, course.ID, transition.Action == TransitionShow)
+
+	case TransitionExpire:
+		_, err = tx.Exec(stmtExpireCourse, course.ID)
+	}
+
+	if err != nil {
+		log.Error("failed to run scheduled transition", "transition", transition,
+			"error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	tx.Commit()
+
+	//NOTE: failing deliveries must not fail the transition, errors are logged
+	//by Trigger
+	if activated {
+		payload := WebhookPayload{
+			Event:       WebhookCourseActivation,
+			CourseID:    course.ID,
+			CourseTitle: course.Title,
+		}
+		payload.Trigger()
+	}
+
+	//notify all editors and instructors of an activated course, or all reviewers
+	//of a submitted course, failing to send them does not fail the transition
+	if len(users) != 0 {
+		err = queueEMails(users, transition.Creator, "email.subject.new.course.role",
+			"newCourseRole")
+	} else if len(reviewers) != 0 {
+		err = queueEMails(reviewers, transition.Creator, "email.subject.review.submitted",
+			"reviewSubmitted")
+	}
+	if err != nil {
+		app.SendErrorNote()
+	}
+	return true, nil
+}
+
+//failTransition marks a scheduled transition as failed
+func failTransition(ID int) (err error) {
+
+	_, err = app.Db.Exec(stmtFailTransition, ID)
+	if err != nil {
+		log.Error("failed to mark scheduled transition as failed", "ID", ID,
+			"error", err.Error())
+	}
+	return
+}
+
+const (
+	stmtInsertTransition = `
+		INSERT INTO scheduled_transitions
+			(course_id, action, creator, due, failed)
+		VALUES ($1, $2, $3, $4, false)
+		RETURNING id
+	`
+
+	stmtCancelTransition = `
+		DELETE FROM scheduled_transitions
+		WHERE id = $1
+			AND course_id = $2
+		RETURNING id
+	`
+
+	stmtSelectTransitions = `
+		SELECT id, course_id, action, creator, failed,
+			TO_CHAR (due AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS due
+		FROM scheduled_transitions
+		WHERE course_id = ANY(string_to_array($1, ',')::integer[])
+		ORDER BY due ASC
+	`
+
+	stmtClaimTransition = `
+		DELETE FROM scheduled_transitions
+		WHERE id = (
+				SELECT id FROM scheduled_transitions
+				WHERE NOT failed
+					AND due <= now()
+				ORDER BY due ASC
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+		RETURNING id, course_id, action, creator, failed,
+			TO_CHAR (due AT TIME ZONE $1, 'YYYY-MM-DD HH24:MI') AS due
+	`
+
+	stmtFailTransition = `
+		UPDATE scheduled_transitions
+		SET failed = true
+		WHERE id = $1
+	`
+
+	stmtCourseIsActive = `
+		SELECT active
+		FROM courses
+		WHERE id = $1
+	`
+
+	stmtUpdateVisible = `
+		UPDATE courses
+		SET visible = $2
+		WHERE id = $1
+			AND visible != $2
+	`
+
+	stmtExpireCourse = `
+		UPDATE courses
+		SET expiration_date = now()
+		WHERE id = $1
+			AND expiration_date > now()
+	`
+)
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"database/sql"
+	"turm/app"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/*SentEMails contains all archived e-mails of a course. */
+type SentEMails []SentEMail
+
+/*SentEMail is an archived e-mail that was sent in the context of a course. */
+type SentEMail struct {
+	ID            int           `db:"id, primarykey, autoincrement"`
+	CourseID      int           `db:"course_id"`
+	Sender        sql.NullInt32 `db:"sender"`
+	Subject       string        `db:"subject"`
+	Content       string        `db:"content"`
+	TimeOfSending string        `db:"time_of_sending"`
+
+	//sender data
+	SenderName  sql.NullString `db:"sender_name"`
+	SenderEMail sql.NullString `db:"sender_email"`
+
+	Recipients SentEMailRecipients
+}
+
+/*SentEMailRecipients contains all recipients of an archived e-mail. */
+type SentEMailRecipients []SentEMailRecipient
+
+/*SentEMailRecipient is a recipient of an archived e-mail. */
+type SentEMailRecipient struct {
+	ID            int           `db:"id, primarykey, autoincrement"`
+	SentEMailID   int           `db:"sent_email_id"`
+	EMail         string        `db:"email"`
+	DeliveryState DeliveryState `db:"delivery_state"`
+}
+
+/*Get all archived e-mails of a course. */
+func (emails *SentEMails) Get(courseID int) (err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	err = tx.Select(emails, stmtSelectSentEMails, courseID, app.TimeZone)
+	if err != nil {
+		log.Error("failed to get sent e-mails", "courseID", courseID,
+			"error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	for i := range *emails {
+		err = tx.Select(&(*emails)[i].Recipients, stmtSelectSentEMailRecipients,
+			(*emails)[i].ID)
+		if err != nil {
+			log.Error("failed to get recipients of sent e-mail", "ID", (*emails)[i].ID,
+				"error", err.Error())
+			tx.Rollback()
+			return
+		}
+	}
+
+	tx.Commit()
+	return
+}
+
+/*Archive an e-mail before sending it to all recipients. It sets the archive ID of
+each queued e-mail, so that the mailer can update its delivery state. */
+func (email *SentEMail) Archive(tx *sqlx.Tx, queue []app.EMail) (err error) {
+
+	txWasNil := (tx == nil)
+	if txWasNil {
+		tx, err = app.Db.Beginx()
+		if err != nil {
+			log.Error("failed to begin tx", "error", err.Error())
+			return
+		}
+	}
+
+	err = tx.Get(email, stmtInsertSentEMail, email.CourseID, email.Sender,
+		email.Subject, email.Content)
+	if err != nil {
+		log.Error("failed to archive e-mail", "email", *email, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	for i := range queue {
+
+		recipient := SentEMailRecipient{EMail: queue[i].Recipient}
+		err = tx.Get(&recipient, stmtInsertSentEMailRecipient, email.ID, recipient.EMail)
+		if err != nil {
+			log.Error("failed to archive e-mail recipient", "ID", email.ID,
+				"recipient", recipient.EMail, "error", err.Error())
+			tx.Rollback()
+			return
+		}
+
+		queue[i].ArchiveID = recipient.ID
+		email.Recipients = append(email.Recipients, recipient)
+	}
+
+	if txWasNil {
+		tx.Commit()
+	}
+	return
+}
+
+const (
+	stmtSelectSentEMails = `
+		SELECT s.id, s.course_id, s.sender, s.subject, s.content,
+			TO_CHAR (s.time_of_sending AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS time_of_sending,
+			u.first_name || ' ' || u.last_name AS sender_name, u.email AS sender_email
+		FROM sent_emails s LEFT OUTER JOIN users u ON s.sender = u.id
+		WHERE s.course_id = $1
+		ORDER BY s.time_of_sending DESC
+	`
+
+	stmtSelectSentEMailRecipients = `
+		SELECT id, sent_email_id, email, delivery_state
+		FROM sent_email_recipients
+		WHERE sent_email_id = $1
+		ORDER BY email ASC
+	`
+
+	stmtInsertSentEMail = `
+		INSERT INTO sent_emails
+			(course_id, sender, subject, content, time_of_sending)
+		VALUES ($1, $2, $3, $4, now())
+		RETURNING id
+	`
+
+	stmtInsertSentEMailRecipient = `
+		INSERT INTO sent_email_recipients
+			(sent_email_id, email, delivery_state)
+		VALUES ($1, $2, 0 /* queued */)
+		RETURNING id, sent_email_id, email, delivery_state
+	`
+)
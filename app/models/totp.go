@@ -0,0 +1,264 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"turm/app"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//recoveryCodeCount is the number of recovery codes of an user
+const recoveryCodeCount = 10
+
+//userTOTP contains the two-factor authentication fields of an user
+type userTOTP struct {
+	Secret   sql.NullString `db:"totp_secret"`
+	Enabled  bool           `db:"totp_enabled"`
+	LastStep int64          `db:"totp_last_step"`
+}
+
+/*NewTOTPSecret returns the pending TOTP secret of an user who did not yet enable the
+two-factor authentication, or generates a new one. The secret is only used after the
+user confirmed it with a valid code. It returns the QR code to enroll the secret in
+an authenticator app. If the user already enabled the two-factor authentication, then
+the secret is empty. */
+func (user *User) NewTOTPSecret() (secret, qrCode string, err error) {
+
+	newSecret, err := app.GenerateTOTPSecret()
+	if err != nil {
+		return
+	}
+
+	err = app.Db.QueryRowx(stmtUpdateTOTPSecret, newSecret, user.ID).Scan(&user.EMail, &secret)
+	if err == sql.ErrNoRows { //already enabled
+		return "", "", nil
+	} else if err != nil {
+		log.Error("failed to update TOTP secret", "userID", user.ID, "error", err.Error())
+		return
+	}
+
+	qrCode, err = app.TOTPQRCode(secret, user.EMail)
+	return
+}
+
+/*EnableTOTP enables the two-factor authentication if the code is valid for the new
+TOTP secret of the user. It returns new recovery codes, which are only shown once. */
+func (user *User) EnableTOTP(code string) (recoveryCodes []string, err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	totp := userTOTP{}
+	if err = tx.Get(&totp, stmtSelectTOTP, user.ID); err != nil {
+		log.Error("failed to get TOTP data", "userID", user.ID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	step, valid := app.ValidateTOTP(totp.Secret.String, strings.TrimSpace(code), totp.LastStep)
+	if totp.Enabled || !totp.Secret.Valid || !valid {
+		tx.Commit()
+		return
+	}
+
+	if _, err = tx.Exec(stmtEnableTOTP, user.ID, step); err != nil {
+		log.Error("failed to enable TOTP", "userID", user.ID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	if recoveryCodes, err = user.newRecoveryCodes(tx); err != nil {
+		return
+	}
+
+	tx.Commit()
+	return
+}
+
+/*VerifyTOTP verifies the second factor of an user, which is either a TOTP code or
+an unused recovery code. Recovery codes can only be used once. */
+func (user *User) VerifyTOTP(code string) (valid bool, err error) {
+
+	code = strings.TrimSpace(code)
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	totp := userTOTP{}
+	if err = tx.Get(&totp, stmtSelectTOTP, user.ID); err != nil {
+		log.Error("failed to get TOTP data", "userID", user.ID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+	if !totp.Enabled {
+		tx.Commit()
+		return
+	}
+
+	//TOTP code
+	if step, validCode := app.ValidateTOTP(totp.Secret.String, code, totp.LastStep); validCode {
+
+		//NOTE: the update only succeeds for the first of concurrent requests using
+		//the same code, so that each code is accepted only once
+		result, err := tx.Exec(stmtUpdateTOTPLastStep, user.ID, step)
+		if err != nil {
+			log.Error("failed to update TOTP step", "userID", user.ID, "error", err.Error())
+			tx.Rollback()
+			return false, err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			log.Error("failed to get updated TOTP steps", "userID", user.ID,
+				"error", err.Error())
+			tx.Rollback()
+			return false, err
+		}
+		tx.Commit()
+		return rows == 1, nil
+	}
+
+	//recovery code
+	err = tx.Get(&valid, stmtUseRecoveryCode, user.ID, hashToken(strings.ToUpper(code)))
+	if err == sql.ErrNoRows {
+		tx.Commit()
+		return false, nil
+	} else if err != nil {
+		log.Error("failed to use recovery code", "userID", user.ID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	log.Debug("used recovery code", "userID", user.ID)
+	tx.Commit()
+	return
+}
+
+/*DisableTOTP disables the two-factor authentication of an user and deletes all
+recovery codes. */
+func (user *User) DisableTOTP() (err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	if _, err = tx.Exec(stmtDisableTOTP, user.ID); err != nil {
+		log.Error("failed to disable TOTP", "userID", user.ID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+	if _, err = tx.Exec(stmtDeleteRecoveryCodes, user.ID); err != nil {
+		log.Error("failed to delete recovery codes", "userID", user.ID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	tx.Commit()
+	return
+}
+
+/*NewRecoveryCodes replaces all recovery codes of an user. */
+func (user *User) NewRecoveryCodes() (recoveryCodes []string, err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	if recoveryCodes, err = user.newRecoveryCodes(tx); err != nil {
+		return
+	}
+
+	tx.Commit()
+	return
+}
+
+//newRecoveryCodes replaces all recovery codes of an user, only their hashes are stored
+func (user *User) newRecoveryCodes(tx *sqlx.Tx) (recoveryCodes []string, err error) {
+
+	if _, err = tx.Exec(stmtDeleteRecoveryCodes, user.ID); err != nil {
+		log.Error("failed to delete recovery codes", "userID", user.ID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	for i := 0; i < recoveryCodeCount; i++ {
+
+		//two blocks of five characters, e.g., ABCDE-FGHIJ
+		secret, err := app.GenerateTOTPSecret()
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		code := secret[:5] + "-" + secret[5:10]
+
+		if _, err = tx.Exec(stmtInsertRecoveryCode, user.ID, hashToken(code)); err != nil {
+			log.Error("failed to insert recovery code", "userID", user.ID, "error", err.Error())
+			tx.Rollback()
+			return nil, err
+		}
+		recoveryCodes = append(recoveryCodes, code)
+	}
+	return
+}
+
+const (
+	stmtSelectTOTP = `
+		SELECT totp_secret, totp_enabled, totp_last_step
+		FROM users
+		WHERE id = $1
+	`
+
+	stmtUpdateTOTPSecret = `
+		UPDATE users
+		SET totp_secret = COALESCE(totp_secret, $1)
+		WHERE id = $2
+			AND NOT totp_enabled
+		RETURNING email, totp_secret
+	`
+
+	stmtEnableTOTP = `
+		UPDATE users
+		SET totp_enabled = true, totp_last_step = $2
+		WHERE id = $1
+	`
+
+	stmtUpdateTOTPLastStep = `
+		UPDATE users
+		SET totp_last_step = $2
+		WHERE id = $1
+			AND (totp_last_step IS NULL OR totp_last_step < $2)
+	`
+
+	stmtDisableTOTP = `
+		UPDATE users
+		SET totp_enabled = false, totp_secret = NULL, totp_last_step = 0
+		WHERE id = $1
+	`
+
+	stmtDeleteRecoveryCodes = `
+		DELETE FROM recovery_codes
+		WHERE user_id = $1
+	`
+
+	stmtInsertRecoveryCode = `
+		INSERT INTO recovery_codes (user_id, code_hash)
+		VALUES ($1, $2)
+	`
+
+	stmtUseRecoveryCode = `
+		DELETE FROM recovery_codes
+		WHERE user_id = $1
+			AND code_hash = $2
+		RETURNING true AS valid
+	`
+)
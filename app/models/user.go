@@ -22,6 +22,10 @@ type User struct {
 	FirstLogin string         `db:"first_login"`
 	Language   sql.NullString `db:"language"`
 
+	//e-mail delivery fields
+	EMailUndeliverable bool           `db:"email_undeliverable"`
+	UnconfirmedEMail   sql.NullString `db:"unconfirmed_email"`
+
 	//ldap user fields
 	MatrNr        sql.NullInt32    `db:"matr_nr, unique"`
 	AcademicTitle sql.NullString   `db:"academic_title"`
@@ -29,6 +33,7 @@ type User struct {
 	NameAffix     sql.NullString   `db:"name_affix"`
 	Affiliations  NullAffiliations `db:"affiliations"`
 	Studies       Studies          ``
+	LDAPUsername  sql.NullString   `db:"ldap_username"`
 
 	//external user fields
 	Password       sql.NullString `db:"password"`
@@ -42,6 +47,9 @@ type User struct {
 	//used for event enrollment
 	IsLDAP bool `db:"is_ldap"`
 
+	//two-factor authentication
+	TOTPEnabled bool `db:"totp_enabled"`
+
 	//used for profile page
 	ActiveEnrollments  Enrollments
 	ExpiredEnrollments Enrollments
@@ -49,6 +57,21 @@ type User struct {
 	ExpiredSlots       Enrollments
 }
 
+/*OIDCIdentity identifies an user at an OpenID Connect provider. */
+type OIDCIdentity struct {
+	Issuer        string
+	Subject       string
+	EMailVerified bool
+}
+
+/*SAMLIdentity identifies an user at a SAML identity provider, e.g., by its
+persistent NameID or its eduPersonPrincipalName. */
+type SAMLIdentity struct {
+	Issuer        string
+	Subject       string
+	EMailVerified bool
+}
+
 /*ValidateRegister User fields of newly registered users. */
 func (user *User) ValidateRegister(tx *sqlx.Tx, v *revel.Validation) {
 
@@ -68,7 +91,7 @@ func (user *User) ValidateRegister(tx *sqlx.Tx, v *revel.Validation) {
 	v.Required(isLdapEMail).
 		MessageKey("validation.email.ldap")
 
-	ValidateLength(&user.Password.String, "validation.invalid.passwords", 6, 127, v)
+	ValidatePassword(user.Password.String, v)
 
 	equal := (user.Password.String == user.PasswordRepeat)
 	v.Required(equal).
@@ -195,7 +218,8 @@ func (user *User) GetNavigationData() (err error) {
 	return
 }
 
-/*Login inserts or updates a user. It provides all session values of that user. */
+/*Login inserts or updates a user. It provides all session values of that user.
+External users must be authenticated with VerifyPassword before. */
 func (user *User) Login() (err error) {
 
 	tx, err := app.Db.Beginx()
@@ -214,9 +238,11 @@ func (user *User) Login() (err error) {
 		//insert or update users table data
 		err = tx.Get(user, stmtLoginLdap,
 			user.FirstName, user.LastName, user.EMail, user.Salutation, now, now, user.MatrNr,
-			user.AcademicTitle, user.Title, user.NameAffix, user.Affiliations, app.TimeZone)
+			user.AcademicTitle, user.Title, user.NameAffix, user.Affiliations, app.TimeZone,
+			user.LDAPUsername)
 		if err != nil {
-			log.Error("failed to update or insert ldap user", "user", user, "error", err.Error())
+			log.Error("failed to update or insert ldap user", "email", user.EMail,
+				"error", err.Error())
 			tx.Rollback()
 			return
 		}
@@ -245,13 +271,15 @@ func (user *User) Login() (err error) {
 
 		log.Debug("external login")
 
-		err = tx.Get(user, stmtLoginExtern, now, user.EMail, user.Password)
+		err = tx.Get(user, stmtLoginExtern, now, user.ID)
 		if err != nil {
 			if err != sql.ErrNoRows {
-				log.Error("failed to update external user", "user", user, "error", err.Error())
+				log.Error("failed to update external user", "userID", user.ID,
+					"error", err.Error())
 				tx.Rollback()
 				return
 			}
+			user.ID = 0
 			err = nil
 		}
 
@@ -265,84 +293,180 @@ func (user *User) Login() (err error) {
 	return
 }
 
-/*Register inserts an external user. It provides all session values of that user. */
-func (user *User) Register(v *revel.Validation) (err error) {
+/*GetSessionData returns all session values of an user, e.g., after the second login step. */
+func (user *User) GetSessionData() (err error) {
 
 	tx, err := app.Db.Beginx()
 	if err != nil {
 		log.Error("failed to begin tx", "error", err.Error())
-		return
+		return err
 	}
 
-	if user.ValidateRegister(tx, v); v.HasErrors() {
+	if err = tx.Get(user, stmtGetSessionData, user.ID); err != nil {
+		log.Error("failed to get session data", "userID", user.ID, "error", err.Error())
 		tx.Rollback()
 		return
 	}
 
-	activationCode := generateCode()
+	user.IsEditor, user.IsInstructor, err = user.IsEditorInstructor(tx)
+	if err != nil {
+		return
+	}
 
-	//last login and first login
-	now := time.Now().Format(revel.TimeFormats[0])
+	tx.Commit()
+	return
+}
 
-	err = tx.Get(user, stmtRegisterExtern, user.FirstName, user.LastName, user.EMail,
-		user.Salutation, now, now, user.Password, activationCode, user.Language)
+/*LoginOIDC logs in an user authenticated by an OpenID Connect provider. Unknown
+identities are linked to the user with the same verified e-mail address. If no such
+user exists, then it registers a new external user. */
+func (user *User) LoginOIDC(identity *OIDCIdentity, v *revel.Validation) (err error) {
+
+	tx, err := app.Db.Beginx()
 	if err != nil {
-		log.Error("failed to register external user", "user", user, "error", err.Error())
+		log.Error("failed to begin tx", "error", err.Error())
+		return err
+	}
+
+	//update the user data of known identities
+	err = tx.Get(user, stmtLoginOIDC, identity.Issuer, identity.Subject,
+		user.FirstName, user.LastName, user.MatrNr, user.AcademicTitle, user.Title,
+		user.Affiliations)
+	if err == sql.ErrNoRows {
+
+		//NOTE: linking to an unverified e-mail address allows taking over foreign accounts
+		if !identity.EMailVerified {
+			v.ErrorKey("login.oidc.email.unverified", user.EMail)
+			tx.Commit()
+			return nil
+		}
+
+		log.Debug("link OIDC identity", "issuer", identity.Issuer, "email", user.EMail)
+		err = tx.Get(user, stmtLinkOIDC, identity.Issuer, identity.Subject,
+			user.FirstName, user.LastName, user.EMail, user.MatrNr, user.AcademicTitle,
+			user.Title, user.Affiliations)
+		if err == sql.ErrNoRows { //the user is already linked to another identity
+			v.ErrorKey("login.oidc.linked", user.EMail)
+			tx.Commit()
+			return nil
+		}
+	}
+
+	if err != nil {
+		log.Error("failed to login OIDC user", "issuer", identity.Issuer,
+			"subject", identity.Subject, "user", user, "error", err.Error())
 		tx.Rollback()
 		return
 	}
 
-	user.ActivationCode.String = activationCode
+	user.IsEditor, user.IsInstructor, err = user.IsEditorInstructor(tx)
+	if err != nil {
+		return
+	}
+
 	tx.Commit()
 	return
 }
 
-/*GenerateNewPassword for an user. */
-func (user *User) GenerateNewPassword(v *revel.Validation) (err error) {
+/*LoginSAML logs in an user authenticated by a SAML identity provider. Unknown
+identities are only linked to the user with the same e-mail address, if the identity
+provider verified that address. Otherwise, it registers a new user. */
+func (user *User) LoginSAML(identity *SAMLIdentity, v *revel.Validation) (err error) {
 
 	tx, err := app.Db.Beginx()
 	if err != nil {
 		log.Error("failed to begin tx", "error", err.Error())
+		return err
+	}
+
+	//update the user data of known identities
+	err = tx.Get(user, stmtLoginSAML, identity.Issuer, identity.Subject,
+		user.FirstName, user.LastName, user.MatrNr, user.AcademicTitle, user.Title,
+		user.Affiliations)
+	if err == sql.ErrNoRows {
+
+		//NOTE: linking to an unverified e-mail address allows taking over foreign accounts
+		stmt := stmtRegisterSAML
+		if identity.EMailVerified {
+			log.Debug("link SAML identity", "issuer", identity.Issuer, "email", user.EMail)
+			stmt = stmtLinkSAML
+		}
+
+		err = tx.Get(user, stmt, identity.Issuer, identity.Subject,
+			user.FirstName, user.LastName, user.EMail, user.MatrNr, user.AcademicTitle,
+			user.Title, user.Affiliations)
+		if err == sql.ErrNoRows {
+			if identity.EMailVerified { //the user is already linked to another identity
+				v.ErrorKey("login.saml.linked", user.EMail)
+			} else {
+				v.ErrorKey("login.saml.email.unverified", user.EMail)
+			}
+			tx.Commit()
+			return nil
+		}
+
+		//first login, update the courses of study of that user
+		if err == nil && user.MatrNr.Valid {
+			if err = app.Parse(tx); err != nil {
+				return
+			}
+		}
+	}
+
+	if err != nil {
+		log.Error("failed to login SAML user", "issuer", identity.Issuer,
+			"subject", identity.Subject, "user", user, "error", err.Error())
+		tx.Rollback()
 		return
 	}
 
-	v.Check(user.EMail,
-		revel.Required{},
-		revel.MaxSize{255},
-	).MessageKey("validation.invalid.email")
-	v.Email(user.EMail).
-		MessageKey("validation.invalid.email")
+	user.IsEditor, user.IsInstructor, err = user.IsEditorInstructor(tx)
+	if err != nil {
+		return
+	}
 
-	isLdapEMail := !strings.Contains(user.EMail, app.Mailer.Suffix)
-	v.Required(isLdapEMail).
-		MessageKey("validation.email.ldap")
+	tx.Commit()
+	return
+}
 
-	data := ValidateUniqueData{
-		Column: "email",
-		Table:  "users",
-		Value:  user.EMail,
-		Tx:     tx,
+/*Register inserts an external user. It provides all session values of that user. */
+func (user *User) Register(v *revel.Validation) (err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
 	}
-	v.Check(data,
-		NotUnique{},
-	).MessageKey("validation.invalid.email")
 
-	if v.HasErrors() {
+	if user.ValidateRegister(tx, v); v.HasErrors() {
 		tx.Rollback()
 		return
 	}
 
-	password := generateCode()
+	activationCode := generateCode()
 
-	err = tx.Get(user, stmtUpdatePasswordByEMail, password, user.EMail)
+	hash, err := app.HashPassword(user.Password.String)
 	if err != nil {
-		log.Error("failed to update password", "user", *user,
-			"password", password, "error", err.Error())
 		tx.Rollback()
 		return
 	}
 
-	user.Password.String = password
+	//the plaintext password must not remain in the user, e.g., in the e-mail data
+	user.Password.String, user.PasswordRepeat = "", ""
+
+	//last login and first login
+	now := time.Now().Format(revel.TimeFormats[0])
+
+	err = tx.Get(user, stmtRegisterExtern, user.FirstName, user.LastName, user.EMail,
+		user.Salutation, now, now, hash, activationCode, user.Language)
+	if err != nil {
+		log.Error("failed to register external user", "email", user.EMail,
+			"error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	user.ActivationCode.String = activationCode
 	tx.Commit()
 	return
 }
@@ -362,12 +486,22 @@ func (user *User) NewPassword(newPw1, newPw2 string, v *revel.Validation) (err e
 		return
 	}
 
+	if ValidatePassword(newPw1, v); v.HasErrors() {
+		tx.Rollback()
+		return
+	}
+
 	//ensure that the old password is valid
-	match := false
-	err = tx.Get(&match, stmtPasswordsMatch, user.ID, user.Password)
+	var hash sql.NullString
+	err = tx.Get(&hash, stmtSelectPasswordByID, user.ID)
 	if err != nil {
-		log.Error("failed to validate if passwords match", "userID",
-			user.ID, "password", user.Password, "error", err.Error())
+		log.Error("failed to get password", "userID", user.ID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+	match, _, err := app.VerifyPassword(hash.String, user.Password.String)
+	if err != nil {
+		log.Error("failed to verify password", "userID", user.ID, "error", err.Error())
 		tx.Rollback()
 		return
 	} else if !match {
@@ -376,10 +510,22 @@ func (user *User) NewPassword(newPw1, newPw2 string, v *revel.Validation) (err e
 		return
 	}
 
-	err = tx.Get(user, stmtUpdatePassword, newPw1, user.ID)
+	if hash.String, err = app.HashPassword(newPw1); err != nil {
+		tx.Rollback()
+		return
+	}
+
+	err = tx.Get(user, stmtUpdatePassword, hash.String, user.ID)
+	if err != nil {
+		log.Error("failed to update password", "userID", user.ID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	//password reset tokens are no longer valid
+	_, err = tx.Exec(stmtDeleteResetTokens, user.ID)
 	if err != nil {
-		log.Error("failed to update password", "user", user,
-			"newPw1", newPw1, "error", err.Error())
+		log.Error("failed to delete reset tokens", "userID", user.ID, "error", err.Error())
 		tx.Rollback()
 		return
 	}
@@ -422,6 +568,47 @@ func (user *User) VerifyActivationCode() (success bool, err error) {
 	return
 }
 
+/*VerifyPassword verifies the password of an external user with this e-mail address.
+If successful, then it sets the ID of the user and rehashes outdated password hashes,
+e.g., hashes generated by pgcrypto. */
+func (user *User) VerifyPassword(password string) (success bool, err error) {
+
+	data := struct {
+		ID       int            `db:"id"`
+		Password sql.NullString `db:"password"`
+	}{}
+
+	err = app.Db.Get(&data, stmtSelectPasswordByEMail, user.EMail)
+	if err == sql.ErrNoRows || (err == nil && !data.Password.Valid) {
+		return false, nil
+	} else if err != nil {
+		log.Error("failed to get password", "email", user.EMail, "error", err.Error())
+		return
+	}
+
+	success, rehash, err := app.VerifyPassword(data.Password.String, password)
+	if err != nil {
+		log.Error("failed to verify password", "email", user.EMail, "error", err.Error())
+		return
+	} else if !success {
+		return
+	}
+	user.ID = data.ID
+
+	if rehash {
+		hash, err := app.HashPassword(password)
+		if err != nil {
+			return false, err
+		}
+		if _, err = app.Db.Exec(stmtRehashPassword, hash, data.ID, data.Password.String); err != nil {
+			log.Error("failed to rehash password", "userID", data.ID, "error", err.Error())
+			return false, err
+		}
+		log.Debug("rehashed password", "userID", data.ID)
+	}
+	return
+}
+
 /*NewActivationCode creates a new activation code for an user. */
 func (user *User) NewActivationCode() (err error) {
 
@@ -484,6 +671,85 @@ func (user *User) ChangeUserData(v *revel.Validation) (err error) {
 	return
 }
 
+/*RequestEMailConfirmation sets a new confirmation code for the (new) e-mail address of an
+user whose e-mail address is undeliverable. LDAP users can only confirm their current
+e-mail address. */
+func (user *User) RequestEMailConfirmation(v *revel.Validation) (code string, err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	current := User{ID: user.ID}
+	if err = current.Get(tx); err != nil {
+		return
+	}
+
+	user.EMail = strings.ToLower(strings.TrimSpace(user.EMail))
+	if current.IsLDAP || user.EMail == "" {
+		user.EMail = current.EMail
+	}
+
+	if user.EMail != current.EMail {
+
+		ValidateLength(&user.EMail, "validation.invalid.email", 1, 255, v)
+		v.Email(user.EMail).
+			MessageKey("validation.invalid.email")
+
+		isLdapEMail := !strings.Contains(user.EMail, app.Mailer.Suffix)
+		v.Required(isLdapEMail).
+			MessageKey("validation.email.ldap")
+
+		data := ValidateUniqueData{
+			Column: "email",
+			Table:  "users",
+			Value:  user.EMail,
+			Tx:     tx,
+		}
+		v.Check(data,
+			Unique{},
+		).MessageKey("validation.email.notUnique")
+
+		if v.HasErrors() {
+			tx.Rollback()
+			return
+		}
+	}
+
+	code = generateCode()
+
+	err = tx.Get(user, stmtUpdateEMailConfirmation, user.EMail, code, user.ID)
+	if err != nil {
+		log.Error("failed to update e-mail confirmation code", "user", user,
+			"error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	tx.Commit()
+	return
+}
+
+/*ConfirmEMail verifies the e-mail confirmation code of an user. If it matches, then the
+unconfirmed e-mail address becomes the e-mail address of the user and it is no longer
+marked as undeliverable. */
+func (user *User) ConfirmEMail(code string) (success bool, err error) {
+
+	err = app.Db.Get(user, stmtConfirmEMail, code, user.ID)
+	if err == sql.ErrNoRows {
+		log.Debug("invalid e-mail confirmation code", "userID", user.ID)
+		return false, nil
+	} else if err != nil {
+		log.Error("failed to confirm e-mail address", "userID", user.ID,
+			"error", err.Error())
+		return
+	}
+
+	return true, nil
+}
+
 /*IsEditorInstructor returns whether a user is an editor or instructor or not. */
 func (user *User) IsEditorInstructor(tx *sqlx.Tx) (bool, bool, error) {
 
@@ -609,24 +875,118 @@ const (
 	stmtLoginLdap = `
 		INSERT INTO users (
 			first_name, last_name, email, salutation, role, last_login,
-			first_login, matr_nr, academic_title, title, name_affix, affiliations
+			first_login, matr_nr, academic_title, title, name_affix, affiliations, ldap_username
 		)
-		VALUES ($1, $2, $3, $4, 0, $5, $6, $7, $8, $9, $10, $11)
+		VALUES ($1, $2, $3, $4, 0, $5, $6, $7, $8, $9, $10, $11, $13)
 		ON CONFLICT (email)
 		DO UPDATE
 			SET
 				first_name = $1, last_name = $2, salutation = $4, last_login = $5,
-				matr_nr = $7, academic_title = $8, title = $9, name_affix = $10, affiliations = $11
+				matr_nr = $7, academic_title = $8, title = $9, name_affix = $10, affiliations = $11,
+				ldap_username = COALESCE($13, users.ldap_username)
 		RETURNING id, last_name, first_name, email, role, matr_nr, language,
+			email_undeliverable, totp_enabled,
 			TO_CHAR (first_login AT TIME ZONE $12, 'YYYY-MM-DD HH24:MI:SS') as first_login
 	`
 
 	stmtLoginExtern = `
 		UPDATE users
 		SET last_login = $1
-		WHERE email = $2
-			AND password = CRYPT($3, password)
-		RETURNING id, last_name, first_name, email, role, activation_code, language
+		WHERE id = $2
+			AND password IS NOT NULL
+		RETURNING id, last_name, first_name, email, role, activation_code, language,
+			email_undeliverable, totp_enabled
+	`
+
+	stmtLoginOIDC = `
+		UPDATE users
+		SET first_name = $3, last_name = $4, last_login = now(),
+			matr_nr = COALESCE($5, matr_nr),
+			academic_title = COALESCE($6, academic_title),
+			title = COALESCE($7, title),
+			affiliations = COALESCE($8, affiliations)
+		WHERE oidc_issuer = $1
+			AND oidc_subject = $2
+		RETURNING id, last_name, first_name, email, role, activation_code, language,
+			email_undeliverable, (password IS NULL) AS is_ldap, totp_enabled
+	`
+
+	/* new users get a random password, so that they are external users */
+	stmtLinkOIDC = `
+		INSERT INTO users (
+			first_name, last_name, email, salutation, role, last_login, first_login,
+			matr_nr, academic_title, title, affiliations, password, oidc_issuer, oidc_subject
+		)
+		VALUES ($3, $4, $5, 0, 0, now(), now(), $6, $7, $8, $9,
+			CRYPT(encode(gen_random_bytes(32), 'hex'), gen_salt('bf')), $1, $2)
+		ON CONFLICT (email)
+		DO UPDATE
+			SET
+				first_name = $3, last_name = $4, last_login = now(),
+				matr_nr = COALESCE($6, users.matr_nr),
+				academic_title = COALESCE($7, users.academic_title),
+				title = COALESCE($8, users.title),
+				affiliations = COALESCE($9, users.affiliations),
+				oidc_issuer = $1, oidc_subject = $2,
+				activation_code = NULL
+			WHERE users.oidc_subject IS NULL
+		RETURNING id, last_name, first_name, email, role, activation_code, language,
+			email_undeliverable, (password IS NULL) AS is_ldap, totp_enabled
+	`
+
+	stmtLoginSAML = `
+		UPDATE users
+		SET first_name = $3, last_name = $4, last_login = now(),
+			matr_nr = COALESCE($5, matr_nr),
+			academic_title = COALESCE($6, academic_title),
+			title = COALESCE($7, title),
+			affiliations = COALESCE($8, affiliations)
+		WHERE saml_issuer = $1
+			AND saml_subject = $2
+		RETURNING id, last_name, first_name, email, role, activation_code, language,
+			email_undeliverable, (password IS NULL) AS is_ldap, totp_enabled
+	`
+
+	/* users of unverified e-mail addresses are only registered, if their e-mail
+	address is not yet in use */
+	stmtRegisterSAML = `
+		INSERT INTO users (
+			first_name, last_name, email, salutation, role, last_login, first_login,
+			matr_nr, academic_title, title, affiliations, saml_issuer, saml_subject
+		)
+		VALUES ($3, $4, $5, 0, 0, now(), now(), $6, $7, $8, $9, $1, $2)
+		ON CONFLICT (email)
+		DO NOTHING
+		RETURNING id, last_name, first_name, email, role, activation_code, language,
+			email_undeliverable, (password IS NULL) AS is_ldap, totp_enabled
+	`
+
+	stmtLinkSAML = `
+		INSERT INTO users (
+			first_name, last_name, email, salutation, role, last_login, first_login,
+			matr_nr, academic_title, title, affiliations, saml_issuer, saml_subject
+		)
+		VALUES ($3, $4, $5, 0, 0, now(), now(), $6, $7, $8, $9, $1, $2)
+		ON CONFLICT (email)
+		DO UPDATE
+			SET
+				first_name = $3, last_name = $4, last_login = now(),
+				matr_nr = COALESCE($6, users.matr_nr),
+				academic_title = COALESCE($7, users.academic_title),
+				title = COALESCE($8, users.title),
+				affiliations = COALESCE($9, users.affiliations),
+				saml_issuer = $1, saml_subject = $2,
+				activation_code = NULL
+			WHERE users.saml_subject IS NULL
+		RETURNING id, last_name, first_name, email, role, activation_code, language,
+			email_undeliverable, (password IS NULL) AS is_ldap, totp_enabled
+	`
+
+	stmtGetSessionData = `
+		SELECT id, last_name, first_name, email, role, activation_code, language,
+			email_undeliverable, (password IS NULL) AS is_ldap, totp_enabled
+		FROM users
+		WHERE id = $1
 	`
 
 	stmtRegisterExtern = `
@@ -634,7 +994,7 @@ const (
 			first_name, last_name, email, salutation, role, last_login,
 			first_login, password, activation_code, language
 		)
-		VALUES ($1, $2, $3, $4, 0, $5, $6, CRYPT($7, gen_salt('bf')), CRYPT($8, gen_salt('bf')), $9)
+		VALUES ($1, $2, $3, $4, 0, $5, $6, $7, CRYPT($8, gen_salt('bf')), $9)
 		RETURNING
 			/* data to send notification e-mail containing the activation */
 			id, last_name, first_name, email, role, language, salutation
@@ -644,9 +1004,10 @@ const (
 		SELECT
 			id, last_name, first_name, email, salutation, role, activation_code,
 			language, matr_nr, academic_title, title, name_affix, affiliations,
+			email_undeliverable, unconfirmed_email,
 			TO_CHAR (last_login AT TIME ZONE $1, 'YYYY-MM-DD HH24:MI') as last_login,
 			TO_CHAR (first_login AT TIME ZONE $1, 'YYYY-MM-DD HH24:MI') as first_login,
-			(password IS NULL) AS is_ldap
+			(password IS NULL) AS is_ldap, totp_enabled
 		FROM users
 		WHERE id = $2
 	`
@@ -656,22 +1017,33 @@ const (
 		FROM users WHERE id = $1
 	`
 
-	stmtUpdatePasswordByEMail = `
+	stmtUpdatePassword = `
 		UPDATE users
-		SET password = CRYPT($1, gen_salt('bf'))
-		WHERE email = $2
+		SET password = $1
+		WHERE id = $2
 		RETURNING
-			/* data to send notification e-mail containing the new password */
+			/* data to send notification e-mail containing */
 			id, last_name, first_name, email, language, salutation
 	`
 
-	stmtUpdatePassword = `
+	stmtSelectPasswordByEMail = `
+		SELECT id, password
+		FROM users
+		WHERE email = $1
+	`
+
+	stmtSelectPasswordByID = `
+		SELECT password
+		FROM users
+		WHERE id = $1
+	`
+
+	/* only rehash the password if it was not changed in the meantime */
+	stmtRehashPassword = `
 		UPDATE users
-		SET password = CRYPT($1, gen_salt('bf'))
+		SET password = $1
 		WHERE id = $2
-		RETURNING
-			/* data to send notification e-mail containing */
-			id, last_name, first_name, email, language, salutation
+			AND password = $3
 	`
 
 	stmtSelectCode = `
@@ -726,6 +1098,25 @@ const (
 		RETURNING id, salutation, first_name, last_name, email
 	`
 
+	stmtUpdateEMailConfirmation = `
+		UPDATE users
+		SET unconfirmed_email = $1, email_confirmation_code = CRYPT($2, gen_salt('bf'))
+		WHERE id = $3
+		RETURNING
+			/* data to send the e-mail containing the confirmation code to the new address */
+			id, last_name, first_name, unconfirmed_email AS email, language, salutation
+	`
+
+	stmtConfirmEMail = `
+		UPDATE users
+		SET email = unconfirmed_email, email_undeliverable = false,
+			unconfirmed_email = NULL, email_confirmation_code = NULL
+		WHERE id = $2
+			AND unconfirmed_email IS NOT NULL
+			AND email_confirmation_code = CRYPT($1, email_confirmation_code)
+		RETURNING id, email, email_undeliverable
+	`
+
 	stmtAuthorizedToEditCourse = `
 		SELECT EXISTS (
 			SELECT true
@@ -797,13 +1188,4 @@ const (
 
 		) AS authorized
 	`
-
-	stmtPasswordsMatch = `
-		SELECT EXISTS (
-			SELECT id
-			FROM users
-			WHERE id = $1
-				AND password = CRYPT($2, password)
-		) AS match
-	`
 )
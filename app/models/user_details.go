@@ -26,6 +26,12 @@ type UserDetails struct {
 	Categories []Category
 	FAQs       []HelpPageEntries
 	News       []HelpPageEntries
+
+	//failed login attempts and lockouts of the user
+	Throttles LoginThrottles
+
+	//impersonations by or of the user
+	Impersonations Impersonations
 }
 
 /*Get all user details. */
@@ -45,6 +51,14 @@ func (user *UserDetails) Get() (err error) {
 	if err = user.Groups.SelectByUser(&user.User.ID, tx); err != nil {
 		return
 	}
+	//get failed login attempts
+	if err = user.Throttles.SelectByUser(tx, &user.User); err != nil {
+		return
+	}
+	//get impersonations
+	if err = user.Impersonations.SelectByUser(tx, &user.User.ID); err != nil {
+		return
+	}
 
 	//TODO: get Enrollments, FormerEnrollments, Courses, Categories, FAQs, News
 
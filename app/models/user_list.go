@@ -43,6 +43,21 @@ func (user *UserListEntry) Insert(table string) (active bool, data EMailData, er
 		return
 	}
 
+	//new editors and instructors are allowed to view matriculation numbers
+	user.ViewMatrNr = true
+	if active, data, err = user.insert(tx, table); err != nil {
+		return
+	}
+
+	tx.Commit()
+	return
+}
+
+//insert a user list entry of a course, if the course is active, then it returns the
+//e-mail data of new editors and instructors
+func (user *UserListEntry) insert(tx *sqlx.Tx, table string) (active bool,
+	data EMailData, err error) {
+
 	//construct SQL
 	colViewMatrNr := ""
 	colViewMatrNrValue := ""
@@ -87,11 +102,11 @@ func (user *UserListEntry) Insert(table string) (active bool, data EMailData, er
 			}
 
 			data.CourseRole = table
-			data.ViewMatrNr = true
+			data.ViewMatrNr = user.ViewMatrNr
 		}
 
 		//insert user
-		err = tx.Get(user, insertUser, user.UserID, user.CourseID, true)
+		err = tx.Get(user, insertUser, user.UserID, user.CourseID, user.ViewMatrNr)
 
 	} else {
 
@@ -103,10 +118,7 @@ func (user *UserListEntry) Insert(table string) (active bool, data EMailData, er
 		log.Error("failed to insert user into user list", "user", user,
 			"table", table, "error", err.Error())
 		tx.Rollback()
-		return
 	}
-
-	tx.Commit()
 	return
 }
 
@@ -119,6 +131,19 @@ func (user *UserListEntry) Delete(table string) (active bool, data EMailData, er
 		return
 	}
 
+	if active, data, err = user.delete(tx, table); err != nil {
+		return
+	}
+
+	tx.Commit()
+	return
+}
+
+//delete a user list entry of a course, if the course is active, then it returns the
+//e-mail data of removed editors and instructors
+func (user *UserListEntry) delete(tx *sqlx.Tx, table string) (active bool,
+	data EMailData, err error) {
+
 	deleteUser := `
 		DELETE FROM ` + table + `
 		WHERE user_id = $1
@@ -160,8 +185,6 @@ func (user *UserListEntry) Delete(table string) (active bool, data EMailData, er
 			data.CourseRole = table
 		}
 	}
-
-	tx.Commit()
 	return
 }
 
@@ -174,6 +197,19 @@ func (user *UserListEntry) Update(table string) (active bool, data EMailData, er
 		return
 	}
 
+	if active, data, err = user.update(tx, table); err != nil {
+		return
+	}
+
+	tx.Commit()
+	return
+}
+
+//update the ViewMatrNr field of a list entry of a course, if the course is active,
+//then it returns the e-mail data of the editor or instructor
+func (user *UserListEntry) update(tx *sqlx.Tx, table string) (active bool,
+	data EMailData, err error) {
+
 	updateUser := `
 		UPDATE ` + table + `
 		SET view_matr_nr = $3
@@ -190,6 +226,8 @@ func (user *UserListEntry) Update(table string) (active bool, data EMailData, er
 	if err != nil {
 		log.Error("failed to update user from user list", "user", user,
 			"table", table, "error", err.Error())
+		tx.Rollback()
+		return
 	}
 
 	if table == "editors" || table == "instructors" {
@@ -219,8 +257,6 @@ func (user *UserListEntry) Update(table string) (active bool, data EMailData, er
 			data.ViewMatrNr = user.ViewMatrNr
 		}
 	}
-
-	tx.Commit()
 	return
 }
 
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"database/sql"
+	"turm/app"
+)
+
+/*UserSessions contains all active sessions of an user. */
+type UserSessions []UserSession
+
+/*UserSession is a server-side session of a logged in user. The session cookie only
+contains a random token, whose hash identifies the session. */
+type UserSession struct {
+	ID           int    `db:"id, primarykey, autoincrement"`
+	UserID       int    `db:"user_id"`
+	TokenHash    string `db:"token_hash, unique"`
+	StayLoggedIn bool   `db:"stay_logged_in"`
+	IP           string `db:"ip"`
+	UserAgent    string `db:"user_agent"`
+
+	//not fields in the respective table
+	Created    string `db:"created_str"`
+	LastActive string `db:"last_active_str"`
+	Current    bool   `db:"current"`
+}
+
+/*Insert a new session. It returns the token of the session. */
+func (session *UserSession) Insert() (token string, err error) {
+
+	token, session.TokenHash, err = generateToken()
+	if err != nil {
+		return
+	}
+
+	if len(session.UserAgent) > 255 {
+		session.UserAgent = session.UserAgent[:255]
+	}
+
+	err = app.Db.Get(&session.ID, stmtInsertUserSession, session.UserID, session.TokenHash,
+		session.StayLoggedIn, session.IP, session.UserAgent, session.expires())
+	if err != nil {
+		log.Error("failed to insert session", "userID", session.UserID, "error", err.Error())
+	}
+	return
+}
+
+/*Validate returns whether the session of the token exists, belongs to the user and
+is not expired. Valid sessions are extended. */
+func (session *UserSession) Validate(token string) (valid bool, err error) {
+
+	session.TokenHash = hashToken(token)
+	err = app.Db.Get(session, stmtValidateUserSession, session.TokenHash, session.UserID,
+		app.Sessions.Expires.Seconds(), app.Sessions.StayLoggedInExpires.Seconds())
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		log.Error("failed to validate session", "userID", session.UserID, "error", err.Error())
+		return
+	}
+	return true, nil
+}
+
+/*Delete a session of an user. */
+func (session *UserSession) Delete() (err error) {
+
+	_, err = app.Db.Exec(stmtDeleteUserSession, session.ID, session.UserID)
+	if err != nil {
+		log.Error("failed to delete session", "session", session, "error", err.Error())
+	}
+	return
+}
+
+/*DeleteByToken deletes the session of a token, e.g., when logging out. */
+func (session *UserSession) DeleteByToken(token string) (err error) {
+
+	_, err = app.Db.Exec(stmtDeleteUserSessionByToken, hashToken(token))
+	if err != nil {
+		log.Error("failed to delete session", "userID", session.UserID, "error", err.Error())
+	}
+	return
+}
+
+/*DeleteUserSessions deletes all sessions of an user, except for the session of the
+token, e.g., the current session. If the token is empty, then all sessions are deleted. */
+func DeleteUserSessions(userID int, exceptToken string) (err error) {
+
+	_, err = app.Db.Exec(stmtDeleteUserSessions, userID, hashToken(exceptToken))
+	if err != nil {
+		log.Error("failed to delete sessions", "userID", userID, "error", err.Error())
+	}
+	return
+}
+
+/*Select all active sessions of an user. The session of the token is marked as current. */
+func (sessions *UserSessions) Select(userID int, token string) (err error) {
+
+	err = app.Db.Select(sessions, stmtSelectUserSessions, userID, hashToken(token),
+		app.TimeZone)
+	if err != nil {
+		log.Error("failed to select sessions", "userID", userID, "error", err.Error())
+	}
+	return
+}
+
+//expires returns the time of inactivity after which the session expires in seconds
+func (session *UserSession) expires() float64 {
+
+	if session.StayLoggedIn {
+		return app.Sessions.StayLoggedInExpires.Seconds()
+	}
+	return app.Sessions.Expires.Seconds()
+}
+
+//deleteUserSessions deletes all expired sessions
+type deleteUserSessions struct{}
+
+/*Run the job to delete all expired sessions. Impersonations end with the session of
+the admin, also if the admin never returns to end them. */
+func (job deleteUserSessions) Run() {
+
+	_, err := app.Db.Exec(stmtDeleteExpiredUserSessions)
+	if err != nil {
+		log.Error("failed to delete expired sessions", "error", err.Error())
+		app.SendErrorNote()
+		return
+	}
+
+	//sessions can also be deleted by the user, e.g., when logging out everywhere
+	if _, err = app.Db.Exec(stmtEndImpersonationsWithoutSession); err != nil {
+		log.Error("failed to end impersonations without session", "error", err.Error())
+		app.SendErrorNote()
+	}
+}
+
+const (
+	stmtInsertUserSession = `
+		INSERT INTO user_sessions (user_id, token_hash, stay_logged_in, ip, user_agent,
+			created, last_active, expires)
+		VALUES ($1, $2, $3, $4, $5, now(), now(), now() + $6 * interval '1 second')
+		RETURNING id
+	`
+
+	stmtValidateUserSession = `
+		UPDATE user_sessions
+		SET last_active = now(),
+			expires = now() + (
+				CASE WHEN stay_logged_in THEN $4 ELSE $3 END
+			) * interval '1 second'
+		WHERE token_hash = $1
+			AND user_id = $2
+			AND expires > now()
+		RETURNING id, user_id, token_hash, stay_logged_in, ip, user_agent
+	`
+
+	stmtDeleteUserSession = `
+		DELETE FROM user_sessions
+		WHERE id = $1
+			AND user_id = $2
+	`
+
+	stmtDeleteUserSessionByToken = `
+		DELETE FROM user_sessions
+		WHERE token_hash = $1
+	`
+
+	stmtDeleteUserSessions = `
+		DELETE FROM user_sessions
+		WHERE user_id = $1
+			AND token_hash != $2
+	`
+
+	stmtSelectUserSessions = `
+		SELECT id, user_id, token_hash, stay_logged_in, ip, user_agent,
+			(token_hash = $2) AS current,
+			TO_CHAR (created AT TIME ZONE $3, 'YYYY-MM-DD HH24:MI') AS created_str,
+			TO_CHAR (last_active AT TIME ZONE $3, 'YYYY-MM-DD HH24:MI') AS last_active_str
+		FROM user_sessions
+		WHERE user_id = $1
+			AND expires > now()
+		ORDER BY (token_hash = $2) DESC, last_active DESC
+	`
+
+	stmtDeleteExpiredUserSessions = `
+		WITH expired AS (
+			DELETE FROM user_sessions
+			WHERE expires < now()
+			RETURNING token_hash, expires
+		)
+		UPDATE impersonations i
+		SET ended = e.expires
+		FROM expired e
+		WHERE i.session_token_hash = e.token_hash
+			AND i.ended IS NULL
+	`
+)
@@ -86,6 +86,7 @@ func (users *Users) AutoEnrollFromWaitList(tx *sqlx.Tx, eventID *int,
 const (
 	stmtSearchUsers = `
     SELECT id, last_name, first_name, email, salutation, role, title, academic_title, name_affix,
+      email_undeliverable,
       TO_CHAR (last_login AT TIME ZONE $3, 'YYYY-MM-DD HH24:MI') as last_login
     FROM users
     WHERE (
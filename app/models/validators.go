@@ -21,6 +21,14 @@ func ValidateLength(str *string, msgKey string, min, max int, v *revel.Validatio
 	v.MaxSize(*str, max).MessageKey(msgKey)
 }
 
+/*ValidatePassword validates a new password against the password policy. */
+func ValidatePassword(password string, v *revel.Validation) {
+
+	v.Required(app.PasswordSatisfiesPolicy(password)).
+		MessageKey("validation.invalid.password.policy", app.Passwords.MinLength,
+			app.Passwords.MaxLength, app.Passwords.MinClasses)
+}
+
 /*ValidateLengthAndValid sets valid to true if the string is not empty and
 also validates its length (if non-empty). */
 func ValidateLengthAndValid(str *sql.NullString, msgKey string, min, max int, v *revel.Validation) {
@@ -0,0 +1,465 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+	"turm/app"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/revel/revel"
+)
+
+/*Events of webhooks. */
+const (
+	WebhookEnroll            = "enroll"
+	WebhookUnsubscribe       = "unsubscribe"
+	WebhookWaitlistPromotion = "waitlist.promotion"
+	WebhookStatusChange      = "status.change"
+	WebhookSlotBooking       = "slot.booking"
+	WebhookCourseActivation  = "course.activation"
+	WebhookCourseExpiry      = "course.expiry"
+	WebhookTest              = "test"
+)
+
+/*WebhookEvents contains all events that webhooks can subscribe to. */
+var WebhookEvents = []string{WebhookEnroll, WebhookUnsubscribe, WebhookWaitlistPromotion,
+	WebhookStatusChange, WebhookSlotBooking, WebhookCourseActivation, WebhookCourseExpiry}
+
+/*Webhooks contains all webhooks of a course or all global webhooks. */
+type Webhooks []Webhook
+
+/*Webhook subscribes an URL to events of a course. Global webhooks (without course)
+subscribe to the events of all courses. */
+type Webhook struct {
+	ID       int           `db:"id, primarykey, autoincrement"`
+	CourseID sql.NullInt32 `db:"course_id"`
+	URL      string        `db:"url"`
+	Secret   string        `db:"secret"`
+	Events   string        `db:"events"`
+	Creator  sql.NullInt32 `db:"creator"`
+
+	//not fields in the respective table
+	EventList  []string          ``
+	Created    string            `db:"created_str"`
+	Deliveries WebhookDeliveries ``
+}
+
+/*WebhookDeliveries contains the delivery log of a webhook. */
+type WebhookDeliveries []WebhookDelivery
+
+/*WebhookDelivery is a (queued) delivery of an event to a webhook. */
+type WebhookDelivery struct {
+	ID         int            `db:"id, primarykey, autoincrement"`
+	WebhookID  int            `db:"webhook_id"`
+	CourseID   sql.NullInt32  `db:"course_id"`
+	Event      string         `db:"event"`
+	Payload    string         `db:"payload"`
+	Attempts   int            `db:"attempts"`
+	State      DeliveryState  `db:"state"`
+	StatusCode sql.NullInt32  `db:"status_code"`
+	Response   sql.NullString `db:"response"`
+
+	//not fields in the respective table
+	URL         string         `db:"url"`
+	Secret      string         `db:"secret"`
+	Created     string         `db:"created_str"`
+	LastAttempt sql.NullString `db:"last_attempt_str"`
+}
+
+/*WebhookPayload is the JSON body of a delivery. */
+type WebhookPayload struct {
+	Event       string       `json:"event"`
+	Timestamp   time.Time    `json:"timestamp"`
+	CourseID    int          `json:"course_id"`
+	CourseTitle string       `json:"course_title,omitempty"`
+	EventID     int          `json:"event_id,omitempty"`
+	EventTitle  string       `json:"event_title,omitempty"`
+	User        *WebhookUser `json:"user,omitempty"`
+	Status      string       `json:"status,omitempty"`
+	Start       string       `json:"start,omitempty"`
+	End         string       `json:"end,omitempty"`
+}
+
+/*WebhookUser is the user of an event in a payload. The matriculation number is only
+delivered to global webhooks, as the creators of course webhooks are not necessarily
+allowed to view matriculation numbers. */
+type WebhookUser struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	EMail     string `json:"email"`
+	MatrNr    *int32 `json:"matr_nr,omitempty"`
+}
+
+/*NewWebhookUser returns the payload data of an user. */
+func NewWebhookUser(user *User) *WebhookUser {
+
+	webhookUser := WebhookUser{
+		ID:        user.ID,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		EMail:     user.EMail,
+	}
+	if user.MatrNr.Valid {
+		matrNr := user.MatrNr.Int32
+		webhookUser.MatrNr = &matrNr
+	}
+	return &webhookUser
+}
+
+/*Validate the URL and the events of a webhook. */
+func (webhook *Webhook) Validate(v *revel.Validation) {
+
+	webhook.URL = strings.TrimSpace(webhook.URL)
+	parsed, err := url.Parse(webhook.URL)
+	if err != nil || parsed.Host == "" || len(webhook.URL) > 1023 ||
+		(parsed.Scheme != "https" && !(parsed.Scheme == "http" && app.Webhooks.AllowHTTP)) {
+		v.ErrorKey("validation.invalid.webhook.url")
+		return
+	}
+
+	//NOTE: host names are checked again after resolving them at delivery time
+	host := strings.ToLower(parsed.Hostname())
+	ip := net.ParseIP(host)
+	if !app.Webhooks.AllowPrivate && (host == "localhost" || strings.HasSuffix(host, ".localhost") ||
+		(ip != nil && !app.WebhookAddressAllowed(ip))) {
+		v.ErrorKey("validation.invalid.webhook.address")
+		return
+	}
+
+	if len(webhook.EventList) == 0 {
+		v.ErrorKey("validation.invalid.webhook.events")
+	}
+	for _, event := range webhook.EventList {
+		valid := false
+		for _, webhookEvent := range WebhookEvents {
+			if event == webhookEvent {
+				valid = true
+			}
+		}
+		if !valid {
+			v.ErrorKey("validation.invalid.webhook.events")
+			return
+		}
+	}
+
+	webhook.Events = strings.Join(webhook.EventList, ",")
+}
+
+/*Insert a new webhook with a random secret. */
+func (webhook *Webhook) Insert() (err error) {
+
+	webhook.Secret, _, err = generateToken()
+	if err != nil {
+		return
+	}
+
+	err = app.Db.Get(&webhook.ID, stmtInsertWebhook, webhook.CourseID, webhook.URL,
+		webhook.Secret, webhook.Events, webhook.Creator)
+	if err != nil {
+		log.Error("failed to insert webhook", "webhook", webhook, "error", err.Error())
+	}
+	return
+}
+
+/*Delete a webhook of a course (or a global webhook) and its delivery log. */
+func (webhook *Webhook) Delete() (err error) {
+
+	_, err = app.Db.Exec(stmtDeleteWebhook, webhook.ID, webhook.CourseID)
+	if err != nil {
+		log.Error("failed to delete webhook", "ID", webhook.ID, "courseID",
+			webhook.CourseID, "error", err.Error())
+	}
+	return
+}
+
+/*Test queues a test delivery to a webhook of a course (or to a global webhook). */
+func (webhook *Webhook) Test() (found bool, err error) {
+
+	payload, err := json.Marshal(WebhookPayload{
+		Event:     WebhookTest,
+		Timestamp: time.Now(),
+		CourseID:  int(webhook.CourseID.Int32),
+	})
+	if err != nil {
+		log.Error("failed to marshal test payload", "ID", webhook.ID, "error", err.Error())
+		return
+	}
+
+	var ID int
+	err = app.Db.Get(&ID, stmtInsertTestDelivery, webhook.ID, webhook.CourseID,
+		WebhookTest, string(payload))
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		log.Error("failed to insert test delivery", "ID", webhook.ID, "error", err.Error())
+		return
+	}
+	return true, nil
+}
+
+/*Select all webhooks of a course (or all global webhooks) and their latest deliveries. */
+func (webhooks *Webhooks) Select(courseID sql.NullInt32) (err error) {
+
+	tx, err := app.Db.Beginx()
+	if err != nil {
+		log.Error("failed to begin tx", "error", err.Error())
+		return
+	}
+
+	err = tx.Select(webhooks, stmtSelectWebhooks, courseID, app.TimeZone)
+	if err != nil {
+		log.Error("failed to select webhooks", "courseID", courseID, "error", err.Error())
+		tx.Rollback()
+		return
+	}
+
+	for key := range *webhooks {
+		(*webhooks)[key].EventList = strings.Split((*webhooks)[key].Events, ",")
+		err = (*webhooks)[key].Deliveries.Select(tx, (*webhooks)[key].ID)
+		if err != nil {
+			return
+		}
+	}
+
+	tx.Commit()
+	return
+}
+
+/*Select the latest deliveries of a webhook. */
+func (deliveries *WebhookDeliveries) Select(tx *sqlx.Tx, webhookID int) (err error) {
+
+	err = tx.Select(deliveries, stmtSelectWebhookDeliveries, webhookID, app.TimeZone)
+	if err != nil {
+		log.Error("failed to select webhook deliveries", "webhookID", webhookID,
+			"error", err.Error())
+		tx.Rollback()
+	}
+	return
+}
+
+/*Trigger queues a delivery of the payload to each webhook subscribed to its event,
+i.e., to all global webhooks and to all webhooks of its course. */
+func (payload *WebhookPayload) Trigger() (err error) {
+
+	payload.Timestamp = time.Now()
+	globalData, err := json.Marshal(payload)
+	if err != nil {
+		log.Error("failed to marshal webhook payload", "event", payload.Event,
+			"error", err.Error())
+		return
+	}
+
+	//course webhooks never receive the matriculation number
+	courseData := globalData
+	if payload.User != nil && payload.User.MatrNr != nil {
+		coursePayload, courseUser := *payload, *payload.User
+		courseUser.MatrNr = nil
+		coursePayload.User = &courseUser
+		if courseData, err = json.Marshal(coursePayload); err != nil {
+			log.Error("failed to marshal webhook payload", "event", payload.Event,
+				"error", err.Error())
+			return
+		}
+	}
+
+	_, err = app.Db.Exec(stmtInsertWebhookDeliveries, payload.Event, payload.CourseID,
+		string(globalData), string(courseData))
+	if err != nil {
+		log.Error("failed to queue webhook deliveries", "event", payload.Event,
+			"courseID", payload.CourseID, "error", err.Error())
+	}
+	return
+}
+
+//deliverWebhooks delivers all due webhook deliveries
+type deliverWebhooks struct{}
+
+/*Run the job to queue the deliveries of expired courses and to deliver all due
+deliveries. Each run delivers at most 100 deliveries. */
+func (job deliverWebhooks) Run() {
+
+	_, err := app.Db.Exec(stmtInsertExpiryDeliveries, WebhookCourseExpiry)
+	if err != nil {
+		log.Error("failed to queue course expiry deliveries", "error", err.Error())
+		app.SendErrorNote()
+	}
+
+	for i := 0; i < 100; i++ {
+		delivered, err := deliverNextWebhook()
+		if err != nil {
+			app.SendErrorNote()
+			return
+		} else if !delivered {
+			return
+		}
+	}
+}
+
+//deliverNextWebhook claims the next due delivery, posts it and logs the result
+func deliverNextWebhook() (delivered bool, err error) {
+
+	//NOTE: claiming a delivery postpones its next attempt, so that a crashed
+	//delivery is retried later
+	delivery := WebhookDelivery{}
+	err = app.Db.Get(&delivery, stmtClaimWebhookDelivery,
+		(app.Webhooks.Timeout + time.Minute).Seconds())
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		log.Error("failed to claim webhook delivery", "error", err.Error())
+		return
+	}
+
+	status, response, postErr := app.PostWebhook(delivery.URL, delivery.Secret,
+		delivery.Event, delivery.ID, []byte(delivery.Payload))
+
+	delivery.Attempts++
+	delivery.StatusCode = sql.NullInt32{Int32: int32(status), Valid: status != 0}
+	delivery.Response = sql.NullString{String: response, Valid: response != ""}
+	if postErr != nil {
+		delivery.Response = sql.NullString{String: postErr.Error(), Valid: true}
+	}
+
+	delivery.State = QUEUED
+	if postErr == nil && status >= 200 && status < 300 {
+		delivery.State = SENT
+	} else if delivery.Attempts >= app.Webhooks.MaxAttempts {
+		delivery.State = FAILED
+		log.Warn("webhook delivery failed", "ID", delivery.ID, "webhookID",
+			delivery.WebhookID, "status", status, "attempts", delivery.Attempts)
+	}
+
+	_, err = app.Db.Exec(stmtUpdateWebhookDelivery, delivery.ID, delivery.Attempts,
+		delivery.State, delivery.StatusCode, delivery.Response,
+		app.Webhooks.Backoff(delivery.Attempts).Seconds())
+	if err != nil {
+		log.Error("failed to update webhook delivery", "ID", delivery.ID,
+			"error", err.Error())
+		return
+	}
+	return true, nil
+}
+
+//deleteWebhookDeliveries deletes all outdated delivery logs
+type deleteWebhookDeliveries struct{}
+
+/*Run the job to delete all outdated delivery logs. */
+func (job deleteWebhookDeliveries) Run() {
+
+	_, err := app.Db.Exec(stmtDeleteWebhookDeliveries, app.Webhooks.LogDays, QUEUED)
+	if err != nil {
+		log.Error("failed to delete webhook deliveries", "error", err.Error())
+		app.SendErrorNote()
+	}
+}
+
+const (
+	stmtInsertWebhook = `
+		INSERT INTO webhooks (course_id, url, secret, events, creator, created)
+		VALUES ($1, $2, $3, $4, $5, now())
+		RETURNING id
+	`
+
+	stmtDeleteWebhook = `
+		DELETE FROM webhooks
+		WHERE id = $1
+			AND course_id IS NOT DISTINCT FROM $2
+	`
+
+	stmtSelectWebhooks = `
+		SELECT id, course_id, url, secret, events, creator,
+			TO_CHAR (created AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI') AS created_str
+		FROM webhooks
+		WHERE course_id IS NOT DISTINCT FROM $1
+		ORDER BY created ASC
+	`
+
+	stmtSelectWebhookDeliveries = `
+		SELECT id, webhook_id, course_id, event, payload, attempts, state, status_code,
+			response,
+			TO_CHAR (created AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI:SS') AS created_str,
+			TO_CHAR (last_attempt AT TIME ZONE $2, 'YYYY-MM-DD HH24:MI:SS') AS last_attempt_str
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY created DESC
+		LIMIT 20
+	`
+
+	stmtInsertTestDelivery = `
+		INSERT INTO webhook_deliveries (webhook_id, course_id, event, payload, attempts,
+			state, next_attempt, created)
+		SELECT id, course_id, $3, $4, 0, 0, now(), now()
+		FROM webhooks
+		WHERE id = $1
+			AND course_id IS NOT DISTINCT FROM $2
+		RETURNING id
+	`
+
+	stmtInsertWebhookDeliveries = `
+		INSERT INTO webhook_deliveries (webhook_id, course_id, event, payload, attempts,
+			state, next_attempt, created)
+		SELECT id, $2, $1,
+			CASE WHEN course_id IS NULL THEN $3 ELSE $4 END,
+			0, 0, now(), now()
+		FROM webhooks
+		WHERE (course_id IS NULL OR course_id = $2)
+			AND $1 = ANY (string_to_array(events, ','))
+	`
+
+	stmtInsertExpiryDeliveries = `
+		INSERT INTO webhook_deliveries (webhook_id, course_id, event, payload, attempts,
+			state, next_attempt, created)
+		SELECT w.id, c.id, $1,
+			json_build_object('event', $1::text, 'timestamp', now(), 'course_id', c.id,
+				'course_title', c.title)::text,
+			0, 0, now(), now()
+		FROM courses c JOIN webhooks w ON (w.course_id IS NULL OR w.course_id = c.id)
+		WHERE c.active
+			AND c.expiration_date <= now()
+			AND c.expiration_date > now() - interval '7 days'
+			AND w.created < c.expiration_date
+			AND $1 = ANY (string_to_array(w.events, ','))
+			AND NOT EXISTS (
+				SELECT d.id
+				FROM webhook_deliveries d
+				WHERE d.webhook_id = w.id
+					AND d.course_id = c.id
+					AND d.event = $1
+			)
+	`
+
+	stmtClaimWebhookDelivery = `
+		UPDATE webhook_deliveries d
+		SET next_attempt = now() + $1 * interval '1 second'
+		FROM webhooks w
+		WHERE w.id = d.webhook_id
+			AND d.id = (
+				SELECT id FROM webhook_deliveries
+				WHERE state = 0
+					AND next_attempt <= now()
+				ORDER BY next_attempt ASC
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+		RETURNING d.id, d.webhook_id, d.course_id, d.event, d.payload, d.attempts, d.state,
+			w.url, w.secret
+	`
+
+	stmtUpdateWebhookDelivery = `
+		UPDATE webhook_deliveries
+		SET attempts = $2, state = $3, status_code = $4, response = $5,
+			last_attempt = now(), next_attempt = now() + $6 * interval '1 second'
+		WHERE id = $1
+	`
+
+	stmtDeleteWebhookDeliveries = `
+		DELETE FROM webhook_deliveries
+		WHERE created < now() - $1 * interval '1 day'
+			AND state != $2
+	`
+)
@@ -0,0 +1,187 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/revel/revel"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*PasswordConf contains the parameters of the password hashing and the password policy. */
+type PasswordConf struct {
+	//Algorithm is either argon2id or bcrypt
+	Algorithm string
+
+	BcryptCost int
+
+	Argon2Time    uint32
+	Argon2Memory  uint32 //in KiB
+	Argon2Threads uint8
+	Argon2KeyLen  uint32
+
+	//MinLength and MaxLength of new passwords
+	MinLength int
+	MaxLength int
+	//MinClasses is the minimum number of different character classes (lowercase
+	//and uppercase letters, digits and special characters) of new passwords
+	MinClasses int
+
+	//ResetValidity is the validity of password reset links in minutes
+	ResetValidity int
+}
+
+var (
+	//Passwords holds the parameters of the password hashing and the password policy
+	Passwords PasswordConf
+
+	//errInvalidHash is returned for hashes of unknown formats
+	errInvalidHash = errors.New("invalid password hash")
+)
+
+/*HashPassword hashes a password with the configured algorithm and parameters. */
+func HashPassword(password string) (hash string, err error) {
+
+	if Passwords.Algorithm == "bcrypt" {
+		bytes, err := bcrypt.GenerateFromPassword([]byte(password), Passwords.BcryptCost)
+		if err != nil {
+			revel.AppLog.Error("failed to generate bcrypt hash", "error", err.Error())
+		}
+		return string(bytes), err
+	}
+
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		revel.AppLog.Error("failed to generate salt", "error", err.Error())
+		return
+	}
+
+	key := argon2.IDKey([]byte(password), salt, Passwords.Argon2Time, Passwords.Argon2Memory,
+		Passwords.Argon2Threads, Passwords.Argon2KeyLen)
+
+	//PHC string format
+	hash = fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		Passwords.Argon2Memory, Passwords.Argon2Time, Passwords.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	return
+}
+
+/*VerifyPassword compares a password with its hash. Legacy hashes, i.e., bcrypt hashes
+generated by pgcrypto, are supported. If the hash does not use the configured algorithm
+and parameters, then rehash is true. */
+func VerifyPassword(hash, password string) (match, rehash bool, err error) {
+
+	if strings.HasPrefix(hash, "$argon2id$") {
+
+		var version int
+		var memory, time uint32
+		var threads uint8
+		parts := strings.Split(hash, "$")
+		if len(parts) != 6 {
+			return false, false, errInvalidHash
+		}
+		if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+			return false, false, errInvalidHash
+		}
+		if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+			return false, false, errInvalidHash
+		}
+		salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+		if err != nil {
+			return false, false, errInvalidHash
+		}
+		key, err := base64.RawStdEncoding.DecodeString(parts[5])
+		if err != nil {
+			return false, false, errInvalidHash
+		}
+
+		otherKey := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+		match = subtle.ConstantTimeCompare(key, otherKey) == 1
+
+		rehash = Passwords.Algorithm != "argon2id" || version != argon2.Version ||
+			memory != Passwords.Argon2Memory || time != Passwords.Argon2Time ||
+			threads != Passwords.Argon2Threads || uint32(len(key)) != Passwords.Argon2KeyLen
+		return match, rehash, nil
+	}
+
+	//bcrypt hashes, including the legacy pgcrypto hashes ($2a$)
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, errInvalidHash
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true, true, nil
+	}
+	rehash = Passwords.Algorithm != "bcrypt" || cost != Passwords.BcryptCost ||
+		(!strings.HasPrefix(hash, "$2a$") && !strings.HasPrefix(hash, "$2b$"))
+	return true, rehash, nil
+}
+
+/*PasswordSatisfiesPolicy returns whether a new password satisfies the password policy. */
+func PasswordSatisfiesPolicy(password string) bool {
+
+	length := len([]rune(password))
+	if length < Passwords.MinLength || length > Passwords.MaxLength {
+		return false
+	}
+
+	//bcrypt only uses the first 72 bytes of a password
+	if Passwords.Algorithm == "bcrypt" && len(password) > 72 {
+		return false
+	}
+
+	var lower, upper, digit, special int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			special = 1
+		}
+	}
+	return lower+upper+digit+special >= Passwords.MinClasses
+}
+
+//initPasswordData initializes the password hashing and the password policy
+func initPasswordData() {
+
+	Passwords.Algorithm = revel.Config.StringDefault("password.hash", "argon2id")
+	if Passwords.Algorithm != "argon2id" && Passwords.Algorithm != "bcrypt" {
+		revel.AppLog.Fatal("invalid password.hash value set in config",
+			"value", Passwords.Algorithm)
+	}
+
+	Passwords.BcryptCost = revel.Config.IntDefault("password.bcrypt.cost", 12)
+	if Passwords.BcryptCost < bcrypt.MinCost || Passwords.BcryptCost > bcrypt.MaxCost {
+		revel.AppLog.Fatal("invalid password.bcrypt.cost value set in config",
+			"value", Passwords.BcryptCost)
+	}
+
+	Passwords.Argon2Time = uint32(revel.Config.IntDefault("password.argon2.time", 1))
+	Passwords.Argon2Memory = uint32(revel.Config.IntDefault("password.argon2.memory", 64*1024))
+	Passwords.Argon2Threads = uint8(revel.Config.IntDefault("password.argon2.threads", 4))
+	Passwords.Argon2KeyLen = uint32(revel.Config.IntDefault("password.argon2.keylen", 32))
+	if Passwords.Argon2Time == 0 || Passwords.Argon2Memory == 0 || Passwords.Argon2Threads == 0 ||
+		Passwords.Argon2KeyLen < 16 {
+		revel.AppLog.Fatal("invalid password.argon2 values set in config")
+	}
+
+	Passwords.MinLength = revel.Config.IntDefault("password.min.length", 10)
+	Passwords.MaxLength = 127
+	Passwords.MinClasses = revel.Config.IntDefault("password.min.classes", 3)
+	Passwords.ResetValidity = revel.Config.IntDefault("password.reset.validity", 60)
+}
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"time"
+
+	"github.com/revel/revel"
+)
+
+/*RateLimitConf contains the parameters of the login rate limiting and the account lockout. */
+type RateLimitConf struct {
+	//AccountAttempts and IPAttempts are the number of failed attempts per account and
+	//per IP address before the backoff starts
+	AccountAttempts int
+	IPAttempts      int
+
+	//BackoffBase doubles with each further failed attempt up to BackoffMax
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+
+	//LockoutAttempts is the number of failed attempts per account, after which the
+	//account is locked for LockoutDuration or until an admin unlocks it
+	LockoutAttempts int
+	LockoutDuration time.Duration
+
+	//Window after which failed attempts are forgotten
+	Window time.Duration
+}
+
+//RateLimits holds the parameters of the login rate limiting and the account lockout
+var RateLimits RateLimitConf
+
+/*Backoff returns the time to wait after the last failed attempt. The first attempts
+are free, afterwards, the backoff doubles with each failed attempt. */
+func (conf *RateLimitConf) Backoff(failures, freeAttempts int) time.Duration {
+
+	exceeded := failures - freeAttempts
+	if exceeded <= 0 {
+		return 0
+	}
+
+	backoff := conf.BackoffBase
+	for i := 1; i < exceeded && backoff < conf.BackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > conf.BackoffMax {
+		return conf.BackoffMax
+	}
+	return backoff
+}
+
+//initRateLimitData initializes the parameters of the login rate limiting
+func initRateLimitData() {
+
+	RateLimits.AccountAttempts = revel.Config.IntDefault("ratelimit.account.attempts", 3)
+	RateLimits.IPAttempts = revel.Config.IntDefault("ratelimit.ip.attempts", 20)
+	RateLimits.BackoffBase = time.Duration(revel.Config.IntDefault("ratelimit.backoff.base", 1)) * time.Second
+	RateLimits.BackoffMax = time.Duration(revel.Config.IntDefault("ratelimit.backoff.max", 900)) * time.Second
+	RateLimits.LockoutAttempts = revel.Config.IntDefault("ratelimit.lockout.attempts", 10)
+	RateLimits.LockoutDuration = time.Duration(revel.Config.IntDefault("ratelimit.lockout.duration", 30)) * time.Minute
+	RateLimits.Window = time.Duration(revel.Config.IntDefault("ratelimit.window", 24)) * time.Hour
+
+	if RateLimits.IPAttempts <= 0 || RateLimits.BackoffBase <= 0 ||
+		RateLimits.BackoffMax < RateLimits.BackoffBase ||
+		RateLimits.LockoutAttempts <= RateLimits.AccountAttempts || RateLimits.Window <= 0 {
+		revel.AppLog.Fatal("invalid ratelimit values set in config")
+	}
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"time"
+
+	"github.com/revel/revel"
+)
+
+/*SessionConf contains the expiration of the server-side sessions. */
+type SessionConf struct {
+	//Expires is the time of inactivity after which a session expires
+	Expires time.Duration
+	//StayLoggedInExpires is the time of inactivity after which a session of a user
+	//who chose to stay logged in expires
+	StayLoggedInExpires time.Duration
+}
+
+//Sessions holds the expiration of the server-side sessions
+var Sessions SessionConf
+
+//initSessionData initializes the expiration of the server-side sessions
+func initSessionData() {
+
+	//NOTE: session.expires can also be "session", i.e., the cookie expires when
+	//closing the browser, in which case the default expiration is used
+	var err error
+	Sessions.Expires, err = time.ParseDuration(revel.Config.StringDefault("session.expires", "1h"))
+	if err != nil {
+		Sessions.Expires = time.Hour
+	}
+
+	Sessions.StayLoggedInExpires, err = time.ParseDuration(
+		revel.Config.StringDefault("session.stay.expires", "720h"))
+	if err != nil || Sessions.StayLoggedInExpires < Sessions.Expires {
+		revel.AppLog.Fatal("invalid session.stay.expires value set in config")
+	}
+}
@@ -0,0 +1,419 @@
+package app
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/revel/revel"
+)
+
+/*Spreadsheet is a workbook that is streamed in the Office Open XML (XLSX) or in the
+OpenDocument (ODS) format. The first row of each sheet is a frozen header row. */
+type Spreadsheet struct {
+	Sheets []Sheet
+}
+
+/*Sheet of a spreadsheet. The cells of its rows are strings (text), integers or
+floats (numbers), time.Time values (dates with time of day in the time zone of
+the application) or nil (empty cells). */
+type Sheet struct {
+	Name   string
+	Header []string
+	Rows   [][]interface{}
+}
+
+/*AddSheet appends a sheet to the spreadsheet. Its name is adjusted to be a valid
+and unique sheet name, i.e., at most 31 characters without []:*?/\. */
+func (spreadsheet *Spreadsheet) AddSheet(sheet Sheet) {
+
+	name := strings.TrimSpace(strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return ' '
+		}
+		return r
+	}, sheet.Name))
+	if name == "" {
+		name = "Sheet"
+	}
+
+	//sheet names are compared case-insensitively
+	taken := make(map[string]bool)
+	for _, other := range spreadsheet.Sheets {
+		taken[strings.ToLower(other.Name)] = true
+	}
+
+	sheet.Name = truncateRunes(name, 31)
+	for i := 2; taken[strings.ToLower(sheet.Name)]; i++ {
+		suffix := " (" + strconv.Itoa(i) + ")"
+		sheet.Name = truncateRunes(name, 31-len(suffix)) + suffix
+	}
+
+	spreadsheet.Sheets = append(spreadsheet.Sheets, sheet)
+}
+
+/*WriteXLSX streams the spreadsheet in the Office Open XML format. */
+func (spreadsheet *Spreadsheet) WriteXLSX(w io.Writer) (err error) {
+
+	spreadsheet.ensureSheet()
+	archive, loc := zip.NewWriter(w), location()
+
+	err = writeZipEntry(archive, "[Content_Types].xml", false, func(b *bufio.Writer) {
+		b.WriteString(xml.Header)
+		b.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+		b.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+		b.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+		b.WriteString(`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+		b.WriteString(`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+		for i := range spreadsheet.Sheets {
+			fmt.Fprintf(b, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+		}
+		b.WriteString(`</Types>`)
+	})
+	if err != nil {
+		return
+	}
+
+	err = writeZipEntry(archive, "_rels/.rels", false, func(b *bufio.Writer) {
+		b.WriteString(xml.Header)
+		b.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+		b.WriteString(`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>`)
+		b.WriteString(`</Relationships>`)
+	})
+	if err != nil {
+		return
+	}
+
+	err = writeZipEntry(archive, "xl/workbook.xml", false, func(b *bufio.Writer) {
+		b.WriteString(xml.Header)
+		b.WriteString(`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+		for i, sheet := range spreadsheet.Sheets {
+			fmt.Fprintf(b, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escapeXML(sheet.Name), i+1, i+1)
+		}
+		b.WriteString(`</sheets></workbook>`)
+	})
+	if err != nil {
+		return
+	}
+
+	err = writeZipEntry(archive, "xl/_rels/workbook.xml.rels", false, func(b *bufio.Writer) {
+		b.WriteString(xml.Header)
+		b.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+		for i := range spreadsheet.Sheets {
+			fmt.Fprintf(b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+		}
+		fmt.Fprintf(b, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(spreadsheet.Sheets)+1)
+		b.WriteString(`</Relationships>`)
+	})
+	if err != nil {
+		return
+	}
+
+	//the cell formats are: 0 default, 1 bold header, 2 date with time of day
+	err = writeZipEntry(archive, "xl/styles.xml", false, func(b *bufio.Writer) {
+		b.WriteString(xml.Header)
+		b.WriteString(`<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+		b.WriteString(`<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm"/></numFmts>`)
+		b.WriteString(`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>`)
+		b.WriteString(`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>`)
+		b.WriteString(`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>`)
+		b.WriteString(`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>`)
+		b.WriteString(`<cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>`)
+		b.WriteString(`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>`)
+		b.WriteString(`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/></cellXfs>`)
+		b.WriteString(`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>`)
+		b.WriteString(`</styleSheet>`)
+	})
+	if err != nil {
+		return
+	}
+
+	for i := range spreadsheet.Sheets {
+		sheet := &spreadsheet.Sheets[i]
+		err = writeZipEntry(archive, fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), false,
+			func(b *bufio.Writer) {
+				sheet.writeXLSX(b, loc)
+			})
+		if err != nil {
+			return
+		}
+	}
+
+	if err = archive.Close(); err != nil {
+		revel.AppLog.Error("failed to close spreadsheet", "error", err.Error())
+	}
+	return
+}
+
+/*WriteODS streams the spreadsheet in the OpenDocument format. */
+func (spreadsheet *Spreadsheet) WriteODS(w io.Writer) (err error) {
+
+	spreadsheet.ensureSheet()
+	archive, loc := zip.NewWriter(w), location()
+
+	//the mimetype must be the first and uncompressed entry of the archive
+	err = writeZipEntry(archive, "mimetype", true, func(b *bufio.Writer) {
+		b.WriteString("application/vnd.oasis.opendocument.spreadsheet")
+	})
+	if err != nil {
+		return
+	}
+
+	err = writeZipEntry(archive, "META-INF/manifest.xml", false, func(b *bufio.Writer) {
+		b.WriteString(xml.Header)
+		b.WriteString(`<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" manifest:version="1.2">`)
+		b.WriteString(`<manifest:file-entry manifest:full-path="/" manifest:version="1.2" manifest:media-type="application/vnd.oasis.opendocument.spreadsheet"/>`)
+		b.WriteString(`<manifest:file-entry manifest:full-path="content.xml" manifest:media-type="text/xml"/>`)
+		b.WriteString(`<manifest:file-entry manifest:full-path="settings.xml" manifest:media-type="text/xml"/>`)
+		b.WriteString(`</manifest:manifest>`)
+	})
+	if err != nil {
+		return
+	}
+
+	//the header rows are frozen by splitting each table below its first row
+	err = writeZipEntry(archive, "settings.xml", false, func(b *bufio.Writer) {
+		b.WriteString(xml.Header)
+		b.WriteString(`<office:document-settings xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:config="urn:oasis:names:tc:opendocument:xmlns:config:1.0" office:version="1.2">`)
+		b.WriteString(`<office:settings><config:config-item-set config:name="ooo:view-settings">`)
+		b.WriteString(`<config:config-item-map-indexed config:name="Views"><config:config-item-map-entry>`)
+		b.WriteString(`<config:config-item config:name="ViewId" config:type="string">view1</config:config-item>`)
+		b.WriteString(`<config:config-item-map-named config:name="Tables">`)
+		for _, sheet := range spreadsheet.Sheets {
+			fmt.Fprintf(b, `<config:config-item-map-entry config:name="%s">`, escapeXML(sheet.Name))
+			b.WriteString(`<config:config-item config:name="VerticalSplitMode" config:type="short">2</config:config-item>`)
+			b.WriteString(`<config:config-item config:name="VerticalSplitPosition" config:type="int">1</config:config-item>`)
+			b.WriteString(`<config:config-item config:name="ActiveSplitRange" config:type="short">2</config:config-item>`)
+			b.WriteString(`<config:config-item config:name="PositionTop" config:type="int">0</config:config-item>`)
+			b.WriteString(`<config:config-item config:name="PositionBottom" config:type="int">1</config:config-item>`)
+			b.WriteString(`</config:config-item-map-entry>`)
+		}
+		b.WriteString(`</config:config-item-map-named></config:config-item-map-entry></config:config-item-map-indexed>`)
+		b.WriteString(`</config:config-item-set></office:settings></office:document-settings>`)
+	})
+	if err != nil {
+		return
+	}
+
+	err = writeZipEntry(archive, "content.xml", false, func(b *bufio.Writer) {
+		b.WriteString(xml.Header)
+		b.WriteString(`<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:style="urn:oasis:names:tc:opendocument:xmlns:style:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0" xmlns:fo="urn:oasis:names:tc:opendocument:xmlns:xsl-fo-compatible:1.0" xmlns:number="urn:oasis:names:tc:opendocument:xmlns:datastyle:1.0" office:version="1.2">`)
+		b.WriteString(`<office:automatic-styles>`)
+		b.WriteString(`<number:date-style style:name="N1"><number:year number:style="long"/><number:text>-</number:text>`)
+		b.WriteString(`<number:month number:style="long"/><number:text>-</number:text><number:day number:style="long"/>`)
+		b.WriteString(`<number:text> </number:text><number:hours number:style="long"/><number:text>:</number:text>`)
+		b.WriteString(`<number:minutes number:style="long"/></number:date-style>`)
+		b.WriteString(`<style:style style:name="co1" style:family="table-column"><style:table-column-properties style:column-width="4cm"/></style:style>`)
+		b.WriteString(`<style:style style:name="ce1" style:family="table-cell"><style:text-properties fo:font-weight="bold"/></style:style>`)
+		b.WriteString(`<style:style style:name="ce2" style:family="table-cell" style:data-style-name="N1"/>`)
+		b.WriteString(`</office:automatic-styles><office:body><office:spreadsheet>`)
+		for i := range spreadsheet.Sheets {
+			spreadsheet.Sheets[i].writeODS(b, loc)
+		}
+		b.WriteString(`</office:spreadsheet></office:body></office:document-content>`)
+	})
+	if err != nil {
+		return
+	}
+
+	if err = archive.Close(); err != nil {
+		revel.AppLog.Error("failed to close spreadsheet", "error", err.Error())
+	}
+	return
+}
+
+//writeXLSX writes the worksheet of a sheet
+func (sheet *Sheet) writeXLSX(b *bufio.Writer, loc *time.Location) {
+
+	b.WriteString(xml.Header)
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	b.WriteString(`<sheetViews><sheetView workbookViewId="0">`)
+	b.WriteString(`<pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/>`)
+	b.WriteString(`</sheetView></sheetViews>`)
+	if len(sheet.Header) != 0 {
+		fmt.Fprintf(b, `<cols><col min="1" max="%d" width="20" customWidth="1"/></cols>`,
+			len(sheet.Header))
+	}
+	b.WriteString(`<sheetData><row r="1">`)
+	for j, title := range sheet.Header {
+		fmt.Fprintf(b, `<c r="%s1" t="inlineStr" s="1"><is><t xml:space="preserve">%s</t></is></c>`,
+			columnName(j), escapeXML(title))
+	}
+	b.WriteString(`</row>`)
+
+	for i, row := range sheet.Rows {
+		fmt.Fprintf(b, `<row r="%d">`, i+2)
+		for j, value := range row {
+
+			ref := columnName(j) + strconv.Itoa(i+2)
+			switch cell := value.(type) {
+			case string:
+				if cell != "" {
+					fmt.Fprintf(b, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`,
+						ref, escapeXML(cell))
+				}
+			case time.Time:
+				fmt.Fprintf(b, `<c r="%s" s="2"><v>%s</v></c>`, ref, serialDate(cell.In(loc)))
+			case nil:
+			default:
+				if number, ok := formatNumber(cell); ok {
+					fmt.Fprintf(b, `<c r="%s"><v>%s</v></c>`, ref, number)
+				}
+			}
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+}
+
+//writeODS writes the table of a sheet
+func (sheet *Sheet) writeODS(b *bufio.Writer, loc *time.Location) {
+
+	fmt.Fprintf(b, `<table:table table:name="%s">`, escapeXML(sheet.Name))
+	if len(sheet.Header) != 0 {
+		fmt.Fprintf(b, `<table:table-column table:style-name="co1" table:number-columns-repeated="%d"/>`,
+			len(sheet.Header))
+	}
+	b.WriteString(`<table:table-header-rows><table:table-row>`)
+	for _, title := range sheet.Header {
+		fmt.Fprintf(b, `<table:table-cell table:style-name="ce1" office:value-type="string"><text:p>%s</text:p></table:table-cell>`,
+			escapeXML(title))
+	}
+	b.WriteString(`</table:table-row></table:table-header-rows>`)
+
+	for _, row := range sheet.Rows {
+		b.WriteString(`<table:table-row>`)
+		for _, value := range row {
+
+			switch cell := value.(type) {
+			case string:
+				if cell == "" {
+					b.WriteString(`<table:table-cell/>`)
+					continue
+				}
+				fmt.Fprintf(b, `<table:table-cell office:value-type="string"><text:p>%s</text:p></table:table-cell>`,
+					escapeXML(cell))
+			case time.Time:
+				cell = cell.In(loc)
+				fmt.Fprintf(b, `<table:table-cell table:style-name="ce2" office:value-type="date" office:date-value="%s"><text:p>%s</text:p></table:table-cell>`,
+					cell.Format("2006-01-02T15:04:05"), cell.Format("2006-01-02 15:04"))
+			case nil:
+				b.WriteString(`<table:table-cell/>`)
+			default:
+				number, ok := formatNumber(cell)
+				if !ok {
+					b.WriteString(`<table:table-cell/>`)
+					continue
+				}
+				fmt.Fprintf(b, `<table:table-cell office:value-type="float" office:value="%s"><text:p>%s</text:p></table:table-cell>`,
+					number, number)
+			}
+		}
+		b.WriteString(`</table:table-row>`)
+	}
+	b.WriteString(`</table:table>`)
+}
+
+//ensureSheet adds an empty sheet to spreadsheets without sheets, because
+//spreadsheet applications require at least one sheet
+func (spreadsheet *Spreadsheet) ensureSheet() {
+
+	if len(spreadsheet.Sheets) == 0 {
+		spreadsheet.AddSheet(Sheet{})
+	}
+}
+
+//writeZipEntry creates a new entry of a zip archive and writes its content, stored
+//entries are not compressed
+func writeZipEntry(archive *zip.Writer, name string, store bool,
+	write func(b *bufio.Writer)) (err error) {
+
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	if store {
+		header.Method = zip.Store
+	}
+	header.Modified = time.Now()
+
+	file, err := archive.CreateHeader(header)
+	if err != nil {
+		revel.AppLog.Error("failed to create spreadsheet entry", "name", name, "error", err.Error())
+		return
+	}
+
+	b := bufio.NewWriter(file)
+	write(b)
+	if err = b.Flush(); err != nil {
+		revel.AppLog.Error("failed to write spreadsheet entry", "name", name, "error", err.Error())
+	}
+	return
+}
+
+//columnName returns the name of a column, e.g., A for 0 and AA for 26
+func columnName(i int) (name string) {
+
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return
+}
+
+//serialDate returns the serial number of a date, i.e., the number of days since
+//1899-12-30 of its wall clock time
+func serialDate(t time.Time) string {
+
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(),
+		0, time.UTC)
+	days := wall.Sub(time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)).Hours() / 24
+	return strconv.FormatFloat(days, 'f', -1, 64)
+}
+
+//location returns the location of the time zone of the application
+func location() *time.Location {
+
+	loc, err := time.LoadLocation(TimeZone)
+	if err != nil {
+		revel.AppLog.Error("failed to load location", "timeZone", TimeZone,
+			"error", err.Error())
+		return time.Local
+	}
+	return loc
+}
+
+//formatNumber returns the string representation of an integer or a float
+func formatNumber(value interface{}) (number string, ok bool) {
+
+	switch n := value.(type) {
+	case int:
+		return strconv.Itoa(n), true
+	case int32:
+		return strconv.FormatInt(int64(n), 10), true
+	case int64:
+		return strconv.FormatInt(n, 10), true
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+//escapeXML escapes a string for XML text and attribute values, invalid characters
+//are replaced by the unicode replacement character
+func escapeXML(str string) string {
+
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(str))
+	return b.String()
+}
+
+//truncateRunes shortens a string to a maximum number of characters
+func truncateRunes(str string, max int) string {
+
+	if runes := []rune(str); len(runes) > max {
+		return strings.TrimSpace(string(runes[:max]))
+	}
+	return str
+}
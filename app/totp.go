@@ -0,0 +1,137 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/revel/revel"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+/*TOTPConf contains the configuration of the two-factor authentication. */
+type TOTPConf struct {
+	//Issuer is shown in the authenticator apps
+	Issuer string
+	//EnforcedRoles must enable the two-factor authentication
+	EnforcedRoles []string
+}
+
+const (
+	//totpPeriod is the validity of a TOTP code in seconds
+	totpPeriod = 30
+	//totpDigits is the number of digits of a TOTP code
+	totpDigits = 6
+	//totpSkew is the number of accepted periods before and after the current period
+	totpSkew = 1
+)
+
+var (
+	//TOTP holds the configuration of the two-factor authentication
+	TOTP TOTPConf
+
+	//totpEncoding encodes the secrets for the authenticator apps
+	totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+/*GenerateTOTPSecret generates a random base32 encoded TOTP secret. */
+func GenerateTOTPSecret() (secret string, err error) {
+
+	bytes := make([]byte, 20)
+	if _, err = rand.Read(bytes); err != nil {
+		revel.AppLog.Error("failed to generate TOTP secret", "error", err.Error())
+		return
+	}
+	return totpEncoding.EncodeToString(bytes), nil
+}
+
+/*ValidateTOTP validates a TOTP code (RFC 6238). To prevent the reuse of codes, only
+codes of periods after the last used period are valid. It returns the period of the
+valid code. */
+func ValidateTOTP(secret, code string, lastStep int64) (step int64, valid bool) {
+
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := time.Now().Unix() / totpPeriod
+	for step = current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+/*TOTPQRCode returns the QR code to enroll a TOTP secret in an authenticator app
+as a data URI. */
+func TOTPQRCode(secret, account string) (dataURI string, err error) {
+
+	label := url.PathEscape(TOTP.Issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", TOTP.Issuer)
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	uri := "otpauth://totp/" + label + "?" + params.Encode()
+
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		revel.AppLog.Error("failed to encode QR code", "error", err.Error())
+		return
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
+
+/*TOTPEnforced returns whether users with this role must enable the two-factor
+authentication. */
+func TOTPEnforced(role string) bool {
+
+	for _, enforcedRole := range TOTP.EnforcedRoles {
+		if enforcedRole == role {
+			return true
+		}
+	}
+	return false
+}
+
+//totpCode computes the TOTP code of a period (RFC 4226)
+func totpCode(key []byte, step int64) string {
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+//initTOTPData initializes the configuration of the two-factor authentication
+func initTOTPData() {
+
+	TOTP.Issuer = revel.Config.StringDefault("totp.issuer", revel.AppName)
+
+	TOTP.EnforcedRoles = nil
+	roles := revel.Config.StringDefault("totp.enforce.roles", "")
+	for _, role := range strings.Split(roles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			TOTP.EnforcedRoles = append(TOTP.EnforcedRoles, role)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/revel/revel"
+)
+
+/*WebhookConf contains the parameters of the webhook deliveries. */
+type WebhookConf struct {
+	//Timeout of each delivery
+	Timeout time.Duration
+
+	//MaxAttempts is the number of attempts before a delivery fails, the retry delay
+	//starts at RetryBase and doubles with each failed attempt up to RetryMax
+	MaxAttempts int
+	RetryBase   time.Duration
+	RetryMax    time.Duration
+
+	//LogDays is the number of days after which delivery logs are deleted
+	LogDays int
+
+	//AllowHTTP allows webhook URLs without TLS
+	AllowHTTP bool
+
+	//AllowPrivate allows deliveries to loopback, private and link-local addresses
+	AllowPrivate bool
+}
+
+//Webhooks holds the parameters of the webhook deliveries
+var Webhooks WebhookConf
+
+//webhookDialer only connects to public addresses, the addresses are checked after
+//resolving the host name, so that the check also applies to DNS rebinding
+var webhookDialer = &net.Dialer{
+	Timeout: 10 * time.Second,
+	Control: webhookDialControl,
+}
+
+//webhookClient posts all webhook deliveries
+var webhookClient = &http.Client{
+	//NOTE: no proxy is used, because it would connect to the checked addresses
+	Transport: &http.Transport{
+		DialContext:         webhookDialer.DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	//NOTE: redirects are not followed, the receiver must answer directly
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+//webhookBlockedNets are the loopback, private, link-local and other special-purpose
+//address ranges, which webhooks must not be delivered to
+var webhookBlockedNets = parseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4",
+	"240.0.0.0/4", "::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+//errWebhookAddress is returned when connecting to a blocked address
+var errWebhookAddress = errors.New("webhook address is not public")
+
+/*WebhookAddressAllowed returns true if webhooks can be delivered to the IP address. */
+func WebhookAddressAllowed(ip net.IP) bool {
+
+	if Webhooks.AllowPrivate {
+		return true
+	}
+	for _, network := range webhookBlockedNets {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+//webhookDialControl rejects connections to blocked addresses
+func webhookDialControl(network, address string, conn syscall.RawConn) error {
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !WebhookAddressAllowed(ip) {
+		return errWebhookAddress
+	}
+	return nil
+}
+
+//parseCIDRs parses a list of address ranges
+func parseCIDRs(cidrs ...string) (networks []*net.IPNet) {
+
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return
+}
+
+/*Backoff returns the time to wait before the next attempt of a delivery. */
+func (conf *WebhookConf) Backoff(attempts int) time.Duration {
+
+	backoff := conf.RetryBase
+	for i := 1; i < attempts && backoff < conf.RetryMax; i++ {
+		backoff *= 2
+	}
+	if backoff > conf.RetryMax {
+		return conf.RetryMax
+	}
+	return backoff
+}
+
+/*SignWebhook returns the HMAC-SHA256 signature of a delivery. The signature covers the
+timestamp and the body, so that receivers can reject replayed deliveries. */
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+/*PostWebhook posts a signed JSON payload to the URL of a webhook. It returns the
+HTTP status code and, for 2xx responses, the beginning of the response body. Only
+2xx responses are successful deliveries. */
+func PostWebhook(url, secret, event string, deliveryID int, body []byte) (status int,
+	response string, err error) {
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Turm-Webhooks")
+	req.Header.Set("X-Turm-Event", event)
+	req.Header.Set("X-Turm-Delivery", strconv.Itoa(deliveryID))
+	req.Header.Set("X-Turm-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Turm-Signature", SignWebhook(secret, timestamp, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	//NOTE: the bodies of other responses are not returned, so that the delivery log
+	//does not show the content of internal error pages
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, "", nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1000))
+	if err != nil {
+		return resp.StatusCode, "", err
+	}
+	return resp.StatusCode, string(data), nil
+}
+
+//initWebhookData initializes the parameters of the webhook deliveries
+func initWebhookData() {
+
+	Webhooks.Timeout = time.Duration(revel.Config.IntDefault("webhooks.timeout", 10)) * time.Second
+	Webhooks.MaxAttempts = revel.Config.IntDefault("webhooks.max.attempts", 8)
+	Webhooks.RetryBase = time.Duration(revel.Config.IntDefault("webhooks.retry.base", 60)) * time.Second
+	Webhooks.RetryMax = time.Duration(revel.Config.IntDefault("webhooks.retry.max", 21600)) * time.Second
+	Webhooks.LogDays = revel.Config.IntDefault("webhooks.log.days", 30)
+	Webhooks.AllowHTTP = revel.Config.BoolDefault("webhooks.allow.http", false)
+	Webhooks.AllowPrivate = revel.Config.BoolDefault("webhooks.allow.private", false)
+
+	//NOTE: the logs are required to deliver each course expiry only once
+	if Webhooks.Timeout <= 0 || Webhooks.MaxAttempts <= 0 || Webhooks.RetryBase <= 0 ||
+		Webhooks.RetryMax < Webhooks.RetryBase || Webhooks.LogDays < 7 {
+		revel.AppLog.Fatal("invalid webhooks values set in config")
+	}
+	webhookClient.Timeout = Webhooks.Timeout
+	webhookDialer.Timeout = Webhooks.Timeout
+}
@@ -124,8 +124,9 @@ function openGroupsNav() {
   $('#nav-groups-modal').modal('show');
 }
 
-//openCategoryModal shows the modal to insert/update a category
-function openCategoryModal(table, ID, name, action, title) {
+//openCategoryModal shows the modal to insert/update a category,
+//only news feed categories have a language
+function openCategoryModal(table, ID, name, action, title, language) {
 
   $('#admin-category-modal-form').attr("action", action);
   $('#admin-category-modal-title').html(title);
@@ -133,6 +134,14 @@ function openCategoryModal(table, ID, name, action, title) {
   $('#admin-category-modal-table').val(table);
   $('#admin-category-modal-name').val(name);
 
+  if (table == "news_feed_category") {
+    $('#admin-category-modal-language').val(language);
+    $('#admin-category-modal-language-row').removeClass("d-none");
+  } else {
+    $('#admin-category-modal-language').val("");
+    $('#admin-category-modal-language-row').addClass("d-none");
+  }
+
   //show the modal
   $('#admin-category-modal').modal('show');
 }
@@ -216,13 +225,15 @@ function detectTextFieldChange(source, textField, text) {
 
 //openAdminGroupModal shows the modal to insert/update a group
 function openAdminGroupModal(ID, parentID, inheritsLimits, action, title,
-  value, childHasLimits, courseLimits) {
+  value, childHasLimits, courseLimits, requiresApproval, managers) {
 
   $('#admin-group-modal-form').attr("action", action);
   $('#admin-group-modal-title').html(title);
   $('#admin-group-modal-ID').val(ID);
   $('#admin-group-modal-parentID').val(parentID);
   $('#admin-group-modal-name').val(value);
+  $('#admin-group-modal-requiresApproval').prop("checked", requiresApproval);
+  $('#admin-group-modal-managers').val(managers);
 
   $('#admin-group-modal-courseLimits').attr("disabled", (inheritsLimits || childHasLimits));
   if (inheritsLimits || childHasLimits) {
@@ -239,6 +250,22 @@ function openAdminGroupModal(ID, parentID, inheritsLimits, action, title,
   $('#admin-group-modal').modal('show');
 }
 
+function openGroupArchiveModal(ID, title) {
+
+  $('#admin-group-archive-modal-title').html(title);
+  $('#admin-group-archive-modal-ID').val(ID);
+  $('#admin-group-archive-modal-parentID').val(ID);
+
+  //only existing groups can be exported
+  if (ID === "") {
+    $('#admin-group-archive-modal-export').addClass("d-none");
+  } else {
+    $('#admin-group-archive-modal-export').removeClass("d-none");
+  }
+
+  $('#admin-group-archive-modal').modal('show');
+}
+
 //enterEnrollDataModal opens a modal when enrolling in a course
 function enterEnrollDataModal(action, msg, ID, hasKey, hasComments) {
 
@@ -288,16 +315,20 @@ function bookSlotModal(courseID, calendarEventID, date, year, weekDay, monday, d
   $('#book-slot-modal').modal('show');
 }
 
-function searchCourse(valDiv, resultDiv, dropdownID, action) {
+//searchCourse renders the course search results, the optional filter form
+//restricts the results
+function searchCourse(valDiv, resultDiv, dropdownID, action, filterForm) {
 
   let dropdown = document.getElementById(resultDiv);
   let contains = dropdown.classList.contains("show");
 
   let value = $('#' + valDiv).val();
   if (value != "") {
-    $.get(action, {
-      "value": value
-    }, function(data) {
+    let params = "value=" + encodeURIComponent(value);
+    if (filterForm) {
+      params += "&" + $('#' + filterForm).serialize();
+    }
+    $.get(action, params, function(data) {
       $('#' + resultDiv).html(data);
     })
 
@@ -170,6 +170,9 @@ function openTextAreaModal(title, field, valid, action, info, isEMail) {
     }
   }
 
+  //reset the custom e-mail preview
+  $('#change-text-area-modal-preview').addClass('d-none');
+
   //set content
   if (valid) {
     quill.root.innerHTML = $('#div-' + field).html();
@@ -531,3 +534,35 @@ function openDuplicateDeleteModal(title, content, action, fieldID, meetingID, ev
   //show the modal
   $('#duplicate-delete-modal').modal('show');
 }
+
+function previewCustomEMail(action, ID) {
+
+  $.ajax({
+    type: 'POST',
+    url: action,
+    data: {
+      ID: ID,
+      value: quill.root.innerHTML,
+      onWaitlist: document.getElementById("change-text-area-modal-preview-waitlist").checked
+    },
+
+    success: function(response) {
+      if (response.Status == "success") {
+        $('#change-text-area-modal-preview').html(response.Value);
+        $('#change-text-area-modal-preview').removeClass('d-none');
+      } else {
+        showToast(response.Msg, 'danger');
+      }
+    },
+
+    error: function (error) {
+      showToast("error", 'danger');
+    },
+  });
+}
+
+function openWebhooksModal(action) {
+
+  renderContent(action, '#webhooks-modal-content');
+  $('#webhooks-modal').modal('show');
+}
@@ -26,4 +26,41 @@ function showChosenOption() {
     $('#search-draft-section').addClass("d-none");
     $("#custom-file-upload").prop('required', false);
   }
+  showTemplateSection(option == 3);
+}
+
+//showTemplateSection shows the course templates and the enrollment start of the new
+//course, the templates are loaded once
+function showTemplateSection(show) {
+  $('#template-section').toggleClass("d-none", !show);
+  $("#template-start").prop('required', show);
+  if (show && !$('#template-list').data("loaded")) {
+    $('#template-list').data("loaded", true);
+    renderContent($('#template-list').data("url"), '#template-list');
+  }
+}
+
+//openReviewModal shows the modal to approve, reject or request changes of a course,
+//rejections and change requests require a comment
+function openReviewModal(ID, decision, title, requiresComment) {
+  $('#review-course-modal-ID').val(ID);
+  $('#review-course-modal-decision').val(decision);
+  $('#review-course-modal-title').html(title);
+  $('#review-course-modal-comment').val("");
+  $("#review-course-modal-comment").prop('required', requiresComment);
+  $('#review-course-modal').modal('show');
+}
+
+//openScheduleModal shows the modal to schedule a transition of a course, drafts
+//can be activated and active courses can be expired
+function openScheduleModal(ID, active) {
+  $('#schedule-transition-ID').val(ID);
+  $('#schedule-transition-activate').prop('disabled', active);
+  $('#schedule-transition-activate').prop('hidden', active);
+  $('#schedule-transition-expire').prop('disabled', !active);
+  $('#schedule-transition-expire').prop('hidden', !active);
+  $('#schedule-transition-action').val(active ? "expire" : "activate");
+  $('#schedule-transition-date').val("");
+  $('#schedule-transition-time').val("");
+  $('#schedule-transition-modal').modal('show');
 }
@@ -12,11 +12,73 @@ function toggleEventSelection(elemID) {
   }
 }
 
+function toggleCSVOptions(elemID) {
+
+  let selected = $('#' + elemID).val();
+
+  let options = document.getElementById(elemID + "-options");
+  if (selected == "0") {
+    options.classList.remove("d-none");
+  } else {
+    options.classList.add("d-none");
+  }
+}
+
 function submitParticipantsModal(elemID) {
   $('#' + elemID + '-form').submit();
   $('#' + elemID + '-modal').modal('hide');
 }
 
+//toggleEMailMode shows the time of sending input fields for scheduled e-mails
+function toggleEMailMode() {
+
+  const scheduled = ($('#email-participants-mode').val() == "1");
+
+  $('#email-participants-send-date').prop('required', scheduled);
+  $('#email-participants-send-time-value').prop('required', scheduled);
+
+  if (scheduled) {
+    $('#email-participants-send-time').removeClass("d-none");
+  } else {
+    $('#email-participants-send-time').addClass("d-none");
+  }
+}
+
+//openScheduledEMailModal opens the e-mail modal to edit a scheduled e-mail or draft
+function openScheduledEMailModal(email) {
+
+  const conf = email.Conf;
+
+  $('#email-participants-scheduled-ID').val(email.ID);
+  $('#email-participants-subject').val(email.Subject);
+
+  $('#selector-events-email').val(conf.AllEvents ? "true" : "false");
+  toggleEventSelection('selector-events-email');
+  $('#selector-events-email-options select').val((conf.EventIDs || []).map(String));
+
+  $('#email-participants-participants').prop('checked', conf.Participants);
+  $('#email-participants-waitlist').prop('checked', conf.WaitList);
+  $('#email-participants-unsubscribed').prop('checked', conf.Unsubscribed);
+
+  $('#email-participants-start').val(conf.Start.substring(0, 10));
+  $('#email-participants-start-time').val(conf.StartTime);
+  $('#email-participants-end').val(conf.End.substring(0, 10));
+  $('#email-participants-end-time').val(conf.EndTime);
+
+  quill.root.innerHTML = email.Content;
+  $('#e-mail-participants-value').val(email.Content);
+
+  $('#email-participants-mode').val(email.IsDraft ? "2" : "1");
+  if (email.TimeOfSending.Valid) {
+    const timeOfSending = email.TimeOfSending.String.split(" ");
+    $('#email-participants-send-date').val(timeOfSending[0]);
+    $('#email-participants-send-time-value').val(timeOfSending[1]);
+  }
+  toggleEMailMode();
+
+  $('#email-participants-modal').modal('show');
+}
+
 function reactToEntryInput(eventIdx, courseID, eventID) {
 
   document.getElementById("search-form-" + eventIdx).classList.add('was-validated');
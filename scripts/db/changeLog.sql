@@ -15,3 +15,437 @@ COMMENT ON TABLE log_entries IS 'Table containing all relevant error log entries
 /* Move away from the terms blacklist and whitelist. */
 ALTER TABLE blacklists RENAME TO blocklists;
 ALTER TABLE whitelists RENAME TO allowlists; 
+
+/* Archive all e-mails sent in the context of a course. */
+CREATE TABLE sent_emails (
+  id                  serial                        PRIMARY KEY,
+  course_id           integer                       NOT NULL,
+  sender              integer,
+  subject             varchar(511)                  NOT NULL,
+  content             text                          NOT NULL,
+  time_of_sending     timestamp with time zone      NOT NULL,
+
+  FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+  FOREIGN KEY (sender) REFERENCES users (id) ON DELETE SET NULL
+);
+COMMENT ON TABLE sent_emails IS 'Archive of all e-mails sent in the context of a course.';
+
+CREATE TABLE sent_email_recipients (
+  id                  serial                        PRIMARY KEY,
+  sent_email_id       integer                       NOT NULL,
+  email               varchar(255)                  NOT NULL,
+  delivery_state      integer                       NOT NULL DEFAULT 0,
+
+  FOREIGN KEY (sent_email_id) REFERENCES sent_emails (id) ON DELETE CASCADE
+);
+COMMENT ON TABLE sent_email_recipients IS 'Recipients and delivery states of all archived e-mails.';
+
+/* Mark bouncing e-mail addresses as undeliverable until the user confirms a (new) address. */
+ALTER TABLE users ADD COLUMN email_undeliverable boolean NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN unconfirmed_email varchar(255);
+ALTER TABLE users ADD COLUMN email_confirmation_code varchar(255);
+
+/* Scheduled e-mails and drafts to lists of participants. */
+CREATE TABLE scheduled_emails (
+  id                  serial                        PRIMARY KEY,
+  course_id           integer                       NOT NULL,
+  creator             integer,
+  subject             varchar(255)                  NOT NULL DEFAULT '',
+  content             text                          NOT NULL DEFAULT '',
+  list_conf           jsonb                         NOT NULL,
+  is_draft            boolean                       NOT NULL DEFAULT true,
+  time_of_sending     timestamp with time zone,
+  last_edited         timestamp with time zone      NOT NULL,
+
+  FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+  FOREIGN KEY (creator) REFERENCES users (id) ON DELETE SET NULL,
+  CHECK (is_draft OR time_of_sending IS NOT NULL)
+);
+COMMENT ON TABLE scheduled_emails IS 'E-mails to lists of participants that are scheduled for sending or saved as drafts.';
+
+/* Link users to their identities at OpenID Connect providers. */
+ALTER TABLE users ADD COLUMN oidc_issuer varchar(255);
+ALTER TABLE users ADD COLUMN oidc_subject varchar(255);
+ALTER TABLE users ADD CONSTRAINT users_oidc_identity_key UNIQUE (oidc_issuer, oidc_subject);
+
+/* Single-use, time-limited password reset tokens, only their SHA-256 hashes are stored. */
+CREATE TABLE password_reset_tokens (
+  id                  serial                        PRIMARY KEY,
+  user_id             integer                       NOT NULL,
+  token_hash          varchar(64)                   NOT NULL UNIQUE,
+  expiration          timestamp with time zone      NOT NULL,
+
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+COMMENT ON TABLE password_reset_tokens IS 'Password reset tokens of external users.';
+
+/* Optional TOTP two-factor authentication with single-use recovery codes. */
+ALTER TABLE users ADD COLUMN totp_secret varchar(64);
+ALTER TABLE users ADD COLUMN totp_enabled boolean NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN totp_last_step bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE recovery_codes (
+  id                  serial                        PRIMARY KEY,
+  user_id             integer                       NOT NULL,
+  code_hash           varchar(64)                   NOT NULL,
+
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+  UNIQUE (user_id, code_hash)
+);
+COMMENT ON TABLE recovery_codes IS 'SHA-256 hashes of the recovery codes of the two-factor authentication.';
+
+/* Rate limiting of failed login attempts per account and per IP address. */
+CREATE TABLE login_throttles (
+  id                  serial                        PRIMARY KEY,
+  throttle_key        varchar(511)                  NOT NULL UNIQUE,
+  failures            integer                       NOT NULL DEFAULT 1,
+  last_failure        timestamp with time zone      NOT NULL,
+  locked_until        timestamp with time zone
+);
+COMMENT ON TABLE login_throttles IS 'Failed login attempts per account, IP address, second login step and password reset request.';
+
+/* Server-side sessions, which expire after inactivity and can be revoked. */
+CREATE TABLE user_sessions (
+  id                  serial                        PRIMARY KEY,
+  user_id             integer                       NOT NULL,
+  token_hash          varchar(64)                   NOT NULL UNIQUE,
+  stay_logged_in      boolean                       NOT NULL DEFAULT false,
+  ip                  varchar(64)                   NOT NULL,
+  user_agent          varchar(255)                  NOT NULL,
+  created             timestamp with time zone      NOT NULL,
+  last_active         timestamp with time zone      NOT NULL,
+  expires             timestamp with time zone      NOT NULL,
+
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+COMMENT ON TABLE user_sessions IS 'Server-side sessions of logged in users, identified by the SHA-256 hash of a random token.';
+
+/* Audit log of admins impersonating users. */
+CREATE TABLE impersonations (
+  id                  serial                        PRIMARY KEY,
+  admin_id            integer                       NOT NULL,
+  user_id             integer                       NOT NULL,
+  writable            boolean                       NOT NULL DEFAULT false,
+  ip                  varchar(64)                   NOT NULL,
+  started             timestamp with time zone      NOT NULL,
+  ended               timestamp with time zone,
+
+  FOREIGN KEY (admin_id) REFERENCES users (id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+COMMENT ON TABLE impersonations IS 'Start and end of each impersonation of an user by an admin.';
+
+/* Personal access tokens of the JSON API. */
+CREATE TABLE api_tokens (
+  id                  serial                        PRIMARY KEY,
+  user_id             integer                       NOT NULL,
+  name                varchar(255)                  NOT NULL,
+  token_hash          varchar(64)                   NOT NULL UNIQUE,
+  scopes              varchar(255)                  NOT NULL,
+  created             timestamp with time zone      NOT NULL,
+  last_used           timestamp with time zone,
+  expires             timestamp with time zone,
+
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+COMMENT ON TABLE api_tokens IS 'Revocable personal access tokens of the JSON API, identified by the SHA-256 hash of a random token.';
+
+/* Webhooks subscribing to the events of a course or (without course) of all courses. */
+CREATE TABLE webhooks (
+  id                  serial                        PRIMARY KEY,
+  course_id           integer,
+  url                 varchar(1023)                 NOT NULL,
+  secret              varchar(64)                   NOT NULL,
+  events              varchar(511)                  NOT NULL,
+  creator             integer,
+  created             timestamp with time zone      NOT NULL,
+
+  FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+  FOREIGN KEY (creator) REFERENCES users (id) ON DELETE SET NULL
+);
+COMMENT ON TABLE webhooks IS 'URLs receiving signed JSON deliveries of course events.';
+
+/* Delivery queue and delivery log of the webhooks. */
+CREATE TABLE webhook_deliveries (
+  id                  serial                        PRIMARY KEY,
+  webhook_id          integer                       NOT NULL,
+  course_id           integer,
+  event               varchar(63)                   NOT NULL,
+  payload             text                          NOT NULL,
+  attempts            integer                       NOT NULL DEFAULT 0,
+  state               integer                       NOT NULL DEFAULT 0,
+  next_attempt        timestamp with time zone      NOT NULL,
+  status_code         integer,
+  response            text,
+  created             timestamp with time zone      NOT NULL,
+  last_attempt        timestamp with time zone,
+
+  FOREIGN KEY (webhook_id) REFERENCES webhooks (id) ON DELETE CASCADE
+);
+COMMENT ON TABLE webhook_deliveries IS 'Queued deliveries and the delivery log of the webhooks.';
+
+/* Language of the news feed categories, NULL for all languages. */
+ALTER TABLE news_feed_category ADD COLUMN language varchar(15);
+
+/* Approval workflow of courses. Courses of groups requiring approval are submitted
+for review by the group managers instead of being activated immediately. */
+ALTER TABLE groups ADD COLUMN requires_approval boolean NOT NULL DEFAULT false;
+
+CREATE TABLE group_managers (
+  group_id            integer                       NOT NULL,
+  user_id             integer                       NOT NULL,
+
+  PRIMARY KEY (group_id, user_id),
+  FOREIGN KEY (group_id) REFERENCES groups (id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+COMMENT ON TABLE group_managers IS 'Users reviewing the courses of a group subtree.';
+
+CREATE TABLE course_reviews (
+  id                  serial                        PRIMARY KEY,
+  course_id           integer                       NOT NULL,
+  state               integer                       NOT NULL DEFAULT 0,
+  submitted_by        integer, /* Set to null if user data is deleted due to data policy requirements. */
+  submitted           timestamp with time zone      NOT NULL,
+  reviewer            integer, /* Set to null if user data is deleted due to data policy requirements. */
+  reviewed            timestamp with time zone,
+  comment             text,
+
+  FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+  FOREIGN KEY (submitted_by) REFERENCES users (id) ON DELETE SET NULL,
+  FOREIGN KEY (reviewer) REFERENCES users (id) ON DELETE SET NULL
+);
+COMMENT ON TABLE course_reviews IS 'Submissions of courses for review and the decisions of the reviewers.';
+
+/* Scheduled transitions of courses, i.e., their activation, a change of their
+visibility or their expiration at a future timestamp. */
+CREATE TABLE scheduled_transitions (
+  id                  serial                        PRIMARY KEY,
+  course_id           integer                       NOT NULL,
+  action              varchar(15)                   NOT NULL,
+  due                 timestamp with time zone      NOT NULL,
+  creator             integer, /* Set to null if user data is deleted due to data policy requirements. */
+  failed              boolean                       NOT NULL DEFAULT false,
+
+  FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+  FOREIGN KEY (creator) REFERENCES users (id) ON DELETE SET NULL
+);
+COMMENT ON TABLE scheduled_transitions IS 'Pending transitions of courses, executed by a job.';
+
+/* Revision history of courses. Each revision is a snapshot of the editable data
+of a course in JSON format. */
+CREATE TABLE course_revisions (
+  id                  serial                        PRIMARY KEY,
+  course_id           integer                       NOT NULL,
+  editor              integer, /* Set to null if user data is deleted due to data policy requirements. */
+  created             timestamp with time zone      NOT NULL,
+  data                text                          NOT NULL,
+
+  FOREIGN KEY (course_id) REFERENCES courses (id) ON DELETE CASCADE,
+  FOREIGN KEY (editor) REFERENCES users (id) ON DELETE SET NULL
+);
+COMMENT ON TABLE course_revisions IS 'Snapshots of the course data after each edit.';
+
+/* The last revision of a course is its last modification, e.g., in the catalog feeds. */
+CREATE INDEX course_revisions_course_id_created_idx ON course_revisions (course_id, created);
+
+/* Library of course templates. Templates without a group are available institution-wide,
+courses created from a group template are placed in that group. */
+CREATE TABLE course_templates (
+  id                  serial                        PRIMARY KEY,
+  name                varchar(255)                  NOT NULL,
+  description         text,
+  group_id            integer,
+  creator             integer, /* Set to null if user data is deleted due to data policy requirements. */
+  created             timestamp with time zone      NOT NULL,
+  data                text                          NOT NULL,
+
+  FOREIGN KEY (group_id) REFERENCES groups (id) ON DELETE CASCADE,
+  FOREIGN KEY (creator) REFERENCES users (id) ON DELETE SET NULL
+);
+COMMENT ON TABLE course_templates IS 'Course structures curated by admins, stored as course JSON.';
+
+/* Failed attempts to send scheduled e-mails, which are retried with a backoff. */
+ALTER TABLE scheduled_emails ADD COLUMN attempts integer NOT NULL DEFAULT 0;
+ALTER TABLE scheduled_emails ADD COLUMN retry_after timestamp with time zone;
+
+/* Custom e-mails are templates now, escape the braces of custom e-mails written before.
+NOTE: must run before any custom e-mail uses the template syntax. */
+UPDATE courses SET custom_email = replace(custom_email, '{{', '{{"{{"}}')
+WHERE custom_email LIKE '%{{%';
+
+/* Link users to their identities at SAML identity providers. */
+ALTER TABLE users ADD COLUMN saml_issuer varchar(255);
+ALTER TABLE users ADD COLUMN saml_subject varchar(255);
+ALTER TABLE users ADD CONSTRAINT users_saml_identity_key UNIQUE (saml_issuer, saml_subject);
+
+/* LDAP usernames of users, so that their failed login attempts are shown to admins. */
+ALTER TABLE users ADD COLUMN ldap_username varchar(255);
+
+/* Weighted full-text search documents of courses, stemmed in German and English.
+(A): title and subtitle, (B): speaker, group path, creator, editors, instructors and
+events, (C): description, meetings and calendar events. */
+ALTER TABLE courses ADD COLUMN search_document tsvector;
+CREATE INDEX courses_search_document_idx ON courses USING GIN (search_document);
+
+CREATE FUNCTION course_search_document(integer) RETURNS tsvector AS $$
+  WITH RECURSIVE path (id, parent_id, name)
+    AS (
+      SELECT g.id, g.parent_id, g.name
+      FROM groups g JOIN courses c ON c.parent_id = g.id
+      WHERE c.id = $1
+
+      UNION ALL
+
+      SELECT g.id, g.parent_id, g.name
+      FROM groups g, path p
+      WHERE g.id = p.parent_id
+    ),
+
+    fields (weight_a, weight_b, weight_c)
+    AS (
+      SELECT concat_ws(' ', c.title, c.subtitle),
+        concat_ws(' ', c.speaker,
+          (SELECT string_agg(p.name, ' ') FROM path p),
+          (
+            SELECT string_agg(concat_ws(' ', u.first_name, u.last_name, u.email), ' ')
+            FROM users u
+            WHERE u.id = c.creator
+              OR u.id IN (SELECT user_id FROM editors WHERE course_id = c.id)
+              OR u.id IN (SELECT user_id FROM instructors WHERE course_id = c.id)
+          ),
+          (
+            SELECT string_agg(concat_ws(' ', e.title, e.annotation), ' ')
+            FROM events e
+            WHERE e.course_id = c.id
+          )),
+        concat_ws(' ', c.description,
+          (
+            SELECT string_agg(concat_ws(' ', m.place, m.annotation), ' ')
+            FROM meetings m JOIN events e ON m.event_id = e.id
+            WHERE e.course_id = c.id
+          ),
+          (
+            SELECT string_agg(concat_ws(' ', ce.title, ce.annotation), ' ')
+            FROM calendar_events ce
+            WHERE ce.course_id = c.id
+          ))
+      FROM courses c
+      WHERE c.id = $1
+    )
+
+  SELECT setweight(to_tsvector('german', weight_a), 'A') ||
+    setweight(to_tsvector('english', weight_a), 'A') ||
+    setweight(to_tsvector('german', weight_b), 'B') ||
+    setweight(to_tsvector('english', weight_b), 'B') ||
+    setweight(to_tsvector('german', weight_c), 'C') ||
+    setweight(to_tsvector('english', weight_c), 'C')
+  FROM fields
+$$ LANGUAGE sql STABLE;
+
+/* Updates the search documents of all courses affected by a change of a row. */
+CREATE FUNCTION update_course_search_documents() RETURNS trigger AS $$
+DECLARE
+  rec record;
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    rec := OLD;
+  ELSE
+    rec := NEW;
+  END IF;
+
+  CASE TG_TABLE_NAME
+    WHEN 'courses' THEN
+      UPDATE courses SET search_document = course_search_document(id)
+      WHERE id = rec.id;
+    WHEN 'meetings' THEN
+      UPDATE courses SET search_document = course_search_document(id)
+      WHERE id = (SELECT course_id FROM events WHERE id = rec.event_id);
+    WHEN 'users' THEN
+      UPDATE courses SET search_document = course_search_document(id)
+      WHERE creator = rec.id
+        OR id IN (SELECT course_id FROM editors WHERE user_id = rec.id)
+        OR id IN (SELECT course_id FROM instructors WHERE user_id = rec.id);
+    WHEN 'groups' THEN
+      UPDATE courses SET search_document = course_search_document(id)
+      WHERE parent_id IN (
+        WITH RECURSIVE subtree (id)
+          AS (
+            SELECT rec.id
+
+            UNION ALL
+
+            SELECT g.id
+            FROM groups g, subtree s
+            WHERE g.parent_id = s.id
+          )
+        SELECT id FROM subtree
+      );
+    ELSE /* events, calendar events, editors and instructors */
+      UPDATE courses SET search_document = course_search_document(id)
+      WHERE id = rec.course_id;
+  END CASE;
+
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+/* NOTE: updating the search document does not fire the trigger of the courses again,
+as it is not one of the listed columns. */
+CREATE TRIGGER courses_search_document
+  AFTER INSERT OR UPDATE OF title, subtitle, speaker, description, creator, parent_id ON courses
+  FOR EACH ROW EXECUTE PROCEDURE update_course_search_documents();
+CREATE TRIGGER events_search_document
+  AFTER INSERT OR DELETE OR UPDATE OF title, annotation ON events
+  FOR EACH ROW EXECUTE PROCEDURE update_course_search_documents();
+CREATE TRIGGER meetings_search_document
+  AFTER INSERT OR DELETE OR UPDATE OF place, annotation ON meetings
+  FOR EACH ROW EXECUTE PROCEDURE update_course_search_documents();
+CREATE TRIGGER calendar_events_search_document
+  AFTER INSERT OR DELETE OR UPDATE OF title, annotation ON calendar_events
+  FOR EACH ROW EXECUTE PROCEDURE update_course_search_documents();
+CREATE TRIGGER editors_search_document
+  AFTER INSERT OR DELETE ON editors
+  FOR EACH ROW EXECUTE PROCEDURE update_course_search_documents();
+CREATE TRIGGER instructors_search_document
+  AFTER INSERT OR DELETE ON instructors
+  FOR EACH ROW EXECUTE PROCEDURE update_course_search_documents();
+CREATE TRIGGER users_search_document
+  AFTER UPDATE OF first_name, last_name, email ON users
+  FOR EACH ROW
+  WHEN (OLD.first_name IS DISTINCT FROM NEW.first_name
+    OR OLD.last_name IS DISTINCT FROM NEW.last_name
+    OR OLD.email IS DISTINCT FROM NEW.email)
+  EXECUTE PROCEDURE update_course_search_documents();
+CREATE TRIGGER groups_search_document
+  AFTER UPDATE OF name, parent_id ON groups
+  FOR EACH ROW
+  WHEN (OLD.name IS DISTINCT FROM NEW.name OR OLD.parent_id IS DISTINCT FROM NEW.parent_id)
+  EXECUTE PROCEDURE update_course_search_documents();
+
+UPDATE courses SET search_document = course_search_document(id);
+
+/* Revisions identify the users of user lists by their IDs, remove the e-mail addresses
+from the labels of earlier revisions. */
+UPDATE course_revisions r
+SET data = jsonb_set(r.data::jsonb, '{labels}', (
+    SELECT COALESCE(jsonb_object_agg(l.key,
+        CASE WHEN l.key ~ '^(editors|instructors|blocklists|allowlists)\.'
+          THEN '""'::jsonb
+          ELSE l.value
+        END), '{}'::jsonb)
+    FROM jsonb_each(r.data::jsonb -> 'labels') l
+  ))::text
+WHERE jsonb_typeof(r.data::jsonb -> 'labels') = 'object';
+
+/* Impersonations end with the server-side session of the admin, end the open
+impersonations of admins without sessions. */
+ALTER TABLE impersonations ADD COLUMN session_token_hash varchar(64);
+UPDATE impersonations i
+SET ended = now()
+WHERE i.ended IS NULL
+  AND NOT EXISTS (
+    SELECT true
+    FROM user_sessions s
+    WHERE s.user_id = i.admin_id
+  );